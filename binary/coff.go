@@ -0,0 +1,304 @@
+package binary
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// Magic numbers of Microchip's COFF variants, version 2 is written by MPLINK 4 and newer.
+const (
+	coffMagicV1  = 0x1234
+	coffMagicV2  = 0x1240
+	coffOptMagic = 0x5678
+)
+
+// Sizes of the records of a COFF file. Symbols and their auxiliary entries are
+// 2 bytes longer in version 2, which has a 32 bit type.
+const (
+	coffFileHeaderSize    = 20
+	coffSectionHeaderSize = 40
+	coffLineSize          = 16
+	coffSymbolSizeV1      = 18
+	coffSymbolSizeV2      = 20
+)
+
+// Section flags of Microchip's COFF. Sections without the data flags are in program memory, like ROM data.
+const (
+	coffText = 0x0020
+	coffData = 0x0040
+	coffBSS  = 0x0080
+)
+
+// Storage classes of the symbols that are read.
+const (
+	coffClassExternal = 2
+	coffClassStatic   = 3
+	coffClassLabel    = 6
+	coffClassFile     = 103
+)
+
+// coffFunction is the derived type of functions.
+const coffFunction = 2
+
+// coffReader reads the records of a COFF file.
+type coffReader struct {
+	data    []byte
+	version int
+
+	// strings is the string table, offsets into it include its 4 byte size.
+	strings []byte
+
+	// files maps the symbol table indexes of the .file symbols to the names of the source files.
+	files map[uint32]string
+}
+
+func (r *coffReader) bytes(offset, n uint32) ([]byte, error) {
+	if uint64(offset)+uint64(n) > uint64(len(r.data)) {
+		return nil, errors.New("COFF file is truncated")
+	}
+	return r.data[offset : offset+n], nil
+}
+
+func le16(b []byte) uint16 {
+	return uint16(b[0]) | uint16(b[1])<<8
+}
+
+func le32(b []byte) uint32 {
+	return uint32(le16(b)) | uint32(le16(b[2:]))<<16
+}
+
+// name decodes the name of a section or a symbol. Names longer than 8 bytes are in the string table.
+func (r *coffReader) name(field []byte) string {
+	if le32(field) == 0 {
+		return r.string(le32(field[4:]))
+	}
+	if i := slices.Index(field[:8], 0); i >= 0 {
+		return string(field[:i])
+	}
+	return string(field[:8])
+}
+
+func (r *coffReader) string(offset uint32) string {
+	if offset < 4 || offset >= uint32(len(r.strings)) {
+		return ""
+	}
+	s := r.strings[offset:]
+	if i := slices.Index(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return string(s)
+}
+
+// coffSection is a section header.
+type coffSection struct {
+	name  string
+	addr  uint32
+	size  uint32
+	data  uint32
+	lines uint32
+	nLine uint16
+	flags uint32
+}
+
+// ParseCOFF parses a COFF file created by MPLINK for a PIC18, e.g. with MPLAB C18, XC8 v1 or MPASM.
+//
+// Code and ROM data sections are stored in the image at their addresses in the program memory space,
+// which includes the user IDs, the configuration bytes and the data EEPROM. Data sections are in data memory,
+// they are initialized by the startup code of the program. The line numbers of the sections are stored
+// in the firmware's line table, there is no DWARF debug information.
+func ParseCOFF(coffFile []byte) (*Firmware, error) {
+	r := &coffReader{data: coffFile, files: make(map[uint32]string)}
+	header, err := r.bytes(0, coffFileHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+
+	switch magic := le16(header); magic {
+	case coffMagicV1:
+		r.version = 1
+	case coffMagicV2:
+		r.version = 2
+	default:
+		return nil, fmt.Errorf("not a Microchip COFF file (magic 0x%04X)", magic)
+	}
+
+	nSections := uint32(le16(header[2:]))
+	symbolsOffset, nSymbols := le32(header[8:]), le32(header[12:])
+	optionalSize := uint32(le16(header[16:]))
+	if optionalSize >= 2 {
+		optional, err := r.bytes(coffFileHeaderSize, optionalSize)
+		if err != nil {
+			return nil, err
+		}
+		if magic := le16(optional); magic != coffOptMagic {
+			return nil, fmt.Errorf("unknown optional header (magic 0x%04X)", magic)
+		}
+	}
+
+	symbolSize := uint32(coffSymbolSizeV1)
+	if r.version == 2 {
+		symbolSize = coffSymbolSizeV2
+	}
+	// A corrupt symbol count could make the size of the table wrap around to a small number.
+	if uint64(nSymbols)*uint64(symbolSize) > uint64(len(r.data)) {
+		return nil, errors.New("COFF file is truncated")
+	}
+	symbols, err := r.bytes(symbolsOffset, nSymbols*symbolSize)
+	if err != nil {
+		return nil, err
+	}
+	if nSymbols > 0 {
+		stringsOffset := symbolsOffset + nSymbols*symbolSize
+		if size, err := r.bytes(stringsOffset, 4); err == nil {
+			if r.strings, err = r.bytes(stringsOffset, le32(size)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sections := make([]coffSection, nSections)
+	for i := range sections {
+		header, err := r.bytes(coffFileHeaderSize+optionalSize+uint32(i)*coffSectionHeaderSize, coffSectionHeaderSize)
+		if err != nil {
+			return nil, err
+		}
+		sections[i] = coffSection{
+			name:  r.name(header),
+			addr:  le32(header[8:]),
+			size:  le32(header[16:]),
+			data:  le32(header[20:]),
+			lines: le32(header[28:]),
+			nLine: le16(header[34:]),
+			flags: le32(header[36:]),
+		}
+	}
+
+	firmware := &Firmware{Image: *NewImage()}
+	for i := uint32(0); i < nSymbols; i++ {
+		entry := symbols[i*symbolSize : (i+1)*symbolSize]
+		if symbol, ok := r.symbol(i, entry, symbols, sections); ok {
+			firmware.Symbols = append(firmware.Symbols, symbol)
+		}
+		i += uint32(entry[symbolSize-1])
+	}
+	slices.SortStableFunc(firmware.Symbols, func(a, b Symbol) int {
+		if a.Space != b.Space {
+			return int(a.Space) - int(b.Space)
+		}
+		return int(a.Addr) - int(b.Addr)
+	})
+
+	for _, section := range sections {
+		if section.flags&(coffData|coffBSS) != 0 || section.data == 0 || section.size == 0 {
+			continue
+		}
+
+		data, err := r.bytes(section.data, section.size)
+		if err != nil {
+			return nil, fmt.Errorf("section %s: %w", section.name, err)
+		}
+		if err := firmware.Write(section.addr, data); err != nil {
+			return nil, fmt.Errorf("section %s: %w", section.name, err)
+		}
+
+		lines, err := r.lines(section)
+		if err != nil {
+			return nil, fmt.Errorf("section %s: %w", section.name, err)
+		}
+		firmware.lines = append(firmware.lines, lines...)
+	}
+	return firmware, nil
+}
+
+// symbol converts the functions, variables and labels of the symbol table, other symbols are skipped.
+// The names of source files are remembered for the line numbers.
+func (r *coffReader) symbol(index uint32, entry, symbols []byte, sections []coffSection) (Symbol, bool) {
+	symbolSize := uint32(len(entry))
+	class := entry[symbolSize-2]
+	if class == coffClassFile {
+		// The name of the file is in the auxiliary entry.
+		if entry[symbolSize-1] > 0 && (index+2)*symbolSize <= uint32(len(symbols)) {
+			r.files[index] = r.string(le32(symbols[(index+1)*symbolSize:]))
+		}
+		return Symbol{}, false
+	}
+	if class != coffClassExternal && class != coffClassStatic && class != coffClassLabel {
+		return Symbol{}, false
+	}
+
+	sectionNumber := int16(le16(entry[12:]))
+	name := r.name(entry)
+	if name == "" || sectionNumber <= 0 || int(sectionNumber) > len(sections) {
+		return Symbol{}, false
+	}
+	section := sections[sectionNumber-1]
+
+	// The first derived type follows the basic type, which has 4 bits in version 1 and 5 bits in version 2.
+	derived := (le16(entry[14:]) >> 4) & 0x3
+	if r.version == 2 {
+		derived = uint16(le32(entry[14:])>>5) & 0x7
+	}
+
+	space, kind := ProgramSpace, SymbolObject
+	if section.flags&(coffData|coffBSS) != 0 {
+		space = DataSpace
+	}
+	if derived == coffFunction || section.flags&coffText != 0 {
+		kind = SymbolFunction
+	}
+
+	return Symbol{
+		Name:    name,
+		Kind:    kind,
+		Space:   space,
+		Addr:    le32(entry[8:]),
+		Section: section.name,
+	}, true
+}
+
+// lines reads the line numbers of a section. Each line ends at the next address with a line number.
+func (r *coffReader) lines(section coffSection) ([]LineEntry, error) {
+	if section.nLine == 0 {
+		return nil, nil
+	}
+	data, err := r.bytes(section.lines, uint32(section.nLine)*coffLineSize)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]LineEntry, 0, section.nLine)
+	for i := range uint32(section.nLine) {
+		line := data[i*coffLineSize:]
+		entries = append(entries, LineEntry{
+			Start:  le32(line[6:]),
+			File:   r.files[le32(line)],
+			Line:   int(le16(line[4:])),
+			IsStmt: true,
+		})
+	}
+
+	slices.SortStableFunc(entries, func(a, b LineEntry) int {
+		return int(a.Start) - int(b.Start)
+	})
+	for i := range entries {
+		entries[i].End = section.addr + section.size
+		for _, next := range entries[i+1:] {
+			if next.Start > entries[i].Start {
+				entries[i].End = next.Start
+				break
+			}
+		}
+	}
+	return entries, nil
+}
+
+// ReadCOFFFile is a convenience function to read a file and parse it using [ParseCOFF]
+func ReadCOFFFile(filename string) (*Firmware, error) {
+	coffFile, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCOFF(coffFile)
+}
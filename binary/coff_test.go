@@ -0,0 +1,148 @@
+package binary
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+// Offsets of the parts of the file built by testCOFF.
+const (
+	testCOFFSections = coffFileHeaderSize + 18
+	testCOFFData     = testCOFFSections + coffSectionHeaderSize
+	testCOFFLines    = testCOFFData + 4
+	testCOFFSymbols  = testCOFFLines + 2*coffLineSize
+)
+
+// testCOFF builds a version 2 COFF file with a code section, its line numbers,
+// a .file symbol, a function and a label with a long name.
+func testCOFF() []byte {
+	le := binary.LittleEndian
+	var file []byte
+
+	file = le.AppendUint16(file, coffMagicV2)
+	file = le.AppendUint16(file, 1)
+	file = le.AppendUint32(file, 0)
+	file = le.AppendUint32(file, testCOFFSymbols)
+	file = le.AppendUint32(file, 4)
+	file = le.AppendUint16(file, 18)
+	file = le.AppendUint16(file, 0)
+
+	optional := make([]byte, 18)
+	le.PutUint16(optional, coffOptMagic)
+	file = append(file, optional...)
+
+	section := make([]byte, coffSectionHeaderSize)
+	copy(section, ".code")
+	le.PutUint32(section[16:], 4)
+	le.PutUint32(section[20:], testCOFFData)
+	le.PutUint32(section[28:], testCOFFLines)
+	le.PutUint16(section[34:], 2)
+	le.PutUint32(section[36:], coffText)
+	file = append(file, section...)
+
+	// MOVLW 0x42, SLEEP
+	file = append(file, 0x42, 0x0E, 0x03, 0x00)
+
+	for i, line := range []uint16{3, 4} {
+		entry := make([]byte, coffLineSize)
+		le.PutUint16(entry[4:], line)
+		le.PutUint32(entry[6:], uint32(i*2))
+		file = append(file, entry...)
+	}
+
+	symbol := func(name string, stringOffset, value uint32, section int16, class, aux uint8) {
+		entry := make([]byte, coffSymbolSizeV2)
+		if name == "" {
+			le.PutUint32(entry[4:], stringOffset)
+		} else {
+			copy(entry, name)
+		}
+		le.PutUint32(entry[8:], value)
+		le.PutUint16(entry[12:], uint16(section))
+		entry[18], entry[19] = class, aux
+		file = append(file, entry...)
+	}
+	symbol(".file", 0, 0, -2, coffClassFile, 1)
+	auxiliary := make([]byte, coffSymbolSizeV2)
+	le.PutUint32(auxiliary, 4)
+	file = append(file, auxiliary...)
+	symbol("main", 0, 0, 1, coffClassExternal, 0)
+	symbol("", 13, 2, 1, coffClassLabel, 0)
+
+	strings := []byte("\x00\x00\x00\x00main.asm\x00long_label\x00")
+	le.PutUint32(strings, uint32(len(strings)))
+	return append(file, strings...)
+}
+
+func TestParseCOFF(t *testing.T) {
+	firmware, err := ParseCOFF(testCOFF())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []byte{0x42, 0x0E, 0x03, 0x00}; !bytes.Equal(firmware.Program, want) {
+		t.Errorf("program memory is % X, want % X", firmware.Program, want)
+	}
+
+	want := []Symbol{
+		{Name: "main", Kind: SymbolFunction, Space: ProgramSpace, Addr: 0, Section: ".code"},
+		{Name: "long_label", Kind: SymbolFunction, Space: ProgramSpace, Addr: 2, Section: ".code"},
+	}
+	if !slices.Equal(firmware.Symbols, want) {
+		t.Errorf("symbols are\n%+v\nwant\n%+v", firmware.Symbols, want)
+	}
+
+	lines, err := firmware.LineTable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLines := []LineEntry{
+		{Start: 0, End: 2, File: "main.asm", Line: 3, IsStmt: true},
+		{Start: 2, End: 4, File: "main.asm", Line: 4, IsStmt: true},
+	}
+	if !slices.Equal(lines.Entries(), wantLines) {
+		t.Errorf("line entries are\n%+v\nwant\n%+v", lines.Entries(), wantLines)
+	}
+}
+
+func TestParseCOFFTruncated(t *testing.T) {
+	file := testCOFF()
+	for n := range len(file) {
+		// Files cut in the string table lose the long names, but are otherwise valid.
+		_, err := ParseCOFF(file[:n])
+		if err == nil && n < testCOFFSymbols+4*coffSymbolSizeV2 {
+			t.Errorf("a file truncated to %d bytes was parsed", n)
+		}
+	}
+}
+
+func TestParseCOFFMalformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(file []byte)
+	}{
+		{"magic", func(file []byte) { binary.LittleEndian.PutUint16(file, 0x1235) }},
+		{"optional header magic", func(file []byte) { binary.LittleEndian.PutUint16(file[coffFileHeaderSize:], 0) }},
+		{"optional header size", func(file []byte) { binary.LittleEndian.PutUint16(file[16:], 0xFFFF) }},
+		{"section count", func(file []byte) { binary.LittleEndian.PutUint16(file[2:], 0xFFFF) }},
+		{"section data", func(file []byte) { binary.LittleEndian.PutUint32(file[testCOFFSections+20:], 0xFFFFFFF0) }},
+		{"section size", func(file []byte) { binary.LittleEndian.PutUint32(file[testCOFFSections+16:], 0xFFFFFFFF) }},
+		{"section address", func(file []byte) { binary.LittleEndian.PutUint32(file[testCOFFSections+8:], 0xFFFFFFFE) }},
+		{"line numbers", func(file []byte) { binary.LittleEndian.PutUint16(file[testCOFFSections+34:], 0xFFFF) }},
+		{"symbol table offset", func(file []byte) { binary.LittleEndian.PutUint32(file[8:], 0xFFFFFFFF) }},
+		// The size of 0x40000004 symbols of 20 bytes wraps around to the size of the 4 symbols in 32 bits.
+		{"symbol count", func(file []byte) { binary.LittleEndian.PutUint32(file[12:], 0x40000004) }},
+		{"symbol count", func(file []byte) { binary.LittleEndian.PutUint32(file[12:], 0xCCCCCCCD) }},
+		{"symbol count", func(file []byte) { binary.LittleEndian.PutUint32(file[12:], 0xFFFFFFFF) }},
+	}
+
+	for _, test := range tests {
+		file := testCOFF()
+		test.modify(file)
+		if _, err := ParseCOFF(file); err == nil {
+			t.Errorf("a file with an invalid %s was parsed", test.name)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package binary
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/natk64/go-pic-emu/pic18"
+)
+
+// Device describes the memories of a PIC18 device that are written by a programmer.
+// All devices have the user IDs and the configuration bytes.
+type Device struct {
+	Name string
+
+	// ProgramSize is the size of the program flash in bytes.
+	ProgramSize uint32
+
+	// EEPROMSize is the size of the data EEPROM in bytes, it is 0 for devices without one.
+	EEPROMSize uint32
+}
+
+// Devices are the devices with the peripherals of the emulator, the PIC18(L)F2x/4xK22 family.
+var Devices = []Device{
+	{Name: "PIC18F23K22", ProgramSize: 0x2000, EEPROMSize: 256},
+	{Name: "PIC18F24K22", ProgramSize: 0x4000, EEPROMSize: 256},
+	{Name: "PIC18F25K22", ProgramSize: 0x8000, EEPROMSize: 256},
+	{Name: "PIC18F26K22", ProgramSize: 0x10000, EEPROMSize: 1024},
+	{Name: "PIC18F43K22", ProgramSize: 0x2000, EEPROMSize: 256},
+	{Name: "PIC18F44K22", ProgramSize: 0x4000, EEPROMSize: 256},
+	{Name: "PIC18F45K22", ProgramSize: 0x8000, EEPROMSize: 256},
+	{Name: "PIC18F46K22", ProgramSize: 0x10000, EEPROMSize: 1024},
+}
+
+// DefaultDevice is the device with the largest memories, which fits every program for the emulator.
+var DefaultDevice, _ = LookupDevice("PIC18F46K22")
+
+// LookupDevice returns the device with the given name, ignoring case. The LF variants have the same memories.
+func LookupDevice(name string) (Device, bool) {
+	name = strings.Replace(strings.ToUpper(name), "LF", "F", 1)
+	for _, device := range Devices {
+		if device.Name == name {
+			return device, true
+		}
+	}
+	return Device{}, false
+}
+
+// Contains returns true if the device implements addr in the program memory space:
+// the program flash, the user IDs, the configuration bytes or the data EEPROM.
+func (device Device) Contains(addr uint32) bool {
+	switch {
+	case addr < IDAddress:
+		return addr < device.ProgramSize
+	case addr < IDAddress+IDSize:
+		return true
+	case addr >= pic18.ConfigAddress && addr < pic18.ConfigAddress+pic18.ConfigSize:
+		return true
+	case addr >= EEPROMAddress:
+		return addr-EEPROMAddress < device.EEPROMSize
+	}
+	return false
+}
+
+// Validate returns an error if the image has data at an address that the device doesn't implement.
+func (device Device) Validate(image *Image) error {
+	if err := device.check(0, len(image.Program)); err != nil {
+		return err
+	}
+	if err := device.check(IDAddress, len(image.IDs)); err != nil {
+		return err
+	}
+	return device.check(EEPROMAddress, len(image.EEPROM))
+}
+
+// check returns an error for the first byte of [addr, addr+n) that the device doesn't implement.
+func (device Device) check(addr uint32, n int) error {
+	for i := range uint32(n) {
+		if device.Contains(addr + i) {
+			continue
+		}
+
+		addr += i
+		switch {
+		case addr < IDAddress:
+			return fmt.Errorf("address 0x%06X is beyond the %d KiB of program memory of the %s", addr, device.ProgramSize/1024, device.Name)
+		case addr >= EEPROMAddress && device.EEPROMSize > 0:
+			return fmt.Errorf("address 0x%06X is beyond the %d bytes of data EEPROM of the %s", addr, device.EEPROMSize, device.Name)
+		}
+		return fmt.Errorf("address 0x%06X isn't implemented by the %s", addr, device.Name)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package binary
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/natk64/go-pic-emu/pic18"
+)
+
+func TestLookupDevice(t *testing.T) {
+	for _, name := range []string{"PIC18F25K22", "pic18f25k22", "PIC18LF25K22"} {
+		device, ok := LookupDevice(name)
+		if !ok || device.Name != "PIC18F25K22" || device.ProgramSize != 0x8000 {
+			t.Errorf("LookupDevice(%s) = %+v, %v, want the PIC18F25K22", name, device, ok)
+		}
+	}
+	if _, ok := LookupDevice("PIC18F4550"); ok {
+		t.Error("found a device that isn't emulated")
+	}
+}
+
+func TestDeviceContains(t *testing.T) {
+	device, _ := LookupDevice("PIC18F23K22")
+	tests := []struct {
+		addr uint32
+		want bool
+	}{
+		{0x0000, true},
+		{0x1FFF, true},
+		{0x2000, false},
+		{IDAddress + IDSize - 1, true},
+		{IDAddress + IDSize, false},
+		{pic18.ConfigAddress, true},
+		{pic18.ConfigAddress + pic18.ConfigSize, false},
+		{EEPROMAddress + 255, true},
+		{EEPROMAddress + 256, false},
+	}
+	for _, test := range tests {
+		if got := device.Contains(test.addr); got != test.want {
+			t.Errorf("Contains(0x%06X) = %v, want %v", test.addr, got, test.want)
+		}
+	}
+}
+
+func TestDeviceValidate(t *testing.T) {
+	device, _ := LookupDevice("PIC18F23K22")
+	tests := []struct {
+		name  string
+		write uint32
+		size  int
+		err   string
+	}{
+		{"full flash", 0, 0x2000, ""},
+		{"program", 0x1FFF, 2, "address 0x002000 is beyond the 8 KiB of program memory of the PIC18F23K22"},
+		{"IDs", IDAddress, IDSize, ""},
+		{"EEPROM", EEPROMAddress + 200, 100, "address 0xF00100 is beyond the 256 bytes of data EEPROM of the PIC18F23K22"},
+	}
+
+	for _, test := range tests {
+		image := NewImage()
+		if err := image.Write(test.write, make([]byte, test.size)); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		err := device.Validate(image)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: got the error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
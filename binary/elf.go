@@ -0,0 +1,192 @@
+package binary
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// SymbolKind tells whether a symbol is a function or a variable.
+type SymbolKind uint8
+
+const (
+	SymbolFunction SymbolKind = iota
+	SymbolObject
+)
+
+// Space is the address space of a symbol.
+type Space uint8
+
+const (
+	ProgramSpace Space = iota
+	DataSpace
+)
+
+// Symbol is a function or a variable of a firmware.
+type Symbol struct {
+	Name  string
+	Kind  SymbolKind
+	Space Space
+
+	// Addr is the byte address in the symbol's address space, Size may be 0 if it is unknown.
+	Addr uint32
+	Size uint32
+
+	// Section is the name of the section that contains the symbol.
+	Section string
+}
+
+// Bank returns the data memory bank of a symbol in [DataSpace].
+func (symbol Symbol) Bank() int {
+	return int(symbol.Addr >> 8)
+}
+
+// Contains returns true if addr belongs to the symbol.
+func (symbol Symbol) Contains(addr uint32) bool {
+	return addr >= symbol.Addr && addr-symbol.Addr < max(symbol.Size, 1)
+}
+
+// Firmware is a program with its symbols and debug information.
+type Firmware struct {
+	Image
+
+	// Symbols are sorted by address space and address.
+	Symbols []Symbol
+
+	// DWARF is the debug information, it is nil if the file has none.
+	DWARF *dwarf.Data
+
+	// lines are the line numbers of formats without DWARF.
+	lines []LineEntry
+}
+
+// Lookup returns the symbol with the given name.
+func (firmware *Firmware) Lookup(name string) (Symbol, bool) {
+	for _, symbol := range firmware.Symbols {
+		if symbol.Name == name {
+			return symbol, true
+		}
+	}
+	return Symbol{}, false
+}
+
+// Labels returns the names of the functions by address.
+func (firmware *Firmware) Labels() map[uint32]string {
+	labels := make(map[uint32]string)
+	for _, symbol := range firmware.Symbols {
+		if symbol.Kind == SymbolFunction {
+			labels[symbol.Addr] = symbol.Name
+		}
+	}
+	return labels
+}
+
+// Variables returns the names of the variables in data memory by address.
+func (firmware *Firmware) Variables() map[uint16]string {
+	variables := make(map[uint16]string)
+	for _, symbol := range firmware.Symbols {
+		if symbol.Kind == SymbolObject && symbol.Space == DataSpace {
+			variables[uint16(symbol.Addr)] = symbol.Name
+		}
+	}
+	return variables
+}
+
+// ParseELF parses an ELF file created by XC8 (v2 or newer) for a PIC18.
+//
+// The contents of the allocated, read-only sections are stored in the image at their addresses in the
+// program memory space, which includes the user IDs, the configuration bytes and the data EEPROM.
+// Writable sections are in data memory, they are initialized by the startup code of the program.
+func ParseELF(elfFile []byte) (*Firmware, error) {
+	file, err := elf.NewFile(bytes.NewReader(elfFile))
+	if err != nil {
+		return nil, err
+	}
+
+	if file.Machine != elf.EM_MCHP_PIC {
+		return nil, fmt.Errorf("ELF file is for %v, not for a PIC", file.Machine)
+	}
+
+	firmware := &Firmware{Image: *NewImage()}
+	for _, section := range file.Sections {
+		if section.Type != elf.SHT_PROGBITS || section.Flags&elf.SHF_ALLOC == 0 || section.Flags&elf.SHF_WRITE != 0 {
+			continue
+		}
+
+		data, err := section.Data()
+		if err != nil {
+			return nil, fmt.Errorf("section %s: %w", section.Name, err)
+		}
+		if err := firmware.Write(uint32(section.Addr), data); err != nil {
+			return nil, fmt.Errorf("section %s: %w", section.Name, err)
+		}
+	}
+
+	symbols, err := file.Symbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return nil, err
+	}
+	for _, sym := range symbols {
+		symbol, ok := elfSymbol(file, sym)
+		if ok {
+			firmware.Symbols = append(firmware.Symbols, symbol)
+		}
+	}
+	slices.SortStableFunc(firmware.Symbols, func(a, b Symbol) int {
+		if a.Space != b.Space {
+			return int(a.Space) - int(b.Space)
+		}
+		return int(a.Addr) - int(b.Addr)
+	})
+
+	if file.Section(".debug_info") != nil {
+		if firmware.DWARF, err = file.DWARF(); err != nil {
+			return nil, fmt.Errorf("reading debug information: %w", err)
+		}
+	}
+	return firmware, nil
+}
+
+// elfSymbol converts the functions and variables of an ELF symbol table, other symbols are skipped.
+func elfSymbol(file *elf.File, sym elf.Symbol) (Symbol, bool) {
+	var kind SymbolKind
+	switch elf.ST_TYPE(sym.Info) {
+	case elf.STT_FUNC:
+		kind = SymbolFunction
+	case elf.STT_OBJECT:
+		kind = SymbolObject
+	default:
+		return Symbol{}, false
+	}
+
+	if sym.Name == "" || sym.Section == elf.SHN_UNDEF || int(sym.Section) >= len(file.Sections) {
+		return Symbol{}, false
+	}
+
+	section := file.Sections[sym.Section]
+	space := ProgramSpace
+	if section.Flags&elf.SHF_WRITE != 0 && section.Flags&elf.SHF_EXECINSTR == 0 {
+		space = DataSpace
+	}
+
+	return Symbol{
+		Name:    sym.Name,
+		Kind:    kind,
+		Space:   space,
+		Addr:    uint32(sym.Value),
+		Size:    uint32(sym.Size),
+		Section: section.Name,
+	}, true
+}
+
+// ReadELFFile is a convenience function to read a file and parse it using [ParseELF]
+func ReadELFFile(filename string) (*Firmware, error) {
+	elfFile, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseELF(elfFile)
+}
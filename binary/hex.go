@@ -30,14 +30,36 @@ func ReadHexFile(filename string) ([]byte, error) {
 	return ParseHex(hexData)
 }
 
-// ParseIHex parses a ihex format file
+// ParseIHex parses a ihex format file and returns the program memory of the [DefaultDevice].
+// Bytes that aren't present in the file are erased (0xFF).
 func ParseIHex(hexFile []byte) ([]byte, error) {
+	image, err := ParseIHexImage(hexFile, DefaultDevice)
+	if err != nil {
+		return nil, err
+	}
+	return append(image.Program, bytes.Repeat([]byte{0xFF}, int(DefaultDevice.ProgramSize)-len(image.Program))...), nil
+}
+
+// ParseIHexImage parses a ihex format file with 32 bit addresses (extended linear address records)
+// into the memories of a device. Data at addresses the device doesn't implement is an error.
+// Unlike [ParseIHex], the program memory isn't padded to the device's ProgramSize, it ends after
+// the last byte in the file like in the other formats.
+func ParseIHexImage(hexFile []byte, device Device) (*Image, error) {
 	mem := gohex.NewMemory()
 	if err := mem.ParseIntelHex(bytes.NewReader(hexFile)); err != nil {
 		return nil, err
 	}
 
-	return mem.ToBinary(0, 0xFFFF, 0), nil
+	image := NewImage()
+	for _, segment := range mem.GetDataSegments() {
+		if err := device.check(segment.Address, len(segment.Data)); err != nil {
+			return nil, err
+		}
+		if err := image.Write(segment.Address, segment.Data); err != nil {
+			return nil, err
+		}
+	}
+	return image, nil
 }
 
 // ReadIHexFile is a convenience function to read a file and parse it using [ParseIHex]
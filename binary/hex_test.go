@@ -0,0 +1,72 @@
+package binary
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// ihexRecord formats an Intel HEX record with its checksum.
+func ihexRecord(kind byte, addr uint16, data ...byte) string {
+	record := append([]byte{byte(len(data)), byte(addr >> 8), byte(addr), kind}, data...)
+	var sum byte
+	for _, b := range record {
+		sum += b
+	}
+	return fmt.Sprintf(":%X\n", append(record, -sum))
+}
+
+// ihexFile returns an Intel HEX file with the records, followed by an end of file record.
+func ihexFile(records ...string) []byte {
+	return []byte(strings.Join(records, "") + ihexRecord(0x01, 0))
+}
+
+func TestParseIHexImage(t *testing.T) {
+	file := ihexFile(
+		ihexRecord(0x00, 0x0004, 0x42, 0x0E),
+		// Extended linear address 0x30 for the configuration bytes.
+		ihexRecord(0x04, 0, 0x00, 0x30),
+		ihexRecord(0x00, 0x0001, 0x12),
+		ihexRecord(0x04, 0, 0x00, 0xF0),
+		ihexRecord(0x00, 0x0000, 0x55),
+	)
+
+	image, err := ParseIHexImage(file, DefaultDevice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x42, 0x0E}; !bytes.Equal(image.Program, want) {
+		t.Errorf("program memory is % X, want % X", image.Program, want)
+	}
+	if image.Config[1] != 0x12 || image.Config[0] != NewImage().Config[0] {
+		t.Errorf("the configuration is % X, want the defaults with 0x12 at CONFIG1H", image.Config)
+	}
+	if !bytes.Equal(image.EEPROM, []byte{0x55}) {
+		t.Errorf("the EEPROM is % X, want 55", image.EEPROM)
+	}
+
+	// ParseIHex pads the program memory to the size of the flash.
+	program, err := ParseIHex(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(program) != int(DefaultDevice.ProgramSize) || program[4] != 0x42 || program[6] != 0xFF {
+		t.Errorf("ParseIHex returned %d bytes, want the program padded to %d bytes", len(program), DefaultDevice.ProgramSize)
+	}
+}
+
+func TestParseIHexImageDevice(t *testing.T) {
+	small, _ := LookupDevice("PIC18F23K22")
+	file := ihexFile(ihexRecord(0x00, 0x2000, 0x00))
+
+	if _, err := ParseIHexImage(file, DefaultDevice); err != nil {
+		t.Errorf("the PIC18F46K22 rejected a program at 0x2000: %v", err)
+	}
+	if _, err := ParseIHexImage(file, small); err == nil {
+		t.Error("the PIC18F23K22 accepted a program at 0x2000")
+	}
+	if _, err := ParseIHexImage([]byte(":00000001FE\n"), small); err == nil {
+		t.Error("a record with a bad checksum was accepted")
+	}
+}
@@ -0,0 +1,79 @@
+package binary
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/natk64/go-pic-emu/pic18"
+)
+
+// Addresses of the memory regions in the program memory space of a firmware file.
+// The configuration bytes are at [pic18.ConfigAddress].
+const (
+	IDAddress     = 0x200000
+	EEPROMAddress = 0xF00000
+)
+
+// Sizes of the regions of an [Image].
+const (
+	IDSize        = 8
+	MaxEEPROMSize = 0x10000
+)
+
+// Image is the content of the memories that are written by a programmer.
+// Bytes that aren't present in the firmware file have their erased value,
+// which is 0xFF for everything except the configuration bytes.
+type Image struct {
+	// Program is the program memory starting at address 0, it ends after the last byte in the file
+	// rather than at the end of the device's flash, so its length tells where the program ends.
+	Program []byte
+
+	// IDs are the user ID locations, they are nil if the file doesn't contain any.
+	IDs []byte
+
+	// Config are the configuration bytes, missing bytes are taken from [pic18.DefaultConfigWords].
+	Config []byte
+
+	// EEPROM is the data EEPROM, it is nil if the file doesn't contain any
+	// and ends after the last byte in the file otherwise.
+	EEPROM []byte
+}
+
+// NewImage returns an image of an erased device.
+func NewImage() *Image {
+	return &Image{Config: append([]byte(nil), pic18.DefaultConfigWords[:]...)}
+}
+
+// Write stores data at an address of the program memory space, the data may span several regions.
+func (image *Image) Write(addr uint32, data []byte) error {
+	for len(data) > 0 {
+		region, offset, size, err := image.region(addr)
+		if err != nil {
+			return err
+		}
+
+		n := min(len(data), size-offset)
+		if len(*region) < offset+n {
+			*region = append(*region, bytes.Repeat([]byte{0xFF}, offset+n-len(*region))...)
+		}
+		copy((*region)[offset:], data[:n])
+		data = data[n:]
+		addr += uint32(n)
+	}
+	return nil
+}
+
+// region returns the region that contains addr, the offset of addr in it and the maximum size of the region.
+func (image *Image) region(addr uint32) (region *[]byte, offset, size int, err error) {
+	switch {
+	case addr < IDAddress:
+		return &image.Program, int(addr), IDAddress, nil
+	case addr < IDAddress+IDSize:
+		return &image.IDs, int(addr - IDAddress), IDSize, nil
+	case addr >= pic18.ConfigAddress && addr < pic18.ConfigAddress+pic18.ConfigSize:
+		return &image.Config, int(addr - pic18.ConfigAddress), pic18.ConfigSize, nil
+	case addr >= EEPROMAddress && addr < EEPROMAddress+MaxEEPROMSize:
+		return &image.EEPROM, int(addr - EEPROMAddress), MaxEEPROMSize, nil
+	}
+	return nil, 0, 0, fmt.Errorf("address 0x%06X is outside of the program memory space", addr)
+}
@@ -0,0 +1,67 @@
+package binary
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/natk64/go-pic-emu/pic18"
+)
+
+func TestImageWrite(t *testing.T) {
+	image := NewImage()
+	if !bytes.Equal(image.Config, pic18.DefaultConfigWords[:]) {
+		t.Errorf("an erased image has the configuration % X, want the defaults", image.Config)
+	}
+
+	writes := []struct {
+		addr uint32
+		data []byte
+	}{
+		{0x0004, []byte{0x12, 0x34}},
+		{0x0000, []byte{0xAB}},
+		{IDAddress + 1, []byte{0x01}},
+		{pic18.ConfigAddress + 1, []byte{0x22}},
+		{EEPROMAddress + 2, []byte{0x55}},
+	}
+	for _, write := range writes {
+		if err := image.Write(write.addr, write.data); err != nil {
+			t.Fatalf("writing 0x%06X: %v", write.addr, err)
+		}
+	}
+
+	// Gaps are filled with the erased value.
+	if want := []byte{0xAB, 0xFF, 0xFF, 0xFF, 0x12, 0x34}; !bytes.Equal(image.Program, want) {
+		t.Errorf("program memory is % X, want % X", image.Program, want)
+	}
+	if want := []byte{0xFF, 0x01}; !bytes.Equal(image.IDs, want) {
+		t.Errorf("the IDs are % X, want % X", image.IDs, want)
+	}
+	want := pic18.DefaultConfigWords
+	want[1] = 0x22
+	if !bytes.Equal(image.Config, want[:]) {
+		t.Errorf("the configuration is % X, want % X", image.Config, want)
+	}
+	if want := []byte{0xFF, 0xFF, 0x55}; !bytes.Equal(image.EEPROM, want) {
+		t.Errorf("the EEPROM is % X, want % X", image.EEPROM, want)
+	}
+}
+
+func TestImageWriteRegions(t *testing.T) {
+	// A write that crosses the end of the IDs continues at the next address, which isn't in any region.
+	image := NewImage()
+	if err := image.Write(IDAddress+IDSize-1, []byte{0x01, 0x02}); err == nil {
+		t.Error("a write past the IDs succeeded")
+	}
+	if len(image.IDs) != IDSize || image.IDs[IDSize-1] != 0x01 {
+		t.Errorf("the IDs are % X, want the last one written", image.IDs)
+	}
+
+	for _, addr := range []uint32{IDAddress + IDSize, pic18.ConfigAddress + pic18.ConfigSize, EEPROMAddress + MaxEEPROMSize} {
+		if err := image.Write(addr, []byte{0}); err == nil {
+			t.Errorf("writing 0x%06X succeeded", addr)
+		}
+	}
+	if err := image.Write(0, nil); err != nil {
+		t.Errorf("writing nothing failed: %v", err)
+	}
+}
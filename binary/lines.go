@@ -0,0 +1,161 @@
+package binary
+
+import (
+	"debug/dwarf"
+	"io"
+	"path/filepath"
+	"slices"
+)
+
+// LineEntry maps the program memory addresses [Start, End) to a line of a source file.
+type LineEntry struct {
+	Start, End uint32
+
+	File string
+	Line int
+
+	// IsStmt is true if the entry starts a statement, which is where breakpoints and steps stop.
+	IsStmt bool
+}
+
+// LineTable maps program memory addresses to source lines.
+type LineTable struct {
+	// entries are sorted by address and don't overlap.
+	entries []LineEntry
+}
+
+// NewLineTable creates a line table. Adjacent entries for the same line are merged,
+// because the instructions of a statement are often split into several entries.
+func NewLineTable(entries []LineEntry) *LineTable {
+	sorted := slices.Clone(entries)
+	slices.SortStableFunc(sorted, func(a, b LineEntry) int {
+		return int(a.Start) - int(b.Start)
+	})
+
+	table := &LineTable{}
+	for _, entry := range sorted {
+		if entry.End <= entry.Start || entry.Line == 0 {
+			continue
+		}
+
+		if n := len(table.entries); n > 0 {
+			last := &table.entries[n-1]
+			if last.End == entry.Start && last.File == entry.File && last.Line == entry.Line {
+				last.End = entry.End
+				last.IsStmt = last.IsStmt || entry.IsStmt
+				continue
+			}
+			// Overlapping entries are cut, the earlier entry wins.
+			if entry.Start < last.End {
+				if entry.End <= last.End {
+					continue
+				}
+				entry.Start = last.End
+			}
+		}
+		table.entries = append(table.entries, entry)
+	}
+	return table
+}
+
+// LineTable reads the line number information of the DWARF data, or of the COFF file.
+// It returns nil if the firmware has no line numbers.
+func (firmware *Firmware) LineTable() (*LineTable, error) {
+	if firmware.DWARF == nil {
+		if firmware.lines == nil {
+			return nil, nil
+		}
+		return NewLineTable(firmware.lines), nil
+	}
+
+	var entries []LineEntry
+	reader := firmware.DWARF.Reader()
+	for {
+		unit, err := reader.Next()
+		if err != nil {
+			return nil, err
+		}
+		if unit == nil {
+			break
+		}
+		if unit.Tag != dwarf.TagCompileUnit {
+			reader.SkipChildren()
+			continue
+		}
+
+		lines, err := firmware.DWARF.LineReader(unit)
+		if err != nil {
+			return nil, err
+		}
+		reader.SkipChildren()
+		if lines == nil {
+			continue
+		}
+
+		// Every row covers the addresses up to the next row of the same sequence.
+		var row, prev dwarf.LineEntry
+		havePrev := false
+		for {
+			err := lines.Next(&row)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if havePrev && row.Address > prev.Address && prev.File != nil {
+				entries = append(entries, LineEntry{
+					Start:  uint32(prev.Address),
+					End:    uint32(row.Address),
+					File:   prev.File.Name,
+					Line:   prev.Line,
+					IsStmt: prev.IsStmt,
+				})
+			}
+			prev, havePrev = row, !row.EndSequence
+		}
+	}
+	return NewLineTable(entries), nil
+}
+
+// Entries returns the entries of the table, sorted by address.
+func (table *LineTable) Entries() []LineEntry {
+	return table.entries
+}
+
+// Lookup returns the entry that contains addr.
+func (table *LineTable) Lookup(addr uint32) (LineEntry, bool) {
+	i, found := slices.BinarySearchFunc(table.entries, addr, func(entry LineEntry, addr uint32) int {
+		return int(entry.Start) - int(addr)
+	})
+	if !found {
+		if i == 0 {
+			return LineEntry{}, false
+		}
+		i--
+	}
+
+	entry := table.entries[i]
+	return entry, addr < entry.End
+}
+
+// LineAddress returns the first line at or after line of a file that contains code, and the lowest address
+// of its statements. Files match if their paths are equal, otherwise if their names are equal,
+// because the program is usually built in a different directory than the one it is debugged in.
+func (table *LineTable) LineAddress(file string, line int) (int, uint32, bool) {
+	match := func(entryFile string) bool { return filepath.Clean(entryFile) == filepath.Clean(file) }
+	if !slices.ContainsFunc(table.entries, func(entry LineEntry) bool { return match(entry.File) }) {
+		match = func(entryFile string) bool { return filepath.Base(entryFile) == filepath.Base(file) }
+	}
+
+	bestLine, bestAddr, found := 0, uint32(0), false
+	for _, entry := range table.entries {
+		if !entry.IsStmt || entry.Line < line || !match(entry.File) {
+			continue
+		}
+		if !found || entry.Line < bestLine || (entry.Line == bestLine && entry.Start < bestAddr) {
+			bestLine, bestAddr, found = entry.Line, entry.Start, true
+		}
+	}
+	return bestLine, bestAddr, found
+}
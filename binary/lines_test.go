@@ -0,0 +1,99 @@
+package binary
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNewLineTable(t *testing.T) {
+	table := NewLineTable([]LineEntry{
+		{Start: 0x10, End: 0x14, File: "main.c", Line: 5},
+		// Adjacent entries of the same line are merged, out of order entries are sorted.
+		{Start: 0x00, End: 0x04, File: "main.c", Line: 3, IsStmt: true},
+		{Start: 0x04, End: 0x08, File: "main.c", Line: 3},
+		// Empty entries and entries without a line are dropped.
+		{Start: 0x08, End: 0x08, File: "main.c", Line: 4, IsStmt: true},
+		{Start: 0x08, End: 0x0C, File: "main.c", Line: 0},
+		// Overlapping entries are cut or dropped, the earlier one wins.
+		{Start: 0x12, End: 0x18, File: "main.c", Line: 6, IsStmt: true},
+		{Start: 0x12, End: 0x14, File: "main.c", Line: 7},
+		// The same line in another file isn't merged.
+		{Start: 0x18, End: 0x1C, File: "util.c", Line: 6, IsStmt: true},
+	})
+
+	want := []LineEntry{
+		{Start: 0x00, End: 0x08, File: "main.c", Line: 3, IsStmt: true},
+		{Start: 0x10, End: 0x14, File: "main.c", Line: 5},
+		{Start: 0x14, End: 0x18, File: "main.c", Line: 6, IsStmt: true},
+		{Start: 0x18, End: 0x1C, File: "util.c", Line: 6, IsStmt: true},
+	}
+	if entries := table.Entries(); !slices.Equal(entries, want) {
+		t.Errorf("entries are\n%+v\nwant\n%+v", entries, want)
+	}
+}
+
+func TestLineTableLookup(t *testing.T) {
+	table := NewLineTable([]LineEntry{
+		{Start: 0x04, End: 0x08, File: "main.c", Line: 3, IsStmt: true},
+		{Start: 0x0C, End: 0x10, File: "main.c", Line: 4, IsStmt: true},
+	})
+
+	tests := []struct {
+		addr uint32
+		line int
+		ok   bool
+	}{
+		{0x00, 0, false},
+		{0x04, 3, true},
+		{0x07, 3, true},
+		{0x08, 0, false},
+		{0x0C, 4, true},
+		{0x0E, 4, true},
+		{0x10, 0, false},
+	}
+	for _, test := range tests {
+		entry, ok := table.Lookup(test.addr)
+		if ok != test.ok || (ok && entry.Line != test.line) {
+			t.Errorf("Lookup(0x%02X) = line %d, %v, want line %d, %v", test.addr, entry.Line, ok, test.line, test.ok)
+		}
+	}
+
+	if _, ok := NewLineTable(nil).Lookup(0); ok {
+		t.Error("an empty table contains address 0")
+	}
+}
+
+func TestLineAddress(t *testing.T) {
+	table := NewLineTable([]LineEntry{
+		{Start: 0x00, End: 0x04, File: "/build/src/main.c", Line: 3, IsStmt: true},
+		{Start: 0x04, End: 0x08, File: "/build/src/main.c", Line: 5},
+		{Start: 0x08, End: 0x0C, File: "/build/src/main.c", Line: 6, IsStmt: true},
+		{Start: 0x0C, End: 0x10, File: "/build/src/util.c", Line: 6, IsStmt: true},
+		{Start: 0x10, End: 0x14, File: "/build/src/main.c", Line: 3, IsStmt: true},
+	})
+
+	tests := []struct {
+		file string
+		line int
+
+		wantLine int
+		wantAddr uint32
+		ok       bool
+	}{
+		// The lowest address of a line.
+		{"/build/src/main.c", 3, 3, 0x00, true},
+		// Line 4 has no code and line 5 no statement, so the breakpoint moves to line 6.
+		{"/build/src/main.c", 4, 6, 0x08, true},
+		// Files are matched by their name if no path matches.
+		{"/home/user/util.c", 1, 6, 0x0C, true},
+		{"/build/src/main.c", 7, 0, 0, false},
+		{"other.c", 1, 0, 0, false},
+	}
+	for _, test := range tests {
+		line, addr, ok := table.LineAddress(test.file, test.line)
+		if line != test.wantLine || addr != test.wantAddr || ok != test.ok {
+			t.Errorf("LineAddress(%s, %d) = %d, 0x%02X, %v, want %d, 0x%02X, %v",
+				test.file, test.line, line, addr, ok, test.wantLine, test.wantAddr, test.ok)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"github.com/natk64/go-pic-emu/binary"
+	"github.com/natk64/go-pic-emu/pic18/coverage"
+	"github.com/natk64/go-pic-emu/pic18/disasm"
+)
+
+// writeCoverage writes the coverage collected by the machine's CPU.
+// The report lists every instruction of the program, the LCOV file needs the source lines of an assembly source
+// or the line numbers of an ELF or COFF file.
+func writeCoverage(m *machine, info *debugInfo, reportPath, lcovPath string) error {
+	if reportPath != "" {
+		file, err := os.Create(reportPath)
+		if err != nil {
+			return err
+		}
+
+		opts := &disasm.Options{ExtendedSet: m.cpu.BankController.ExtendedSet, Symbols: info.labels}
+		err = coverage.WriteReport(file, m.cpu.Coverage, m.programBus, 0, m.programEnd, opts)
+		if err := errors.Join(err, file.Close()); err != nil {
+			return err
+		}
+	}
+
+	if lcovPath != "" {
+		lines := info.lineTable
+		if lines == nil && len(info.lines) > 0 {
+			lines = assemblyLineTable(info.source, info.lines)
+		}
+		if lines == nil {
+			return errors.New("an LCOV file needs line information, run an assembly source or firmware with debug information")
+		}
+
+		file, err := os.Create(lcovPath)
+		if err != nil {
+			return err
+		}
+
+		opts := &disasm.Options{ExtendedSet: m.cpu.BankController.ExtendedSet, Symbols: info.labels}
+		err = coverage.WriteLCOV(file, m.cpu.Coverage, m.programBus, lines, opts)
+		if err := errors.Join(err, file.Close()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assemblyLineTable converts the line addresses of an assembly source to a line table.
+// Every entry covers the first word of an instruction, which is enough to find the instruction.
+func assemblyLineTable(source string, lines map[int]uint32) *binary.LineTable {
+	entries := make([]binary.LineEntry, 0, len(lines))
+	for line, addr := range lines {
+		entries = append(entries, binary.LineEntry{Start: addr, End: addr + 2, File: source, Line: line, IsStmt: true})
+	}
+	return binary.NewLineTable(entries)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/natk64/go-pic-emu/pic18"
+	"github.com/natk64/go-pic-emu/pic18/dap"
+	"github.com/natk64/go-pic-emu/pic18/debug"
+)
+
+// dapCommand runs a Debug Adapter Protocol server for VS Code.
+// Without -listen, the protocol runs over stdin and stdout.
+//
+// Usage: pic18-emu dap [-listen addr]
+func dapCommand(args []string) {
+	flags := flag.NewFlagSet("dap", flag.ExitOnError)
+	listen := flags.String("listen", "", "TCP address to listen on, e.g. localhost:4711 (default: stdin/stdout)")
+	flags.Parse(args)
+
+	server := &dap.Server{Launch: launchDebug}
+
+	var err error
+	if *listen != "" {
+		log.Printf("waiting for a debug adapter client on %s", *listen)
+		err = server.ListenAndServe(*listen)
+	} else {
+		err = server.Serve(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout})
+	}
+
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// launchDebug starts a program for the debug adapter.
+func launchDebug(args *dap.LaunchArguments, output io.Writer) (*dap.Program, error) {
+	if args.Program == "" {
+		return nil, fmt.Errorf("no program given")
+	}
+
+	m, info, err := loadDebugProgram(args.Program)
+	if err != nil {
+		return nil, err
+	}
+
+	if args.Snapshot != "" {
+		if err := m.loadSnapshot(args.Snapshot); err != nil {
+			return nil, err
+		}
+	}
+
+	m.redirectUARTs(output)
+
+	var history *pic18.History
+	if args.History >= 0 {
+		history = pic18.NewHistory(m.cpu, m.ram)
+		history.Limit = args.History
+	}
+
+	target := debug.New(m.cpu, history)
+	target.Lines = info.lineTable
+	evaluator, err := info.evaluator(target)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dap.Program{
+		Target:    target,
+		Labels:    info.labels,
+		Variables: info.variables,
+		Evaluator: evaluator,
+		Source:    info.source,
+		Lines:     info.lines,
+	}, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/natk64/go-pic-emu/pic18/disasm"
+)
+
+// disasmCommand prints a listing of a program, a HEX, assembly, ELF or COFF file like for the debuggers.
+// The labels of the program, or the call targets of a HEX file, are printed before their instructions.
+//
+// Usage: pic18-emu disasm [-start addr] [-end addr] [-xinst] program
+func disasmCommand(args []string) {
+	flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+	start := flags.Uint("start", 0, "first address to disassemble")
+	end := flags.Uint("end", 0, "end of the disassembled range (exclusive), defaults to the end of the program")
+	xinst := flags.String("xinst", "auto", "extended instruction set: on, off or auto (use the XINST configuration bit)")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: pic18-emu disasm [flags] program")
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+
+	m, info, err := loadDebugProgram(flags.Arg(0))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	opts := &disasm.Options{Symbols: info.labels}
+	switch *xinst {
+	case "on":
+		opts.ExtendedSet = true
+	case "off":
+		opts.ExtendedSet = false
+	default:
+		opts.ExtendedSet = m.config.ExtendedSet
+	}
+
+	if *end == 0 {
+		*end = uint(m.programEnd)
+	}
+
+	for _, line := range disasm.Disassemble(m.program, uint32(*start), uint32(*end), opts) {
+		if name, ok := opts.Symbols[line.Address]; ok {
+			fmt.Printf("%s:\n", name)
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/natk64/go-pic-emu/pic18"
+	"github.com/natk64/go-pic-emu/pic18/debug"
+	"github.com/natk64/go-pic-emu/pic18/gdbserver"
+)
+
+// gdbserverCommand lets a GDB remote serial protocol client debug a program.
+//
+// Usage: pic18-emu gdbserver [-listen addr] [-history n] [-load snapshot] file.hex|file.elf|file.cof
+func gdbserverCommand(args []string) {
+	flags := flag.NewFlagSet("gdbserver", flag.ExitOnError)
+	listen := flags.String("listen", "localhost:3333", "TCP address to listen on")
+	historyLimit := flags.Int("history", pic18.DefaultHistoryLimit, "number of instructions recorded for reverse execution (0 = disabled)")
+	load := flags.String("load", "", "restore the machine from a snapshot before debugging")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: pic18-emu gdbserver [flags] file.hex|file.elf|file.cof")
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+
+	m, _, err := loadDebugProgram(flags.Arg(0))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if *load != "" {
+		if err := m.loadSnapshot(*load); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	var history *pic18.History
+	if *historyLimit > 0 {
+		history = pic18.NewHistory(m.cpu, m.ram)
+		history.Limit = *historyLimit
+	}
+
+	server := &gdbserver.Server{Target: debug.New(m.cpu, history)}
+	log.Printf("waiting for GDB on %s", *listen)
+	err = server.ListenAndServe(*listen)
+	if err != nil && !errors.Is(err, gdbserver.ErrKilled) {
+		log.Fatalln(err)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/natk64/go-pic-emu/binary"
+	"github.com/natk64/go-pic-emu/pic18"
+	"github.com/natk64/go-pic-emu/pic18/asm"
+	"github.com/natk64/go-pic-emu/pic18/disasm"
+	"github.com/natk64/go-pic-emu/pic18/eval"
+	"github.com/natk64/go-pic-emu/pic18/instruction"
+	"github.com/natk64/go-pic-emu/pic18/peripherals/eusart"
+)
+
+// machine is the emulated device with all of its memories and peripherals.
+type machine struct {
+	cpu    *pic18.CPU
+	config pic18.ConfigTable
+
+	ram     pic18.Memory[uint16]
+	program pic18.Memory[uint32]
+
+	// programEnd is the address after the last byte of the loaded program, the rest of the flash is erased.
+	programEnd uint32
+
+	configMem  pic18.Memory[uint32]
+	dataBus    pic18.MultiBusReadWriter[uint16]
+	programBus pic18.MultiBusReadWriter[uint32]
+
+	eusart1 *eusart.EUSART
+	eusart2 *eusart.EUSART
+}
+
+// newMachine creates a machine running the program in an Intel HEX file.
+// The machine is in the Power-on Reset state.
+func newMachine(hexPath string) (*machine, error) {
+	hexFile, err := os.ReadFile(hexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	image, err := binary.ParseIHexImage(hexFile, binary.DefaultDevice)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMachineFromImage(image.Program, image.Config), nil
+}
+
+// newMachineFromImage creates a machine from a program memory image and the configuration bytes.
+// The flash after the end of the program is erased. The machine is in the Power-on Reset state.
+// The user IDs and the data EEPROM of a firmware file aren't mapped, the emulator has no EEPROM module
+// and programs can't read the IDs.
+func newMachineFromImage(program, configWords []byte) *machine {
+	m := &machine{
+		config:     pic18.DecodeConfig(configWords),
+		ram:        pic18.Memory[uint16]{Data: make([]byte, 2048)},
+		program:    pic18.Memory[uint32]{Data: erasedImage(program)},
+		programEnd: uint32(len(program)+1) &^ 1,
+		configMem:  pic18.Memory[uint32]{Offset: pic18.ConfigAddress, Data: configWords},
+	}
+
+	sleep := &pic18.SleepController{}
+	cpu := &pic18.CPU{
+		Config:       &m.config,
+		Stack:        pic18.Stack{Data: make([]uint32, 31)},
+		Sleep:        sleep,
+		Watchdog:     &pic18.WatchdogTimer{Mode: m.config.Watchdog, Postscaler: m.config.WatchdogPostscaler},
+		Interrupts:   pic18.InterruptController{Sleep: sleep},
+		EventHandler: DefaultEventHandler{},
+		Table:        &pic18.TableRWController{Config: &m.config},
+	}
+	m.cpu = cpu
+
+	m.eusart1 = eusart.New(1, &cpu.Interrupts)
+	m.eusart2 = eusart.New(2, &cpu.Interrupts)
+	cpu.Resets.OnReset = func(pic18.ResetCause) {
+		m.eusart1.Reset()
+		m.eusart2.Reset()
+	}
+
+	m.dataBus = pic18.MultiBusReadWriter[uint16]{
+		m.ram,
+		cpu,
+		m.eusart1,
+		m.eusart2,
+		cpu.Table,
+		&cpu.Alu,
+		&cpu.Stack,
+		&cpu.BankController,
+		&cpu.Interrupts,
+		&cpu.Resets,
+		cpu.Watchdog,
+	}
+
+	m.programBus = pic18.MultiBusReadWriter[uint32]{
+		m.program,
+		m.configMem,
+	}
+
+	cpu.DataBus = m.dataBus
+	cpu.ProgramBus = m.programBus
+	cpu.BankController.Bus = m.dataBus
+	cpu.BankController.WReg = &cpu.WReg
+	cpu.Table.ProgramBus = m.programBus
+
+	cpu.PowerOnReset()
+	return m
+}
+
+// redirectUARTs writes everything the EUSARTs transmit to w.
+// Transmissions complete immediately, from the goroutine that runs the CPU.
+func (m *machine) redirectUARTs(w io.Writer) {
+	for _, uart := range []*eusart.EUSART{m.eusart1, m.eusart2} {
+		uart.Transmit = func(data uint8, bit9 bool) {
+			w.Write([]byte{data})
+			uart.TXDone()
+		}
+	}
+}
+
+// debugInfo describes a program for debuggers.
+type debugInfo struct {
+	labels map[uint32]string
+
+	// variables maps data memory addresses to the names of variables, it is empty unless the program is an ELF or COFF file.
+	variables map[uint16]string
+
+	// source is the path of the assembly source and lines maps its lines to addresses,
+	// they are empty for HEX, ELF and COFF files.
+	source string
+	lines  map[int]uint32
+
+	// firmware contains the symbols and the debug information of an ELF or COFF file, it is nil for other programs.
+	firmware *binary.Firmware
+
+	// lineTable maps addresses to the source lines of an ELF or COFF file with debug information, it may be nil.
+	lineTable *binary.LineTable
+}
+
+// functionRange returns the addresses [start, end) of a function.
+// Functions without a known size end at the next label.
+func (info *debugInfo) functionRange(name string) (start, end uint32, ok bool) {
+	if info.firmware != nil {
+		if symbol, ok := info.firmware.Lookup(name); ok && symbol.Kind == binary.SymbolFunction && symbol.Size > 0 {
+			return symbol.Addr, symbol.Addr + symbol.Size, true
+		}
+	}
+
+	for addr, label := range info.labels {
+		if label == name {
+			start, ok = addr, true
+			break
+		}
+	}
+	if !ok {
+		return 0, 0, false
+	}
+
+	end = binary.IDAddress
+	for addr := range info.labels {
+		if addr > start && addr < end {
+			end = addr
+		}
+	}
+	return start, end, true
+}
+
+// evaluator creates an expression evaluator on the memories of a target.
+// It returns nil if the program has no DWARF debug information.
+func (info *debugInfo) evaluator(memory eval.Memory) (*eval.Evaluator, error) {
+	if info.firmware == nil || info.firmware.DWARF == nil {
+		return nil, nil
+	}
+	return eval.New(info.firmware, memory)
+}
+
+// loadDebugProgram creates a machine for debugging a program.
+// Programs ending in .asm are assembled first, so the labels and source lines are known.
+// ELF files (.elf) and COFF files of older toolchains (.cof) provide the symbols of the functions and variables.
+func loadDebugProgram(path string) (*machine, *debugInfo, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".asm":
+		return loadAssembly(path)
+	case ".elf":
+		return loadFirmware(binary.ReadELFFile(path))
+	case ".cof":
+		return loadFirmware(binary.ReadCOFFFile(path))
+	}
+
+	m, err := newMachine(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// There are no labels in a HEX file, name the call targets so call stacks are easier to read.
+	info := &debugInfo{labels: make(map[uint32]string)}
+	for _, line := range disasm.Disassemble(m.program, 0, m.programEnd, nil) {
+		if line.Opcode == instruction.CALL || line.Opcode == instruction.RCALL {
+			info.labels[line.Target] = fmt.Sprintf("sub_%06X", line.Target)
+		}
+	}
+	return m, info, nil
+}
+
+func loadAssembly(path string) (*machine, *debugInfo, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	assembled, err := asm.Assemble(string(source), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	image := &binary.Image{Program: assembled.Image, IDs: assembled.IDs, EEPROM: assembled.EEPROM}
+	if err := binary.DefaultDevice.Validate(image); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	config := pic18.DefaultConfigWords[:]
+	if assembled.Config != nil {
+		config = assembled.Config
+	}
+
+	info := &debugInfo{
+		labels: assembled.Labels(),
+		source: path,
+		lines:  assembled.Lines,
+	}
+	return newMachineFromImage(assembled.Image, config), info, nil
+}
+
+func loadFirmware(firmware *binary.Firmware, err error) (*machine, *debugInfo, error) {
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := binary.DefaultDevice.Validate(&firmware.Image); err != nil {
+		return nil, nil, err
+	}
+
+	lineTable, err := firmware.LineTable()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading line table: %w", err)
+	}
+
+	info := &debugInfo{
+		labels:    firmware.Labels(),
+		variables: firmware.Variables(),
+		firmware:  firmware,
+		lineTable: lineTable,
+	}
+	return newMachineFromImage(firmware.Program, firmware.Config), info, nil
+}
+
+// erasedImage extends a program to the program memory size of the default device,
+// the added bytes are erased flash, which reads as 0xFF.
+func erasedImage(program []byte) []byte {
+	image := bytes.Repeat([]byte{0xFF}, max(len(program), int(binary.DefaultDevice.ProgramSize)))
+	copy(image, program)
+	return image
+}
+
+// components returns everything that is saved in a snapshot.
+func (m *machine) components() map[string]pic18.Stateful {
+	return map[string]pic18.Stateful{
+		"cpu":     m.cpu,
+		"ram":     m.ram,
+		"program": m.program,
+		"config":  m.configMem,
+		"eusart1": m.eusart1,
+		"eusart2": m.eusart2,
+	}
+}
+
+func (m *machine) saveSnapshot(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := pic18.SaveSnapshot(file, m.components()); err != nil {
+		return fmt.Errorf("saving snapshot: %w", err)
+	}
+	return file.Close()
+}
+
+func (m *machine) loadSnapshot(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := pic18.LoadSnapshot(file, m.components()); err != nil {
+		return fmt.Errorf("loading snapshot: %w", err)
+	}
+	return nil
+}
@@ -1,13 +1,14 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
-	"github.com/natk64/go-pic-emu/binary"
 	"github.com/natk64/go-pic-emu/pic18"
-	"github.com/natk64/go-pic-emu/pic18/peripherals/eusart"
+	"github.com/natk64/go-pic-emu/pic18/profile"
 )
 
 var _ pic18.CpuEventHandler = DefaultEventHandler{}
@@ -19,75 +20,147 @@ func (DefaultEventHandler) IllegalInstruction() {
 }
 
 func main() {
-	run := true
-	sleep := &pic18.SleepController{
-		OnSleep: func() {
-			run = false
-		},
-		OnWakeUp: func() { run = true },
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "disasm":
+			disasmCommand(os.Args[2:])
+			return
+		case "gdbserver":
+			gdbserverCommand(os.Args[2:])
+			return
+		case "dap":
+			dapCommand(os.Args[2:])
+			return
+		case "monitor":
+			monitorCommand(os.Args[2:])
+			return
+		case "trace":
+			traceCommand(os.Args[2:])
+			return
+		}
 	}
 
-	cpu := &pic18.CPU{
-		Config:       &pic18.ConfigTable{},
-		Stack:        pic18.Stack{Data: make([]uint32, 31)},
-		Sleep:        sleep,
-		Interrupts:   pic18.InterruptController{Sleep: sleep},
-		EventHandler: DefaultEventHandler{},
-		Table:        &pic18.TableRWController{},
-	}
+	runEmulator(os.Args[1:])
+}
 
-	ram := pic18.Memory[uint16]{Data: make([]byte, 2048)}
-	eusart1 := eusart.New(1, &cpu.Interrupts)
-	eusart2 := eusart.New(2, &cpu.Interrupts)
-
-	var dataBus pic18.BusReadWriter[uint16] = pic18.MultiBusReadWriter[uint16]{
-		ram,
-		cpu,
-		eusart1,
-		eusart2,
-		cpu.Table,
-		&cpu.Alu,
-		&cpu.Stack,
-		&cpu.BankController,
-		&cpu.Interrupts,
+// runEmulator runs a program until it has been asleep for 5 seconds.
+//
+// Usage: pic18-emu [-load snapshot] [-save snapshot] [-cycles n] [-trace file [-trace-format f] [-trace-pc range]...]
+// [-vcd file [-vcd-fosc hz] [-vcd-signal signal]...] [-coverage file] [-lcov file] [-profile file]
+// [file.hex|file.asm|file.elf|file.cof]
+func runEmulator(args []string) {
+	flags := flag.NewFlagSet("pic18-emu", flag.ExitOnError)
+	load := flags.String("load", "", "restore the machine from a snapshot before running")
+	save := flags.String("save", "", "save a snapshot of the machine when the emulation stops")
+	maxCycles := flags.Uint64("cycles", 0, "stop after this many instruction cycles (0 = no limit)")
+	tracePath := flags.String("trace", "", "write a trace with one record per instruction to this file")
+	traceFormat := flags.String("trace-format", "json", "format of the trace: json (JSON Lines) or binary")
+	var traceRanges rangeList
+	flags.Var(&traceRanges, "trace-pc", "only trace instructions in this range (start-end, a single address or a function), can be repeated")
+	vcdPath := flags.String("vcd", "", "write a value change dump (VCD) of the selected signals to this file")
+	vcdFosc := flags.Uint64("vcd-fosc", 0, "oscillator frequency in Hz for the VCD time stamps (0 = one nanosecond per instruction cycle)")
+	var vcdSignals signalList
+	flags.Var(&vcdSignals, "vcd-signal", "record a signal in the VCD: an SFR or address, reg.bit, irq:LABEL, sleeping or isr; can be repeated")
+	coveragePath := flags.String("coverage", "", "write the number of executions of every instruction to this file")
+	lcovPath := flags.String("lcov", "", "write an LCOV coverage file, the program must be an assembly source or have debug information")
+	profilePath := flags.String("profile", "", "write a pprof profile of the cycles spent in each function to this file")
+	flags.Parse(args)
+
+	programPath := "output/program.hex"
+	if flags.NArg() > 0 {
+		programPath = flags.Arg(0)
 	}
 
-	program, err := binary.ReadIHexFile("output/program.hex")
+	m, info, err := loadDebugProgram(programPath)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	programBus := pic18.MultiBusReadWriter[uint32]{
-		pic18.Memory[uint32]{
-			Data: program,
-		},
+	if *load != "" {
+		if err := m.loadSnapshot(*load); err != nil {
+			log.Fatalln(err)
+		}
 	}
 
-	cpu.DataBus = dataBus
-	cpu.ProgramBus = programBus
-	cpu.BankController.Bus = pic18.BusPrinter(dataBus)
-	cpu.BankController.WReg = &cpu.WReg
-	cpu.Table.ProgramBus = programBus
+	cpu := m.cpu
+	if *coveragePath != "" || *lcovPath != "" {
+		cpu.Coverage = &pic18.Coverage{}
+	}
 
-	cpu.PowerOnReset()
+	tick := cpu.Tick
+	if *tracePath != "" {
+		tracer, stop, err := startTrace(m, info, *tracePath, *traceFormat, &traceRanges)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer func() {
+			if err := stop(); err != nil {
+				log.Println("trace:", err)
+			}
+		}()
+		tick = tracer.Tick
+	}
+	var profiler *profile.Profiler
+	if *profilePath != "" {
+		profiler = profile.New(cpu)
+		tickCPU := tick
+		tick = func() {
+			profiler.Record()
+			tickCPU()
+		}
+	}
+	if *vcdPath != "" {
+		sample, stop, err := startVCD(m, *vcdPath, vcdSignals, *vcdFosc)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer func() {
+			if err := stop(); err != nil {
+				log.Println("vcd:", err)
+			}
+		}()
+
+		sample()
+		tickCPU := tick
+		tick = func() {
+			tickCPU()
+			sample()
+		}
+	}
+	cpu.BankController.Bus = pic18.BusPrinter[uint16](cpu.BankController.Bus)
 
-	ticks := 0
 	start := time.Now()
-	for {
-		if !run {
+	for *maxCycles == 0 || cpu.Cycles() < *maxCycles {
+		if cpu.Sleep.Asleep() && !cpu.Watchdog.Enabled(true) {
 			if time.Since(start) > time.Second*5 {
 				break
 			}
 			time.Sleep(time.Millisecond)
 			continue
 		}
-		ticks++
-		cpu.Tick()
+		tick()
 	}
 
 	elapsed := time.Since(start)
-	fmt.Printf("%d ticks in %v, %v MHz\n", ticks, elapsed, ticks/int(elapsed.Microseconds()))
+	cycles := cpu.Cycles()
+	fmt.Printf("%d cycles in %v, %v MHz\n", cycles, elapsed, cycles/uint64(max(elapsed.Microseconds(), 1)))
 
-	wreg, _ := dataBus.BusRead(pic18.Registers.WREG)
+	wreg, _ := m.dataBus.BusRead(pic18.Registers.WREG)
 	fmt.Printf("WREG: %d\n", wreg)
+
+	if err := writeCoverage(m, info, *coveragePath, *lcovPath); err != nil {
+		log.Println("coverage:", err)
+	}
+
+	if profiler != nil {
+		if err := writeProfile(profiler, *profilePath, programPath, info); err != nil {
+			log.Println("profile:", err)
+		}
+	}
+
+	if *save != "" {
+		if err := m.saveSnapshot(*save); err != nil {
+			log.Fatalln(err)
+		}
+	}
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/natk64/go-pic-emu/pic18"
+	"github.com/natk64/go-pic-emu/pic18/debug"
+	"github.com/natk64/go-pic-emu/pic18/monitor"
+)
+
+// monitorCommand runs the interactive debugger.
+//
+// Usage: pic18-emu monitor [-script file] [-history n] [-load snapshot] program.hex|program.asm|program.elf|program.cof
+func monitorCommand(args []string) {
+	flags := flag.NewFlagSet("monitor", flag.ExitOnError)
+	script := flags.String("script", "", "execute the commands in this file before reading from stdin")
+	historyLimit := flags.Int("history", pic18.DefaultHistoryLimit, "number of instructions recorded for stepping back (0 = disabled)")
+	load := flags.String("load", "", "restore the machine from a snapshot before debugging")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: pic18-emu monitor [flags] program.hex|program.asm|program.elf|program.cof")
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+
+	m, info, err := loadDebugProgram(flags.Arg(0))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if *load != "" {
+		if err := m.loadSnapshot(*load); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	m.redirectUARTs(os.Stdout)
+
+	var history *pic18.History
+	if *historyLimit > 0 {
+		history = pic18.NewHistory(m.cpu, m.ram)
+		history.Limit = *historyLimit
+	}
+
+	// Ctrl-C stops the program instead of the monitor.
+	interrupt := make(chan struct{}, 1)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		for range signals {
+			select {
+			case interrupt <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	target := debug.New(m.cpu, history)
+	target.Lines = info.lineTable
+	evaluator, err := info.evaluator(target)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	mon := &monitor.Monitor{
+		Target:    target,
+		Labels:    info.labels,
+		Variables: info.variables,
+		Evaluator: evaluator,
+		Out:       os.Stdout,
+		Interrupt: interrupt,
+	}
+
+	if *script != "" {
+		if err := mon.RunScript(*script); err != nil {
+			if errors.Is(err, monitor.ErrQuit) {
+				return
+			}
+			log.Fatalln(err)
+		}
+	}
+
+	// Without a terminal, the input is treated like a script.
+	stat, err := os.Stdin.Stat()
+	interactive := err == nil && stat.Mode()&os.ModeCharDevice != 0
+	if err := mon.Run(os.Stdin, interactive); err != nil && !errors.Is(err, monitor.ErrQuit) {
+		log.Fatalln(err)
+	}
+}
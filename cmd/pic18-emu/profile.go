@@ -0,0 +1,24 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"github.com/natk64/go-pic-emu/pic18/profile"
+)
+
+// writeProfile writes the cycles counted by the profiler as a pprof profile.
+func writeProfile(profiler *profile.Profiler, path, programPath string, info *debugInfo) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = profiler.WriteProfile(file, &profile.Symbols{
+		Labels:  info.labels,
+		Source:  info.source,
+		Lines:   info.lines,
+		Program: programPath,
+	})
+	return errors.Join(err, file.Close())
+}
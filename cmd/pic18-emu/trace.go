@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/natk64/go-pic-emu/pic18/trace"
+)
+
+// rangeList is a flag that can be repeated, each value is a range of program memory addresses
+// like 0x100-0x200 (the end is exclusive), a single address or the name of a function.
+type rangeList struct {
+	ranges trace.Filter
+
+	// functions are resolved when the program has been loaded.
+	functions []string
+}
+
+func (list *rangeList) String() string {
+	parts := make([]string, len(list.ranges))
+	for i, r := range list.ranges {
+		parts[i] = fmt.Sprintf("%#x-%#x", r.Start, r.End)
+	}
+	return strings.Join(append(parts, list.functions...), ",")
+}
+
+func (list *rangeList) Set(value string) error {
+	startStr, endStr, isRange := strings.Cut(value, "-")
+	start, err := strconv.ParseUint(startStr, 0, 21)
+	if err != nil && !isRange && value != "" {
+		list.functions = append(list.functions, value)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("invalid address %q", startStr)
+	}
+
+	end := start + 2
+	if isRange {
+		if end, err = strconv.ParseUint(endStr, 0, 22); err != nil {
+			return fmt.Errorf("invalid address %q", endStr)
+		}
+	}
+	if end <= start {
+		return fmt.Errorf("empty range %q", value)
+	}
+
+	list.ranges = append(list.ranges, trace.Range{Start: uint32(start), End: uint32(end)})
+	return nil
+}
+
+// filter returns the ranges with the functions resolved using the symbols of the program.
+func (list *rangeList) filter(info *debugInfo) (trace.Filter, error) {
+	filter := slices.Clone(list.ranges)
+	for _, name := range list.functions {
+		if info == nil {
+			return nil, fmt.Errorf("function %s can't be resolved without the program", name)
+		}
+		start, end, ok := info.functionRange(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown function %q", name)
+		}
+		filter = append(filter, trace.Range{Start: start, End: end})
+	}
+	return filter, nil
+}
+
+// startTrace creates a trace writer for the emulator. The format is json (JSON Lines) or binary.
+func startTrace(m *machine, info *debugInfo, path, format string, ranges *rangeList) (*trace.Tracer, func() error, error) {
+	filter, err := ranges.filter(info)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var out trace.Writer
+	switch format {
+	case "json":
+		out = trace.NewJSONWriter(file)
+	case "binary":
+		if out, err = trace.NewBinaryWriter(file, m.cpu.BankController.ExtendedSet); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+	default:
+		file.Close()
+		return nil, nil, fmt.Errorf("unknown trace format %q", format)
+	}
+
+	tracer := trace.New(m.cpu, out)
+	tracer.Filter = filter
+
+	stop := func() error {
+		err := errors.Join(tracer.Err(), out.Flush())
+		return errors.Join(err, file.Close())
+	}
+	return tracer, stop, nil
+}
+
+// traceCommand decodes a binary trace.
+//
+// Usage: pic18-emu trace [-json] [-pc range]... file.trace
+func traceCommand(args []string) {
+	flags := flag.NewFlagSet("trace", flag.ExitOnError)
+	jsonOutput := flags.Bool("json", false, "write JSON Lines instead of text")
+	var ranges rangeList
+	flags.Var(&ranges, "pc", "only show instructions in this range (start-end or a single address), can be repeated")
+	flags.Parse(args)
+
+	filter, err := ranges.filter(nil)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: pic18-emu trace [flags] file.trace")
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer file.Close()
+
+	reader, err := trace.NewReader(file)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var out trace.Writer = &textWriter{w: os.Stdout}
+	if *jsonOutput {
+		out = trace.NewJSONWriter(os.Stdout)
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			out.Flush()
+			log.Fatalln(err)
+		}
+
+		if !filter.Includes(record.PC) {
+			continue
+		}
+		if err := out.WriteRecord(record); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if err := out.Flush(); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// textWriter formats records as a listing with one instruction per line.
+type textWriter struct {
+	w io.Writer
+}
+
+func (writer *textWriter) WriteRecord(record *trace.Record) error {
+	words := make([]string, len(record.Words))
+	for i, word := range record.Words {
+		words[i] = fmt.Sprintf("%04X", word)
+	}
+
+	var line strings.Builder
+	fmt.Fprintf(&line, "%10d  %06X  %-10s %-28s W=%02X S=%02X B=%02X",
+		record.Cycle, record.PC, strings.Join(words, " "), record.Text, record.WREG, record.STATUS, record.BSR)
+	for _, access := range record.Accesses {
+		kind := "R"
+		if access.Write {
+			kind = "W"
+		}
+		fmt.Fprintf(&line, "  %s %03X=%02X", kind, access.Addr, access.Value)
+	}
+	line.WriteByte('\n')
+
+	_, err := io.WriteString(writer.w, line.String())
+	return err
+}
+
+func (writer *textWriter) Flush() error {
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/natk64/go-pic-emu/pic18"
+	"github.com/natk64/go-pic-emu/pic18/disasm"
+	"github.com/natk64/go-pic-emu/pic18/vcd"
+)
+
+// signalList is a flag that can be repeated, each value names a signal for the VCD:
+//
+//	PORTB, 0xF81      an SFR or any other data memory address
+//	PORTB.3, 0x20.0   a single bit
+//	irq:RC1           the request flag of the interrupt source with this debug label
+//	sleeping, isr     whether the CPU is asleep or in an interrupt service routine
+type signalList []string
+
+func (list *signalList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *signalList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+// parseSignal creates the signal described by spec, see signalList.
+func parseSignal(m *machine, spec string) (vcd.Signal, error) {
+	switch strings.ToLower(spec) {
+	case "sleeping":
+		return vcd.Sleeping(m.cpu), nil
+	case "isr":
+		return vcd.InISR(m.cpu), nil
+	}
+
+	if label, ok := strings.CutPrefix(spec, "irq:"); ok {
+		signal, ok := vcd.InterruptRequest(&m.cpu.Interrupts, label)
+		if !ok {
+			return vcd.Signal{}, fmt.Errorf("unknown interrupt %q, available: %s", label, strings.Join(m.cpu.Interrupts.InterruptLabels(), ", "))
+		}
+		return signal, nil
+	}
+
+	register, bitStr, isBit := strings.Cut(spec, ".")
+	addr, err := registerAddress(register)
+	if err != nil {
+		return vcd.Signal{}, err
+	}
+	if pic18.IsIndirectRegister(addr) || addr == pic18.Registers.PCL {
+		return vcd.Signal{}, fmt.Errorf("%s can't be recorded, reading it has side effects", register)
+	}
+
+	if !isBit {
+		return vcd.Register(spec, m.dataBus, addr), nil
+	}
+
+	bit, err := strconv.ParseUint(bitStr, 10, 3)
+	if err != nil {
+		return vcd.Signal{}, fmt.Errorf("invalid bit %q", bitStr)
+	}
+	return vcd.Bit(spec, m.dataBus, addr, uint8(bit)), nil
+}
+
+// registerAddress resolves an SFR name or a data memory address.
+func registerAddress(s string) (uint16, error) {
+	for addr, name := range disasm.RegisterNames {
+		if strings.EqualFold(name, s) {
+			return addr, nil
+		}
+	}
+
+	addr, err := strconv.ParseUint(s, 0, 12)
+	if err != nil {
+		return 0, fmt.Errorf("unknown register %q", s)
+	}
+	return uint16(addr), nil
+}
+
+// startVCD creates a VCD writer for the emulator. Without signals, the CPU state and all interrupt flags are recorded.
+// sample must be called after every tick and stop when the emulation ends.
+func startVCD(m *machine, path string, specs []string, fosc uint64) (sample func(), stop func() error, err error) {
+	if len(specs) == 0 {
+		specs = []string{"sleeping", "isr"}
+		for _, label := range m.cpu.Interrupts.InterruptLabels() {
+			specs = append(specs, "irq:"+label)
+		}
+	}
+
+	signals := make([]vcd.Signal, len(specs))
+	for i, spec := range specs {
+		if signals[i], err = parseSignal(m, spec); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	writer, err := vcd.NewWriter(file, signals, fosc)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	sample = func() { writer.Sample(m.cpu.Cycles()) }
+	stop = func() error {
+		return errors.Join(writer.Flush(m.cpu.Cycles()), file.Close())
+	}
+	return sample, stop, nil
+}
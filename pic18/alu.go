@@ -23,35 +23,48 @@ type ALU struct {
 }
 
 func (alu *ALU) Add(a, b uint8) uint8 {
-	result := uint16(a) + uint16(b)
-	result8bit := uint8(result)
-	alu.status = updateStatusAll(alu.status, result)
-	return result8bit
+	return alu.add(a, b, 0)
 }
 
+// Sub computes a - b. Like on the real device, the carry flag is an inverted borrow.
 func (alu *ALU) Sub(a, b uint8) uint8 {
-	result := uint16(a) - uint16(b)
-	result8bit := uint8(result)
-	alu.status = updateStatusAll(alu.status, result)
-	return result8bit
+	return alu.add(a, ^b, 1)
 }
 
 func (alu *ALU) AddWithCarry(a, b uint8) uint8 {
-	carryBit := alu.status & statusC
-	result := uint16(a) + uint16(b) + uint16(carryBit)
-	alu.status = updateStatusAll(alu.status, result)
-	return uint8(result)
+	return alu.add(a, b, uint8(alu.status&statusC))
 }
 
+// SubWithBorrow computes a - b - !C.
 func (alu *ALU) SubWithBorrow(a, b uint8) uint8 {
-	var borrow uint16
-	if (alu.status & statusC) == 0 {
-		borrow = 1
+	return alu.add(a, ^b, uint8(alu.status&statusC))
+}
+
+// add computes a + b + carry and updates all status flags.
+// Subtractions are implemented as a + ^b + 1, which gives the datasheet behaviour of C and DC for free.
+func (alu *ALU) add(a, b, carry uint8) uint8 {
+	result16 := uint16(a) + uint16(b) + uint16(carry)
+	result := uint8(result16)
+
+	var status AluStatus
+	if result16&0x100 != 0 {
+		status |= statusC
+	}
+	if (a&0x0F)+(b&0x0F)+carry > 0x0F {
+		status |= statusDC
+	}
+	if result == 0 {
+		status |= statusZ
+	}
+	if (a^result)&(b^result)&0x80 != 0 {
+		status |= statusOV
+	}
+	if result&0x80 != 0 {
+		status |= statusN
 	}
 
-	result := uint16(a) + uint16(b) + uint16(borrow)
-	alu.status = updateStatusAll(alu.status, result)
-	return uint8(result)
+	alu.status = status
+	return result
 }
 
 func (alu *ALU) And(a, b uint8) uint8 {
@@ -73,53 +86,40 @@ func (alu *ALU) Xor(a, b uint8) uint8 {
 }
 
 func (alu *ALU) Negate(a uint8) uint8 {
-	result := uint16(^a + 1)
-	alu.status = updateStatusAll(alu.status, result)
-	return uint8(result)
+	return alu.Sub(0, a)
 }
 
 func (alu *ALU) RotateLeft(a uint8) uint8 {
-	value := uint16(a)
-	value <<= 1
-	if value&(1<<8) != 0 {
-		value |= 1
-	}
-
-	alu.status = updateStatusN_Z(alu.status, uint8(value))
-	return uint8(value)
+	result := (a << 1) | (a >> 7)
+	alu.status = updateStatusN_Z(alu.status, result)
+	return result
 }
 
 func (alu *ALU) RotateRight(a uint8) uint8 {
-	alu.status &= ^statusC
-	result := a >> 1
-	if a&1 != 0 {
-		result |= (1 << 7)
-	}
-
+	result := (a >> 1) | (a << 7)
 	alu.status = updateStatusN_Z(alu.status, result)
 	return result
 }
 
 func (alu *ALU) RotateLeftCarry(a uint8) uint8 {
-	value := uint16(a)
-	value <<= 1
-	if alu.status&statusC != 0 {
-		value |= 1
-	}
+	result := (a << 1) | uint8(alu.status&statusC)
 
 	alu.status &= ^statusC
-
-	if value&0b100000000 != 0 {
+	if a&0x80 != 0 {
 		alu.status |= statusC
 	}
 
-	alu.status = updateStatusN_Z(alu.status, uint8(value))
-	return uint8(value)
+	alu.status = updateStatusN_Z(alu.status, result)
+	return result
 }
 
 func (alu *ALU) RotateRightCarry(a uint8) uint8 {
-	alu.status &= ^statusC
 	result := a >> 1
+	if alu.status&statusC != 0 {
+		result |= 0x80
+	}
+
+	alu.status &= ^statusC
 	if a&1 != 0 {
 		alu.status |= statusC
 	}
@@ -128,25 +128,19 @@ func (alu *ALU) RotateRightCarry(a uint8) uint8 {
 	return result
 }
 
+// DecimalAdjust implements DAW, only the carry flag is affected.
 func (alu *ALU) DecimalAdjust(a uint8) uint8 {
-	low := a & 0x0F
-	high := a & 0xF0
-	var result uint16
-
-	if low > 9 || (alu.status&statusDC != 0) {
-		result = uint16(low) + 6
-	} else {
-		result = uint16(low)
+	result := uint16(a)
+
+	if a&0x0F > 9 || alu.status&statusDC != 0 {
+		result += 0x06
 	}
 
-	if high > 9 || (alu.status&statusC != 0) {
-		result |= uint16((high + 6) << 4)
-	} else {
-		result |= uint16(high << 4)
+	if result&0xF0 > 0x90 || result > 0xFF || alu.status&statusC != 0 {
+		result += 0x60
 	}
 
-	alu.status &= ^statusC
-	if result&(1<<8) != 0 {
+	if result > 0xFF {
 		alu.status |= statusC
 	}
 
@@ -165,30 +159,11 @@ func (alu *ALU) Mul(a, b uint8) {
 	alu.productLow = uint8(result)
 }
 
-// TODO: DC Flag
-func updateStatusAll(status AluStatus, result uint16) AluStatus {
-	var newStatus AluStatus
-	if (result & 0b10000000) != 0 {
-		newStatus |= statusN
-	}
-	if (status & statusN) != (newStatus & statusN) {
-		newStatus |= statusOV
-	}
-	if (result & 0xFF) == 0 {
-		newStatus |= statusZ
-	}
-	if (result & 0b100000000) != 0 {
-		newStatus |= statusC
-	}
-
-	return newStatus
-}
-
 func updateStatusN_Z(status AluStatus, result uint8) AluStatus {
 	status &= ^statusZ
 	status &= ^statusN
 	if result&0b10000000 != 0 {
-		status = statusN
+		status |= statusN
 	}
 	if result == 0 {
 		status |= statusZ
@@ -197,16 +172,28 @@ func updateStatusN_Z(status AluStatus, result uint8) AluStatus {
 }
 
 func (alu ALU) BusRead(addr uint16) (uint8, AddrMask) {
-	if addr == Registers.STATUS {
+	switch addr {
+	case Registers.STATUS:
 		return uint8(alu.status), 0x1F
+	case Registers.PRODH:
+		return alu.productHigh, 0xFF
+	case Registers.PRODL:
+		return alu.productLow, 0xFF
 	}
 	return 0, 0
 }
 
 func (alu *ALU) BusWrite(addr uint16, data uint8) AddrMask {
-	if addr == Registers.STATUS {
+	switch addr {
+	case Registers.STATUS:
 		alu.status = AluStatus(data & 0x1F)
 		return 0x1F
+	case Registers.PRODH:
+		alu.productHigh = data
+		return 0xFF
+	case Registers.PRODL:
+		alu.productLow = data
+		return 0xFF
 	}
 	return 0
 }
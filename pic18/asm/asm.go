@@ -0,0 +1,503 @@
+// Package asm implements an assembler for a subset of the MPASM syntax for PIC18 devices.
+//
+// It supports labels, the org, equ, set, db, dw, cblock/endc, radix and end directives,
+// expressions with the low, high and upper operators, access and banked operands
+// and, if enabled, the extended instruction set including indexed literal offset operands ([k]).
+//
+// Like MPASM, numbers without a radix specifier are hexadecimal unless changed with the radix directive.
+// Special function registers can be referenced by name.
+package asm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/natk64/go-pic-emu/binary"
+	"github.com/natk64/go-pic-emu/pic18"
+	"github.com/natk64/go-pic-emu/pic18/disasm"
+)
+
+// Options control the assembler.
+type Options struct {
+	// ExtendedSet allows extended instructions and indexed literal offset operands.
+	ExtendedSet bool
+}
+
+// Program is the result of assembling a source file.
+type Program struct {
+	// Image contains the program flash starting at address 0.
+	// Unused bytes are 0xFF, like erased flash.
+	Image []byte
+
+	// IDs contains the user ID locations starting at [binary.IDAddress], it ends after the last byte
+	// the source sets. It is nil if the source doesn't set any, unused bytes are 0xFF.
+	IDs []byte
+
+	// Config contains the configuration bytes starting at [pic18.ConfigAddress].
+	// It is nil if the source doesn't set any configuration bytes.
+	Config []byte
+
+	// EEPROM contains the data EEPROM starting at [binary.EEPROMAddress], it ends after the last byte
+	// the source sets. It is nil if the source doesn't set any, unused bytes are 0xFF.
+	EEPROM []byte
+
+	// Symbols contains all labels and constants defined in the source.
+	Symbols map[string]uint32
+
+	// Lines maps source line numbers (starting at 1) to the address of the instruction on that line.
+	Lines map[int]uint32
+
+	labels []string
+}
+
+// Labels returns the program memory labels, keyed by address.
+// If an address has multiple labels, the first one in the source is used.
+func (program *Program) Labels() map[uint32]string {
+	labels := make(map[uint32]string)
+	for _, name := range program.labels {
+		addr := program.Symbols[name]
+		if _, ok := labels[addr]; !ok {
+			labels[addr] = name
+		}
+	}
+	return labels
+}
+
+// Error is returned for problems in the source.
+type Error struct {
+	Line int
+	Err  error
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("line %d: %v", err.Line, err.Err)
+}
+
+func (err *Error) Unwrap() error {
+	return err.Err
+}
+
+// Assemble assembles the source code into a program image.
+func Assemble(source string, opts *Options) (*Program, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	lines := strings.Split(strings.ReplaceAll(source, "\r\n", "\n"), "\n")
+
+	// The first pass only determines the addresses of labels.
+	first := newAssembler(opts, true)
+	if err := first.run(lines); err != nil {
+		return nil, err
+	}
+
+	second := newAssembler(opts, false)
+	second.eval.symbols = first.eval.symbols
+	if err := second.run(lines); err != nil {
+		return nil, err
+	}
+
+	return second.program(), nil
+}
+
+type assembler struct {
+	opts      *Options
+	firstPass bool
+	eval      evaluator
+
+	// defined tracks the symbols defined in this pass, to detect duplicates.
+	defined map[string]bool
+	labels  []string
+
+	line  int
+	lines map[int]uint32
+
+	addr   uint32
+	memory map[uint32]byte
+
+	cblock int64
+}
+
+func newAssembler(opts *Options, firstPass bool) *assembler {
+	symbols := make(map[string]int64)
+	for name, value := range registerSymbols {
+		symbols[name] = value
+	}
+
+	return &assembler{
+		opts:      opts,
+		firstPass: firstPass,
+		eval: evaluator{
+			symbols:        symbols,
+			radix:          16,
+			allowUndefined: firstPass,
+		},
+		defined: make(map[string]bool),
+		memory:  make(map[uint32]byte),
+		lines:   make(map[int]uint32),
+	}
+}
+
+func (a *assembler) run(lines []string) error {
+	inCblock := false
+
+	for i, line := range lines {
+		a.line = i + 1
+		line = stripComment(line)
+		if inCblock {
+			if strings.EqualFold(strings.TrimSpace(line), "endc") {
+				inCblock = false
+			} else if err := a.cblockEntry(line); err != nil {
+				return &Error{Line: i + 1, Err: err}
+			}
+			continue
+		}
+
+		label, mnemonic, operands := splitLine(line)
+		directive := strings.ToLower(mnemonic)
+
+		var err error
+		switch {
+		case directive == "end":
+			return nil
+		case directive == "cblock":
+			inCblock = true
+			if len(operands) > 0 {
+				a.cblock, err = a.value(operands[0])
+			}
+		default:
+			err = a.statement(label, mnemonic, directive, operands)
+		}
+
+		if err != nil {
+			return &Error{Line: i + 1, Err: err}
+		}
+	}
+
+	return nil
+}
+
+func (a *assembler) statement(label, mnemonic, directive string, operands []string) error {
+	switch directive {
+	case "equ", "set":
+		if label == "" {
+			return fmt.Errorf("%s without a name", directive)
+		}
+		if len(operands) != 1 {
+			return fmt.Errorf("%s expects one operand", directive)
+		}
+		value, err := a.value(operands[0])
+		if err != nil {
+			return err
+		}
+		return a.define(label, value, directive == "set")
+	}
+
+	if label != "" {
+		if err := a.define(label, int64(a.addr), false); err != nil {
+			return err
+		}
+		a.labels = append(a.labels, label)
+	}
+
+	switch directive {
+	case "":
+		return nil
+	case "org":
+		if len(operands) != 1 {
+			return fmt.Errorf("org expects one operand")
+		}
+		value, err := a.value(operands[0])
+		if err != nil {
+			return err
+		}
+		a.addr = uint32(value)
+		return nil
+	case "radix":
+		if len(operands) != 1 {
+			return fmt.Errorf("radix expects one operand")
+		}
+		switch strings.ToLower(operands[0]) {
+		case "hex":
+			a.eval.radix = 16
+		case "dec":
+			a.eval.radix = 10
+		case "oct":
+			a.eval.radix = 8
+		default:
+			return fmt.Errorf("unknown radix %q", operands[0])
+		}
+		return nil
+	case "db":
+		return a.dataBytes(operands)
+	case "dw":
+		for _, operand := range operands {
+			value, err := a.value(operand)
+			if err != nil {
+				return err
+			}
+			if err := a.emit(uint16(value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "list", "processor", "#include", "include", "errorlevel", "nolist":
+		return nil
+	}
+
+	words, err := a.encode(mnemonic, operands)
+	if err != nil {
+		return err
+	}
+	a.lines[a.line] = a.addr
+	return a.emit(words...)
+}
+
+// cblockEntry defines the names on a line inside a cblock.
+// Names are separated by commas and can be followed by ":size".
+func (a *assembler) cblockEntry(line string) error {
+	for _, entry := range splitOperands(line) {
+		name, sizeExpr, hasSize := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		size := int64(1)
+		if hasSize {
+			var err error
+			if size, err = a.value(sizeExpr); err != nil {
+				return err
+			}
+		}
+
+		if err := a.define(name, a.cblock, false); err != nil {
+			return err
+		}
+		a.cblock += size
+	}
+	return nil
+}
+
+func (a *assembler) dataBytes(operands []string) error {
+	var data []byte
+	for _, operand := range operands {
+		operand = strings.TrimSpace(operand)
+		if len(operand) >= 2 && operand[0] == '"' && operand[len(operand)-1] == '"' {
+			data = append(data, operand[1:len(operand)-1]...)
+			continue
+		}
+
+		value, err := a.value(operand)
+		if err != nil {
+			return err
+		}
+		data = append(data, byte(value))
+	}
+
+	// db always fills whole words of the program flash.
+	if len(data)%2 != 0 && a.addr < binary.IDAddress {
+		data = append(data, 0)
+	}
+
+	for _, b := range data {
+		if err := a.store(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *assembler) define(name string, value int64, redefine bool) error {
+	if a.defined[name] && !redefine {
+		return fmt.Errorf("symbol %q redefined", name)
+	}
+	a.defined[name] = true
+	a.eval.symbols[name] = value
+	return nil
+}
+
+func (a *assembler) value(expr string) (int64, error) {
+	a.eval.pc = int64(a.addr)
+	return a.eval.eval(expr)
+}
+
+func (a *assembler) emit(words ...uint16) error {
+	for _, word := range words {
+		if err := a.store(uint8(word)); err != nil {
+			return err
+		}
+		if err := a.store(uint8(word >> 8)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// store writes a byte at the current address, which must be in one of the regions of the program memory space:
+// the flash, the user IDs, the configuration bytes or the data EEPROM.
+func (a *assembler) store(b byte) error {
+	addr := a.addr
+	switch {
+	case addr < binary.IDAddress+binary.IDSize:
+	case addr >= pic18.ConfigAddress && addr < pic18.ConfigAddress+pic18.ConfigSize:
+	case addr >= binary.EEPROMAddress && addr < binary.EEPROMAddress+binary.MaxEEPROMSize:
+	default:
+		return fmt.Errorf("address 0x%06X is outside of the program memory space", addr)
+	}
+
+	a.memory[addr] = b
+	a.addr++
+	return nil
+}
+
+func (a *assembler) program() *Program {
+	program := &Program{
+		Symbols: make(map[string]uint32),
+		Lines:   a.lines,
+		labels:  a.labels,
+	}
+
+	for name := range a.defined {
+		program.Symbols[name] = uint32(a.eval.symbols[name])
+	}
+
+	addresses := make([]uint32, 0, len(a.memory))
+	for addr := range a.memory {
+		addresses = append(addresses, addr)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+
+	for _, addr := range addresses {
+		value := a.memory[addr]
+		switch {
+		case addr >= pic18.ConfigAddress && addr < pic18.ConfigAddress+pic18.ConfigSize:
+			if program.Config == nil {
+				program.Config = append([]byte{}, pic18.DefaultConfigWords[:]...)
+			}
+			program.Config[addr-pic18.ConfigAddress] = value
+		case addr >= binary.EEPROMAddress:
+			program.EEPROM = erasedWrite(program.EEPROM, addr-binary.EEPROMAddress, value)
+		case addr >= binary.IDAddress:
+			program.IDs = erasedWrite(program.IDs, addr-binary.IDAddress, value)
+		default:
+			program.Image = erasedWrite(program.Image, addr, value)
+		}
+	}
+
+	return program
+}
+
+// erasedWrite stores a byte in a memory, which is extended with erased bytes (0xFF) if needed.
+func erasedWrite(memory []byte, offset uint32, value byte) []byte {
+	for uint32(len(memory)) <= offset {
+		memory = append(memory, 0xFF)
+	}
+	memory[offset] = value
+	return memory
+}
+
+// stripComment removes a ; comment, ignoring semicolons in strings.
+func stripComment(line string) string {
+	inString := false
+	for i, c := range line {
+		switch c {
+		case '"':
+			inString = !inString
+		case ';':
+			if !inString {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// splitLine splits a source line into label, mnemonic and operands.
+// A label either starts in the first column or ends with a colon.
+func splitLine(line string) (label, mnemonic string, operands []string) {
+	if strings.TrimSpace(line) == "" {
+		return "", "", nil
+	}
+
+	startsInFirstColumn := line[0] != ' ' && line[0] != '\t'
+	fields := strings.Fields(line)
+	first := fields[0]
+
+	rest := strings.TrimSpace(line)
+	rest = strings.TrimSpace(rest[len(first):])
+
+	isDefinition := len(fields) > 1 && (strings.EqualFold(fields[1], "equ") || strings.EqualFold(fields[1], "set"))
+
+	if strings.HasSuffix(first, ":") {
+		label = strings.TrimSuffix(first, ":")
+	} else if (startsInFirstColumn && !isKeyword(first)) || isDefinition {
+		label = first
+	} else {
+		rest = strings.TrimSpace(line)
+	}
+
+	if rest == "" {
+		return label, "", nil
+	}
+
+	mnemonic = rest
+	rest = ""
+	if end := strings.IndexAny(mnemonic, " \t"); end >= 0 {
+		mnemonic, rest = mnemonic[:end], mnemonic[end:]
+	}
+
+	return label, mnemonic, splitOperands(rest)
+}
+
+// splitOperands splits an operand list at commas outside of strings, brackets and parentheses.
+func splitOperands(str string) []string {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return nil
+	}
+
+	var operands []string
+	depth := 0
+	inString := false
+	start := 0
+	for i, c := range str {
+		switch c {
+		case '"':
+			inString = !inString
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case ',':
+			if !inString && depth == 0 {
+				operands = append(operands, strings.TrimSpace(str[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(operands, strings.TrimSpace(str[start:]))
+}
+
+func isKeyword(word string) bool {
+	word = strings.ToLower(word)
+	if _, ok := encodings[word]; ok {
+		return true
+	}
+
+	switch word {
+	case "org", "equ", "set", "db", "dw", "cblock", "endc", "end", "radix", "list", "nolist", "processor", "#include", "include", "errorlevel":
+		return true
+	}
+	return strings.HasPrefix(word, "tblrd") || strings.HasPrefix(word, "tblwt")
+}
+
+// registerSymbols contains the names of all special function registers.
+var registerSymbols = func() map[string]int64 {
+	symbols := make(map[string]int64)
+	for addr, name := range disasm.RegisterNames {
+		symbols[name] = int64(addr)
+	}
+	return symbols
+}()
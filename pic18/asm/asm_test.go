@@ -0,0 +1,185 @@
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/natk64/go-pic-emu/pic18"
+)
+
+// words returns the little endian instruction words of an image.
+func words(image []byte) []uint16 {
+	var words []uint16
+	for i := 0; i+1 < len(image); i += 2 {
+		words = append(words, uint16(image[i])|uint16(image[i+1])<<8)
+	}
+	return words
+}
+
+func TestAssembleInstructions(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []uint16
+	}{
+		{"movlw 0x42", []uint16{0x0E42}},
+		{"movlw 42", []uint16{0x0E42}},
+		{"movlw d'42'", []uint16{0x0E2A}},
+		{"addwf 0x20, w", []uint16{0x2420}},
+		{"addwf 0x20, f", []uint16{0x2620}},
+		{"addwf 0x120, f", []uint16{0x2720}},
+		{"addwf 0x20, f, banked", []uint16{0x2720}},
+		{"movwf INTCON", []uint16{0x6EF2}},
+		{"clrf WREG", []uint16{0x6AE8}},
+		{"bsf 0x10, 3", []uint16{0x8610}},
+		{"btfss STATUS, 2", []uint16{0xA4D8}},
+		{"movff 0x123, 0x456", []uint16{0xC123, 0xF456}},
+		{"lfsr 2, 0x345", []uint16{0xEE23, 0xF045}},
+		{"goto 0x1234", []uint16{0xEF1A, 0xF009}},
+		{"call 0x100, 1", []uint16{0xED80, 0xF000}},
+		{"tblrd*+", []uint16{0x0009}},
+		{"tblwt+*", []uint16{0x000F}},
+		{"return 1", []uint16{0x0013}},
+		{"retfie", []uint16{0x0010}},
+		{"sleep", []uint16{0x0003}},
+		{"movlb 5", []uint16{0x0105}},
+	}
+
+	for _, test := range tests {
+		program, err := Assemble("\t"+test.source, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.source, err)
+			continue
+		}
+		if got := words(program.Image); !slices.Equal(got, test.want) {
+			t.Errorf("%s: got %04X, want %04X", test.source, got, test.want)
+		}
+	}
+}
+
+func TestAssembleLabels(t *testing.T) {
+	program, err := Assemble(`
+	org 0
+start
+	bra main
+	org 0x10
+main
+	rcall sub
+	bz main
+	bra start
+sub
+	return
+`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []uint16{0xD007, 0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF, 0xD802, 0xE0FE, 0xD7F5, 0x0012}
+	if got := words(program.Image); !slices.Equal(got, want) {
+		t.Errorf("got %04X, want %04X", got, want)
+	}
+
+	labels := program.Labels()
+	for addr, name := range map[uint32]string{0x00: "start", 0x10: "main", 0x16: "sub"} {
+		if labels[addr] != name {
+			t.Errorf("label at 0x%02X is %q, want %q", addr, labels[addr], name)
+		}
+	}
+	if program.Lines[7] != 0x10 {
+		t.Errorf("line 7 is at 0x%02X, want 0x10", program.Lines[7])
+	}
+}
+
+func TestAssembleData(t *testing.T) {
+	program, err := Assemble(`
+	cblock 0x20
+	a, b:2, c
+	endc
+	db 1, 2, 3
+	dw 0x1234
+	db "hi"
+	movlw c
+`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// db fills whole words, so the odd byte is followed by 0.
+	want := []byte{1, 2, 3, 0, 0x34, 0x12, 'h', 'i', 0x23, 0x0E}
+	if !bytes.Equal(program.Image, want) {
+		t.Errorf("image is % X, want % X", program.Image, want)
+	}
+}
+
+func TestAssembleRegions(t *testing.T) {
+	program, err := Assemble(`
+	org 0
+	goto 0
+	org 0x200000
+	db 1, 2, 3
+	org 0x300001
+	db 0x08
+	org 0xF00000
+	db "EE"
+	org 0xF00004
+	db 0x55
+`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []byte{0x00, 0xEF, 0x00, 0xF0}; !bytes.Equal(program.Image, want) {
+		t.Errorf("image is % X, want % X", program.Image, want)
+	}
+	if want := []byte{1, 2, 3}; !bytes.Equal(program.IDs, want) {
+		t.Errorf("IDs are % X, want % X", program.IDs, want)
+	}
+	if want := []byte{'E', 'E', 0xFF, 0xFF, 0x55}; !bytes.Equal(program.EEPROM, want) {
+		t.Errorf("EEPROM is % X, want % X", program.EEPROM, want)
+	}
+
+	config := append([]byte{}, pic18.DefaultConfigWords[:]...)
+	config[1] = 0x08
+	if !bytes.Equal(program.Config, config) {
+		t.Errorf("config is % X, want % X", program.Config, config)
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		line   int
+	}{
+		{"\tnop\n\tfoo 1", 2},
+		{"\tmovlw undefined", 1},
+		{"x equ 1\nx equ 2", 2},
+		{"\tnop\n\torg 0x300000\n\tdb 1\n\torg 0x300010\n\tdb 1", 5},
+		{"\torg 0x200008\n\tnop", 2},
+		{"\taddfsr 1, 5", 1},
+	}
+
+	for _, test := range tests {
+		_, err := Assemble(test.source, nil)
+		var asmErr *Error
+		if !errors.As(err, &asmErr) {
+			t.Errorf("%q: got error %v, want an *Error", test.source, err)
+			continue
+		}
+		if asmErr.Line != test.line {
+			t.Errorf("%q: error %v is on line %d, want %d", test.source, err, asmErr.Line, test.line)
+		}
+	}
+}
+
+func TestAssembleExtended(t *testing.T) {
+	program, err := Assemble("\taddfsr 1, 5\n\tmovf [2], w\n\tpushl 0x12", &Options{ExtendedSet: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []uint16{0xE845, 0x5002, 0xEA12}
+	if got := words(program.Image); !slices.Equal(got, want) {
+		t.Errorf("got %04X, want %04X", got, want)
+	}
+}
@@ -0,0 +1,390 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+type format int
+
+const (
+	formatNone        format = iota // no operands
+	formatByte                      // f, d, a
+	formatByteNoDest                // f, a
+	formatBit                       // f, b, a
+	formatLiteral                   // k
+	formatBranch                    // n, 8 bit relative
+	formatBranchLong                // n, 11 bit relative
+	formatReturn                    // [s]
+	formatCall                      // k, [s]
+	formatGoto                      // k
+	formatLfsr                      // f, k
+	formatMovff                     // fs, fd
+	formatMovlb                     // k
+	formatFsrLiteral                // f, k (extended)
+	formatLiteralLink               // k (extended)
+	formatMovsf                     // [zs], fd (extended)
+	formatMovss                     // [zs], [zd] (extended)
+)
+
+type encoding struct {
+	base     uint16
+	format   format
+	extended bool
+}
+
+var encodings = map[string]encoding{
+	"addwf":  {0x2400, formatByte, false},
+	"addwfc": {0x2000, formatByte, false},
+	"andwf":  {0x1400, formatByte, false},
+	"comf":   {0x1C00, formatByte, false},
+	"decf":   {0x0400, formatByte, false},
+	"decfsz": {0x2C00, formatByte, false},
+	"dcfsnz": {0x4C00, formatByte, false},
+	"incf":   {0x2800, formatByte, false},
+	"incfsz": {0x3C00, formatByte, false},
+	"infsnz": {0x4800, formatByte, false},
+	"iorwf":  {0x1000, formatByte, false},
+	"movf":   {0x5000, formatByte, false},
+	"rlcf":   {0x3400, formatByte, false},
+	"rlncf":  {0x4400, formatByte, false},
+	"rrcf":   {0x3000, formatByte, false},
+	"rrncf":  {0x4000, formatByte, false},
+	"subfwb": {0x5400, formatByte, false},
+	"subwf":  {0x5C00, formatByte, false},
+	"subwfb": {0x5800, formatByte, false},
+	"swapf":  {0x3800, formatByte, false},
+	"xorwf":  {0x1800, formatByte, false},
+
+	"clrf":   {0x6A00, formatByteNoDest, false},
+	"cpfseq": {0x6200, formatByteNoDest, false},
+	"cpfsgt": {0x6400, formatByteNoDest, false},
+	"cpfslt": {0x6000, formatByteNoDest, false},
+	"movwf":  {0x6E00, formatByteNoDest, false},
+	"mulwf":  {0x0200, formatByteNoDest, false},
+	"negf":   {0x6C00, formatByteNoDest, false},
+	"setf":   {0x6800, formatByteNoDest, false},
+	"tstfsz": {0x6600, formatByteNoDest, false},
+
+	"bcf":   {0x9000, formatBit, false},
+	"bsf":   {0x8000, formatBit, false},
+	"btfsc": {0xB000, formatBit, false},
+	"btfss": {0xA000, formatBit, false},
+	"btg":   {0x7000, formatBit, false},
+
+	"bc":    {0xE200, formatBranch, false},
+	"bn":    {0xE600, formatBranch, false},
+	"bnc":   {0xE300, formatBranch, false},
+	"bnn":   {0xE700, formatBranch, false},
+	"bnov":  {0xE500, formatBranch, false},
+	"bnz":   {0xE100, formatBranch, false},
+	"bov":   {0xE400, formatBranch, false},
+	"bz":    {0xE000, formatBranch, false},
+	"bra":   {0xD000, formatBranchLong, false},
+	"rcall": {0xD800, formatBranchLong, false},
+
+	"addlw": {0x0F00, formatLiteral, false},
+	"andlw": {0x0B00, formatLiteral, false},
+	"iorlw": {0x0900, formatLiteral, false},
+	"movlw": {0x0E00, formatLiteral, false},
+	"mullw": {0x0D00, formatLiteral, false},
+	"retlw": {0x0C00, formatLiteral, false},
+	"sublw": {0x0800, formatLiteral, false},
+	"xorlw": {0x0A00, formatLiteral, false},
+
+	"clrwdt": {0x0004, formatNone, false},
+	"daw":    {0x0007, formatNone, false},
+	"nop":    {0x0000, formatNone, false},
+	"pop":    {0x0006, formatNone, false},
+	"push":   {0x0005, formatNone, false},
+	"reset":  {0x00FF, formatNone, false},
+	"sleep":  {0x0003, formatNone, false},
+
+	"retfie": {0x0010, formatReturn, false},
+	"return": {0x0012, formatReturn, false},
+	"call":   {0xEC00, formatCall, false},
+	"goto":   {0xEF00, formatGoto, false},
+	"lfsr":   {0xEE00, formatLfsr, false},
+	"movff":  {0xC000, formatMovff, false},
+	"movlb":  {0x0100, formatMovlb, false},
+
+	"tblrd*":  {0x0008, formatNone, false},
+	"tblrd*+": {0x0009, formatNone, false},
+	"tblrd*-": {0x000A, formatNone, false},
+	"tblrd+*": {0x000B, formatNone, false},
+	"tblwt*":  {0x000C, formatNone, false},
+	"tblwt*+": {0x000D, formatNone, false},
+	"tblwt*-": {0x000E, formatNone, false},
+	"tblwt+*": {0x000F, formatNone, false},
+
+	"addfsr":  {0xE800, formatFsrLiteral, true},
+	"subfsr":  {0xE900, formatFsrLiteral, true},
+	"addulnk": {0xE8C0, formatLiteralLink, true},
+	"subulnk": {0xE9C0, formatLiteralLink, true},
+	"callw":   {0x0014, formatNone, true},
+	"movsf":   {0xEB00, formatMovsf, true},
+	"movss":   {0xEB80, formatMovss, true},
+	"pushl":   {0xEA00, formatLiteral, true},
+}
+
+// operandCounts contains the minimum and maximum number of operands of every format.
+var operandCounts = map[format][2]int{
+	formatNone:        {0, 0},
+	formatByte:        {1, 3},
+	formatByteNoDest:  {1, 2},
+	formatBit:         {2, 3},
+	formatLiteral:     {1, 1},
+	formatBranch:      {1, 1},
+	formatBranchLong:  {1, 1},
+	formatReturn:      {0, 1},
+	formatCall:        {1, 2},
+	formatGoto:        {1, 1},
+	formatLfsr:        {2, 2},
+	formatMovff:       {2, 2},
+	formatMovlb:       {1, 1},
+	formatFsrLiteral:  {2, 2},
+	formatLiteralLink: {1, 1},
+	formatMovsf:       {2, 2},
+	formatMovss:       {2, 2},
+}
+
+// encode assembles a single instruction.
+func (a *assembler) encode(mnemonic string, operands []string) ([]uint16, error) {
+	enc, ok := encodings[strings.ToLower(mnemonic)]
+	if !ok {
+		return nil, fmt.Errorf("unknown instruction %q", mnemonic)
+	}
+	if enc.extended && !a.opts.ExtendedSet {
+		return nil, fmt.Errorf("%s requires the extended instruction set", strings.ToUpper(mnemonic))
+	}
+
+	counts := operandCounts[enc.format]
+	if len(operands) < counts[0] || len(operands) > counts[1] {
+		return nil, fmt.Errorf("%s: wrong number of operands", strings.ToUpper(mnemonic))
+	}
+
+	switch enc.format {
+	case formatNone:
+		return []uint16{enc.base}, nil
+
+	case formatByte:
+		file, err := a.fileOperand(operands[0], operands[1:], 2)
+		if err != nil {
+			return nil, err
+		}
+		dest := int64(1)
+		if len(operands) > 1 {
+			if dest, err = a.keyword(operands[1], "w", "f"); err != nil {
+				return nil, err
+			}
+		}
+		return []uint16{enc.base | file | uint16(dest)<<9}, nil
+
+	case formatByteNoDest:
+		file, err := a.fileOperand(operands[0], operands[1:], 1)
+		if err != nil {
+			return nil, err
+		}
+		return []uint16{enc.base | file}, nil
+
+	case formatBit:
+		file, err := a.fileOperand(operands[0], operands[1:], 2)
+		if err != nil {
+			return nil, err
+		}
+		bit, err := a.ranged(operands[1], 0, 7)
+		if err != nil {
+			return nil, err
+		}
+		return []uint16{enc.base | file | uint16(bit)<<9}, nil
+
+	case formatLiteral:
+		k, err := a.ranged(operands[0], -128, 255)
+		if err != nil {
+			return nil, err
+		}
+		return []uint16{enc.base | uint16(k)&0xFF}, nil
+
+	case formatBranch, formatBranchLong:
+		target, err := a.value(operands[0])
+		if err != nil {
+			return nil, err
+		}
+		offset := (target - int64(a.addr) - 2) / 2
+		limit := int64(128)
+		mask := uint16(0xFF)
+		if enc.format == formatBranchLong {
+			limit = 1024
+			mask = 0x7FF
+		}
+		if !a.firstPass && (target%2 != 0 || offset < -limit || offset >= limit) {
+			return nil, fmt.Errorf("branch target 0x%X out of range", target)
+		}
+		return []uint16{enc.base | uint16(offset)&mask}, nil
+
+	case formatReturn:
+		fast := int64(0)
+		if len(operands) > 0 {
+			var err error
+			if fast, err = a.keyword(operands[0], "", "fast"); err != nil {
+				return nil, err
+			}
+		}
+		return []uint16{enc.base | uint16(fast)}, nil
+
+	case formatCall, formatGoto:
+		target, err := a.ranged(operands[0], 0, 0x1FFFFF)
+		if err != nil {
+			return nil, err
+		}
+		k := uint16(target>>1) & 0xFF
+		if enc.format == formatCall && len(operands) > 1 {
+			fast, err := a.keyword(operands[1], "", "fast")
+			if err != nil {
+				return nil, err
+			}
+			k |= uint16(fast) << 8
+		}
+		return []uint16{enc.base | k, 0xF000 | uint16(target>>9)&0xFFF}, nil
+
+	case formatLfsr:
+		fsr, err := a.ranged(operands[0], 0, 2)
+		if err != nil {
+			return nil, err
+		}
+		k, err := a.ranged(operands[1], 0, 0xFFF)
+		if err != nil {
+			return nil, err
+		}
+		return []uint16{enc.base | uint16(fsr)<<4 | uint16(k>>8), 0xF000 | uint16(k)&0xFF}, nil
+
+	case formatMovff:
+		src, err := a.ranged(operands[0], 0, 0xFFF)
+		if err != nil {
+			return nil, err
+		}
+		dst, err := a.ranged(operands[1], 0, 0xFFF)
+		if err != nil {
+			return nil, err
+		}
+		return []uint16{enc.base | uint16(src), 0xF000 | uint16(dst)}, nil
+
+	case formatMovlb:
+		k, err := a.ranged(operands[0], 0, 0x0F)
+		if err != nil {
+			return nil, err
+		}
+		return []uint16{enc.base | uint16(k)}, nil
+
+	case formatFsrLiteral:
+		fsr, err := a.ranged(operands[0], 0, 2)
+		if err != nil {
+			return nil, err
+		}
+		k, err := a.ranged(operands[1], 0, 0x3F)
+		if err != nil {
+			return nil, err
+		}
+		return []uint16{enc.base | uint16(fsr)<<6 | uint16(k)}, nil
+
+	case formatLiteralLink:
+		k, err := a.ranged(operands[0], 0, 0x3F)
+		if err != nil {
+			return nil, err
+		}
+		return []uint16{enc.base | uint16(k)}, nil
+
+	case formatMovsf, formatMovss:
+		src, err := a.indexed(operands[0])
+		if err != nil {
+			return nil, err
+		}
+		if enc.format == formatMovss {
+			dst, err := a.indexed(operands[1])
+			if err != nil {
+				return nil, err
+			}
+			return []uint16{enc.base | src, 0xF000 | dst}, nil
+		}
+		dst, err := a.ranged(operands[1], 0, 0xFFF)
+		if err != nil {
+			return nil, err
+		}
+		return []uint16{enc.base | src, 0xF000 | uint16(dst)}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported instruction %q", mnemonic)
+}
+
+// fileOperand encodes the f and a fields of a file register operand.
+// The access mode is taken from rest[accessIndex-1] if present, otherwise it's derived from the address like MPASM does:
+// addresses in the access bank use the access bank, everything else uses the BSR.
+func (a *assembler) fileOperand(operand string, rest []string, accessIndex int) (uint16, error) {
+	if strings.HasPrefix(operand, "[") {
+		if len(rest) >= accessIndex {
+			return 0, fmt.Errorf("indexed operands can't specify the access mode")
+		}
+		k, err := a.indexed(operand)
+		if err == nil && !a.firstPass && k > 0x5F {
+			err = fmt.Errorf("indexed offset 0x%X out of range", k)
+		}
+		return k, err
+	}
+
+	addr, err := a.ranged(operand, 0, 0xFFF)
+	if err != nil {
+		return 0, err
+	}
+
+	var banked int64
+	if len(rest) >= accessIndex {
+		if banked, err = a.keyword(rest[accessIndex-1], "access", "banked"); err != nil {
+			return 0, err
+		}
+	} else {
+		inAccessBank := addr >= 0xF60 || (addr < 0x60 && !a.opts.ExtendedSet)
+		if !inAccessBank {
+			banked = 1
+		}
+	}
+
+	return uint16(addr)&0xFF | uint16(banked)<<8, nil
+}
+
+// indexed parses a [k] operand of the indexed literal offset addressing mode.
+func (a *assembler) indexed(operand string) (uint16, error) {
+	if !strings.HasPrefix(operand, "[") || !strings.HasSuffix(operand, "]") {
+		return 0, fmt.Errorf("expected an indexed operand [k], got %q", operand)
+	}
+	if !a.opts.ExtendedSet {
+		return 0, fmt.Errorf("indexed operands require the extended instruction set")
+	}
+	k, err := a.ranged(operand[1:len(operand)-1], 0, 0x7F)
+	return uint16(k), err
+}
+
+// keyword parses an operand that is either 0, 1 or one of the given names (for 0 and 1 respectively).
+// The single letter abbreviations of the names are accepted as well.
+func (a *assembler) keyword(operand, zero, one string) (int64, error) {
+	word := strings.ToLower(strings.TrimSpace(operand))
+	switch {
+	case zero != "" && (word == zero || word == zero[:1]):
+		return 0, nil
+	case word == one || word == one[:1]:
+		return 1, nil
+	}
+	return a.ranged(operand, 0, 1)
+}
+
+// ranged evaluates an expression and checks that it is in [min, max].
+// Range checks are skipped in the first pass, because the values of labels aren't known yet.
+func (a *assembler) ranged(expr string, min, max int64) (int64, error) {
+	value, err := a.value(expr)
+	if err != nil {
+		return 0, err
+	}
+	if !a.firstPass && (value < min || value > max) {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", value, min, max)
+	}
+	return value, nil
+}
@@ -0,0 +1,279 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// evaluator evaluates MPASM style expressions.
+type evaluator struct {
+	symbols map[string]int64
+	pc      int64
+	radix   int
+
+	// allowUndefined makes undefined symbols evaluate to 0, it is used in the first pass.
+	allowUndefined bool
+
+	tokens []string
+	pos    int
+}
+
+func (e *evaluator) eval(expr string) (int64, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return 0, err
+	}
+	if len(tokens) == 0 {
+		return 0, fmt.Errorf("missing operand")
+	}
+
+	e.tokens = tokens
+	e.pos = 0
+	value, err := e.binary(0)
+	if err != nil {
+		return 0, err
+	}
+	if e.pos != len(e.tokens) {
+		return 0, fmt.Errorf("unexpected %q in expression %q", e.tokens[e.pos], expr)
+	}
+	return value, nil
+}
+
+var binaryPrecedence = map[string]int{
+	"|": 1, "^": 2, "&": 3,
+	"<<": 4, ">>": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+func (e *evaluator) peek() string {
+	if e.pos < len(e.tokens) {
+		return e.tokens[e.pos]
+	}
+	return ""
+}
+
+func (e *evaluator) binary(minPrecedence int) (int64, error) {
+	left, err := e.unary()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		op := e.peek()
+		precedence, ok := binaryPrecedence[op]
+		if !ok || precedence <= minPrecedence {
+			return left, nil
+		}
+		e.pos++
+
+		right, err := e.binary(precedence)
+		if err != nil {
+			return 0, err
+		}
+
+		switch op {
+		case "|":
+			left |= right
+		case "^":
+			left ^= right
+		case "&":
+			left &= right
+		case "<<":
+			left <<= uint(right)
+		case ">>":
+			left >>= uint(right)
+		case "+":
+			left += right
+		case "-":
+			left -= right
+		case "*":
+			left *= right
+		case "/", "%":
+			if right == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			if op == "/" {
+				left /= right
+			} else {
+				left %= right
+			}
+		}
+	}
+}
+
+func (e *evaluator) unary() (int64, error) {
+	token := e.peek()
+	if token == "" {
+		return 0, fmt.Errorf("unexpected end of expression")
+	}
+	e.pos++
+
+	switch strings.ToLower(token) {
+	case "-", "+", "~", "!", "low", "high", "upper":
+		value, err := e.unary()
+		if err != nil {
+			return 0, err
+		}
+		switch strings.ToLower(token) {
+		case "-":
+			return -value, nil
+		case "~":
+			return ^value, nil
+		case "!":
+			if value == 0 {
+				return 1, nil
+			}
+			return 0, nil
+		case "low":
+			return value & 0xFF, nil
+		case "high":
+			return (value >> 8) & 0xFF, nil
+		case "upper":
+			return (value >> 16) & 0xFF, nil
+		}
+		return value, nil
+	case "(":
+		value, err := e.binary(0)
+		if err != nil {
+			return 0, err
+		}
+		if e.peek() != ")" {
+			return 0, fmt.Errorf("missing )")
+		}
+		e.pos++
+		return value, nil
+	case "$":
+		return e.pc, nil
+	}
+
+	if value, ok, err := parseNumber(token, e.radix); ok || err != nil {
+		return value, err
+	}
+
+	if value, ok := e.symbols[token]; ok {
+		return value, nil
+	}
+	if e.allowUndefined {
+		return 0, nil
+	}
+	return 0, fmt.Errorf("undefined symbol %q", token)
+}
+
+// parseNumber parses the MPASM number formats: 0x1F, 1Fh, H'1F', D'31', .31, B'11111', O'37', A'c' and 'c'.
+// Plain numbers use the current radix.
+func parseNumber(token string, radix int) (int64, bool, error) {
+	if len(token) >= 3 && token[0] == '\'' && token[len(token)-1] == '\'' {
+		return charValue(token[1 : len(token)-1])
+	}
+
+	if len(token) >= 3 && token[1] == '\'' && token[len(token)-1] == '\'' {
+		digits := token[2 : len(token)-1]
+		switch unicode.ToUpper(rune(token[0])) {
+		case 'H':
+			return parseDigits(digits, 16)
+		case 'D':
+			return parseDigits(digits, 10)
+		case 'B':
+			return parseDigits(digits, 2)
+		case 'O':
+			return parseDigits(digits, 8)
+		case 'A':
+			return charValue(digits)
+		}
+		return 0, false, nil
+	}
+
+	lower := strings.ToLower(token)
+	switch {
+	case strings.HasPrefix(lower, "0x"):
+		return parseDigits(token[2:], 16)
+	case strings.HasPrefix(lower, "0b"):
+		return parseDigits(token[2:], 2)
+	case len(token) > 1 && token[0] == '.' && unicode.IsDigit(rune(token[1])):
+		return parseDigits(token[1:], 10)
+	}
+
+	if token == "" || !unicode.IsDigit(rune(token[0])) {
+		return 0, false, nil
+	}
+
+	switch lower[len(lower)-1] {
+	case 'h':
+		return parseDigits(token[:len(token)-1], 16)
+	case 'd':
+		if radix != 16 {
+			return parseDigits(token[:len(token)-1], 10)
+		}
+	case 'b':
+		if radix != 16 {
+			return parseDigits(token[:len(token)-1], 2)
+		}
+	}
+
+	return parseDigits(token, radix)
+}
+
+func parseDigits(digits string, base int) (int64, bool, error) {
+	value, err := strconv.ParseInt(digits, base, 64)
+	if err != nil {
+		return 0, true, fmt.Errorf("invalid number %q", digits)
+	}
+	return value, true, nil
+}
+
+func charValue(str string) (int64, bool, error) {
+	if len(str) != 1 {
+		return 0, true, fmt.Errorf("invalid character constant %q", str)
+	}
+	return int64(str[0]), true, nil
+}
+
+// tokenize splits an expression into numbers, identifiers and operators.
+func tokenize(expr string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '\'':
+			end := strings.IndexByte(expr[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character constant")
+			}
+			tokens = append(tokens, expr[i:i+end+2])
+			i += end + 2
+		case isIdentChar(c) || c == '.':
+			start := i
+			for i < len(expr) && (isIdentChar(expr[i]) || expr[i] == '.') {
+				i++
+			}
+			// Radix prefixed numbers like H'1F'
+			if i-start == 1 && i < len(expr) && expr[i] == '\'' {
+				end := strings.IndexByte(expr[i+1:], '\'')
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated number")
+				}
+				i += end + 2
+			}
+			tokens = append(tokens, expr[start:i])
+		case strings.HasPrefix(expr[i:], "<<") || strings.HasPrefix(expr[i:], ">>"):
+			tokens = append(tokens, expr[i:i+2])
+			i += 2
+		case strings.ContainsRune("+-*/%&|^~!()$", rune(c)):
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '?' || c == '#' || c == '@' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
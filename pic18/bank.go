@@ -1,6 +1,8 @@
 package pic18
 
 type BankController struct {
+	// ExtendedSet enables the extended instruction set (XINST),
+	// which maps access bank addresses below 0x60 relative to FSR2.
 	ExtendedSet bool
 	WReg        *uint8
 	BSR         uint8
@@ -50,7 +52,8 @@ func (controller BankController) address(location uint8, useBankSelectRegister b
 	}
 
 	if controller.ExtendedSet && location < 0x60 {
-		return (controller.FSR[2] + uint16(location))
+		// Indexed Literal Offset Addressing
+		return (controller.FSR[2] + uint16(location)) & 0xFFF
 	}
 
 	if location >= 0x80 {
@@ -60,6 +63,11 @@ func (controller BankController) address(location uint8, useBankSelectRegister b
 	return uint16(location)
 }
 
+func (controller *BankController) reset() {
+	controller.BSR = 0
+	controller.newFSR = controller.FSR
+}
+
 func (controller *BankController) ApplyIndirectOp() {
 	controller.FSR = controller.newFSR
 }
@@ -175,7 +183,9 @@ func (controller *BankController) BusWrite(addr uint16, data uint8) AddrMask {
 	return 0
 }
 
-func (BankController) isIndfRegister(addr uint16) bool {
+// IsIndirectRegister reports whether addr is one of the indirect addressing registers (INDFn, POSTINCn, ...).
+// Accessing them accesses the memory pointed to by an FSR, and most of them modify the FSR.
+func IsIndirectRegister(addr uint16) bool {
 	return addr == INDF0 || addr == PREINC0 || addr == POSTINC0 || addr == POSTDEC0 || addr == PLUSW0 ||
 		addr == INDF1 || addr == PREINC1 || addr == POSTINC1 || addr == POSTDEC1 || addr == PLUSW1 ||
 		addr == INDF2 || addr == PREINC2 || addr == POSTINC2 || addr == POSTDEC2 || addr == PLUSW2
@@ -187,13 +197,15 @@ func (controller *BankController) indirectAddress(fsr int, action FSRAction) (ad
 	}
 
 	if action == FSRPlusW {
-		return (controller.FSR[fsr] + uint16(*controller.WReg)) & 0xFFF
+		// WREG is a signed offset
+		offset := int16(int8(*controller.WReg))
+		return (controller.FSR[fsr] + uint16(offset)) & 0xFFF
 	}
 
 	if action == FSRPreInc {
 		controller.newFSR[fsr] = controller.FSR[fsr] + 1
 		controller.newFSR[fsr] &= 0xFFF
-		return controller.FSR[fsr]
+		return controller.newFSR[fsr]
 	}
 
 	if action == FSRPostInc {
@@ -213,7 +225,7 @@ func (controller *BankController) indirectAddress(fsr int, action FSRAction) (ad
 
 func (controller *BankController) indirectRead(fsr int, action FSRAction) (uint8, AddrMask) {
 	address := controller.indirectAddress(fsr, action)
-	if controller.isIndfRegister(address) {
+	if IsIndirectRegister(address) {
 		return 0, 0
 	}
 	return controller.Bus.BusRead(address)
@@ -221,12 +233,22 @@ func (controller *BankController) indirectRead(fsr int, action FSRAction) (uint8
 
 func (controller *BankController) indirectWrite(fsr int, data uint8, action FSRAction) AddrMask {
 	address := controller.indirectAddress(fsr, action)
-	if controller.isIndfRegister(address) {
+	if IsIndirectRegister(address) {
 		return 0
 	}
 	return controller.Bus.BusWrite(address, data)
 }
 
+// SetFSR sets a file select register to a 12 bit value.
+// Unlike writes to FSRnH:FSRnL, this bypasses the bus, it is used by instructions like LFSR and ADDFSR.
+func (controller *BankController) SetFSR(num int, value uint16) {
+	if num < 0 || num >= len(controller.FSR) {
+		return
+	}
+	controller.FSR[num] = value & 0xFFF
+	controller.newFSR[num] = controller.FSR[num]
+}
+
 func (controller *BankController) setFSRH(num int, value uint8) AddrMask {
 	controller.FSR[num] &= 0x00FF
 	controller.FSR[num] |= uint16(value) << 8
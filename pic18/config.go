@@ -1,6 +1,198 @@
 package pic18
 
+// ConfigAddress is the address of the first configuration byte (CONFIG1L) in program memory.
+const ConfigAddress = 0x300000
+
+// ConfigSize is the number of configuration bytes (CONFIG1L to CONFIG7H).
+const ConfigSize = 14
+
+// DefaultConfigWords contains the values of the configuration bytes on an erased device.
+var DefaultConfigWords = [ConfigSize]byte{
+	0x00, 0x25, // CONFIG1L, CONFIG1H
+	0x1F, 0x3F, // CONFIG2L, CONFIG2H
+	0x00, 0xBF, // CONFIG3L, CONFIG3H
+	0x85, 0x00, // CONFIG4L, CONFIG4H
+	0x0F, 0xC0, // CONFIG5L, CONFIG5H
+	0x0F, 0xE0, // CONFIG6L, CONFIG6H
+	0x0F, 0x40, // CONFIG7L, CONFIG7H
+}
+
+// OscillatorMode is the value of the FOSC<3:0> configuration bits.
+type OscillatorMode uint8
+
+const (
+	OscillatorLP OscillatorMode = iota
+	OscillatorXT
+	OscillatorHSHighPower
+	OscillatorHSMediumPower
+	OscillatorECHighPowerClkOut
+	OscillatorECHighPower
+	OscillatorRCClkOut
+	OscillatorRC
+	OscillatorInternal
+	OscillatorInternalClkOut
+	OscillatorECMediumPowerClkOut
+	OscillatorECMediumPower
+	OscillatorECLowPowerClkOut
+	OscillatorECLowPower
+	OscillatorRCClkOut2
+	OscillatorRCClkOut3
+)
+
+// BrownOutMode is the value of the BOREN<1:0> configuration bits.
+type BrownOutMode uint8
+
+const (
+	BrownOutDisabled BrownOutMode = iota
+	BrownOutSoftware
+	BrownOutActive
+	BrownOutAlways
+)
+
+// Number of code protection blocks, not counting the boot block.
+const ProtectionBlocks = 4
+
+// Size of the boot block and of the other code protection blocks in bytes.
+// This is the layout of the devices with 64 KiB of program memory (PIC18F26K22 and PIC18F46K22),
+// the smaller devices of the family have smaller blocks, which isn't modelled.
+const (
+	bootBlockSize       = 0x800
+	protectionBlockSize = 0x4000
+)
+
+// ConfigTable contains the decoded configuration bits of the device.
+//
+// Protection bits are stored as "protected" rather than the inverted values in the configuration bytes.
 type ConfigTable struct {
-	ResetOnUnderflow bool
-	ResetOnFull      bool
+	Oscillator           OscillatorMode // FOSC<3:0>
+	PLL                  bool           // PLLCFG
+	PrimaryClock         bool           // PRICLKEN
+	FailSafeClockMonitor bool           // FCMEN
+	OscillatorSwitchover bool           // IESO
+
+	PowerUpTimer    bool         // PWRTEN (active low)
+	BrownOut        BrownOutMode // BOREN<1:0>
+	BrownOutVoltage uint8        // BORV<1:0>
+
+	Watchdog           WatchdogMode // WDTEN<1:0>
+	WatchdogPostscaler uint32       // WDTPS<3:0>, stored as the ratio
+
+	MclrEnable bool // MCLRE
+
+	StackOverflowReset    bool // STVREN
+	ExtendedSet           bool // XINST
+	LowVoltageProgramming bool // LVP
+	Debug                 bool // DEBUG (active low)
+
+	CodeProtect     [ProtectionBlocks]bool // CP<3:0>
+	CodeProtectBoot bool                   // CPB
+	CodeProtectData bool                   // CPD
+
+	WriteProtect       [ProtectionBlocks]bool // WRT<3:0>
+	WriteProtectBoot   bool                   // WRTB
+	WriteProtectConfig bool                   // WRTC
+	WriteProtectData   bool                   // WRTD
+
+	TableReadProtect     [ProtectionBlocks]bool // EBTR<3:0>
+	TableReadProtectBoot bool                   // EBTRB
+}
+
+// DecodeConfig decodes the configuration bytes starting at [ConfigAddress].
+// Missing bytes are taken from [DefaultConfigWords].
+func DecodeConfig(words []byte) ConfigTable {
+	var raw [ConfigSize]byte
+	copy(raw[:], DefaultConfigWords[:])
+	copy(raw[:], words)
+
+	bit := func(index int, bit int) bool {
+		return raw[index]&(1<<bit) != 0
+	}
+
+	config := ConfigTable{
+		Oscillator:           OscillatorMode(raw[1] & 0x0F),
+		PLL:                  bit(1, 4),
+		PrimaryClock:         bit(1, 5),
+		FailSafeClockMonitor: bit(1, 6),
+		OscillatorSwitchover: bit(1, 7),
+
+		PowerUpTimer:    !bit(2, 0),
+		BrownOut:        BrownOutMode((raw[2] >> 1) & 0x03),
+		BrownOutVoltage: (raw[2] >> 3) & 0x03,
+
+		Watchdog:           WatchdogMode(raw[3] & 0x03),
+		WatchdogPostscaler: 1 << ((raw[3] >> 2) & 0x0F),
+
+		MclrEnable: bit(5, 7),
+
+		StackOverflowReset:    bit(6, 0),
+		LowVoltageProgramming: bit(6, 2),
+		ExtendedSet:           bit(6, 6),
+		Debug:                 !bit(6, 7),
+
+		CodeProtectData: !bit(9, 7),
+		CodeProtectBoot: !bit(9, 6),
+
+		WriteProtectData:   !bit(11, 7),
+		WriteProtectBoot:   !bit(11, 6),
+		WriteProtectConfig: !bit(11, 5),
+
+		TableReadProtectBoot: !bit(13, 6),
+	}
+
+	for i := 0; i < ProtectionBlocks; i++ {
+		config.CodeProtect[i] = !bit(8, i)
+		config.WriteProtect[i] = !bit(10, i)
+		config.TableReadProtect[i] = !bit(12, i)
+	}
+
+	return config
+}
+
+// protectionBlockOf returns the code protection block of a program memory address.
+// The boot block is -1, addresses outside of the protected area return [ProtectionBlocks].
+func protectionBlockOf(addr uint32) int {
+	if addr < bootBlockSize {
+		return -1
+	}
+
+	block := int(addr / protectionBlockSize)
+	if block >= ProtectionBlocks {
+		return ProtectionBlocks
+	}
+	return block
+}
+
+// TableReadAllowed reports whether a table read of addr, executed from pc, returns the stored data.
+// Table reads from a block protected by EBTR, executed outside of that block, read 0.
+func (config *ConfigTable) TableReadAllowed(pc, addr uint32) bool {
+	block := protectionBlockOf(addr)
+	if block == protectionBlockOf(pc) {
+		return true
+	}
+
+	switch {
+	case block < 0:
+		return !config.TableReadProtectBoot
+	case block < ProtectionBlocks:
+		return !config.TableReadProtect[block]
+	default:
+		return true
+	}
+}
+
+// TableWriteAllowed reports whether a table write to addr changes program memory.
+func (config *ConfigTable) TableWriteAllowed(addr uint32) bool {
+	if addr >= ConfigAddress && addr < ConfigAddress+ConfigSize {
+		return !config.WriteProtectConfig
+	}
+
+	block := protectionBlockOf(addr)
+	switch {
+	case block < 0:
+		return !config.WriteProtectBoot
+	case block < ProtectionBlocks:
+		return !config.WriteProtect[block]
+	default:
+		return true
+	}
 }
@@ -0,0 +1,110 @@
+package pic18_test
+
+import (
+	"testing"
+
+	"github.com/natk64/go-pic-emu/pic18"
+)
+
+// defaultConfig is the decoded value of [pic18.DefaultConfigWords].
+var defaultConfig = pic18.ConfigTable{
+	Oscillator:            pic18.OscillatorECHighPower,
+	PrimaryClock:          true,
+	BrownOut:              pic18.BrownOutAlways,
+	BrownOutVoltage:       3,
+	Watchdog:              pic18.WatchdogAlways,
+	WatchdogPostscaler:    32768,
+	MclrEnable:            true,
+	StackOverflowReset:    true,
+	LowVoltageProgramming: true,
+}
+
+func TestDecodeConfigDefaults(t *testing.T) {
+	if config := pic18.DecodeConfig(pic18.DefaultConfigWords[:]); config != defaultConfig {
+		t.Errorf("DecodeConfig(DefaultConfigWords) = %+v, want %+v", config, defaultConfig)
+	}
+	// Missing bytes keep their default value.
+	if config := pic18.DecodeConfig(nil); config != defaultConfig {
+		t.Errorf("DecodeConfig(nil) = %+v, want %+v", config, defaultConfig)
+	}
+}
+
+// Each test replaces one configuration byte, the other bytes keep their default value.
+func TestDecodeConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		index int
+		value byte
+		want  func(config *pic18.ConfigTable)
+	}{
+		// CONFIG1L isn't implemented on this family.
+		{"CONFIG1L", 0, 0xFF, func(config *pic18.ConfigTable) {}},
+		{"CONFIG1H IESO PLLCFG FOSC", 1, 0x98, func(config *pic18.ConfigTable) {
+			config.OscillatorSwitchover = true
+			config.PrimaryClock = false
+			config.PLL = true
+			config.Oscillator = pic18.OscillatorInternal
+		}},
+		{"CONFIG1H FCMEN", 1, 0x40, func(config *pic18.ConfigTable) {
+			config.FailSafeClockMonitor = true
+			config.PrimaryClock = false
+			config.Oscillator = pic18.OscillatorLP
+		}},
+		{"CONFIG2L BORV BOREN PWRTEN", 2, 0x0A, func(config *pic18.ConfigTable) {
+			config.BrownOutVoltage = 1
+			config.BrownOut = pic18.BrownOutSoftware
+			config.PowerUpTimer = true
+		}},
+		{"CONFIG2H WDTPS WDTEN", 3, 0x16, func(config *pic18.ConfigTable) {
+			config.WatchdogPostscaler = 32
+			config.Watchdog = pic18.WatchdogActive
+		}},
+		{"CONFIG3H MCLRE", 5, 0x3F, func(config *pic18.ConfigTable) {
+			config.MclrEnable = false
+		}},
+		{"CONFIG4L DEBUG XINST LVP STVREN", 6, 0x40, func(config *pic18.ConfigTable) {
+			config.Debug = true
+			config.ExtendedSet = true
+			config.LowVoltageProgramming = false
+			config.StackOverflowReset = false
+		}},
+		{"CONFIG5L CP", 8, 0x0A, func(config *pic18.ConfigTable) {
+			config.CodeProtect = [pic18.ProtectionBlocks]bool{true, false, true, false}
+		}},
+		{"CONFIG5H CPD", 9, 0x40, func(config *pic18.ConfigTable) {
+			config.CodeProtectData = true
+		}},
+		{"CONFIG5H CPB", 9, 0x80, func(config *pic18.ConfigTable) {
+			config.CodeProtectBoot = true
+		}},
+		{"CONFIG6L WRT", 10, 0x07, func(config *pic18.ConfigTable) {
+			config.WriteProtect[3] = true
+		}},
+		{"CONFIG6H WRTD", 11, 0x60, func(config *pic18.ConfigTable) {
+			config.WriteProtectData = true
+		}},
+		{"CONFIG6H WRTB", 11, 0xA0, func(config *pic18.ConfigTable) {
+			config.WriteProtectBoot = true
+		}},
+		{"CONFIG6H WRTC", 11, 0xC0, func(config *pic18.ConfigTable) {
+			config.WriteProtectConfig = true
+		}},
+		{"CONFIG7L EBTR", 12, 0x0E, func(config *pic18.ConfigTable) {
+			config.TableReadProtect[0] = true
+		}},
+		{"CONFIG7H EBTRB", 13, 0x00, func(config *pic18.ConfigTable) {
+			config.TableReadProtectBoot = true
+		}},
+	}
+
+	for _, test := range tests {
+		words := pic18.DefaultConfigWords
+		words[test.index] = test.value
+		want := defaultConfig
+		test.want(&want)
+
+		if config := pic18.DecodeConfig(words[:]); config != want {
+			t.Errorf("%s = 0x%02X: decoded %+v, want %+v", test.name, test.value, config, want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package pic18
+
+import "github.com/natk64/go-pic-emu/pic18/instruction"
+
+// CoverageEntry is the coverage of a single instruction.
+type CoverageEntry struct {
+	Addr  uint32
+	Count uint64
+
+	// Conditional is true for conditional branches and skips. Taken counts the executions that branched
+	// or skipped the next instruction, NotTaken the ones that continued with the next instruction.
+	Conditional bool
+	Taken       uint64
+	NotTaken    uint64
+}
+
+// Coverage counts how often the instructions in program memory are executed.
+// It is enabled by setting [CPU.Coverage].
+type Coverage struct {
+	// The counters are indexed by the instruction address divided by 2, they grow as needed.
+	counts   []uint64
+	taken    []uint64
+	notTaken []uint64
+}
+
+// record counts an executed instruction. flushed tells whether the instruction changed the flow of execution.
+func (coverage *Coverage) record(addr uint32, op instruction.Opcode, flushed bool) {
+	index := int(addr >> 1)
+	if index >= len(coverage.counts) {
+		size := max(index+1, 2*len(coverage.counts))
+		coverage.counts = grow(coverage.counts, size)
+		coverage.taken = grow(coverage.taken, size)
+		coverage.notTaken = grow(coverage.notTaken, size)
+	}
+
+	coverage.counts[index]++
+	if !op.Conditional() {
+		return
+	}
+	if flushed {
+		coverage.taken[index]++
+	} else {
+		coverage.notTaken[index]++
+	}
+}
+
+func grow(counters []uint64, size int) []uint64 {
+	return append(counters, make([]uint64, size-len(counters))...)
+}
+
+// Count returns how often the instruction at addr was executed.
+func (coverage *Coverage) Count(addr uint32) uint64 {
+	if index := int(addr >> 1); index < len(coverage.counts) {
+		return coverage.counts[index]
+	}
+	return 0
+}
+
+// Entries returns the coverage of all executed instructions in ascending order of address.
+func (coverage *Coverage) Entries() []CoverageEntry {
+	var entries []CoverageEntry
+	for index, count := range coverage.counts {
+		if count == 0 {
+			continue
+		}
+
+		taken, notTaken := coverage.taken[index], coverage.notTaken[index]
+		entries = append(entries, CoverageEntry{
+			Addr:        uint32(index) << 1,
+			Count:       count,
+			Conditional: taken+notTaken > 0,
+			Taken:       taken,
+			NotTaken:    notTaken,
+		})
+	}
+	return entries
+}
+
+// Reset clears all counters.
+func (coverage *Coverage) Reset() {
+	clear(coverage.counts)
+	clear(coverage.taken)
+	clear(coverage.notTaken)
+}
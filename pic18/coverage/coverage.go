@@ -0,0 +1,172 @@
+// Package coverage writes reports of the instructions counted by a [pic18.Coverage].
+package coverage
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/natk64/go-pic-emu/binary"
+	"github.com/natk64/go-pic-emu/pic18"
+	"github.com/natk64/go-pic-emu/pic18/disasm"
+)
+
+// WriteReport writes the hit count of every instruction in the range [start, end) of program memory,
+// including the instructions that were never executed. Conditional branches and skips also show
+// how often they were taken and not taken.
+func WriteReport(w io.Writer, coverage *pic18.Coverage, bus pic18.ProgramBusReader, start, end uint32, opts *disasm.Options) error {
+	entries := make(map[uint32]pic18.CoverageEntry)
+	for _, entry := range coverage.Entries() {
+		entries[entry.Addr] = entry
+	}
+
+	buffered := bufio.NewWriter(w)
+	executed, total := 0, 0
+	for _, line := range disasm.Disassemble(bus, start, end, opts) {
+		if opts != nil {
+			if label, ok := opts.Symbols[line.Address]; ok {
+				fmt.Fprintf(buffered, "%s:\n", label)
+			}
+		}
+
+		entry := entries[line.Address]
+		branches := ""
+		if entry.Conditional {
+			branches = fmt.Sprintf("  [taken %d, not taken %d]", entry.Taken, entry.NotTaken)
+		}
+		fmt.Fprintf(buffered, "%10d  %s%s\n", entry.Count, line, branches)
+
+		total++
+		if entry.Count > 0 {
+			executed++
+		}
+	}
+
+	if total > 0 {
+		fmt.Fprintf(buffered, "\n%d of %d instructions executed (%.1f%%)\n", executed, total, float64(executed)*100/float64(total))
+	}
+	return buffered.Flush()
+}
+
+// sourceLine collects the instructions of a source line for an LCOV file.
+type sourceLine struct {
+	// count is the highest count of the instructions on the line.
+	count uint64
+	// branches are the conditional branches and skips on the line, the entries of those that never executed are empty.
+	branches []pic18.CoverageEntry
+}
+
+// function is a symbol reported in an LCOV file.
+type function struct {
+	name  string
+	line  int
+	count uint64
+}
+
+// WriteLCOV writes an LCOV tracefile with a record for every source file of the line table.
+// The instructions of every line are read from bus, the count of a line is the highest count of its instructions.
+// The symbols of opts are reported as functions at the line of their address, opts may be nil.
+func WriteLCOV(w io.Writer, coverage *pic18.Coverage, bus pic18.ProgramBusReader, lines *binary.LineTable, opts *disasm.Options) error {
+	entries := make(map[uint32]pic18.CoverageEntry)
+	for _, entry := range coverage.Entries() {
+		entries[entry.Addr] = entry
+	}
+
+	files := make(map[string]map[int]*sourceLine)
+	for _, entry := range lines.Entries() {
+		fileLines := files[entry.File]
+		if fileLines == nil {
+			fileLines = make(map[int]*sourceLine)
+			files[entry.File] = fileLines
+		}
+		line := fileLines[entry.Line]
+		if line == nil {
+			line = &sourceLine{}
+			fileLines[entry.Line] = line
+		}
+
+		for _, inst := range disasm.Disassemble(bus, entry.Start, entry.End, opts) {
+			covered := entries[inst.Address]
+			line.count = max(line.count, covered.Count)
+			if inst.Opcode.Conditional() {
+				line.branches = append(line.branches, covered)
+			}
+		}
+	}
+
+	functions := make(map[string][]function)
+	if opts != nil {
+		for addr, name := range opts.Symbols {
+			if entry, ok := lines.Lookup(addr); ok {
+				functions[entry.File] = append(functions[entry.File], function{name: name, line: entry.Line, count: entries[addr].Count})
+			}
+		}
+	}
+
+	fileNames := make([]string, 0, len(files))
+	for file := range files {
+		fileNames = append(fileNames, file)
+	}
+	slices.Sort(fileNames)
+
+	buffered := bufio.NewWriter(w)
+	for _, file := range fileNames {
+		writeLCOVRecord(buffered, file, files[file], functions[file])
+	}
+	return buffered.Flush()
+}
+
+// writeLCOVRecord writes the record of a source file.
+func writeLCOVRecord(w io.Writer, file string, lines map[int]*sourceLine, functions []function) {
+	fmt.Fprintf(w, "TN:\nSF:%s\n", file)
+
+	slices.SortFunc(functions, func(a, b function) int {
+		return cmp.Or(a.line-b.line, strings.Compare(a.name, b.name))
+	})
+	functionsHit := 0
+	for _, fn := range functions {
+		fmt.Fprintf(w, "FN:%d,%s\nFNDA:%d,%s\n", fn.line, fn.name, fn.count, fn.name)
+		if fn.count > 0 {
+			functionsHit++
+		}
+	}
+	fmt.Fprintf(w, "FNF:%d\nFNH:%d\n", len(functions), functionsHit)
+
+	lineNumbers := make([]int, 0, len(lines))
+	for line := range lines {
+		lineNumbers = append(lineNumbers, line)
+	}
+	slices.Sort(lineNumbers)
+
+	// Every conditional instruction on a line is a block with a taken and a not taken branch.
+	branchesFound, branchesHit := 0, 0
+	for _, number := range lineNumbers {
+		for block, branch := range lines[number].branches {
+			branchesFound += 2
+			if branch.Count == 0 {
+				fmt.Fprintf(w, "BRDA:%d,%d,0,-\nBRDA:%d,%d,1,-\n", number, block, number, block)
+				continue
+			}
+			for i, count := range []uint64{branch.Taken, branch.NotTaken} {
+				fmt.Fprintf(w, "BRDA:%d,%d,%d,%d\n", number, block, i, count)
+				if count > 0 {
+					branchesHit++
+				}
+			}
+		}
+	}
+	fmt.Fprintf(w, "BRF:%d\nBRH:%d\n", branchesFound, branchesHit)
+
+	linesHit := 0
+	for _, number := range lineNumbers {
+		count := lines[number].count
+		fmt.Fprintf(w, "DA:%d,%d\n", number, count)
+		if count > 0 {
+			linesHit++
+		}
+	}
+	fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", len(lineNumbers), linesHit)
+}
@@ -0,0 +1,125 @@
+package coverage
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/natk64/go-pic-emu/binary"
+	"github.com/natk64/go-pic-emu/pic18"
+	"github.com/natk64/go-pic-emu/pic18/disasm"
+	"github.com/natk64/go-pic-emu/pic18/internal/testcpu"
+)
+
+const testSource = `
+	org 0
+main
+	movlw 3
+	movwf 0x10
+loop
+	decfsz 0x10, f
+	bra loop
+	sleep
+	nop
+`
+
+// runCovered runs testSource until SLEEP with coverage enabled.
+// It returns the CPU and the disassembly options with the labels of the program.
+func runCovered(t *testing.T) (*pic18.CPU, *disasm.Options) {
+	t.Helper()
+	cpu, program := testcpu.New(t, testSource)
+	cpu.Coverage = &pic18.Coverage{}
+	for range 100 {
+		cpu.Tick()
+		if cpu.Sleep.Asleep() {
+			return cpu, &disasm.Options{Symbols: program.Labels()}
+		}
+	}
+	t.Fatal("the program didn't reach SLEEP")
+	return nil, nil
+}
+
+func TestCoverage(t *testing.T) {
+	cpu, _ := runCovered(t)
+
+	// DECFSZ continues twice and skips the BRA the third time.
+	want := []pic18.CoverageEntry{
+		{Addr: 0x00, Count: 1},
+		{Addr: 0x02, Count: 1},
+		{Addr: 0x04, Count: 3, Conditional: true, Taken: 1, NotTaken: 2},
+		{Addr: 0x06, Count: 2},
+		{Addr: 0x08, Count: 1},
+	}
+	if entries := cpu.Coverage.Entries(); !slices.Equal(entries, want) {
+		t.Errorf("coverage is\n%+v\nwant\n%+v", entries, want)
+	}
+	if count := cpu.Coverage.Count(0x0A); count != 0 {
+		t.Errorf("the NOP after SLEEP was executed %d times", count)
+	}
+
+	cpu.Coverage.Reset()
+	if entries := cpu.Coverage.Entries(); entries != nil {
+		t.Errorf("coverage after a reset is %+v, want nothing", entries)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	cpu, opts := runCovered(t)
+
+	var out strings.Builder
+	if err := WriteReport(&out, cpu.Coverage, cpu.ProgramBus, 0, 0x0C, opts); err != nil {
+		t.Fatal(err)
+	}
+	report := out.String()
+	for _, want := range []string{
+		"main:\n",
+		"loop:\n",
+		"  [taken 1, not taken 2]\n",
+		"\n5 of 6 instructions executed (83.3%)\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("the report doesn't contain %q:\n%s", want, report)
+		}
+	}
+}
+
+func TestWriteLCOV(t *testing.T) {
+	cpu, opts := runCovered(t)
+
+	// Lines 4 to 10 of the source, the label on line 6 has no code.
+	var entries []binary.LineEntry
+	for i, line := range []int{4, 5, 7, 8, 9, 10} {
+		addr := uint32(i * 2)
+		entries = append(entries, binary.LineEntry{Start: addr, End: addr + 2, File: "test.asm", Line: line, IsStmt: true})
+	}
+
+	var out strings.Builder
+	if err := WriteLCOV(&out, cpu.Coverage, cpu.ProgramBus, binary.NewLineTable(entries), opts); err != nil {
+		t.Fatal(err)
+	}
+	want := `TN:
+SF:test.asm
+FN:4,main
+FNDA:1,main
+FN:7,loop
+FNDA:3,loop
+FNF:2
+FNH:2
+BRDA:7,0,0,1
+BRDA:7,0,1,2
+BRF:2
+BRH:2
+DA:4,1
+DA:5,1
+DA:7,3
+DA:8,2
+DA:9,1
+DA:10,0
+LF:6
+LH:5
+end_of_record
+`
+	if out.String() != want {
+		t.Errorf("the LCOV file is\n%s\nwant\n%s", out.String(), want)
+	}
+}
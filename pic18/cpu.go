@@ -15,12 +15,25 @@ type CPU struct {
 	fetchedInstruction uint16
 	WReg               uint8
 
+	// executing is the address of the instruction that is executing.
+	executing uint32
+
 	pcLatchHigh  uint8
 	pcLatchUpper uint8
 
 	flush          bool
 	interruptState InterruptState
 
+	// interruptedState is the interrupt state when the current interrupt was taken, RETFIE returns to it.
+	// A high priority interrupt can interrupt a low priority ISR, so there are at most two levels.
+	interruptedState InterruptState
+
+	// cycles counts elapsed instruction cycles (Tcy) since the CPU was created.
+	cycles uint64
+
+	// instructions counts executed instructions since the CPU was created.
+	instructions uint64
+
 	shadowWreg   uint8
 	shadowStatus uint8
 	shadowBsr    uint8
@@ -29,15 +42,21 @@ type CPU struct {
 	Stack          Stack
 	BankController BankController
 	Interrupts     InterruptController
+	Resets         ResetController
 
-	nextAction func(instruction.Instruction)
+	// pending is the two word instruction whose second word executes in the next cycle.
+	pending pendingInstruction
 
 	Table        *TableRWController
 	Sleep        *SleepController
+	Watchdog     *WatchdogTimer
 	Config       *ConfigTable
 	DataBus      DataBusReadWriter
 	ProgramBus   ProgramBusReadWriter
 	EventHandler CpuEventHandler
+
+	// Coverage counts the executed instructions if it isn't nil.
+	Coverage *Coverage
 }
 
 type CpuEventHandler interface {
@@ -65,7 +84,9 @@ func (cpu *CPU) BusRead(addr uint16) (uint8, AddrMask) {
 func (cpu *CPU) BusWrite(addr uint16, data uint8) AddrMask {
 	switch addr {
 	case Registers.PCL:
-		cpu.pc = (uint32(cpu.pcLatchUpper) << 16) | (uint32(cpu.pcLatchHigh) << 8) | uint32(data)
+		// Writing PCL is a jump, the fetched instruction is discarded.
+		cpu.pc = (uint32(cpu.pcLatchUpper) << 16) | (uint32(cpu.pcLatchHigh) << 8) | uint32(data&0xFE)
+		cpu.flush = true
 		return 0xFF
 	case Registers.PCLATH:
 		cpu.pcLatchHigh = data
@@ -82,33 +103,72 @@ func (cpu *CPU) BusWrite(addr uint16, data uint8) AddrMask {
 }
 
 func (cpu *CPU) PowerOnReset() {
+	cpu.reset(ResetPowerOn)
+}
+
+// BrownOutReset resets the device after a brown-out condition.
+// It does nothing if BOR is disabled in the configuration bits.
+func (cpu *CPU) BrownOutReset() {
+	if cpu.Config.BrownOut == BrownOutDisabled {
+		return
+	}
+	if cpu.Config.BrownOut == BrownOutActive && cpu.Sleep.Asleep() {
+		return
+	}
+	cpu.reset(ResetBrownOut)
+}
+
+// MclrReset resets the device using the MCLR pin.
+// It does nothing if MCLRE is cleared, in that case the pin is a digital input.
+func (cpu *CPU) MclrReset() {
+	if !cpu.Config.MclrEnable {
+		return
+	}
+	cpu.reset(ResetMCLR)
+}
+
+func (cpu *CPU) WatchdogReset() {
+	cpu.reset(ResetWatchdog)
+}
+
+// reset puts the core into its reset state and records the cause in RCON.
+// The XINST configuration bit is latched here, changes to the configuration take effect on the next reset.
+// Registers that the datasheet lists as unchanged (WREG, STATUS, FSRs, PRODH:PRODL) keep their value.
+func (cpu *CPU) reset(cause ResetCause) {
 	cpu.flush = true
 	cpu.pc = 0
 	cpu.fetchedInstruction = 0
-	cpu.interruptState = 0
-	cpu.nextAction = nil
+	cpu.interruptState = InterruptStateNone
+	cpu.interruptedState = InterruptStateNone
+	cpu.pending = pendingInstruction{}
 	cpu.pcLatchHigh = 0
 	cpu.pcLatchUpper = 0
 	cpu.shadowBsr = 0
 	cpu.shadowStatus = 0
 	cpu.shadowWreg = 0
-}
 
-func (cpu *CPU) MclrReset() {
-	panic("unimplemented")
+	cpu.BankController.ExtendedSet = cpu.Config.ExtendedSet
+	cpu.BankController.reset()
+	cpu.Stack.reset(cause == ResetPowerOn)
+	cpu.Interrupts.reset()
+	cpu.Table.reset()
+	cpu.Watchdog.reset()
+	cpu.Resets.reset(cause)
+	cpu.Sleep.WakeUp()
 }
 
 func (cpu *CPU) GotoInterrupt(highPrio bool) {
 	cpu.shadowWreg = cpu.WReg
 	cpu.shadowStatus = uint8(cpu.Alu.status)
 	cpu.shadowBsr = cpu.BankController.BSR
+	cpu.interruptedState = cpu.interruptState
 	if highPrio {
 		cpu.interruptState = InterruptStateHighPrio
 	} else {
 		cpu.interruptState = InterruptStateLowPrio
 	}
 
-	if !cpu.Stack.Push(cpu.pc) {
+	if !cpu.stackPush(cpu.pc) {
 		return
 	}
 
@@ -124,8 +184,8 @@ func (cpu *CPU) GotoInterrupt(highPrio bool) {
 // It returns true if execution can continue, or false if the CPU was reset and the caller should abort.
 func (cpu *CPU) stackPush(value uint32) bool {
 	becameFull := cpu.Stack.Push(value)
-	if becameFull && cpu.Config.ResetOnFull {
-		cpu.MclrReset()
+	if becameFull && cpu.Config.StackOverflowReset {
+		cpu.reset(ResetStackFull)
 		return false
 	}
 
@@ -135,15 +195,87 @@ func (cpu *CPU) stackPush(value uint32) bool {
 // stackPop wraps stack.Pop, just like stackPush.
 func (cpu *CPU) stackPop() bool {
 	underflow := cpu.Stack.Pop()
-	if underflow && cpu.Config.ResetOnUnderflow {
-		cpu.MclrReset()
+	if underflow && cpu.Config.StackOverflowReset {
+		cpu.reset(ResetStackUnderflow)
 		return false
 	}
 
 	return true
 }
 
+// Cycles returns the number of instruction cycles elapsed so far, including the cycles spent asleep.
+// The counter is monotonic, it is not cleared by any kind of reset.
+func (cpu *CPU) Cycles() uint64 {
+	return cpu.cycles
+}
+
+// InterruptState tells whether the CPU is executing an interrupt service routine.
+// It is set when an interrupt is taken, RETFIE restores the state from before the interrupt,
+// which is the low priority state if a high priority interrupt interrupted a low priority ISR.
+func (cpu *CPU) InterruptState() InterruptState {
+	return cpu.interruptState
+}
+
+// Instructions returns the number of instructions executed so far, including illegal ones.
+// Interrupt entries and the second words of two word instructions don't count. Like the cycle counter,
+// it is not cleared by resets, but it isn't part of snapshots either.
+func (cpu *CPU) Instructions() uint64 {
+	return cpu.instructions
+}
+
+// PC returns the address of the next instruction, it is only meaningful at an instruction boundary.
+func (cpu *CPU) PC() uint32 {
+	return cpu.pc
+}
+
+// InstructionAddress returns the address of the instruction that is executing,
+// or of the last executed instruction between ticks. For two word instructions it is the address of the first word.
+func (cpu *CPU) InstructionAddress() uint32 {
+	return cpu.executing
+}
+
+// SetPC continues execution at pc without spending a cycle. It is meant for debuggers,
+// firmware changes the program counter through PCL.
+func (cpu *CPU) SetPC(pc uint32) {
+	cpu.pc = pc & 0x1FFFFE
+	cpu.pending = pendingInstruction{}
+	cpu.flush = false
+	cpu.FetchInstruction()
+}
+
+// AtInstructionBoundary reports whether the next tick begins a new instruction.
+// The refetch after a jump and the second word of a two word instruction belong to the previous instruction.
+func (cpu *CPU) AtInstructionBoundary() bool {
+	return cpu.pending.Opcode == instruction.ILLEGAL && !cpu.flush
+}
+
+// Tick advances the CPU by exactly one instruction cycle (4 oscillator clocks).
+//
+// Single word instructions complete in one tick. Instructions that modify the program counter
+// (branches, calls, returns, skips, table operations) set the flush flag, so the following tick
+// only refetches and behaves like a forced NOP. Two word instructions execute their second word
+// in the following tick. Together this gives the cycle counts from the instruction set summary,
+// including 2 and 3 cycle skips.
+//
+// While the device is asleep, no instructions are executed and only the watchdog timer advances.
+// The cycle counter keeps counting, so it measures time.
 func (cpu *CPU) Tick() {
+	cpu.cycles++
+	if cpu.Sleep.Asleep() {
+		cpu.tickWatchdog(true)
+		return
+	}
+
+	if cpu.tickWatchdog(false) {
+		return
+	}
+
+	if cpu.pending.Opcode != instruction.ILLEGAL {
+		// The second word of a two word instruction can't be interrupted.
+		cpu.executeSecondWord()
+		return
+	}
+
 	if cpu.Interrupts.CheckHighPriority() {
 		cpu.GotoInterrupt(true)
 		return
@@ -159,23 +291,53 @@ func (cpu *CPU) Tick() {
 	}
 
 	decoded := instruction.Instruction(cpu.fetchedInstruction)
+	cpu.executing = cpu.pc
+	cpu.instructions++
 	cpu.pc += 2
 
-	if cpu.nextAction != nil {
-		tmp := cpu.nextAction
-		cpu.nextAction = nil
-		tmp(decoded)
-		cpu.FetchInstruction()
-		return
-	}
-
-	ok := cpu.ExecuteInstruction(decoded, true)
+	ok := cpu.ExecuteInstruction(decoded, cpu.BankController.ExtendedSet)
 	if !ok {
 		if cpu.EventHandler != nil {
 			cpu.EventHandler.IllegalInstruction()
 		}
 	}
 
+	if cpu.Coverage != nil {
+		// Taken branches and skips flush the fetched instruction.
+		cpu.Coverage.record(cpu.executing, decoded.Opcode(), cpu.flush)
+	}
+
+	cpu.BankController.ApplyIndirectOp()
+	cpu.FetchInstruction()
+}
+
+// tickWatchdog advances the watchdog timer and handles a time-out.
+// It returns true if the device was reset.
+func (cpu *CPU) tickWatchdog(asleep bool) bool {
+	if !cpu.Watchdog.tick(asleep) {
+		return false
+	}
+
+	if asleep {
+		// A time-out during sleep wakes the device up and execution continues after the SLEEP instruction.
+		cpu.Resets.timeOut = false
+		cpu.Resets.powerDown = false
+		cpu.Sleep.WakeUp()
+		return false
+	}
+
+	cpu.WatchdogReset()
+	return true
+}
+
+// executeSecondWord completes a two word instruction using the word that was fetched after the first one.
+func (cpu *CPU) executeSecondWord() {
+	decoded := instruction.Instruction(cpu.fetchedInstruction)
+	cpu.pc += 2
+
+	pending := cpu.pending
+	cpu.pending = pendingInstruction{}
+	cpu.executePending(pending, decoded)
 	cpu.BankController.ApplyIndirectOp()
 	cpu.FetchInstruction()
 }
@@ -4,23 +4,26 @@ import (
 	"github.com/natk64/go-pic-emu/pic18/instruction"
 )
 
+// ExecuteInstruction executes a single instruction word.
+// It returns false if the instruction is illegal, which includes extended instructions when extendedSet is false.
+// Illegal instructions are executed as a NOP.
 func (cpu *CPU) ExecuteInstruction(inst instruction.Instruction, extendedSet bool) bool {
 	opcode := inst.Opcode()
 	if opcode == instruction.ILLEGAL {
 		return false
 	}
 
+	if opcode.Extended() && !extendedSet {
+		return false
+	}
+
 	switch opcode {
 	case instruction.ADDFSR:
-		if extendedSet {
-			cpu.execADDFSR(inst)
-		}
+		cpu.execADDFSR(inst)
 	case instruction.ADDLW:
 		cpu.execADDLW(inst)
 	case instruction.ADDULNK:
-		if extendedSet {
-			cpu.execADDULNK(inst)
-		}
+		cpu.execADDULNK(inst)
 	case instruction.ADDWF:
 		cpu.execADDWF(inst)
 	case instruction.ADDWFC:
@@ -60,9 +63,7 @@ func (cpu *CPU) ExecuteInstruction(inst instruction.Instruction, extendedSet boo
 	case instruction.CALL:
 		cpu.execCALL(inst)
 	case instruction.CALLW:
-		if extendedSet {
-			cpu.execCALLW(inst)
-		}
+		cpu.execCALLW(inst)
 	case instruction.CLRF:
 		cpu.execCLRF(inst)
 	case instruction.CLRWDT:
@@ -106,13 +107,9 @@ func (cpu *CPU) ExecuteInstruction(inst instruction.Instruction, extendedSet boo
 	case instruction.MOVLW:
 		cpu.execMOVLW(inst)
 	case instruction.MOVSF:
-		if extendedSet {
-			cpu.execMOVSF(inst)
-		}
+		cpu.execMOVSF(inst)
 	case instruction.MOVSS:
-		if extendedSet {
-			cpu.execMOVSS(inst)
-		}
+		cpu.execMOVSS(inst)
 	case instruction.MOVWF:
 		cpu.execMOVWF(inst)
 	case instruction.MULLW:
@@ -130,9 +127,7 @@ func (cpu *CPU) ExecuteInstruction(inst instruction.Instruction, extendedSet boo
 	case instruction.PUSH:
 		cpu.execPUSH(inst)
 	case instruction.PUSHL:
-		if extendedSet {
-			cpu.execPUSHL(inst)
-		}
+		cpu.execPUSHL(inst)
 	case instruction.RCALL:
 		cpu.execRCALL(inst)
 	case instruction.RESET:
@@ -156,17 +151,13 @@ func (cpu *CPU) ExecuteInstruction(inst instruction.Instruction, extendedSet boo
 	case instruction.SLEEP:
 		cpu.execSLEEP(inst)
 	case instruction.SUBFSR:
-		if extendedSet {
-			cpu.execSUBFSR(inst)
-		}
+		cpu.execSUBFSR(inst)
 	case instruction.SUBFWB:
 		cpu.execSUBFWB(inst)
 	case instruction.SUBLW:
 		cpu.execSUBLW(inst)
 	case instruction.SUBULNK:
-		if extendedSet {
-			cpu.execSUBULNK(inst)
-		}
+		cpu.execSUBULNK(inst)
 	case instruction.SUBWF:
 		cpu.execSUBWF(inst)
 	case instruction.SUBWFB:
@@ -208,10 +199,46 @@ func offsetPC_11bit(pc uint32, signedWordOffset uint16) uint32 {
 	return uint32(int64(pc) + int64(native)*2)
 }
 
+// pendingInstruction holds what the first word of a two word instruction passes on to the second word.
+// An ILLEGAL opcode means that no instruction is pending.
+type pendingInstruction struct {
+	Opcode instruction.Opcode
+	Value  uint16
+	Index  uint8
+}
+
+// executePending executes the second word of a two word instruction.
+func (cpu *CPU) executePending(pending pendingInstruction, next instruction.Instruction) {
+	switch pending.Opcode {
+	case instruction.LFSR:
+		low := uint16(instruction.LoadFsrLow(next).K())
+		cpu.BankController.SetFSR(int(pending.Index), pending.Value|low)
+	case instruction.MOVFF:
+		dest := instruction.ByteToByte(next).F()
+		if dest == Registers.PCL || dest == Registers.TOSU || dest == Registers.TOSH || dest == Registers.TOSL {
+			return
+		}
+		cpu.DataBus.BusWrite(dest, uint8(pending.Value))
+	case instruction.MOVSF:
+		cpu.DataBus.BusWrite(instruction.MovsfLow(next).F(), uint8(pending.Value))
+	case instruction.MOVSS:
+		cpu.DataBus.BusWrite((cpu.BankController.FSR[2]+uint16(instruction.MovsfHighMovss(next).Z()))&0xFFF, uint8(pending.Value))
+	}
+}
+
+// skip skips the instruction word following the current instruction.
+// Skipping takes 2 cycles, the skipped word is discarded and the next one has to be fetched.
+// If the skipped instruction is a two word instruction, its second word executes as a NOP (see [instruction.NOP1]),
+// which brings the total to the 3 cycles the datasheet specifies.
+func (cpu *CPU) skip() {
+	cpu.pc += 2
+	cpu.flush = true
+}
+
 func (cpu *CPU) execADDFSR(inst instruction.Instruction) {
 	index := int(instruction.XinstFsr(inst).F())
 	if index < len(cpu.BankController.FSR) {
-		cpu.BankController.FSR[index] += uint16(instruction.XinstFsr(inst).K())
+		cpu.BankController.SetFSR(index, cpu.BankController.FSR[index]+uint16(instruction.XinstFsr(inst).K()))
 	}
 }
 
@@ -220,7 +247,7 @@ func (cpu *CPU) execADDLW(inst instruction.Instruction) {
 }
 
 func (cpu *CPU) execADDULNK(inst instruction.Instruction) {
-	cpu.BankController.FSR[2] += uint16(instruction.Literal(inst).K())
+	cpu.BankController.SetFSR(2, cpu.BankController.FSR[2]+uint16(instruction.XinstFsr(inst).K()))
 	cpu.pc = cpu.Stack.Top()
 	if !cpu.stackPop() {
 		return
@@ -328,8 +355,7 @@ func (cpu *CPU) execBTFSC(inst instruction.Instruction) {
 	val := cpu.BankController.Read(instruction.BitOriented(inst).F(), instruction.BitOriented(inst).A())
 	val &= (1 << instruction.BitOriented(inst).Bit())
 	if val == 0 {
-		cpu.pc += 2
-		cpu.flush = true
+		cpu.skip()
 	}
 }
 
@@ -337,8 +363,7 @@ func (cpu *CPU) execBTFSS(inst instruction.Instruction) {
 	val := cpu.BankController.Read(instruction.BitOriented(inst).F(), instruction.BitOriented(inst).A())
 	val &= (1 << instruction.BitOriented(inst).Bit())
 	if val != 0 {
-		cpu.pc += 2
-		cpu.flush = true
+		cpu.skip()
 	}
 }
 
@@ -363,16 +388,17 @@ func (cpu *CPU) execBZ(inst instruction.Instruction) {
 }
 
 func (cpu *CPU) execCALL(inst instruction.Instruction) bool {
-	if !cpu.stackPush(cpu.pc) {
-		return false
-	}
-
 	fetched_low, _ := cpu.ProgramBus.BusRead(cpu.pc)
 	fetched_high, _ := cpu.ProgramBus.BusRead(cpu.pc + 1)
 	next_instruction := (uint16(fetched_high) << 8) | uint16(fetched_low)
 	pcHigh := instruction.ControlCallLow(next_instruction).Literal() << 8
 	pcLow := instruction.ControlCallHigh(inst).Literal()
 
+	// The return address is the instruction after the second word.
+	if !cpu.stackPush(cpu.pc + 2) {
+		return false
+	}
+
 	cpu.pc = (uint32(pcHigh) | uint32(pcLow)) << 1
 
 	if instruction.ControlCallHigh(inst).S() {
@@ -390,8 +416,7 @@ func (cpu *CPU) execCALLW(instruction.Instruction) bool {
 		return false
 	}
 
-	cpu.pc &= 0xFFFFFF00
-	cpu.pc |= uint32(cpu.WReg)
+	cpu.pc = (uint32(cpu.pcLatchUpper) << 16) | (uint32(cpu.pcLatchHigh) << 8) | uint32(cpu.WReg&0xFE)
 
 	cpu.flush = true
 	return true
@@ -404,7 +429,9 @@ func (cpu *CPU) execCLRF(inst instruction.Instruction) {
 }
 
 func (cpu *CPU) execCLRWDT(instruction.Instruction) {
-	// TODO:
+	cpu.Watchdog.Clear()
+	cpu.Resets.timeOut = true
+	cpu.Resets.powerDown = true
 }
 
 func (cpu *CPU) execCOMF(inst instruction.Instruction) {
@@ -421,24 +448,21 @@ func (cpu *CPU) execCOMF(inst instruction.Instruction) {
 func (cpu *CPU) execCPFSEQ(inst instruction.Instruction) {
 	val := cpu.BankController.Read(instruction.ByteOriented(inst).F(), instruction.ByteOriented(inst).A())
 	if val == cpu.WReg {
-		cpu.pc += 2
-		cpu.flush = true
+		cpu.skip()
 	}
 }
 
 func (cpu *CPU) execCPFSGT(inst instruction.Instruction) {
 	val := cpu.BankController.Read(instruction.ByteOriented(inst).F(), instruction.ByteOriented(inst).A())
 	if val > cpu.WReg {
-		cpu.pc += 2
-		cpu.flush = true
+		cpu.skip()
 	}
 }
 
 func (cpu *CPU) execCPFSLT(inst instruction.Instruction) {
 	val := cpu.BankController.Read(instruction.ByteOriented(inst).F(), instruction.ByteOriented(inst).A())
 	if val < cpu.WReg {
-		cpu.pc += 2
-		cpu.flush = true
+		cpu.skip()
 	}
 }
 
@@ -468,8 +492,7 @@ func (cpu *CPU) execDECFSZ(inst instruction.Instruction) {
 	}
 
 	if result == 0 {
-		cpu.pc += 2
-		cpu.flush = true
+		cpu.skip()
 	}
 }
 
@@ -484,8 +507,7 @@ func (cpu *CPU) execDCFSNZ(inst instruction.Instruction) {
 	}
 
 	if result != 0 {
-		cpu.pc += 2
-		cpu.flush = true
+		cpu.skip()
 	}
 }
 
@@ -522,8 +544,7 @@ func (cpu *CPU) execINCFSZ(inst instruction.Instruction) {
 	}
 
 	if result == 0 {
-		cpu.pc += 2
-		cpu.flush = true
+		cpu.skip()
 	}
 }
 
@@ -538,8 +559,7 @@ func (cpu *CPU) execINFSNZ(inst instruction.Instruction) {
 	}
 
 	if result != 0 {
-		cpu.pc += 2
-		cpu.flush = true
+		cpu.skip()
 	}
 }
 
@@ -559,19 +579,10 @@ func (cpu *CPU) execIORWF(inst instruction.Instruction) {
 }
 
 func (cpu *CPU) execLFSR(inst instruction.Instruction) {
-	k := instruction.LoadFsrHigh(inst).K()
-	index := int(instruction.LoadFsrHigh(inst).F())
-	if index < len(cpu.BankController.FSR) {
-		cpu.BankController.FSR[index] &= 0x00FF
-		cpu.BankController.FSR[index] |= uint16(k) << 8
-	}
-
-	cpu.nextAction = func(next instruction.Instruction) {
-		k := instruction.LoadFsrLow(next).K()
-		if index < len(cpu.BankController.FSR) {
-			cpu.BankController.FSR[index] &= 0xFF00
-			cpu.BankController.FSR[index] |= uint16(k)
-		}
+	cpu.pending = pendingInstruction{
+		Opcode: instruction.LFSR,
+		Value:  uint16(instruction.LoadFsrHigh(inst).K()) << 8,
+		Index:  instruction.LoadFsrHigh(inst).F(),
 	}
 }
 
@@ -597,14 +608,7 @@ func (cpu *CPU) execMOVF(inst instruction.Instruction) {
 
 func (cpu *CPU) execMOVFF(inst instruction.Instruction) {
 	val, _ := cpu.DataBus.BusRead(instruction.ByteToByte(inst).F())
-	cpu.nextAction = func(next instruction.Instruction) {
-		dest := instruction.ByteToByte(next).F()
-		if dest == Registers.PCL || dest == Registers.TOSU || dest == Registers.TOSH || dest == Registers.TOSL {
-			return
-		}
-
-		cpu.DataBus.BusWrite(dest, val)
-	}
+	cpu.pending = pendingInstruction{Opcode: instruction.MOVFF, Value: uint16(val)}
 }
 
 func (cpu *CPU) execMOVLB(inst instruction.Instruction) {
@@ -616,17 +620,13 @@ func (cpu *CPU) execMOVLW(inst instruction.Instruction) {
 }
 
 func (cpu *CPU) execMOVSF(inst instruction.Instruction) {
-	src_value, _ := cpu.DataBus.BusRead(uint16(cpu.BankController.FSR[2]) + uint16(instruction.MovsfHighMovss(inst).Z()))
-	cpu.nextAction = func(next instruction.Instruction) {
-		cpu.DataBus.BusWrite(instruction.MovsfLow(next).F(), src_value)
-	}
+	src_value, _ := cpu.DataBus.BusRead((cpu.BankController.FSR[2] + uint16(instruction.MovsfHighMovss(inst).Z())) & 0xFFF)
+	cpu.pending = pendingInstruction{Opcode: instruction.MOVSF, Value: uint16(src_value)}
 }
 
 func (cpu *CPU) execMOVSS(inst instruction.Instruction) {
-	src_value, _ := cpu.DataBus.BusRead(uint16(cpu.BankController.FSR[2]) + uint16(instruction.MovsfHighMovss(inst).Z()))
-	cpu.nextAction = func(next instruction.Instruction) {
-		cpu.DataBus.BusWrite(uint16(cpu.BankController.FSR[2])+uint16(instruction.MovsfHighMovss(next).Z()), src_value)
-	}
+	src_value, _ := cpu.DataBus.BusRead((cpu.BankController.FSR[2] + uint16(instruction.MovsfHighMovss(inst).Z())) & 0xFFF)
+	cpu.pending = pendingInstruction{Opcode: instruction.MOVSS, Value: uint16(src_value)}
 }
 
 func (cpu *CPU) execMOVWF(inst instruction.Instruction) {
@@ -658,7 +658,7 @@ func (cpu *CPU) execPUSH(instruction.Instruction) {
 
 func (cpu *CPU) execPUSHL(inst instruction.Instruction) {
 	cpu.DataBus.BusWrite(cpu.BankController.FSR[2], instruction.Literal(inst).K())
-	cpu.BankController.FSR[2]--
+	cpu.BankController.SetFSR(2, cpu.BankController.FSR[2]-1)
 }
 
 func (cpu *CPU) execRCALL(inst instruction.Instruction) {
@@ -670,7 +670,7 @@ func (cpu *CPU) execRCALL(inst instruction.Instruction) {
 }
 
 func (cpu *CPU) execRESET(instruction.Instruction) {
-	cpu.MclrReset()
+	cpu.reset(ResetInstruction)
 }
 
 func (cpu *CPU) execRETFIE(inst instruction.Instruction) {
@@ -679,7 +679,22 @@ func (cpu *CPU) execRETFIE(inst instruction.Instruction) {
 		return
 	}
 
-	cpu.Interrupts.HighPriorityEnable = true
+	// Re-enable the interrupts of the ISR that returns. Without a known ISR, e.g. if the firmware uses RETFIE
+	// as a return, GIEH is cleared in a high priority ISR, so if it is still set this is a low priority ISR.
+	switch cpu.interruptState {
+	case InterruptStateHighPrio:
+		cpu.Interrupts.HighPriorityEnable = true
+	case InterruptStateLowPrio:
+		cpu.Interrupts.LowPriorityEnable = true
+	default:
+		if cpu.Interrupts.InterruptPriorityEnable && cpu.Interrupts.HighPriorityEnable {
+			cpu.Interrupts.LowPriorityEnable = true
+		} else {
+			cpu.Interrupts.HighPriorityEnable = true
+		}
+	}
+	cpu.interruptState = cpu.interruptedState
+	cpu.interruptedState = InterruptStateNone
 
 	if instruction.ControlReturn(inst).S() {
 		cpu.WReg = cpu.shadowWreg
@@ -765,14 +780,16 @@ func (cpu *CPU) execSETF(inst instruction.Instruction) {
 }
 
 func (cpu *CPU) execSLEEP(instruction.Instruction) {
-	// TODO: Clear WDT
+	cpu.Watchdog.Clear()
+	cpu.Resets.timeOut = true
+	cpu.Resets.powerDown = false
 	cpu.Sleep.Sleep()
 }
 
 func (cpu *CPU) execSUBFSR(inst instruction.Instruction) {
 	index := int(instruction.XinstFsr(inst).F())
 	if index < len(cpu.BankController.FSR) {
-		cpu.BankController.FSR[index] -= uint16(instruction.XinstFsr(inst).K())
+		cpu.BankController.SetFSR(index, cpu.BankController.FSR[index]-uint16(instruction.XinstFsr(inst).K()))
 	}
 }
 
@@ -792,7 +809,7 @@ func (cpu *CPU) execSUBLW(inst instruction.Instruction) {
 }
 
 func (cpu *CPU) execSUBULNK(inst instruction.Instruction) {
-	cpu.BankController.FSR[2] -= uint16(instruction.Literal(inst).K())
+	cpu.BankController.SetFSR(2, cpu.BankController.FSR[2]-uint16(instruction.XinstFsr(inst).K()))
 	cpu.pc = cpu.Stack.Top()
 	if !cpu.stackPop() {
 		return
@@ -835,7 +852,7 @@ func (cpu *CPU) execSWAPF(inst instruction.Instruction) {
 
 func (cpu *CPU) execTBLRD(inst instruction.Instruction) {
 	action := TableAction(instruction.TableOp(inst).N())
-	cpu.Table.TableRead(action)
+	cpu.Table.TableRead(action, cpu.pc-2)
 	cpu.flush = true
 }
 
@@ -848,8 +865,7 @@ func (cpu *CPU) execTBLWT(inst instruction.Instruction) {
 func (cpu *CPU) execTSTFSZ(inst instruction.Instruction) {
 	val := cpu.BankController.Read(instruction.ByteOriented(inst).F(), instruction.ByteOriented(inst).A())
 	if val == 0 {
-		cpu.pc += 2
-		cpu.flush = true
+		cpu.skip()
 	}
 }
 
@@ -0,0 +1,596 @@
+package pic18_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/natk64/go-pic-emu/pic18"
+)
+
+// STATUS flags.
+const (
+	flagC  = 1 << 0
+	flagDC = 1 << 1
+	flagZ  = 1 << 2
+	flagOV = 1 << 3
+	flagN  = 1 << 4
+)
+
+// R is the file register most instruction tests operate on.
+const R = 0x10
+
+// execTest runs a short program and checks W, STATUS, data memory and the number of cycles.
+type execTest struct {
+	name string
+
+	// program contains the instruction words, the comments show them in assembly.
+	// The test appends a SLEEP instruction.
+	program []uint16
+
+	w, status uint8
+	data      map[uint16]uint8
+
+	wantW, wantStatus uint8
+	want              map[uint16]uint8
+
+	// cycles is the number of instruction cycles of the program, without the SLEEP.
+	cycles uint64
+
+	// setup, if not nil, prepares the machine before W, STATUS and the data memory are set.
+	setup func(m *testMachine)
+}
+
+// extended enables the extended instruction set and indexed literal offset addressing.
+func extended(m *testMachine) {
+	m.config.ExtendedSet = true
+	m.cpu.PowerOnReset()
+}
+
+func (test execTest) run(t *testing.T) {
+	t.Helper()
+	m := newTestMachine(t, slices.Concat(test.program, []uint16{0x0003})...)
+	if test.setup != nil {
+		test.setup(m)
+	}
+	m.cpu.WReg = test.w
+	m.write(pic18.Registers.STATUS, 1, uint32(test.status))
+	for addr, value := range test.data {
+		m.write(addr, 1, uint32(value))
+	}
+
+	m.run(t, 100)
+
+	// The first cycle after the reset fetches the first instruction, the last one executes SLEEP.
+	if cycles := m.cpu.Cycles() - 2; cycles != test.cycles {
+		t.Errorf("%s: took %d cycles, want %d", test.name, cycles, test.cycles)
+	}
+	if m.cpu.WReg != test.wantW {
+		t.Errorf("%s: W = 0x%02X, want 0x%02X", test.name, m.cpu.WReg, test.wantW)
+	}
+	if status := uint8(m.read(pic18.Registers.STATUS, 1)); status != test.wantStatus {
+		t.Errorf("%s: STATUS = %s, want %s", test.name, flags(status), flags(test.wantStatus))
+	}
+	for addr, want := range test.want {
+		if got := uint8(m.read(addr, 1)); got != want {
+			t.Errorf("%s: [0x%03X] = 0x%02X, want 0x%02X", test.name, addr, got, want)
+		}
+	}
+}
+
+func flags(status uint8) string {
+	s := ""
+	for i, name := range []string{"C", "DC", "Z", "OV", "N"} {
+		if status&(1<<i) != 0 {
+			s += name + " "
+		}
+	}
+	if s == "" {
+		return "none"
+	}
+	return s[:len(s)-1]
+}
+
+func runExecTests(t *testing.T, tests []execTest) {
+	t.Helper()
+	for _, test := range tests {
+		test.run(t)
+	}
+}
+
+func TestExecArithmetic(t *testing.T) {
+	runExecTests(t, []execTest{
+		{name: "ADDWF half carry", program: []uint16{0x2610}, w: 0x0F, data: map[uint16]uint8{R: 0x01}, // addwf R, f
+			wantW: 0x0F, wantStatus: flagDC, want: map[uint16]uint8{R: 0x10}, cycles: 1},
+		{name: "ADDWF carry", program: []uint16{0x2410}, w: 0x80, data: map[uint16]uint8{R: 0x80}, // addwf R, w
+			wantW: 0x00, wantStatus: flagC | flagZ | flagOV, cycles: 1},
+		{name: "ADDLW overflow", program: []uint16{0x0F01}, w: 0x7F, // addlw 0x01
+			wantW: 0x80, wantStatus: flagDC | flagOV | flagN, cycles: 1},
+		{name: "ADDWFC carry in", program: []uint16{0x2210}, w: 0xFF, status: flagC, data: map[uint16]uint8{R: 0x00}, // addwfc R, f
+			wantW: 0xFF, wantStatus: flagC | flagDC | flagZ, want: map[uint16]uint8{R: 0x00}, cycles: 1},
+		{name: "ADDWFC no carry", program: []uint16{0x2210}, w: 0x01, data: map[uint16]uint8{R: 0x01}, // addwfc R, f
+			wantW: 0x01, want: map[uint16]uint8{R: 0x02}, cycles: 1},
+		{name: "SUBWF positive", program: []uint16{0x5E10}, w: 3, data: map[uint16]uint8{R: 5}, // subwf R, f
+			wantW: 3, wantStatus: flagC | flagDC, want: map[uint16]uint8{R: 2}, cycles: 1},
+		{name: "SUBWF borrow", program: []uint16{0x5E10}, w: 5, data: map[uint16]uint8{R: 3}, // subwf R, f
+			wantW: 5, wantStatus: flagN, want: map[uint16]uint8{R: 0xFE}, cycles: 1},
+		{name: "SUBWF overflow", program: []uint16{0x5E10}, w: 1, data: map[uint16]uint8{R: 0x80}, // subwf R, f
+			wantW: 1, wantStatus: flagC | flagOV, want: map[uint16]uint8{R: 0x7F}, cycles: 1},
+		{name: "SUBLW", program: []uint16{0x0802}, w: 1, // sublw 0x02
+			wantW: 1, wantStatus: flagC | flagDC, cycles: 1},
+		{name: "SUBWFB borrow in", program: []uint16{0x5A10}, w: 3, data: map[uint16]uint8{R: 5}, // subwfb R, f
+			wantW: 3, wantStatus: flagC | flagDC, want: map[uint16]uint8{R: 1}, cycles: 1},
+		{name: "SUBWFB no borrow", program: []uint16{0x5A10}, w: 3, status: flagC, data: map[uint16]uint8{R: 5}, // subwfb R, f
+			wantW: 3, wantStatus: flagC | flagDC, want: map[uint16]uint8{R: 2}, cycles: 1},
+		{name: "SUBFWB", program: []uint16{0x5610}, w: 5, status: flagC, data: map[uint16]uint8{R: 3}, // subfwb R, f
+			wantW: 5, wantStatus: flagC | flagDC, want: map[uint16]uint8{R: 2}, cycles: 1},
+		{name: "SUBFWB borrow", program: []uint16{0x5610}, w: 3, data: map[uint16]uint8{R: 5}, // subfwb R, f
+			wantW: 3, wantStatus: flagN, want: map[uint16]uint8{R: 0xFD}, cycles: 1},
+		{name: "NEGF", program: []uint16{0x6C10}, data: map[uint16]uint8{R: 1}, // negf R
+			wantStatus: flagN, want: map[uint16]uint8{R: 0xFF}, cycles: 1},
+		{name: "NEGF zero", program: []uint16{0x6C10}, data: map[uint16]uint8{R: 0}, // negf R
+			wantStatus: flagC | flagDC | flagZ, want: map[uint16]uint8{R: 0}, cycles: 1},
+		{name: "NEGF -128", program: []uint16{0x6C10}, data: map[uint16]uint8{R: 0x80}, // negf R
+			wantStatus: flagDC | flagOV | flagN, want: map[uint16]uint8{R: 0x80}, cycles: 1},
+		{name: "INCF", program: []uint16{0x2A10}, data: map[uint16]uint8{R: 0xFF}, // incf R, f
+			wantStatus: flagC | flagDC | flagZ, want: map[uint16]uint8{R: 0}, cycles: 1},
+		{name: "DECF", program: []uint16{0x0610}, data: map[uint16]uint8{R: 1}, // decf R, f
+			wantStatus: flagC | flagDC | flagZ, want: map[uint16]uint8{R: 0}, cycles: 1},
+		{name: "DECF borrow", program: []uint16{0x0410}, data: map[uint16]uint8{R: 0}, // decf R, w
+			wantW: 0xFF, wantStatus: flagN, want: map[uint16]uint8{R: 0}, cycles: 1},
+		{name: "DAW", program: []uint16{0x0007}, w: 0x41, status: flagDC, // daw
+			wantW: 0x47, wantStatus: flagDC, cycles: 1},
+		{name: "DAW carry", program: []uint16{0x0007}, w: 0xA0, // daw
+			wantW: 0x00, wantStatus: flagC, cycles: 1},
+		{name: "MULLW", program: []uint16{0x0DFF}, w: 0xFF, status: flagZ, // mullw 0xFF
+			wantW: 0xFF, wantStatus: flagZ, want: map[uint16]uint8{pic18.Registers.PRODH: 0xFE, pic18.Registers.PRODL: 0x01}, cycles: 1},
+		{name: "MULWF", program: []uint16{0x0210}, w: 0x10, data: map[uint16]uint8{R: 0x20}, // mulwf R
+			wantW: 0x10, want: map[uint16]uint8{pic18.Registers.PRODH: 0x02, pic18.Registers.PRODL: 0x00}, cycles: 1},
+	})
+}
+
+func TestExecLogic(t *testing.T) {
+	runExecTests(t, []execTest{
+		{name: "ANDWF keeps C", program: []uint16{0x1410}, w: 0xF0, status: flagC, data: map[uint16]uint8{R: 0x0F}, // andwf R, w
+			wantW: 0x00, wantStatus: flagC | flagZ, cycles: 1},
+		{name: "ANDLW", program: []uint16{0x0B0F}, w: 0xF5, status: flagN, // andlw 0x0F
+			wantW: 0x05, cycles: 1},
+		{name: "XORLW", program: []uint16{0x0AFF}, w: 0x0F, // xorlw 0xFF
+			wantW: 0xF0, wantStatus: flagN, cycles: 1},
+		{name: "IORWF", program: []uint16{0x1210}, w: 0x0F, data: map[uint16]uint8{R: 0xF0}, // iorwf R, f
+			wantW: 0x0F, wantStatus: flagN, want: map[uint16]uint8{R: 0xFF}, cycles: 1},
+		{name: "IORLW", program: []uint16{0x0980}, w: 0x01, // iorlw 0x80
+			wantW: 0x81, wantStatus: flagN, cycles: 1},
+		{name: "XORWF", program: []uint16{0x1A10}, w: 0xFF, data: map[uint16]uint8{R: 0xFF}, // xorwf R, f
+			wantW: 0xFF, wantStatus: flagZ, want: map[uint16]uint8{R: 0}, cycles: 1},
+		{name: "COMF", program: []uint16{0x1E10}, status: flagC | flagZ, data: map[uint16]uint8{R: 0}, // comf R, f
+			wantStatus: flagC | flagN, want: map[uint16]uint8{R: 0xFF}, cycles: 1},
+		{name: "RLCF", program: []uint16{0x3610}, data: map[uint16]uint8{R: 0x80}, // rlcf R, f
+			wantStatus: flagC | flagZ, want: map[uint16]uint8{R: 0}, cycles: 1},
+		{name: "RRCF", program: []uint16{0x3210}, status: flagC, data: map[uint16]uint8{R: 0x01}, // rrcf R, f
+			wantStatus: flagC | flagN, want: map[uint16]uint8{R: 0x80}, cycles: 1},
+		{name: "RLNCF", program: []uint16{0x4610}, status: flagC, data: map[uint16]uint8{R: 0x81}, // rlncf R, f
+			wantStatus: flagC, want: map[uint16]uint8{R: 0x03}, cycles: 1},
+		{name: "RRNCF", program: []uint16{0x4210}, data: map[uint16]uint8{R: 0x01}, // rrncf R, f
+			wantStatus: flagN, want: map[uint16]uint8{R: 0x80}, cycles: 1},
+		{name: "SWAPF", program: []uint16{0x3A10}, status: flagC | flagZ, data: map[uint16]uint8{R: 0x12}, // swapf R, f
+			wantStatus: flagC | flagZ, want: map[uint16]uint8{R: 0x21}, cycles: 1},
+		{name: "BSF", program: []uint16{0x8E10}, data: map[uint16]uint8{R: 0x01}, // bsf R, 7
+			want: map[uint16]uint8{R: 0x81}, cycles: 1},
+		{name: "BCF", program: []uint16{0x9010}, data: map[uint16]uint8{R: 0x81}, // bcf R, 0
+			want: map[uint16]uint8{R: 0x80}, cycles: 1},
+		{name: "BTG", program: []uint16{0x7210}, data: map[uint16]uint8{R: 0x81}, // btg R, 1
+			want: map[uint16]uint8{R: 0x83}, cycles: 1},
+	})
+}
+
+func TestExecMove(t *testing.T) {
+	runExecTests(t, []execTest{
+		{name: "MOVF", program: []uint16{0x5010}, status: flagN | flagC, data: map[uint16]uint8{R: 0}, // movf R, w
+			wantStatus: flagC | flagZ, cycles: 1},
+		{name: "MOVWF", program: []uint16{0x6E10}, w: 0x55, // movwf R
+			wantW: 0x55, want: map[uint16]uint8{R: 0x55}, cycles: 1},
+		{name: "MOVLW", program: []uint16{0x0E42}, // movlw 0x42
+			wantW: 0x42, cycles: 1},
+		{name: "CLRF", program: []uint16{0x6A10}, data: map[uint16]uint8{R: 0x55}, // clrf R
+			wantStatus: flagZ, want: map[uint16]uint8{R: 0}, cycles: 1},
+		{name: "SETF", program: []uint16{0x6810}, // setf R
+			want: map[uint16]uint8{R: 0xFF}, cycles: 1},
+		{name: "MOVFF", program: []uint16{0xC010, 0xF123}, data: map[uint16]uint8{R: 0x77}, // movff R, 0x123
+			want: map[uint16]uint8{0x123: 0x77}, cycles: 2},
+		{name: "MOVLB", program: []uint16{0x0103}, // movlb 3
+			want: map[uint16]uint8{pic18.Registers.BSR: 3}, cycles: 1},
+		{name: "LFSR", program: []uint16{0xEE12, 0xF034}, // lfsr 1, 0x234
+			want: map[uint16]uint8{pic18.FSR1H: 0x02, pic18.FSR1L: 0x34}, cycles: 2},
+		{name: "NOP", program: []uint16{0x0000}, cycles: 1}, // nop
+		// A lone second word of a two word instruction executes as a NOP.
+		{name: "NOP1", program: []uint16{0xF123}, cycles: 1},
+	})
+}
+
+func TestExecIndirect(t *testing.T) {
+	runExecTests(t, []execTest{
+		{name: "PREINC", program: []uint16{
+			0xEE00, 0xF0FF, // lfsr 0, 0x0FF
+			0x50EC, // movf PREINC0, w
+		}, data: map[uint16]uint8{0x100: 0x42},
+			wantW: 0x42, want: map[uint16]uint8{pic18.FSR0H: 0x01, pic18.FSR0L: 0x00}, cycles: 3},
+		{name: "PREINC wraps", program: []uint16{
+			0xEE0F, 0xF0FF, // lfsr 0, 0xFFF
+			0x50EC, // movf PREINC0, w
+		}, data: map[uint16]uint8{0x000: 0x24},
+			wantW: 0x24, want: map[uint16]uint8{pic18.FSR0H: 0x00, pic18.FSR0L: 0x00}, cycles: 3},
+		{name: "POSTDEC", program: []uint16{
+			0xEE11, 0xF000, // lfsr 1, 0x100
+			0x6EE5, // movwf POSTDEC1
+		}, w: 0x33,
+			wantW: 0x33, want: map[uint16]uint8{0x100: 0x33, pic18.FSR1H: 0x00, pic18.FSR1L: 0xFF}, cycles: 3},
+		{name: "PLUSW negative", program: []uint16{
+			0xEE01, 0xF020, // lfsr 0, 0x120
+			0x0EFF, // movlw 0xFF
+			0x50EB, // movf PLUSW0, w
+		}, data: map[uint16]uint8{0x11F: 0x5A},
+			wantW: 0x5A, want: map[uint16]uint8{pic18.FSR0H: 0x01, pic18.FSR0L: 0x20}, cycles: 4},
+		{name: "PLUSW positive", program: []uint16{
+			0xEE01, 0xF020, // lfsr 0, 0x120
+			0x0E7F, // movlw 0x7F
+			0x50EB, // movf PLUSW0, w
+		}, data: map[uint16]uint8{0x19F: 0xA5},
+			wantW: 0xA5, wantStatus: flagN, want: map[uint16]uint8{pic18.FSR0H: 0x01, pic18.FSR0L: 0x20}, cycles: 4},
+	})
+}
+
+// The skipped instruction sets W to 0xAA, so W tells whether the instruction skipped.
+func TestExecSkip(t *testing.T) {
+	runExecTests(t, []execTest{
+		{name: "CPFSEQ equal", program: []uint16{
+			0x6210, // cpfseq R
+			0x0EAA, // movlw 0xAA
+		}, w: 5, data: map[uint16]uint8{R: 5},
+			wantW: 5, cycles: 2},
+		{name: "CPFSEQ not equal", program: []uint16{
+			0x6210, // cpfseq R
+			0x0EAA, // movlw 0xAA
+		}, w: 5, data: map[uint16]uint8{R: 6},
+			wantW: 0xAA, cycles: 2},
+		{name: "CPFSEQ two words", program: []uint16{
+			0x6210,         // cpfseq R
+			0xC010, 0xF123, // movff R, 0x123
+		}, w: 5, data: map[uint16]uint8{R: 5},
+			wantW: 5, want: map[uint16]uint8{0x123: 0}, cycles: 3},
+		{name: "CPFSGT", program: []uint16{
+			0x6410, // cpfsgt R
+			0x0EAA, // movlw 0xAA
+		}, w: 5, data: map[uint16]uint8{R: 6},
+			wantW: 5, cycles: 2},
+		{name: "CPFSLT", program: []uint16{
+			0x6010, // cpfslt R
+			0x0EAA, // movlw 0xAA
+		}, w: 5, data: map[uint16]uint8{R: 4},
+			wantW: 5, cycles: 2},
+		{name: "TSTFSZ", program: []uint16{
+			0x6610, // tstfsz R
+			0x0EAA, // movlw 0xAA
+		}, w: 5, data: map[uint16]uint8{R: 0},
+			wantW: 5, cycles: 2},
+		{name: "DECFSZ", program: []uint16{
+			0x2E10, // decfsz R, f
+			0x0EAA, // movlw 0xAA
+		}, w: 5, data: map[uint16]uint8{R: 1},
+			wantW: 5, want: map[uint16]uint8{R: 0}, cycles: 2},
+		{name: "INCFSZ", program: []uint16{
+			0x3E10, // incfsz R, f
+			0x0EAA, // movlw 0xAA
+		}, w: 5, data: map[uint16]uint8{R: 0xFF},
+			wantW: 5, want: map[uint16]uint8{R: 0}, cycles: 2},
+		{name: "DCFSNZ", program: []uint16{
+			0x4E10, // dcfsnz R, f
+			0x0EAA, // movlw 0xAA
+		}, w: 5, data: map[uint16]uint8{R: 2},
+			wantW: 5, want: map[uint16]uint8{R: 1}, cycles: 2},
+		{name: "INFSNZ", program: []uint16{
+			0x4A10, // infsnz R, f
+			0x0EAA, // movlw 0xAA
+		}, w: 5, data: map[uint16]uint8{R: 0},
+			wantW: 5, want: map[uint16]uint8{R: 1}, cycles: 2},
+		{name: "BTFSC", program: []uint16{
+			0xB610, // btfsc R, 3
+			0x0EAA, // movlw 0xAA
+		}, w: 5, data: map[uint16]uint8{R: 0xF7},
+			wantW: 5, cycles: 2},
+		{name: "BTFSS", program: []uint16{
+			0xA610, // btfss R, 3
+			0x0EAA, // movlw 0xAA
+		}, w: 5, data: map[uint16]uint8{R: 0x08},
+			wantW: 5, cycles: 2},
+		{name: "BTFSS no skip", program: []uint16{
+			0xA610, // btfss R, 3
+			0x0EAA, // movlw 0xAA
+		}, w: 5, data: map[uint16]uint8{R: 0xF7},
+			wantW: 0xAA, cycles: 2},
+	})
+}
+
+func TestExecBranch(t *testing.T) {
+	// The branch skips a SLEEP to the target, which sets W to 0xAA. A branch that isn't taken sleeps right away.
+	branch := func(words ...uint16) []uint16 {
+		return append(words,
+			0x0003, // sleep
+			0x0EAA, // target: movlw 0xAA
+		)
+	}
+
+	runExecTests(t, []execTest{
+		{name: "BC taken", program: branch(0xE201), status: flagC, wantW: 0xAA, wantStatus: flagC, cycles: 3},    // bc target
+		{name: "BC not taken", program: branch(0xE201), cycles: 1},                                               // bc target
+		{name: "BNC taken", program: branch(0xE301), wantW: 0xAA, cycles: 3},                                     // bnc target
+		{name: "BZ taken", program: branch(0xE001), status: flagZ, wantW: 0xAA, wantStatus: flagZ, cycles: 3},    // bz target
+		{name: "BNZ not taken", program: branch(0xE101), status: flagZ, wantStatus: flagZ, cycles: 1},            // bnz target
+		{name: "BN taken", program: branch(0xE601), status: flagN, wantW: 0xAA, wantStatus: flagN, cycles: 3},    // bn target
+		{name: "BNN not taken", program: branch(0xE701), status: flagN, wantStatus: flagN, cycles: 1},            // bnn target
+		{name: "BOV taken", program: branch(0xE401), status: flagOV, wantW: 0xAA, wantStatus: flagOV, cycles: 3}, // bov target
+		{name: "BNOV taken", program: branch(0xE501), wantW: 0xAA, cycles: 3},                                    // bnov target
+		{name: "BRA", program: branch(0xD001), wantW: 0xAA, cycles: 3},                                           // bra target
+		{name: "GOTO", program: branch(0xEF03, 0xF000), wantW: 0xAA, cycles: 3},                                  // goto target
+	})
+}
+
+func TestExecCall(t *testing.T) {
+	runExecTests(t, []execTest{
+		// The return address of CALL is after its second word.
+		{name: "CALL", program: []uint16{
+			0xEC03, 0xF000, // call sub
+			0x0003, // sleep
+			// sub:
+			0x50FD, // movf TOSL, w
+		},
+			wantW: 0x04, want: map[uint16]uint8{pic18.Registers.STKPTR: 1}, cycles: 3},
+		{name: "RCALL", program: []uint16{
+			0xD801, // rcall sub
+			0x0003, // sleep
+			// sub:
+			0x50FD, // movf TOSL, w
+		},
+			wantW: 0x02, want: map[uint16]uint8{pic18.Registers.STKPTR: 1}, cycles: 3},
+		{name: "RETURN", program: []uint16{
+			0xD801, // rcall sub
+			0xD001, // bra done
+			// sub:
+			0x0012, // return
+			// done:
+		},
+			want: map[uint16]uint8{pic18.Registers.STKPTR: 0}, cycles: 6},
+		{name: "RETLW", program: []uint16{
+			0xD801, // rcall sub
+			0xD001, // bra done
+			// sub:
+			0x0C42, // retlw 0x42
+			// done:
+		},
+			wantW: 0x42, cycles: 6},
+		// The fast return restores W, STATUS and BSR from the shadow registers.
+		{name: "CALL FAST", program: []uint16{
+			0xED03, 0xF000, // call sub, 1
+			0xD003, // bra done
+			// sub:
+			0x0E99, // movlw 0x99
+			0x0102, // movlb 2
+			0x0013, // return 1
+			// done:
+		}, w: 0x11, status: flagC,
+			wantW: 0x11, wantStatus: flagC, want: map[uint16]uint8{pic18.Registers.BSR: 0}, cycles: 8},
+		// RETFIE FAST restores the shadow registers like RETURN FAST and sets GIEH.
+		{name: "RETFIE FAST", program: []uint16{
+			0xED03, 0xF000, // call sub, 1
+			0xD003, // bra done
+			// sub:
+			0x0E99, // movlw 0x99
+			0x0102, // movlb 2
+			0x0011, // retfie 1
+			// done:
+		}, w: 0x11, status: flagC,
+			wantW: 0x11, wantStatus: flagC, want: map[uint16]uint8{pic18.Registers.BSR: 0, pic18.Registers.INTCON: 0x80}, cycles: 8},
+	})
+}
+
+func TestExecControl(t *testing.T) {
+	runExecTests(t, []execTest{
+		// PUSH stores the address of the next instruction on the stack.
+		{name: "PUSH", program: []uint16{0x0005}, // push
+			want: map[uint16]uint8{pic18.Registers.STKPTR: 1, pic18.Registers.TOSL: 0x02}, cycles: 1},
+		{name: "POP", program: []uint16{
+			0x0005, // push
+			0x0006, // pop
+		},
+			want: map[uint16]uint8{pic18.Registers.STKPTR: 0}, cycles: 2},
+		// RESET restarts the program with RI cleared, the second time around BTFSC skips it.
+		{name: "RESET", program: []uint16{
+			0xB8D0, // btfsc RCON, 4
+			0x00FF, // reset
+			0x0EAA, // movlw 0xAA
+		},
+			wantW: 0xAA, want: map[uint16]uint8{pic18.Registers.RCON: 0x08}, cycles: 6},
+		// The WDT period is 5 cycles, without CLRWDT it would reset the device before the SLEEP.
+		{name: "CLRWDT", program: []uint16{
+			0x0000, // nop
+			0x0000, // nop
+			0x0004, // clrwdt
+			0x0000, // nop
+			0x0000, // nop
+		}, setup: func(m *testMachine) {
+			m.cpu.Watchdog.Mode = pic18.WatchdogAlways
+			m.cpu.Watchdog.Postscaler = 1
+			m.cpu.Watchdog.Period = 5
+		},
+			want: map[uint16]uint8{pic18.Registers.RCON: 0x18}, cycles: 5},
+		// SLEEP clears PD and sets TO.
+		{name: "SLEEP", program: []uint16{},
+			want: map[uint16]uint8{pic18.Registers.RCON: 0x18}, cycles: 0},
+	})
+}
+
+func TestExecExtended(t *testing.T) {
+	runExecTests(t, []execTest{
+		{name: "ADDFSR SUBFSR", program: []uint16{
+			0xE845, // addfsr 1, 5
+			0xE903, // subfsr 0, 3
+		}, setup: extended,
+			data:   map[uint16]uint8{pic18.FSR1H: 0x01, pic18.FSR1L: 0xFE, pic18.FSR0H: 0x01, pic18.FSR0L: 0x02},
+			want:   map[uint16]uint8{pic18.FSR1H: 0x02, pic18.FSR1L: 0x03, pic18.FSR0H: 0x00, pic18.FSR0L: 0xFF},
+			cycles: 2},
+		// ADDULNK and SUBULNK adjust FSR2 and return.
+		{name: "ADDULNK", program: []uint16{
+			0xD801, // rcall sub
+			0xD001, // bra done
+			// sub:
+			0xE8C4, // addulnk 4
+			// done:
+		}, setup: extended,
+			data:   map[uint16]uint8{pic18.FSR2H: 0x01, pic18.FSR2L: 0x00},
+			want:   map[uint16]uint8{pic18.FSR2H: 0x01, pic18.FSR2L: 0x04, pic18.Registers.STKPTR: 0},
+			cycles: 6},
+		{name: "SUBULNK", program: []uint16{
+			0xD801, // rcall sub
+			0xD001, // bra done
+			// sub:
+			0xE9C4, // subulnk 4
+			// done:
+		}, setup: extended,
+			data:   map[uint16]uint8{pic18.FSR2H: 0x01, pic18.FSR2L: 0x00},
+			want:   map[uint16]uint8{pic18.FSR2H: 0x00, pic18.FSR2L: 0xFC, pic18.Registers.STKPTR: 0},
+			cycles: 6},
+		// CALLW jumps to PCLATU:PCLATH:W.
+		{name: "CALLW", program: []uint16{
+			0x0E06, // movlw 6
+			0x0014, // callw
+			0x0003, // sleep
+			// 6:
+			0x50FD, // movf TOSL, w
+		}, setup: extended,
+			wantW: 0x04, want: map[uint16]uint8{pic18.Registers.STKPTR: 1}, cycles: 4},
+		// The source and destination operands in brackets are offsets from FSR2.
+		{name: "MOVSF MOVSS PUSHL", program: []uint16{
+			0xEB02, 0xF010, // movsf [2], R
+			0xEB81, 0xF003, // movss [1], [3]
+			0xEA5A, // pushl 0x5A
+		}, setup: extended,
+			data:   map[uint16]uint8{pic18.FSR2H: 0x01, pic18.FSR2L: 0x00, 0x101: 0x66, 0x102: 0x77},
+			want:   map[uint16]uint8{R: 0x77, 0x103: 0x66, 0x100: 0x5A, pic18.FSR2H: 0x00, pic18.FSR2L: 0xFF},
+			cycles: 5},
+	})
+}
+
+func TestExecProgramCounter(t *testing.T) {
+	runExecTests(t, []execTest{
+		// Writing PCL jumps to PCLATU:PCLATH:PCL, the fetched MOVLW 0xAA is discarded.
+		{name: "PCL write", program: []uint16{
+			0x0E00, // movlw high target
+			0x6EFA, // movwf PCLATH
+			0x0E0A, // movlw low target
+			0x6EF9, // movwf PCL
+			0x0EAA, // movlw 0xAA
+			// target:
+		},
+			wantW: 0x0A, cycles: 5},
+		// Reading PCL latches the upper bytes of the PC into PCLATH and PCLATU.
+		{name: "PCL read", program: []uint16{
+			0x0000, // nop
+			0x0000, // nop
+			0x50F9, // movf PCL, w
+		},
+			wantW: 0x06, want: map[uint16]uint8{pic18.Registers.PCLATH: 0x00}, cycles: 3},
+	})
+}
+
+func TestExecTable(t *testing.T) {
+	runExecTests(t, []execTest{
+		// TBLPTR has 22 bits, the upper bits of TBLPTRU read as 0 and TBLRD*+ wraps around.
+		{name: "TBLPTRU mask", program: []uint16{0x68F8}, // setf TBLPTRU
+			want: map[uint16]uint8{pic18.TBLPTRU: 0x3F}, cycles: 1},
+		// After the wrap, TBLRD* reads the first instruction, SETF TBLPTRU (0x68F8).
+		{name: "TBLPTR wrap", program: []uint16{
+			0x68F8, // setf TBLPTRU
+			0x68F7, // setf TBLPTRH
+			0x68F6, // setf TBLPTRL
+			0x0009, // tblrd*+
+			0x0008, // tblrd*
+		},
+			want: map[uint16]uint8{pic18.TBLPTRU: 0, pic18.TBLPTRH: 0, pic18.TBLPTRL: 0, pic18.TABLAT: 0xF8}, cycles: 7},
+		// The first instruction is CLRF TBLPTRU (0x6AF8).
+		{name: "TBLRD", program: []uint16{
+			0x6AF8, // clrf TBLPTRU
+			0x6AF7, // clrf TBLPTRH
+			0x6AF6, // clrf TBLPTRL
+			0x000B, // tblrd+*
+		},
+			wantStatus: flagZ, want: map[uint16]uint8{pic18.TABLAT: 0x6A, pic18.TBLPTRL: 0x01}, cycles: 5},
+		// TBLWT* writes TABLAT to the byte at TBLPTR, TBLRD* reads it back.
+		{name: "TBLWT", program: []uint16{
+			0x0E42, // movlw 0x42
+			0x6EF5, // movwf TABLAT
+			0x0E08, // movlw 0x08
+			0x6EF7, // movwf TBLPTRH
+			0x000C, // tblwt*
+			0x6AF5, // clrf TABLAT
+			0x0008, // tblrd*
+		},
+			wantW: 0x08, wantStatus: flagZ, want: map[uint16]uint8{pic18.TABLAT: 0x42}, cycles: 9},
+	})
+}
+
+// A high priority interrupt interrupts a low priority ISR, each RETFIE returns to the previous level
+// and re-enables the interrupts of its own priority.
+func TestExecRetfieNested(t *testing.T) {
+	m := newTestMachine(t, assemble(t, `
+	org 0
+	bra main
+	org 0x08
+	retfie
+	org 0x18
+	nop
+	nop
+	retfie
+main
+	bsf RCON, 7
+	movlw 0xC0
+	movwf INTCON
+loop
+	bra loop
+`)...)
+	low := m.cpu.Interrupts.CreateInterrupt(pic18.PeripheralInterrupt("low", 1, 0))
+	high := m.cpu.Interrupts.CreateInterrupt(pic18.PeripheralInterrupt("high", 1, 1))
+	m.write(0xF9F, 1, 0x02) // IPR1: source 0 has low priority
+
+	interrupts := &m.cpu.Interrupts
+	step := func(what string, done func() bool) {
+		t.Helper()
+		for range 20 {
+			m.cpu.Tick()
+			if done() {
+				return
+			}
+		}
+		t.Fatalf("%s didn't happen, PC 0x%06X", what, m.cpu.PC())
+	}
+
+	step("enabling interrupts", func() bool { return interrupts.HighPriorityEnable && interrupts.LowPriorityEnable })
+	low.Raise()
+	step("low priority interrupt", func() bool { return m.cpu.InterruptState() == pic18.InterruptStateLowPrio })
+	high.Raise()
+	step("high priority interrupt", func() bool { return m.cpu.InterruptState() == pic18.InterruptStateHighPrio })
+	if interrupts.HighPriorityEnable || interrupts.LowPriorityEnable {
+		t.Fatalf("GIEH %v, GIEL %v in the high priority ISR, want both cleared", interrupts.HighPriorityEnable, interrupts.LowPriorityEnable)
+	}
+
+	step("return from the high priority ISR", func() bool { return m.cpu.InterruptState() != pic18.InterruptStateHighPrio })
+	if state := m.cpu.InterruptState(); state != pic18.InterruptStateLowPrio {
+		t.Fatalf("interrupt state %v after the high priority RETFIE, want the low priority state", state)
+	}
+	if !interrupts.HighPriorityEnable || interrupts.LowPriorityEnable {
+		t.Fatalf("GIEH %v, GIEL %v after the high priority RETFIE, want only GIEH", interrupts.HighPriorityEnable, interrupts.LowPriorityEnable)
+	}
+
+	step("return from the low priority ISR", func() bool { return m.cpu.InterruptState() == pic18.InterruptStateNone })
+	if !interrupts.HighPriorityEnable || !interrupts.LowPriorityEnable {
+		t.Fatalf("GIEH %v, GIEL %v after the low priority RETFIE, want both set", interrupts.HighPriorityEnable, interrupts.LowPriorityEnable)
+	}
+}
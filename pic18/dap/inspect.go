@@ -0,0 +1,446 @@
+package dap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/natk64/go-pic-emu/binary"
+	"github.com/natk64/go-pic-emu/pic18"
+	"github.com/natk64/go-pic-emu/pic18/debug"
+	"github.com/natk64/go-pic-emu/pic18/disasm"
+	"github.com/natk64/go-pic-emu/pic18/eval"
+)
+
+// Variable references of the scopes, RAM banks use refBank + bank number
+// and the children of program variables use refValue + index in session.values.
+const (
+	refRegisters = 1
+	refSFRs      = 2
+	refRAM       = 3
+	refLocals    = 4
+	refGlobals   = 5
+	refBank      = 100
+	refValue     = 1000
+)
+
+// sfrBank is the bank that contains the SFRs, it isn't listed as RAM.
+const sfrBank = 0xF
+
+func formatAddress(addr uint32) string {
+	return fmt.Sprintf("0x%06X", addr)
+}
+
+func parseAddress(s string) (uint32, error) {
+	addr, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %q", s)
+	}
+	return uint32(addr), nil
+}
+
+func formatValue(value uint32, size int) string {
+	return fmt.Sprintf("0x%0*X", size*2, value)
+}
+
+func parseValue(s string) (uint32, error) {
+	value, err := strconv.ParseUint(strings.TrimSpace(s), 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return uint32(value), nil
+}
+
+// lookupLabel finds the address of a label, or parses an address.
+func (s *session) lookupLabel(name string) (uint32, bool) {
+	for addr, label := range s.program.Labels {
+		if label == name {
+			return addr, true
+		}
+	}
+
+	addr, err := parseAddress(name)
+	return addr, err == nil
+}
+
+// symbolize returns the name of the closest label before addr.
+func (s *session) symbolize(addr uint32) string {
+	best, found := uint32(0), false
+	for labelAddr := range s.program.Labels {
+		if labelAddr <= addr && (!found || labelAddr > best) {
+			best, found = labelAddr, true
+		}
+	}
+
+	if !found {
+		return formatAddress(addr)
+	}
+	if best == addr {
+		return s.program.Labels[best]
+	}
+	return fmt.Sprintf("%s+0x%X", s.program.Labels[best], addr-best)
+}
+
+// sourceLine returns the source file and line of the instruction that contains addr, or nil if it is unknown.
+func (s *session) sourceLine(addr uint32) (*source, int) {
+	if lines := s.target.Lines; lines != nil {
+		entry, ok := lines.Lookup(addr)
+		if !ok {
+			return nil, 0
+		}
+		return &source{Name: filepath.Base(entry.File), Path: entry.File}, entry.Line
+	}
+
+	bestLine, bestAddr := 0, uint32(0)
+	for line, lineAddr := range s.program.Lines {
+		if lineAddr <= addr && (bestLine == 0 || lineAddr > bestAddr || (lineAddr == bestAddr && line < bestLine)) {
+			bestLine, bestAddr = line, lineAddr
+		}
+	}
+
+	// Instructions are at most 4 bytes, anything further away isn't code from the source.
+	if bestLine == 0 || addr-bestAddr >= 4 {
+		return nil, 0
+	}
+	return &source{Name: filepath.Base(s.program.Source), Path: s.program.Source}, bestLine
+}
+
+func (s *session) frame(id int, addr, lineAddr uint32) stackFrame {
+	frame := stackFrame{
+		ID:                          id,
+		Name:                        s.symbolize(addr),
+		InstructionPointerReference: formatAddress(addr),
+	}
+
+	if src, line := s.sourceLine(lineAddr); src != nil {
+		frame.Source = src
+		frame.Line = line
+		frame.Column = 1
+	}
+	return frame
+}
+
+// stackTrace builds the call stack from the program counter and the return addresses on the hardware stack.
+func (s *session) stackTrace(raw json.RawMessage) (any, error) {
+	cpu := s.target.CPU
+	frames := []stackFrame{s.frame(1, cpu.PC(), cpu.PC())}
+
+	depth := int(s.target.ReadRegister(debug.RegSTKPTR) & 0x1F)
+	depth = min(depth, len(cpu.Stack.Data))
+	for i := depth - 1; i >= 0; i-- {
+		ret := cpu.Stack.Data[i]
+		// The line of the call is the one before the return address.
+		frames = append(frames, s.frame(len(frames)+1, ret, ret-2))
+	}
+
+	return map[string]any{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+func (s *session) scopes(raw json.RawMessage) (any, error) {
+	var scopes []scope
+	if s.program.Evaluator != nil {
+		scopes = append(scopes, scope{Name: "Locals", VariablesReference: refLocals}, scope{Name: "Globals", VariablesReference: refGlobals})
+	}
+	scopes = append(scopes,
+		scope{Name: "Registers", VariablesReference: refRegisters},
+		scope{Name: "SFRs", VariablesReference: refSFRs},
+		scope{Name: "RAM", VariablesReference: refRAM, Expensive: true},
+	)
+	return map[string]any{"scopes": scopes}, nil
+}
+
+// sfrs returns the named SFRs sorted by name.
+func sfrs() []uint16 {
+	addrs := make([]uint16, 0, len(disasm.RegisterNames))
+	for addr := range disasm.RegisterNames {
+		if addr >= sfrBank<<8 {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool { return disasm.RegisterNames[addrs[i]] < disasm.RegisterNames[addrs[j]] })
+	return addrs
+}
+
+// banks returns the RAM banks that are mapped on the data bus.
+func (s *session) banks() []int {
+	var banks []int
+	for bank := range sfrBank {
+		if _, mask := s.target.DataBus.BusRead(uint16(bank) << 8); mask != 0 {
+			banks = append(banks, bank)
+		}
+	}
+	return banks
+}
+
+func (s *session) dataVariable(name string, addr uint16) variable {
+	return variable{
+		Name:            name,
+		Value:           formatValue(uint32(s.target.ReadData(addr)), 1),
+		MemoryReference: formatAddress(uint32(addr)),
+	}
+}
+
+// valueVariable converts a program variable, values with children get a reference to expand them.
+func (s *session) valueVariable(value *eval.Value) variable {
+	v := variable{Name: value.Name, Value: value.String(), Type: value.TypeName()}
+	if value.HasChildren() {
+		s.values = append(s.values, value)
+		v.VariablesReference = refValue + len(s.values) - 1
+	}
+	if value.Addressed() && value.Space == binary.DataSpace {
+		v.MemoryReference = formatAddress(value.Addr)
+	}
+	return v
+}
+
+func (s *session) variables(raw json.RawMessage) (any, error) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	vars := []variable{}
+	switch ref := args.VariablesReference; {
+	case ref == refRegisters:
+		for i, reg := range s.target.Registers() {
+			vars = append(vars, variable{Name: reg.Name, Value: formatValue(s.target.ReadRegister(i), reg.Size)})
+		}
+	case ref == refSFRs:
+		for _, addr := range sfrs() {
+			vars = append(vars, s.dataVariable(disasm.RegisterNames[addr], addr))
+		}
+	case ref == refRAM:
+		for _, bank := range s.banks() {
+			vars = append(vars, variable{Name: fmt.Sprintf("Bank %d", bank), Value: "", VariablesReference: refBank + bank})
+		}
+	case ref >= refBank && ref < refBank+sfrBank:
+		base := uint16(ref-refBank) << 8
+		for offset := range uint16(256) {
+			vars = append(vars, s.dataVariable(fmt.Sprintf("0x%03X", base+offset), base+offset))
+		}
+	case (ref == refLocals || ref == refGlobals) && s.program.Evaluator != nil:
+		evaluator := s.program.Evaluator
+		variables := evaluator.Globals()
+		if ref == refLocals {
+			variables = evaluator.Locals(s.target.CPU.PC())
+		}
+		for _, variable := range variables {
+			vars = append(vars, s.valueVariable(evaluator.Value(variable)))
+		}
+	case ref >= refValue && ref-refValue < len(s.values):
+		for _, child := range s.values[ref-refValue].Children() {
+			vars = append(vars, s.valueVariable(child))
+		}
+	default:
+		return nil, fmt.Errorf("unknown variables reference %d", ref)
+	}
+
+	return map[string]any{"variables": vars}, nil
+}
+
+func (s *session) setVariable(raw json.RawMessage) (any, error) {
+	var args struct {
+		VariablesReference int    `json:"variablesReference"`
+		Name               string `json:"name"`
+		Value              string `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	value, err := parseValue(args.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ref := args.VariablesReference; {
+	case ref == refRegisters:
+		for i, reg := range s.target.Registers() {
+			if reg.Name == args.Name {
+				s.target.WriteRegister(i, value)
+				return map[string]any{"value": formatValue(s.target.ReadRegister(i), reg.Size)}, nil
+			}
+		}
+	case ref == refSFRs, ref >= refBank && ref < refBank+sfrBank:
+		addr, ok := s.dataAddress(args.Name)
+		if ok {
+			s.target.WriteData(addr, uint8(value))
+			return map[string]any{"value": formatValue(uint32(s.target.ReadData(addr)), 1)}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("can't set %q", args.Name)
+}
+
+// dataAddress resolves a variable, an SFR name or a data memory address.
+func (s *session) dataAddress(name string) (uint16, bool) {
+	for addr, variable := range s.program.Variables {
+		if variable == name {
+			return addr, true
+		}
+	}
+	for addr, sfr := range disasm.RegisterNames {
+		if strings.EqualFold(sfr, name) {
+			return addr, true
+		}
+	}
+
+	addr, err := strconv.ParseUint(name, 0, 12)
+	return uint16(addr), err == nil
+}
+
+// evaluate handles expressions from the debug console and the watch pane:
+// core register names, C expressions on the program's variables, SFR names, data memory addresses and program labels.
+func (s *session) evaluate(raw json.RawMessage) (any, error) {
+	var args struct {
+		Expression string `json:"expression"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	expr := strings.TrimSpace(args.Expression)
+	for i, reg := range s.target.Registers() {
+		if strings.EqualFold(reg.Name, expr) {
+			return map[string]any{"result": formatValue(s.target.ReadRegister(i), reg.Size), "variablesReference": 0}, nil
+		}
+	}
+
+	// SFRs and addresses are shown as bytes, everything else is evaluated as C if possible.
+	var evalErr error
+	if evaluator := s.program.Evaluator; evaluator != nil && !isDataName(expr) {
+		value, err := evaluator.Evaluate(expr, s.target.CPU.PC())
+		if err == nil {
+			v := s.valueVariable(value)
+			body := map[string]any{"result": v.Value, "type": v.Type, "variablesReference": v.VariablesReference}
+			if v.MemoryReference != "" {
+				body["memoryReference"] = v.MemoryReference
+			}
+			return body, nil
+		}
+		evalErr = err
+	}
+
+	if addr, ok := s.dataAddress(expr); ok {
+		return map[string]any{
+			"result":             formatValue(uint32(s.target.ReadData(addr)), 1),
+			"variablesReference": 0,
+			"memoryReference":    formatAddress(uint32(addr)),
+		}, nil
+	}
+
+	for addr, label := range s.program.Labels {
+		if label == expr {
+			return map[string]any{"result": formatAddress(addr), "variablesReference": 0}, nil
+		}
+	}
+
+	if evalErr != nil {
+		return nil, evalErr
+	}
+	return nil, fmt.Errorf("unknown expression %q", expr)
+}
+
+// isDataName returns true for SFR names and data memory addresses.
+func isDataName(name string) bool {
+	if _, err := strconv.ParseUint(name, 0, 12); err == nil {
+		return true
+	}
+	for _, sfr := range disasm.RegisterNames {
+		if strings.EqualFold(sfr, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *session) disassemble(raw json.RawMessage) (any, error) {
+	var args struct {
+		MemoryReference   string `json:"memoryReference"`
+		Offset            int    `json:"offset"`
+		InstructionOffset int    `json:"instructionOffset"`
+		InstructionCount  int    `json:"instructionCount"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	base, err := parseAddress(args.MemoryReference)
+	if err != nil {
+		return nil, err
+	}
+	if args.InstructionCount < 0 {
+		return nil, errors.New("negative instruction count")
+	}
+
+	opts := &disasm.Options{
+		ExtendedSet: s.target.CPU.BankController.ExtendedSet,
+		Symbols:     s.program.Labels,
+	}
+
+	// Going backwards, instructions are assumed to be one word.
+	addr := int64(base) + int64(args.Offset) + int64(args.InstructionOffset)*2
+	instructions := make([]disassembledInstruction, 0, args.InstructionCount)
+	for range args.InstructionCount {
+		if addr < 0 || addr > 0x1FFFFF {
+			instructions = append(instructions, disassembledInstruction{Address: formatAddress(uint32(addr)), Instruction: "??"})
+			addr += 2
+			continue
+		}
+
+		line := disasm.Instruction(s.target.ProgramBus, uint32(addr), opts)
+		inst := disassembledInstruction{
+			Address:     formatAddress(line.Address),
+			Instruction: line.Text,
+			Symbol:      s.program.Labels[line.Address],
+		}
+
+		var hexBytes []string
+		for _, word := range line.Words {
+			hexBytes = append(hexBytes, fmt.Sprintf("%02X %02X", uint8(word), uint8(word>>8)))
+		}
+		inst.InstructionBytes = strings.Join(hexBytes, " ")
+
+		if src, sourceLine := s.sourceLine(line.Address); src != nil {
+			inst.Location = src
+			inst.Line = sourceLine
+		}
+
+		instructions = append(instructions, inst)
+		addr += int64(line.Size())
+	}
+
+	return map[string]any{"instructions": instructions}, nil
+}
+
+// dataName returns the name of a variable or an SFR, or the address for other data memory.
+func (s *session) dataName(addr uint16) string {
+	if name, ok := s.program.Variables[addr]; ok {
+		return name
+	}
+	if name, ok := disasm.RegisterNames[addr]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%03X", addr)
+}
+
+// describeHit describes the access that triggered a data breakpoint and the instruction that made it.
+func (s *session) describeHit(hit pic18.WatchHit) string {
+	opts := &disasm.Options{
+		ExtendedSet: s.target.CPU.BankController.ExtendedSet,
+		Symbols:     s.program.Labels,
+	}
+	line := disasm.Instruction(s.target.ProgramBus, hit.PC, opts)
+
+	access := fmt.Sprintf("Read 0x%02X from %s", hit.Value, s.dataName(hit.Addr))
+	if hit.Write {
+		access = fmt.Sprintf("Wrote 0x%02X to %s (was 0x%02X)", hit.Value, s.dataName(hit.Addr), hit.Old)
+	}
+	return fmt.Sprintf("%s at %s: %s", access, s.symbolize(hit.PC), line.Text)
+}
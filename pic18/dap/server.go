@@ -0,0 +1,606 @@
+// Package dap implements a Debug Adapter Protocol (DAP) server,
+// so editors like VS Code can debug programs running in the emulator.
+//
+// The server supports source breakpoints for programs assembled from source or with a line table, function breakpoints on labels
+// or addresses, instruction breakpoints, data breakpoints on SFRs and RAM, stepping by lines or instructions
+// (including stepping back with a history), a call stack built
+// from the hardware stack, variables for the core registers, SFRs and RAM, and disassembly.
+// With DWARF debug information, the local and global variables of the program are shown with their C types.
+package dap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+
+	"github.com/natk64/go-pic-emu/pic18"
+	"github.com/natk64/go-pic-emu/pic18/debug"
+	"github.com/natk64/go-pic-emu/pic18/eval"
+)
+
+// Program is a program that was launched for debugging.
+type Program struct {
+	Target *debug.Target
+
+	// Labels maps program memory addresses to names, it is used for the call stack,
+	// function breakpoints and the disassembly. It may be nil.
+	Labels map[uint32]string
+
+	// Variables maps data memory addresses to the names of variables, it may be nil.
+	Variables map[uint16]string
+
+	// Evaluator shows the variables of the program with their types, it is nil without debug information.
+	Evaluator *eval.Evaluator
+
+	// Source is the path of the assembly source and Lines maps its line numbers to addresses.
+	// They are empty if there is no source.
+	Source string
+	Lines  map[int]uint32
+}
+
+// LaunchArguments are the arguments of the launch request, they are set in launch.json.
+type LaunchArguments struct {
+	// Program is the path of the program to debug.
+	Program     string `json:"program"`
+	StopOnEntry bool   `json:"stopOnEntry"`
+	NoDebug     bool   `json:"noDebug"`
+
+	// Snapshot is the path of a snapshot that is loaded before the program starts.
+	Snapshot string `json:"snapshot"`
+
+	// History is the number of instructions recorded for stepping back.
+	// Zero uses the default, a negative number disables stepping back.
+	History int `json:"history"`
+}
+
+// Server serves debug sessions.
+type Server struct {
+	// Launch starts a program for a launch request.
+	// Output of the program (e.g. UART transmissions) should be written to output, it is shown in the debug console.
+	Launch func(args *LaunchArguments, output io.Writer) (*Program, error)
+}
+
+// ListenAndServe listens on a TCP address and serves one session after another.
+// VS Code connects to it if the launch configuration contains "debugServer" with the port number.
+func (server *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		err = server.Serve(client)
+		client.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Serve handles a single session until the client disconnects.
+func (server *Server) Serve(rw io.ReadWriter) error {
+	s := &session{
+		server:            server,
+		conn:              newConn(rw),
+		sourceBreakpoints: make(map[string][]uint32),
+	}
+	defer s.halt()
+
+	for {
+		req, err := s.conn.read()
+		if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		body, err := s.handle(req)
+		if errors.Is(err, errDisconnect) {
+			return s.conn.respond(req, nil)
+		}
+		if err != nil {
+			err = s.conn.fail(req, err)
+		} else {
+			err = s.conn.respond(req, body)
+		}
+		if err != nil {
+			return err
+		}
+
+		if s.after != nil {
+			s.after()
+			s.after = nil
+		}
+	}
+}
+
+var (
+	errDisconnect  = errors.New("disconnect")
+	errNotLaunched = errors.New("no program has been launched")
+	errRunning     = errors.New("the program is running")
+)
+
+type session struct {
+	server  *Server
+	conn    *conn
+	program *Program
+	target  *debug.Target
+	noDebug bool
+
+	stopOnEntry bool
+
+	// after is called once the response to the current request has been sent,
+	// events caused by a request must not be sent before its response.
+	after func()
+
+	// Breakpoint addresses, the target's breakpoints are the union of all of them.
+	sourceBreakpoints      map[string][]uint32
+	functionBreakpoints    []uint32
+	instructionBreakpoints []uint32
+
+	// values are the variables with children that were sent while the target is stopped,
+	// their variables reference is refValue + index.
+	values []*eval.Value
+
+	mu        sync.Mutex
+	running   bool
+	halting   bool
+	interrupt chan struct{}
+	done      chan struct{}
+}
+
+func (s *session) handle(req *request) (any, error) {
+	if handler, ok := stoppedHandlers[req.Command]; ok {
+		if s.target == nil {
+			return nil, errNotLaunched
+		}
+		if s.isRunning() {
+			return nil, errRunning
+		}
+		return handler(s, req.Arguments)
+	}
+
+	switch req.Command {
+	case "initialize":
+		return map[string]any{
+			"supportsConfigurationDoneRequest": true,
+			"supportsFunctionBreakpoints":      true,
+			"supportsInstructionBreakpoints":   true,
+			"supportsDataBreakpoints":          true,
+			"supportsDisassembleRequest":       true,
+			"supportsSetVariable":              true,
+			"supportsStepBack":                 true,
+			"supportsSteppingGranularity":      true,
+			"supportsTerminateRequest":         true,
+		}, nil
+	case "launch":
+		return nil, s.launch(req.Arguments)
+	case "disconnect":
+		return nil, errDisconnect
+	case "terminate":
+		s.halt()
+		s.after = func() { s.conn.event("terminated", nil) }
+		return nil, nil
+	case "threads":
+		return map[string]any{"threads": []map[string]any{{"id": 1, "name": "PIC18"}}}, nil
+	case "setExceptionBreakpoints":
+		return map[string]any{"breakpoints": []breakpoint{}}, nil
+	case "pause":
+		if s.halt() {
+			s.after = func() { s.stopped(debug.StopInterrupted) }
+		}
+		return nil, nil
+	}
+
+	if s.target == nil {
+		return nil, errNotLaunched
+	}
+
+	switch req.Command {
+	case "configurationDone":
+		if s.stopOnEntry {
+			s.after = func() {
+				s.conn.event("stopped", map[string]any{"reason": "entry", "threadId": 1, "allThreadsStopped": true})
+			}
+		} else {
+			s.resume(s.target.Continue)
+		}
+		return nil, nil
+	case "setBreakpoints":
+		return s.setBreakpoints(req.Arguments)
+	case "setFunctionBreakpoints":
+		return s.setFunctionBreakpoints(req.Arguments)
+	case "setInstructionBreakpoints":
+		return s.setInstructionBreakpoints(req.Arguments)
+	case "dataBreakpointInfo":
+		return s.dataBreakpointInfo(req.Arguments)
+	case "setDataBreakpoints":
+		return s.setDataBreakpoints(req.Arguments)
+	}
+
+	return nil, fmt.Errorf("unsupported request %q", req.Command)
+}
+
+// instructionGranularity tells whether a step request asks for a single instruction instead of a source line.
+func instructionGranularity(raw json.RawMessage) bool {
+	var args struct {
+		Granularity string `json:"granularity"`
+	}
+	json.Unmarshal(raw, &args)
+	return args.Granularity == "instruction"
+}
+
+// stoppedHandlers handle requests that need the target to be stopped.
+var stoppedHandlers = map[string]func(s *session, args json.RawMessage) (any, error){
+	"continue": func(s *session, args json.RawMessage) (any, error) {
+		s.resume(s.target.Continue)
+		return map[string]any{"allThreadsContinued": true}, nil
+	},
+	"next": func(s *session, args json.RawMessage) (any, error) {
+		if instructionGranularity(args) {
+			s.resume(s.target.StepOver)
+		} else {
+			s.resume(s.target.NextLine)
+		}
+		return nil, nil
+	},
+	"stepIn": func(s *session, args json.RawMessage) (any, error) {
+		if instructionGranularity(args) {
+			s.resume(func(<-chan struct{}) debug.StopReason { return s.target.Step() })
+		} else {
+			s.resume(s.target.StepLine)
+		}
+		return nil, nil
+	},
+	"stepOut": func(s *session, args json.RawMessage) (any, error) {
+		s.resume(s.target.StepOut)
+		return nil, nil
+	},
+	"stepBack": func(s *session, args json.RawMessage) (any, error) {
+		if !s.target.CanReverse() {
+			return nil, errors.New("stepping back is disabled")
+		}
+		s.resume(func(<-chan struct{}) debug.StopReason { return s.target.StepBack() })
+		return nil, nil
+	},
+	"reverseContinue": func(s *session, args json.RawMessage) (any, error) {
+		if !s.target.CanReverse() {
+			return nil, errors.New("stepping back is disabled")
+		}
+		s.resume(s.target.ContinueBack)
+		return nil, nil
+	},
+	"stackTrace":  (*session).stackTrace,
+	"scopes":      (*session).scopes,
+	"variables":   (*session).variables,
+	"setVariable": (*session).setVariable,
+	"evaluate":    (*session).evaluate,
+	"disassemble": (*session).disassemble,
+}
+
+func (s *session) launch(raw json.RawMessage) error {
+	if s.program != nil {
+		return errors.New("a program has already been launched")
+	}
+
+	var args LaunchArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return err
+	}
+
+	program, err := s.server.Launch(&args, outputWriter{s.conn})
+	if err != nil {
+		return err
+	}
+
+	s.program = program
+	s.target = program.Target
+	s.after = func() { s.conn.event("initialized", nil) }
+	s.stopOnEntry = args.StopOnEntry
+	s.noDebug = args.NoDebug
+	return nil
+}
+
+// outputWriter sends everything written to it to the debug console.
+type outputWriter struct {
+	conn *conn
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	err := w.conn.event("output", map[string]any{"category": "stdout", "output": string(p)})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *session) isRunning() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running
+}
+
+// resume runs the target in the background after the response has been sent.
+// A stopped event is sent when it stops.
+func (s *session) resume(run func(interrupt <-chan struct{}) debug.StopReason) {
+	s.after = func() { s.start(run) }
+}
+
+func (s *session) start(run func(interrupt <-chan struct{}) debug.StopReason) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.running = true
+	s.values = nil
+	s.interrupt = make(chan struct{}, 1)
+	s.done = make(chan struct{})
+
+	interrupt, done := s.interrupt, s.done
+	go func() {
+		defer close(done)
+		reason := run(interrupt)
+
+		s.mu.Lock()
+		s.running = false
+		halting := s.halting
+		s.mu.Unlock()
+
+		if !halting || reason != debug.StopInterrupted {
+			s.stopped(reason)
+		}
+	}()
+}
+
+// halt stops the target if it is running. It returns true if the target was interrupted,
+// in that case no stopped event has been sent.
+func (s *session) halt() bool {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return false
+	}
+	s.halting = true
+	interrupt, done := s.interrupt, s.done
+	s.mu.Unlock()
+
+	interrupt <- struct{}{}
+	<-done
+
+	s.mu.Lock()
+	s.halting = false
+	s.mu.Unlock()
+	return true
+}
+
+func (s *session) stopped(reason debug.StopReason) {
+	body := map[string]any{"threadId": 1, "allThreadsStopped": true}
+	switch reason {
+	case debug.StopBreakpoint:
+		body["reason"] = "breakpoint"
+	case debug.StopInterrupted:
+		body["reason"] = "pause"
+	case debug.StopIllegalInstruction:
+		body["reason"] = "exception"
+		body["text"] = "illegal instruction"
+	case debug.StopHistoryEnd:
+		body["reason"] = "step"
+		body["description"] = "Reached the start of the execution history"
+	case debug.StopWatchpoint:
+		body["reason"] = "data breakpoint"
+		body["description"] = s.describeHit(s.target.Watchpoints.Hits[0])
+	default:
+		body["reason"] = "step"
+	}
+	s.conn.event("stopped", body)
+}
+
+// updateBreakpoints installs all breakpoints in the target.
+func (s *session) updateBreakpoints() {
+	if s.noDebug {
+		return
+	}
+
+	var addrs []uint32
+	for _, source := range s.sourceBreakpoints {
+		addrs = append(addrs, source...)
+	}
+	addrs = append(addrs, s.functionBreakpoints...)
+	addrs = append(addrs, s.instructionBreakpoints...)
+	s.target.SetBreakpoints(addrs)
+}
+
+func (s *session) setBreakpoints(raw json.RawMessage) (any, error) {
+	var args struct {
+		Source      source `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	isProgramSource := s.target.Lines != nil || (s.program.Source != "" && sameFile(args.Source.Path, s.program.Source))
+
+	var addrs []uint32
+	result := make([]breakpoint, 0, len(args.Breakpoints))
+	for _, bp := range args.Breakpoints {
+		if !isProgramSource {
+			result = append(result, breakpoint{Line: bp.Line, Message: "no code for this file"})
+			continue
+		}
+
+		line, addr, ok := s.codeLine(args.Source.Path, bp.Line)
+		if !ok {
+			result = append(result, breakpoint{Line: bp.Line, Message: "no code at or after this line"})
+			continue
+		}
+		addrs = append(addrs, addr)
+		result = append(result, breakpoint{Verified: true, Line: line})
+	}
+
+	s.sourceBreakpoints[args.Source.Path] = addrs
+	s.updateBreakpoints()
+	return map[string]any{"breakpoints": result}, nil
+}
+
+// codeLine returns the first line of a source file at or after line that contains an instruction.
+func (s *session) codeLine(path string, line int) (int, uint32, bool) {
+	if s.target.Lines != nil {
+		return s.target.Lines.LineAddress(path, line)
+	}
+
+	last := 0
+	for l := range s.program.Lines {
+		last = max(last, l)
+	}
+
+	for ; line <= last; line++ {
+		if addr, ok := s.program.Lines[line]; ok {
+			return line, addr, true
+		}
+	}
+	return 0, 0, false
+}
+
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return absA == absB
+}
+
+func (s *session) setFunctionBreakpoints(raw json.RawMessage) (any, error) {
+	var args struct {
+		Breakpoints []struct {
+			Name string `json:"name"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	s.functionBreakpoints = nil
+	result := make([]breakpoint, 0, len(args.Breakpoints))
+	for _, bp := range args.Breakpoints {
+		addr, ok := s.lookupLabel(bp.Name)
+		if !ok {
+			result = append(result, breakpoint{Message: fmt.Sprintf("unknown function %q", bp.Name)})
+			continue
+		}
+		s.functionBreakpoints = append(s.functionBreakpoints, addr)
+		result = append(result, breakpoint{Verified: true, InstructionReference: formatAddress(addr)})
+	}
+
+	s.updateBreakpoints()
+	return map[string]any{"breakpoints": result}, nil
+}
+
+func (s *session) setInstructionBreakpoints(raw json.RawMessage) (any, error) {
+	var args struct {
+		Breakpoints []struct {
+			InstructionReference string `json:"instructionReference"`
+			Offset               int    `json:"offset"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	s.instructionBreakpoints = nil
+	result := make([]breakpoint, 0, len(args.Breakpoints))
+	for _, bp := range args.Breakpoints {
+		addr, err := parseAddress(bp.InstructionReference)
+		if err != nil {
+			result = append(result, breakpoint{Message: err.Error()})
+			continue
+		}
+		addr = uint32(int(addr) + bp.Offset)
+		s.instructionBreakpoints = append(s.instructionBreakpoints, addr)
+		result = append(result, breakpoint{Verified: true, InstructionReference: formatAddress(addr)})
+	}
+
+	s.updateBreakpoints()
+	return map[string]any{"breakpoints": result}, nil
+}
+
+// accessKinds maps the access types of data breakpoints to watchpoint kinds.
+var accessKinds = map[string]pic18.WatchKind{
+	"read":      pic18.WatchRead,
+	"write":     pic18.WatchWrite,
+	"readWrite": pic18.WatchRead | pic18.WatchWrite,
+}
+
+// dataBreakpointInfo tells whether a variable can be watched. The data ID is the data memory address.
+func (s *session) dataBreakpointInfo(raw json.RawMessage) (any, error) {
+	var args struct {
+		VariablesReference int    `json:"variablesReference"`
+		Name               string `json:"name"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	addr, ok := s.dataAddress(args.Name)
+	if args.VariablesReference == refRegisters || !ok || pic18.IsIndirectRegister(addr) {
+		return map[string]any{"dataId": nil, "description": fmt.Sprintf("%s can't be watched", args.Name)}, nil
+	}
+
+	return map[string]any{
+		"dataId":      formatAddress(uint32(addr)),
+		"description": s.dataName(addr),
+		"accessTypes": []string{"read", "write", "readWrite"},
+		"canPersist":  true,
+	}, nil
+}
+
+func (s *session) setDataBreakpoints(raw json.RawMessage) (any, error) {
+	var args struct {
+		Breakpoints []struct {
+			DataID     string `json:"dataId"`
+			AccessType string `json:"accessType"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	watchpoints := s.target.Watchpoints
+	watchpoints.Clear()
+	result := make([]breakpoint, 0, len(args.Breakpoints))
+	for _, bp := range args.Breakpoints {
+		addr, err := parseAddress(bp.DataID)
+		if err != nil || addr > 0xFFF {
+			result = append(result, breakpoint{Message: fmt.Sprintf("invalid data ID %q", bp.DataID)})
+			continue
+		}
+
+		kind := pic18.WatchWrite
+		if bp.AccessType != "" {
+			if kind = accessKinds[bp.AccessType]; kind == 0 {
+				result = append(result, breakpoint{Message: fmt.Sprintf("unknown access type %q", bp.AccessType)})
+				continue
+			}
+		}
+
+		if !s.noDebug {
+			watchpoints.Watch(uint16(addr), watchpoints.Kind(uint16(addr))|kind)
+		}
+		result = append(result, breakpoint{Verified: true})
+	}
+
+	return map[string]any{"breakpoints": result}, nil
+}
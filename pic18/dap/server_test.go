@@ -0,0 +1,197 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/natk64/go-pic-emu/pic18/debug"
+	"github.com/natk64/go-pic-emu/pic18/internal/testcpu"
+)
+
+const testSource = `
+	org 0
+main
+	movlw 0x42
+	call sub
+	movwf 0x10
+loop
+	bra loop
+sub
+	addlw 1
+	return
+`
+
+// message is a response or an event received by the client.
+type message struct {
+	Type       string          `json:"type"`
+	Command    string          `json:"command"`
+	Event      string          `json:"event"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// client is the editor side of a session.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *textproto.Reader
+	seq  int
+}
+
+// send sends a request and returns its sequence number.
+func (c *client) send(command string, arguments any) int {
+	c.t.Helper()
+	c.seq++
+	body, err := json.Marshal(map[string]any{"seq": c.seq, "type": "request", "command": command, "arguments": arguments})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := fmt.Fprintf(c.conn, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+		c.t.Fatalf("sending %s: %v", command, err)
+	}
+	return c.seq
+}
+
+// next reads messages until one matches, the others are skipped.
+func (c *client) next(what string, match func(m *message) bool) *message {
+	c.t.Helper()
+	for {
+		c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+		header, err := c.r.ReadMIMEHeader()
+		if err != nil {
+			c.t.Fatalf("waiting for %s: %v", what, err)
+		}
+		length, _ := strconv.Atoi(header.Get("Content-Length"))
+		body := make([]byte, length)
+		if _, err := io.ReadFull(c.r.R, body); err != nil {
+			c.t.Fatalf("waiting for %s: %v", what, err)
+		}
+
+		var m message
+		if err := json.Unmarshal(body, &m); err != nil {
+			c.t.Fatalf("waiting for %s: %v", what, err)
+		}
+		if match(&m) {
+			return &m
+		}
+	}
+}
+
+// request sends a request and decodes the body of its successful response into body, which may be nil.
+func (c *client) request(command string, arguments any, body any) {
+	c.t.Helper()
+	seq := c.send(command, arguments)
+	response := c.next(command+" response", func(m *message) bool { return m.Type == "response" && m.RequestSeq == seq })
+	if !response.Success {
+		c.t.Fatalf("%s failed: %s", command, response.Message)
+	}
+	if body != nil {
+		if err := json.Unmarshal(response.Body, body); err != nil {
+			c.t.Fatalf("decoding the %s response: %v", command, err)
+		}
+	}
+}
+
+// stopped waits for a stopped event and returns its reason.
+func (c *client) stopped() string {
+	c.t.Helper()
+	event := c.next("stopped event", func(m *message) bool { return m.Type == "event" && m.Event == "stopped" })
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	json.Unmarshal(event.Body, &body)
+	return body.Reason
+}
+
+// top returns the name and line of the innermost stack frame and the number of frames.
+func (c *client) top() (string, int, int) {
+	c.t.Helper()
+	var trace struct {
+		StackFrames []stackFrame `json:"stackFrames"`
+	}
+	c.request("stackTrace", map[string]any{"threadId": 1}, &trace)
+	return trace.StackFrames[0].Name, trace.StackFrames[0].Line, len(trace.StackFrames)
+}
+
+func TestSession(t *testing.T) {
+	server := &Server{Launch: func(args *LaunchArguments, output io.Writer) (*Program, error) {
+		cpu, program := testcpu.New(t, testSource)
+		return &Program{
+			Target: debug.New(cpu, nil),
+			Labels: program.Labels(),
+			Source: args.Program,
+			Lines:  program.Lines,
+		}, nil
+	}}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(serverConn) }()
+	c := &client{t: t, conn: clientConn, r: textproto.NewReader(bufio.NewReader(clientConn))}
+
+	c.request("initialize", map[string]any{"adapterID": "pic18"}, nil)
+	c.request("launch", map[string]any{"program": "test.asm"}, nil)
+	c.next("initialized event", func(m *message) bool { return m.Event == "initialized" })
+
+	// Line 10 is the ADDLW in sub.
+	var breakpoints struct {
+		Breakpoints []breakpoint `json:"breakpoints"`
+	}
+	c.request("setBreakpoints", map[string]any{"source": map[string]any{"path": "test.asm"}, "breakpoints": []map[string]any{{"line": 10}}}, &breakpoints)
+	if len(breakpoints.Breakpoints) != 1 || !breakpoints.Breakpoints[0].Verified || breakpoints.Breakpoints[0].Line != 10 {
+		t.Fatalf("setBreakpoints returned %+v, want a verified breakpoint on line 10", breakpoints.Breakpoints)
+	}
+
+	c.request("configurationDone", nil, nil)
+	if reason := c.stopped(); reason != "breakpoint" {
+		t.Fatalf("stopped with reason %q, want breakpoint", reason)
+	}
+	if name, line, frames := c.top(); name != "sub" || line != 10 || frames != 2 {
+		t.Errorf("stopped in %s on line %d with %d frames, want sub, line 10 and 2 frames", name, line, frames)
+	}
+
+	var result struct {
+		Result string `json:"result"`
+	}
+	c.request("evaluate", map[string]any{"expression": "wreg"}, &result)
+	if result.Result != "0x42" {
+		t.Errorf("wreg evaluates to %s, want 0x42", result.Result)
+	}
+
+	c.request("stepOut", map[string]any{"threadId": 1}, nil)
+	if reason := c.stopped(); reason != "step" {
+		t.Fatalf("stepOut stopped with reason %q, want step", reason)
+	}
+	if name, line, frames := c.top(); name != "main+0x6" || line != 6 || frames != 1 {
+		t.Errorf("stepOut stopped in %s on line %d with %d frames, want main+0x6, line 6 and 1 frame", name, line, frames)
+	}
+
+	c.request("next", map[string]any{"threadId": 1}, nil)
+	c.stopped()
+	c.request("evaluate", map[string]any{"expression": "0x10"}, &result)
+	if result.Result != "0x43" {
+		t.Errorf("0x10 evaluates to %s after the MOVWF, want 0x43", result.Result)
+	}
+
+	c.request("disconnect", nil, nil)
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Serve didn't return after disconnecting")
+	}
+}
@@ -0,0 +1,416 @@
+// Package debug implements run control for debuggers on top of [pic18.CPU]:
+// breakpoints, stepping, reverse execution and register access.
+package debug
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/natk64/go-pic-emu/binary"
+	"github.com/natk64/go-pic-emu/pic18"
+)
+
+// StopReason tells why execution stopped.
+type StopReason int
+
+const (
+	// StopStep means that a single step completed.
+	StopStep StopReason = iota
+	// StopBreakpoint means that execution reached a breakpoint.
+	StopBreakpoint
+	// StopInterrupted means that the debugger interrupted execution.
+	StopInterrupted
+	// StopIllegalInstruction means that the CPU executed an illegal instruction.
+	StopIllegalInstruction
+	// StopHistoryEnd means that reverse execution reached the oldest recorded state.
+	StopHistoryEnd
+	// StopWatchpoint means that an instruction accessed a watched data address.
+	// The accesses are in Watchpoints.Hits.
+	StopWatchpoint
+)
+
+func (reason StopReason) String() string {
+	switch reason {
+	case StopStep:
+		return "step"
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopInterrupted:
+		return "interrupted"
+	case StopIllegalInstruction:
+		return "illegal instruction"
+	case StopHistoryEnd:
+		return "end of history"
+	case StopWatchpoint:
+		return "watchpoint"
+	default:
+		return fmt.Sprintf("StopReason(%d)", int(reason))
+	}
+}
+
+// pollInterval is the number of ticks between checks for an interrupt request while running.
+const pollInterval = 1024
+
+// Target controls a CPU on behalf of a debugger.
+// All methods must be called from the same goroutine, except for the breakpoint methods,
+// which may be used while another goroutine runs the target.
+type Target struct {
+	CPU *pic18.CPU
+
+	// DataBus and ProgramBus are used for memory access by the debugger.
+	// They should not be wrapped by the history, so that debugger writes aren't recorded.
+	DataBus    pic18.BusReadWriter[uint16]
+	ProgramBus pic18.BusReadWriter[uint32]
+
+	// History enables reverse execution, it may be nil.
+	History *pic18.History
+
+	// Watchpoints intercepts the CPU's data accesses, execution stops after an instruction that triggered one.
+	Watchpoints *pic18.Watchpoints
+
+	// Lines enables stepping by source lines, it may be nil.
+	Lines *binary.LineTable
+
+	// breakpoints is replaced instead of modified, so it can be read while the target runs.
+	breakpoints atomic.Pointer[map[uint32]struct{}]
+	illegal     bool
+}
+
+// New creates a target for the CPU, using the CPU's buses for memory access.
+// The target becomes the event handler of the CPU and attaches watchpoints to its data bus.
+func New(cpu *pic18.CPU, history *pic18.History) *Target {
+	target := &Target{
+		CPU:        cpu,
+		DataBus:    cpu.DataBus,
+		ProgramBus: cpu.ProgramBus,
+		History:    history,
+	}
+
+	if history != nil {
+		// The history replaced the CPU's data bus, the debugger goes around it.
+		target.DataBus = history.Bus()
+	}
+
+	target.Watchpoints = pic18.NewWatchpoints(cpu)
+	target.Watchpoints.Memory = dataReader{target}
+
+	cpu.EventHandler = target
+	return target
+}
+
+// dataReader reads data memory through the debugger, without side effects.
+type dataReader struct {
+	target *Target
+}
+
+func (reader dataReader) BusRead(addr uint16) (uint8, pic18.AddrMask) {
+	return reader.target.ReadData(addr), 0xFF
+}
+
+func (target *Target) IllegalInstruction() {
+	target.illegal = true
+}
+
+// SetBreakpoint adds a breakpoint at a program memory address.
+func (target *Target) SetBreakpoint(addr uint32) {
+	target.updateBreakpoints(func(breakpoints map[uint32]struct{}) {
+		breakpoints[addr] = struct{}{}
+	})
+}
+
+// ClearBreakpoint removes a breakpoint, it does nothing if there is none at the address.
+func (target *Target) ClearBreakpoint(addr uint32) {
+	target.updateBreakpoints(func(breakpoints map[uint32]struct{}) {
+		delete(breakpoints, addr)
+	})
+}
+
+// SetBreakpoints replaces all breakpoints.
+func (target *Target) SetBreakpoints(addrs []uint32) {
+	target.updateBreakpoints(func(breakpoints map[uint32]struct{}) {
+		clear(breakpoints)
+		for _, addr := range addrs {
+			breakpoints[addr] = struct{}{}
+		}
+	})
+}
+
+// HasBreakpoint reports whether there is a breakpoint at the address.
+func (target *Target) HasBreakpoint(addr uint32) bool {
+	breakpoints := target.breakpoints.Load()
+	if breakpoints == nil {
+		return false
+	}
+	_, ok := (*breakpoints)[addr]
+	return ok
+}
+
+// Breakpoints returns the addresses of all breakpoints in ascending order.
+func (target *Target) Breakpoints() []uint32 {
+	breakpoints := target.breakpoints.Load()
+	if breakpoints == nil {
+		return nil
+	}
+
+	addrs := make([]uint32, 0, len(*breakpoints))
+	for addr := range *breakpoints {
+		addrs = append(addrs, addr)
+	}
+	slices.Sort(addrs)
+	return addrs
+}
+
+// updateBreakpoints modifies a copy of the breakpoints and then replaces them.
+// Breakpoints are only changed by the debugger's goroutine, so there is no need to retry.
+func (target *Target) updateBreakpoints(update func(breakpoints map[uint32]struct{})) {
+	breakpoints := make(map[uint32]struct{})
+	if old := target.breakpoints.Load(); old != nil {
+		maps.Copy(breakpoints, *old)
+	}
+	update(breakpoints)
+	target.breakpoints.Store(&breakpoints)
+}
+
+// tick advances the CPU by one cycle, through the history if there is one.
+func (target *Target) tick() {
+	if target.History != nil {
+		target.History.Tick()
+	} else {
+		target.CPU.Tick()
+	}
+}
+
+// idle returns true if ticking can't make progress, because the CPU is asleep and only an interrupt can wake it up.
+func (target *Target) idle() bool {
+	cpu := target.CPU
+	return cpu.Sleep.Asleep() && !cpu.Watchdog.Enabled(true)
+}
+
+// Step executes a single instruction (or one cycle, if the CPU is asleep).
+func (target *Target) Step() StopReason {
+	target.illegal = false
+	target.Watchpoints.Hits = nil
+
+	cpu := target.CPU
+	// After a reset, the first cycle only fetches the first instruction.
+	for !cpu.AtInstructionBoundary() && !cpu.Sleep.Asleep() {
+		target.tick()
+	}
+
+	target.tick()
+	for !cpu.AtInstructionBoundary() && !cpu.Sleep.Asleep() {
+		target.tick()
+	}
+
+	if target.illegal {
+		return StopIllegalInstruction
+	}
+	if len(target.Watchpoints.Hits) > 0 {
+		return StopWatchpoint
+	}
+	return StopStep
+}
+
+// StepOver executes a single instruction like Step, but runs until a called function returns.
+// It stops early at breakpoints or if a value is received from interrupt.
+func (target *Target) StepOver(interrupt <-chan struct{}) StopReason {
+	depth := target.stackDepth()
+	if reason := target.Step(); reason != StopStep {
+		return reason
+	}
+
+	if target.stackDepth() <= depth {
+		return StopStep
+	}
+	return target.runUntil(interrupt, func() bool { return target.stackDepth() <= depth })
+}
+
+// StepOut runs until the current function returns.
+// It stops early at breakpoints or if a value is received from interrupt.
+func (target *Target) StepOut(interrupt <-chan struct{}) StopReason {
+	depth := target.stackDepth()
+	if reason := target.Step(); reason != StopStep {
+		return reason
+	}
+	return target.runUntil(interrupt, func() bool { return target.stackDepth() < depth })
+}
+
+// StepLine runs until the start of another source line, entering called functions that have line information.
+// Code without line information is run through. Without a line table, or if the current instruction has no
+// line, it steps a single instruction. It stops early at breakpoints or if a value is received from interrupt.
+func (target *Target) StepLine(interrupt <-chan struct{}) StopReason {
+	return target.stepLine(interrupt, false)
+}
+
+// NextLine is like StepLine, but runs called functions until they return.
+func (target *Target) NextLine(interrupt <-chan struct{}) StopReason {
+	return target.stepLine(interrupt, true)
+}
+
+func (target *Target) stepLine(interrupt <-chan struct{}, over bool) StopReason {
+	if target.Lines == nil {
+		return target.stepInstruction(interrupt, over)
+	}
+	current, ok := target.Lines.Lookup(target.CPU.PC())
+	if !ok {
+		return target.stepInstruction(interrupt, over)
+	}
+
+	depth := target.stackDepth()
+	done := func() bool {
+		if over && target.stackDepth() > depth {
+			return false
+		}
+
+		// Returning to the middle of a line, e.g. after a call, doesn't stop, only the start of a statement does.
+		pc := target.CPU.PC()
+		entry, ok := target.Lines.Lookup(pc)
+		return ok && entry.IsStmt && entry.Start == pc && entry != current
+	}
+
+	if reason := target.Step(); reason != StopStep {
+		return reason
+	}
+	if done() {
+		return StopStep
+	}
+	return target.runUntil(interrupt, done)
+}
+
+func (target *Target) stepInstruction(interrupt <-chan struct{}, over bool) StopReason {
+	if over {
+		return target.StepOver(interrupt)
+	}
+	return target.Step()
+}
+
+// stackDepth returns the number of return addresses on the hardware stack.
+func (target *Target) stackDepth() int {
+	return int(target.ReadRegister(RegSTKPTR) & 0x1F)
+}
+
+// Continue runs until a breakpoint or watchpoint is reached or a value is received from interrupt.
+// A breakpoint at the current address is stepped over.
+func (target *Target) Continue(interrupt <-chan struct{}) StopReason {
+	if reason := target.Step(); reason != StopStep {
+		return reason
+	}
+	return target.runUntil(interrupt, nil)
+}
+
+// runUntil runs until done returns true at an instruction boundary, a breakpoint or watchpoint is reached
+// or a value is received from interrupt. done may be nil.
+func (target *Target) runUntil(interrupt <-chan struct{}, done func() bool) StopReason {
+	cpu := target.CPU
+	target.Watchpoints.Hits = nil
+	for ticks := 0; ; ticks++ {
+		if cpu.AtInstructionBoundary() && !cpu.Sleep.Asleep() {
+			// The instruction that triggered a watchpoint has completed.
+			if len(target.Watchpoints.Hits) > 0 {
+				return StopWatchpoint
+			}
+			if done != nil && done() {
+				return StopStep
+			}
+			if target.HasBreakpoint(cpu.PC()) {
+				return StopBreakpoint
+			}
+		}
+
+		if target.idle() {
+			select {
+			case <-interrupt:
+				return StopInterrupted
+			case <-time.After(time.Millisecond):
+			}
+			continue
+		}
+
+		if ticks%pollInterval == 0 {
+			select {
+			case <-interrupt:
+				return StopInterrupted
+			default:
+			}
+		}
+
+		target.tick()
+		if target.illegal {
+			return StopIllegalInstruction
+		}
+	}
+}
+
+// CanReverse reports whether reverse execution is available.
+func (target *Target) CanReverse() bool {
+	return target.History != nil
+}
+
+// StepBack reverts the last instruction.
+func (target *Target) StepBack() StopReason {
+	if target.History == nil || target.History.StepBack(1) == 0 {
+		return StopHistoryEnd
+	}
+	return StopStep
+}
+
+// ContinueBack runs backwards until a breakpoint is reached, the history is exhausted
+// or a value is received from interrupt.
+func (target *Target) ContinueBack(interrupt <-chan struct{}) StopReason {
+	for steps := 0; ; steps++ {
+		if target.StepBack() == StopHistoryEnd {
+			return StopHistoryEnd
+		}
+
+		if target.HasBreakpoint(target.CPU.PC()) {
+			return StopBreakpoint
+		}
+
+		if steps%pollInterval == 0 {
+			select {
+			case <-interrupt:
+				return StopInterrupted
+			default:
+			}
+		}
+	}
+}
+
+// ReadData reads data memory without side effects.
+// The indirect addressing registers read as 0 and reading PCL doesn't update PCLATH and PCLATU.
+func (target *Target) ReadData(addr uint16) uint8 {
+	if pic18.IsIndirectRegister(addr) {
+		return 0
+	}
+	if addr == pic18.Registers.PCL {
+		return uint8(target.CPU.PC())
+	}
+
+	data, mask := target.DataBus.BusRead(addr)
+	return data & uint8(mask)
+}
+
+// WriteData writes data memory.
+func (target *Target) WriteData(addr uint16, data uint8) {
+	target.DataBus.BusWrite(addr, data)
+}
+
+// ReadProgram reads program memory.
+func (target *Target) ReadProgram(addr uint32) uint8 {
+	data, mask := target.ProgramBus.BusRead(addr)
+	return data & uint8(mask)
+}
+
+// WriteProgram writes program memory.
+// If the address of the next instruction is written, the instruction is fetched again.
+func (target *Target) WriteProgram(addr uint32, data uint8) {
+	target.ProgramBus.BusWrite(addr, data)
+
+	cpu := target.CPU
+	if addr&^1 == cpu.PC() && cpu.AtInstructionBoundary() {
+		cpu.SetPC(cpu.PC())
+	}
+}
@@ -0,0 +1,151 @@
+package debug
+
+import (
+	"testing"
+
+	"github.com/natk64/go-pic-emu/binary"
+	"github.com/natk64/go-pic-emu/pic18/internal/testcpu"
+)
+
+const callSource = `
+	org 0
+main
+	movlw 1
+	call sub
+	movlw 2
+loop
+	bra loop
+sub
+	movlw 3
+	rcall leaf
+	return
+leaf
+	nop
+	return
+`
+
+// newTestTarget returns a target for source and the addresses of its labels.
+func newTestTarget(t *testing.T, source string) (*Target, map[string]uint32) {
+	t.Helper()
+	cpu, program := testcpu.New(t, source)
+	return New(cpu, nil), program.Symbols
+}
+
+// expectStop checks the stop reason and the address of the next instruction.
+func expectStop(t *testing.T, what string, target *Target, reason, wantReason StopReason, wantPC uint32) {
+	t.Helper()
+	if reason != wantReason || target.CPU.PC() != wantPC {
+		t.Errorf("%s stopped at 0x%06X (%v), want 0x%06X (%v)", what, target.CPU.PC(), reason, wantPC, wantReason)
+	}
+}
+
+func TestStep(t *testing.T) {
+	target, labels := newTestTarget(t, callSource)
+
+	expectStop(t, "step", target, target.Step(), StopStep, 0x02)
+	// CALL is a two word instruction, a single step completes it.
+	expectStop(t, "step into", target, target.Step(), StopStep, labels["sub"])
+}
+
+func TestStepOver(t *testing.T) {
+	target, labels := newTestTarget(t, callSource)
+	target.Step()
+
+	expectStop(t, "step over", target, target.StepOver(nil), StopStep, 0x06)
+	if target.CPU.WReg != 3 {
+		t.Errorf("W = %d after stepping over the call, want 3", target.CPU.WReg)
+	}
+
+	// A breakpoint in the called function stops the step.
+	target, _ = newTestTarget(t, callSource)
+	target.Step()
+	target.SetBreakpoint(labels["leaf"])
+	expectStop(t, "step over with a breakpoint", target, target.StepOver(nil), StopBreakpoint, labels["leaf"])
+}
+
+func TestStepOut(t *testing.T) {
+	target, labels := newTestTarget(t, callSource)
+	target.SetBreakpoint(labels["leaf"])
+	expectStop(t, "continue", target, target.Continue(nil), StopBreakpoint, labels["leaf"])
+
+	// Returning from leaf continues in sub, after the RCALL.
+	expectStop(t, "step out of leaf", target, target.StepOut(nil), StopStep, labels["leaf"]-2)
+	expectStop(t, "step out of sub", target, target.StepOut(nil), StopStep, 0x06)
+}
+
+// callLines maps callSource to the lines of a C file, leaf has no line information.
+var callLines = []binary.LineEntry{
+	{Start: 0x00, End: 0x02, File: "main.c", Line: 3, IsStmt: true},
+	{Start: 0x02, End: 0x06, File: "main.c", Line: 3},
+	{Start: 0x06, End: 0x0A, File: "main.c", Line: 4, IsStmt: true},
+	{Start: 0x0A, End: 0x10, File: "sub.c", Line: 7, IsStmt: true},
+}
+
+func TestStepLine(t *testing.T) {
+	target, labels := newTestTarget(t, callSource)
+	target.Lines = binary.NewLineTable(callLines)
+
+	expectStop(t, "step into sub", target, target.StepLine(nil), StopStep, labels["sub"])
+	// Leaf is run through, the return to the middle of line 7 doesn't stop.
+	expectStop(t, "step out of sub", target, target.StepLine(nil), StopStep, 0x06)
+	if target.CPU.WReg != 3 {
+		t.Errorf("W = %d after stepping through sub, want 3", target.CPU.WReg)
+	}
+
+	target, _ = newTestTarget(t, callSource)
+	target.Lines = binary.NewLineTable(callLines)
+	expectStop(t, "next over the call", target, target.NextLine(nil), StopStep, 0x06)
+
+	// Without line information at the PC, a single instruction is stepped.
+	target, labels = newTestTarget(t, callSource)
+	target.Lines = binary.NewLineTable(callLines)
+	target.SetBreakpoint(labels["leaf"])
+	expectStop(t, "continue", target, target.Continue(nil), StopBreakpoint, labels["leaf"])
+	expectStop(t, "step a line in leaf", target, target.StepLine(nil), StopStep, labels["leaf"]+2)
+}
+
+func TestContinueInterrupted(t *testing.T) {
+	target, labels := newTestTarget(t, callSource)
+	target.SetBreakpoints([]uint32{labels["loop"], labels["sub"]})
+	if got := target.Breakpoints(); len(got) != 2 || got[0] != labels["loop"] {
+		t.Errorf("breakpoints are %X, want the addresses of loop and sub", got)
+	}
+
+	expectStop(t, "continue", target, target.Continue(nil), StopBreakpoint, labels["sub"])
+	expectStop(t, "continue", target, target.Continue(nil), StopBreakpoint, labels["loop"])
+
+	target.ClearBreakpoint(labels["loop"])
+	interrupt := make(chan struct{}, 1)
+	interrupt <- struct{}{}
+	if reason := target.Continue(interrupt); reason != StopInterrupted {
+		t.Errorf("continue in the loop stopped with %v, want %v", reason, StopInterrupted)
+	}
+}
+
+func TestIllegalInstruction(t *testing.T) {
+	target, _ := newTestTarget(t, `
+	org 0
+	nop
+	dw 0x0001
+	nop
+`)
+
+	if reason := target.Continue(nil); reason != StopIllegalInstruction {
+		t.Errorf("continue stopped with %v, want %v", reason, StopIllegalInstruction)
+	}
+}
+
+func TestWriteProgram(t *testing.T) {
+	target, _ := newTestTarget(t, callSource)
+
+	// Replacing the next instruction takes effect immediately.
+	target.WriteProgram(0, 0x42)
+	target.WriteProgram(1, 0x0E)
+	target.Step()
+	if target.CPU.WReg != 0x42 {
+		t.Errorf("W = 0x%02X after executing the patched instruction, want 0x42", target.CPU.WReg)
+	}
+	if data := target.ReadProgram(0); data != 0x42 {
+		t.Errorf("program memory at 0 is 0x%02X, want 0x42", data)
+	}
+}
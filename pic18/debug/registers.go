@@ -0,0 +1,103 @@
+package debug
+
+import (
+	"fmt"
+
+	"github.com/natk64/go-pic-emu/pic18"
+)
+
+// Register describes a CPU register that is visible to debuggers.
+type Register struct {
+	Name string
+	// Size is the size of the register in bytes.
+	Size int
+}
+
+// Register numbers, the hardware stack levels follow after RegStack.
+const (
+	RegWREG = iota
+	RegSTATUS
+	RegBSR
+	RegFSR0
+	RegFSR1
+	RegFSR2
+	RegSTKPTR
+	RegPC
+	RegStack
+)
+
+var coreRegisters = []Register{
+	RegWREG:   {Name: "wreg", Size: 1},
+	RegSTATUS: {Name: "status", Size: 1},
+	RegBSR:    {Name: "bsr", Size: 1},
+	RegFSR0:   {Name: "fsr0", Size: 2},
+	RegFSR1:   {Name: "fsr1", Size: 2},
+	RegFSR2:   {Name: "fsr2", Size: 2},
+	RegSTKPTR: {Name: "stkptr", Size: 1},
+	RegPC:     {Name: "pc", Size: 4},
+}
+
+// Registers returns all registers in register number order.
+// The list contains one register per hardware stack level, so it depends on the stack size.
+func (target *Target) Registers() []Register {
+	registers := append([]Register(nil), coreRegisters...)
+	for i := range target.CPU.Stack.Data {
+		registers = append(registers, Register{Name: fmt.Sprintf("stack%d", i+1), Size: 4})
+	}
+	return registers
+}
+
+// ReadRegister returns the value of a register, or 0 if the register number is invalid.
+func (target *Target) ReadRegister(reg int) uint32 {
+	cpu := target.CPU
+	switch reg {
+	case RegWREG:
+		return uint32(cpu.WReg)
+	case RegSTATUS:
+		return uint32(target.ReadData(pic18.Registers.STATUS))
+	case RegBSR:
+		return uint32(cpu.BankController.BSR)
+	case RegFSR0, RegFSR1, RegFSR2:
+		return uint32(cpu.BankController.FSR[reg-RegFSR0])
+	case RegSTKPTR:
+		return uint32(target.ReadData(pic18.Registers.STKPTR))
+	case RegPC:
+		return cpu.PC()
+	}
+
+	level := reg - RegStack
+	if level >= 0 && level < len(cpu.Stack.Data) {
+		return cpu.Stack.Data[level]
+	}
+	return 0
+}
+
+// WriteRegister changes the value of a register, invalid register numbers are ignored.
+func (target *Target) WriteRegister(reg int, value uint32) {
+	cpu := target.CPU
+	switch reg {
+	case RegWREG:
+		cpu.WReg = uint8(value)
+		return
+	case RegSTATUS:
+		target.WriteData(pic18.Registers.STATUS, uint8(value))
+		return
+	case RegBSR:
+		cpu.BankController.BSR = uint8(value) & 0xF
+		return
+	case RegFSR0, RegFSR1, RegFSR2:
+		cpu.BankController.SetFSR(reg-RegFSR0, uint16(value))
+		return
+	case RegSTKPTR:
+		target.WriteData(pic18.Registers.STKPTR, uint8(value))
+		return
+	case RegPC:
+		cpu.SetPC(value)
+		return
+	}
+
+	level := reg - RegStack
+	if level >= 0 && level < len(cpu.Stack.Data) {
+		cpu.Stack.Data[level] = value & 0x1FFFFF
+	}
+}
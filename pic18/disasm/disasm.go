@@ -0,0 +1,300 @@
+// Package disasm turns PIC18 program memory back into MPASM style assembly.
+package disasm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/natk64/go-pic-emu/pic18"
+	"github.com/natk64/go-pic-emu/pic18/instruction"
+)
+
+// Options control how instructions are rendered.
+type Options struct {
+	// ExtendedSet decodes the extended instruction set and shows access bank operands below 0x60
+	// using the indexed literal offset syntax ([k]).
+	// If it is false, extended instructions are shown as data words.
+	ExtendedSet bool
+
+	// Symbols maps program memory addresses to names, they are used for branch targets and labels.
+	Symbols map[uint32]string
+
+	// DataSymbols maps data memory addresses to names.
+	// Special function registers are always named, entries in this map take precedence.
+	DataSymbols map[uint16]string
+}
+
+// Line is a single disassembled instruction.
+type Line struct {
+	Address uint32
+	Words   []uint16
+	Opcode  instruction.Opcode
+	Text    string
+
+	// Target is the destination of branches, calls and gotos.
+	Target    uint32
+	HasTarget bool
+}
+
+// Size returns the size of the instruction in bytes.
+func (line Line) Size() uint32 {
+	return uint32(len(line.Words)) * 2
+}
+
+// String formats the line like a listing, including the address and the raw instruction words.
+func (line Line) String() string {
+	words := make([]string, len(line.Words))
+	for i, word := range line.Words {
+		words[i] = fmt.Sprintf("%04X", word)
+	}
+	return fmt.Sprintf("%06X  %-10s %s", line.Address, strings.Join(words, " "), line.Text)
+}
+
+// ReadWord reads a little endian instruction word from program memory.
+func ReadWord(bus pic18.ProgramBusReader, addr uint32) uint16 {
+	low, _ := bus.BusRead(addr)
+	high, _ := bus.BusRead(addr + 1)
+	return uint16(high)<<8 | uint16(low)
+}
+
+// Disassemble disassembles all instructions in the range [start, end).
+func Disassemble(bus pic18.ProgramBusReader, start, end uint32, opts *Options) []Line {
+	var lines []Line
+	for addr := start &^ 1; addr < end; {
+		line := Instruction(bus, addr, opts)
+		lines = append(lines, line)
+		addr += line.Size()
+	}
+	return lines
+}
+
+// Instruction disassembles the instruction at addr.
+func Instruction(bus pic18.ProgramBusReader, addr uint32, opts *Options) Line {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	word := ReadWord(bus, addr)
+	inst := instruction.Instruction(word)
+	line := Line{
+		Address: addr,
+		Words:   []uint16{word},
+		Opcode:  inst.Opcode(),
+	}
+
+	if line.Opcode.Extended() && !opts.ExtendedSet {
+		line.Opcode = instruction.ILLEGAL
+	}
+
+	if isTwoWord(line.Opcode) {
+		line.Words = append(line.Words, ReadWord(bus, addr+2))
+	}
+
+	d := disassembler{opts: opts, line: &line}
+	line.Text = d.format(inst)
+	return line
+}
+
+func isTwoWord(op instruction.Opcode) bool {
+	switch op {
+	case instruction.MOVFF, instruction.LFSR, instruction.CALL, instruction.GOTO, instruction.MOVSF, instruction.MOVSS:
+		return true
+	default:
+		return false
+	}
+}
+
+type disassembler struct {
+	opts *Options
+	line *Line
+}
+
+func (d disassembler) format(inst instruction.Instruction) string {
+	op := d.line.Opcode
+	name := op.String()
+
+	switch op {
+	case instruction.ILLEGAL:
+		return fmt.Sprintf("DW 0x%04X", uint16(inst))
+
+	case instruction.ADDWF, instruction.ADDWFC, instruction.ANDWF, instruction.COMF, instruction.DECF,
+		instruction.DECFSZ, instruction.DCFSNZ, instruction.INCF, instruction.INCFSZ, instruction.INFSNZ,
+		instruction.IORWF, instruction.MOVF, instruction.RLCF, instruction.RLNCF, instruction.RRCF,
+		instruction.RRNCF, instruction.SUBFWB, instruction.SUBWF, instruction.SUBWFB, instruction.SWAPF,
+		instruction.XORWF:
+		byteInst := instruction.ByteOriented(inst)
+		dest := "W"
+		if byteInst.D() {
+			dest = "F"
+		}
+		return d.withAccess(name, byteInst.F(), byteInst.A(), dest)
+
+	case instruction.CLRF, instruction.CPFSEQ, instruction.CPFSGT, instruction.CPFSLT, instruction.MOVWF,
+		instruction.MULWF, instruction.NEGF, instruction.SETF, instruction.TSTFSZ:
+		byteInst := instruction.ByteOriented(inst)
+		return d.withAccess(name, byteInst.F(), byteInst.A())
+
+	case instruction.BCF, instruction.BSF, instruction.BTFSC, instruction.BTFSS, instruction.BTG:
+		bitInst := instruction.BitOriented(inst)
+		return d.withAccess(name, bitInst.F(), bitInst.A(), fmt.Sprint(bitInst.Bit()))
+
+	case instruction.BC, instruction.BN, instruction.BNC, instruction.BNN, instruction.BNOV,
+		instruction.BNZ, instruction.BOV, instruction.BZ:
+		offset := int32(int8(instruction.ControlBranchStatus(inst).Literal()))
+		return fmt.Sprintf("%s %s", name, d.target(d.line.Address+2+uint32(offset*2)))
+
+	case instruction.BRA, instruction.RCALL:
+		literal := instruction.ControlBranch(inst).Literal()
+		offset := int32(literal) - int32(literal&0x400)<<1
+		return fmt.Sprintf("%s %s", name, d.target(d.line.Address+2+uint32(offset*2)))
+
+	case instruction.CALL:
+		high := instruction.ControlCallHigh(inst)
+		low := instruction.ControlCallLow(d.line.Words[1])
+		target := (uint32(low.Literal())<<8 | uint32(high.Literal())) << 1
+		if high.S() {
+			return fmt.Sprintf("%s %s, FAST", name, d.target(target))
+		}
+		return fmt.Sprintf("%s %s", name, d.target(target))
+
+	case instruction.GOTO:
+		high := instruction.ControlGotoHigh(inst)
+		low := instruction.ControlGotoLow(d.line.Words[1])
+		target := (uint32(low.Literal())<<8 | uint32(high.Literal())) << 1
+		return fmt.Sprintf("%s %s", name, d.target(target))
+
+	case instruction.RETFIE, instruction.RETURN:
+		if instruction.ControlReturn(inst).S() {
+			return name + " FAST"
+		}
+		return name
+
+	case instruction.ADDLW, instruction.ANDLW, instruction.IORLW, instruction.MOVLW, instruction.MULLW,
+		instruction.RETLW, instruction.SUBLW, instruction.XORLW, instruction.PUSHL:
+		return fmt.Sprintf("%s 0x%02X", name, instruction.Literal(inst).K())
+
+	case instruction.MOVLB:
+		return fmt.Sprintf("%s 0x%X", name, instruction.Literal(inst).K()&0x0F)
+
+	case instruction.MOVFF:
+		src := instruction.ByteToByte(inst).F()
+		dst := instruction.ByteToByte(d.line.Words[1]).F()
+		return fmt.Sprintf("%s %s, %s", name, d.register(src), d.register(dst))
+
+	case instruction.LFSR:
+		high := instruction.LoadFsrHigh(inst)
+		low := instruction.LoadFsrLow(d.line.Words[1])
+		return fmt.Sprintf("%s %d, 0x%03X", name, high.F(), uint16(high.K())<<8|uint16(low.K()))
+
+	case instruction.TBLRD, instruction.TBLWT:
+		suffix := [4]string{"*", "*+", "*-", "+*"}[instruction.TableOp(inst).N()]
+		return name + suffix
+
+	case instruction.ADDFSR, instruction.SUBFSR:
+		fsrInst := instruction.XinstFsr(inst)
+		return fmt.Sprintf("%s %d, 0x%02X", name, fsrInst.F(), fsrInst.K())
+
+	case instruction.ADDULNK, instruction.SUBULNK:
+		return fmt.Sprintf("%s 0x%02X", name, instruction.XinstFsr(inst).K())
+
+	case instruction.MOVSF:
+		src := instruction.MovsfHighMovss(inst).Z()
+		dst := instruction.MovsfLow(d.line.Words[1]).F()
+		return fmt.Sprintf("%s [0x%02X], %s", name, src, d.register(dst))
+
+	case instruction.MOVSS:
+		src := instruction.MovsfHighMovss(inst).Z()
+		dst := instruction.MovsfHighMovss(d.line.Words[1]).Z()
+		return fmt.Sprintf("%s [0x%02X], [0x%02X]", name, src, dst)
+
+	case instruction.NOP1:
+		return instruction.NOP.String()
+
+	default:
+		// Instructions without operands
+		return name
+	}
+}
+
+// withAccess formats an instruction with a file register operand, followed by extra operands and the access mode.
+func (d disassembler) withAccess(name string, f uint8, banked bool, extra ...string) string {
+	operands := make([]string, 0, 3)
+	if banked {
+		operands = append(operands, d.banked(f))
+	} else if d.opts.ExtendedSet && f < 0x60 {
+		// Indexed literal offset addressing, the access bit is implied by the brackets.
+		operands = append(operands, fmt.Sprintf("[0x%02X]", f))
+		operands = append(operands, extra...)
+		return name + " " + strings.Join(operands, ", ")
+	} else {
+		operands = append(operands, d.access(f))
+	}
+
+	operands = append(operands, extra...)
+	if banked {
+		operands = append(operands, "BANKED")
+	} else {
+		operands = append(operands, "ACCESS")
+	}
+
+	return name + " " + strings.Join(operands, ", ")
+}
+
+// access names an access bank operand, which is either access RAM or an SFR.
+func (d disassembler) access(f uint8) string {
+	if f >= 0x80 {
+		return d.register(0xF00 | uint16(f))
+	}
+	return d.register(uint16(f))
+}
+
+// banked formats a banked operand. The BSR isn't known statically, so only the offset is shown.
+func (d disassembler) banked(f uint8) string {
+	return fmt.Sprintf("0x%02X", f)
+}
+
+func (d disassembler) register(addr uint16) string {
+	if name, ok := d.opts.DataSymbols[addr]; ok {
+		return name
+	}
+	if name, ok := RegisterNames[addr]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%03X", addr)
+}
+
+func (d disassembler) target(addr uint32) string {
+	addr &= 0x1FFFFF
+	d.line.Target = addr
+	d.line.HasTarget = true
+	if name, ok := d.opts.Symbols[addr]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%06X", addr)
+}
+
+// RegisterNames maps the address of every special function register known to the emulator to its name.
+var RegisterNames = registerNames()
+
+func registerNames() map[uint16]string {
+	names := map[uint16]string{
+		pic18.FSR0H: "FSR0H", pic18.FSR0L: "FSR0L",
+		pic18.FSR1H: "FSR1H", pic18.FSR1L: "FSR1L",
+		pic18.FSR2H: "FSR2H", pic18.FSR2L: "FSR2L",
+		pic18.INDF0: "INDF0", pic18.POSTINC0: "POSTINC0", pic18.POSTDEC0: "POSTDEC0", pic18.PREINC0: "PREINC0", pic18.PLUSW0: "PLUSW0",
+		pic18.INDF1: "INDF1", pic18.POSTINC1: "POSTINC1", pic18.POSTDEC1: "POSTDEC1", pic18.PREINC1: "PREINC1", pic18.PLUSW1: "PLUSW1",
+		pic18.INDF2: "INDF2", pic18.POSTINC2: "POSTINC2", pic18.POSTDEC2: "POSTDEC2", pic18.PREINC2: "PREINC2", pic18.PLUSW2: "PLUSW2",
+		pic18.TBLPTRU: "TBLPTRU", pic18.TBLPTRH: "TBLPTRH", pic18.TBLPTRL: "TBLPTRL", pic18.TABLAT: "TABLAT",
+	}
+
+	table := reflect.ValueOf(pic18.Registers)
+	for i := 0; i < table.NumField(); i++ {
+		addr := uint16(table.Field(i).Uint())
+		if addr != 0 {
+			names[addr] = table.Type().Field(i).Name
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,162 @@
+package disasm_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/natk64/go-pic-emu/pic18"
+	"github.com/natk64/go-pic-emu/pic18/asm"
+	"github.com/natk64/go-pic-emu/pic18/disasm"
+)
+
+const standardSource = `
+	org 0
+start
+	movff 0x123, 0x456
+	lfsr 2, 0x345
+	call sub, 1
+	goto start
+	movwf 0x10
+	movwf 0x10, b
+	addwf 0x20, w
+	bsf INTCON, 7
+	btfss STATUS, 2
+	bra start
+	bz start
+	tblrd*+
+	tblwt+*
+	movlb 5
+	mullw 0x12
+	retfie
+	sleep
+	reset
+sub
+	rcall start
+	retlw 0x34
+	return 1
+`
+
+const extendedSource = `
+	org 0
+	addfsr 1, 5
+	subfsr 0, 2
+	movsf [2], 0x123
+	movss [1], [3]
+	pushl 0x5A
+	callw
+	addulnk 4
+	subulnk 3
+	movwf [0x10]
+	movf [0x05], w
+	movwf 0x10
+`
+
+// disassemble assembles source and disassembles the whole image.
+func disassemble(t *testing.T, source string, extended bool) ([]byte, []disasm.Line) {
+	t.Helper()
+	program, err := asm.Assemble(source, &asm.Options{ExtendedSet: extended})
+	if err != nil {
+		t.Fatalf("assembling: %v", err)
+	}
+
+	bus := pic18.Memory[uint32]{Data: program.Image}
+	return program.Image, disasm.Disassemble(bus, 0, uint32(len(program.Image)), &disasm.Options{ExtendedSet: extended})
+}
+
+// The disassembly of a program assembles to the same image.
+func TestRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		source   string
+		extended bool
+	}{
+		{"standard", standardSource, false},
+		{"extended", extendedSource, true},
+	} {
+		image, lines := disassemble(t, test.source, test.extended)
+
+		var source strings.Builder
+		source.WriteString("\torg 0\n")
+		for _, line := range lines {
+			source.WriteString("\t" + line.Text + "\n")
+		}
+
+		program, err := asm.Assemble(source.String(), &asm.Options{ExtendedSet: test.extended})
+		if err != nil {
+			t.Errorf("%s: assembling the disassembly: %v\n%s", test.name, err, source.String())
+			continue
+		}
+		if !bytes.Equal(program.Image, image) {
+			t.Errorf("%s: the disassembly assembles to\n% X\nwant\n% X\n%s", test.name, program.Image, image, source.String())
+		}
+	}
+}
+
+func TestInstruction(t *testing.T) {
+	tests := []struct {
+		words    []uint16
+		extended bool
+		want     string
+		size     uint32
+	}{
+		{[]uint16{0xC123, 0xF456}, false, "MOVFF 0x123, 0x456", 4},
+		{[]uint16{0xEE23, 0xF045}, false, "LFSR 2, 0x345", 4},
+		{[]uint16{0xED1A, 0xF009}, false, "CALL 0x001234, FAST", 4},
+		{[]uint16{0xEF1A, 0xF009}, false, "GOTO 0x001234", 4},
+		{[]uint16{0x6EF2}, false, "MOVWF INTCON, ACCESS", 2},
+		{[]uint16{0x6E10}, false, "MOVWF 0x010, ACCESS", 2},
+		{[]uint16{0x6F10}, false, "MOVWF 0x10, BANKED", 2},
+
+		// Access bank operands below 0x60 are offsets from FSR2 in extended mode.
+		{[]uint16{0x6E10}, true, "MOVWF [0x10]", 2},
+		{[]uint16{0x6E60}, true, "MOVWF 0x060, ACCESS", 2},
+		{[]uint16{0xEB02, 0xF123}, true, "MOVSF [0x02], 0x123", 4},
+		{[]uint16{0xEB81, 0xF003}, true, "MOVSS [0x01], [0x03]", 4},
+		{[]uint16{0xE845}, true, "ADDFSR 1, 0x05", 2},
+		{[]uint16{0xE8C4}, true, "ADDULNK 0x04", 2},
+		{[]uint16{0xEA5A}, true, "PUSHL 0x5A", 2},
+		{[]uint16{0x0014}, true, "CALLW", 2},
+
+		// Without XINST, extended instructions are shown as data words.
+		{[]uint16{0xEB02, 0xF123}, false, "DW 0xEB02", 2},
+		{[]uint16{0x0014}, false, "DW 0x0014", 2},
+	}
+
+	for _, test := range tests {
+		image := make([]byte, 2*len(test.words))
+		for i, word := range test.words {
+			image[2*i] = uint8(word)
+			image[2*i+1] = uint8(word >> 8)
+		}
+
+		line := disasm.Instruction(pic18.Memory[uint32]{Data: image}, 0, &disasm.Options{ExtendedSet: test.extended})
+		if line.Text != test.want || line.Size() != test.size {
+			t.Errorf("%04X (extended %v): got %q, %d bytes, want %q, %d bytes", test.words, test.extended, line.Text, line.Size(), test.want, test.size)
+		}
+	}
+}
+
+// Branch targets and operands are shown using the symbols.
+func TestSymbols(t *testing.T) {
+	image, _ := disassemble(t, standardSource, false)
+	opts := &disasm.Options{
+		Symbols:     map[uint32]string{0x00: "start"},
+		DataSymbols: map[uint16]string{0x010: "counter"},
+	}
+
+	lines := disasm.Disassemble(pic18.Memory[uint32]{Data: image}, 0, uint32(len(image)), opts)
+	texts := make(map[string]disasm.Line)
+	for _, line := range lines {
+		texts[line.Text] = line
+	}
+
+	for _, want := range []string{"GOTO start", "BRA start", "RCALL start", "MOVWF counter, ACCESS"} {
+		if _, ok := texts[want]; !ok {
+			t.Errorf("no line is %q", want)
+		}
+	}
+	if line := texts["BRA start"]; !line.HasTarget || line.Target != 0 {
+		t.Errorf("BRA has the target 0x%06X (%v), want 0x000000", line.Target, line.HasTarget)
+	}
+}
@@ -0,0 +1,245 @@
+// Package eval evaluates C expressions on the variables of a program, using the types and locations
+// of its DWARF debug information.
+//
+// Expressions are variable names, field accesses (a.b, p->b), array indexing (a[3]), dereferencing (*p),
+// the address operator (&a), casts to pointer types ((struct reading *)FSR0) and integer literals.
+// SFR names are unsigned char variables, FSR0, FSR1 and FSR2 are the 12 bit values of the register pairs.
+package eval
+
+import (
+	"debug/dwarf"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/natk64/go-pic-emu/binary"
+	"github.com/natk64/go-pic-emu/pic18"
+	"github.com/natk64/go-pic-emu/pic18/disasm"
+)
+
+// Memory reads the memories of the target without side effects, it is implemented by [debug.Target].
+type Memory interface {
+	ReadData(addr uint16) uint8
+	ReadProgram(addr uint32) uint8
+}
+
+// Variable is a variable with a static address, which includes the local variables of XC8's compiled stack.
+type Variable struct {
+	Name  string
+	Type  dwarf.Type
+	Space binary.Space
+	Addr  uint32
+
+	// Scope contains the address ranges of the function of a local variable, it is nil for globals.
+	Scope [][2]uint64
+}
+
+// inScope returns true if the variable is visible at pc.
+func (variable *Variable) inScope(pc uint32) bool {
+	for _, r := range variable.Scope {
+		if uint64(pc) >= r[0] && uint64(pc) < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluator evaluates expressions on the memory of a target.
+type Evaluator struct {
+	Memory Memory
+
+	globals []*Variable
+	locals  []*Variable
+
+	// types maps the names of types to their definitions, structs, unions and enums are prefixed
+	// by their keyword.
+	types map[string]dwarf.Type
+}
+
+// Errors of the location expressions that aren't supported.
+var errLocation = errors.New("location isn't a static address")
+
+// New reads the variables and types of a firmware. It returns an error if the firmware has no debug information.
+func New(firmware *binary.Firmware, memory Memory) (*Evaluator, error) {
+	data := firmware.DWARF
+	if data == nil {
+		return nil, errors.New("no debug information")
+	}
+
+	evaluator := &Evaluator{Memory: memory, types: make(map[string]dwarf.Type)}
+	reader := data.Reader()
+
+	// scopes holds the address ranges of the enclosing functions, nil for entries outside of functions.
+	var scopes [][][2]uint64
+	var scope [][2]uint64
+	for {
+		entry, err := reader.Next()
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			break
+		}
+
+		if entry.Tag == 0 {
+			if len(scopes) > 0 {
+				scope, scopes = scopes[len(scopes)-1], scopes[:len(scopes)-1]
+			}
+			continue
+		}
+
+		switch entry.Tag {
+		case dwarf.TagCompileUnit:
+			scope = nil
+		case dwarf.TagVariable:
+			variable, err := evaluator.variable(data, firmware, entry, reader.AddressSize())
+			if err == nil {
+				variable.Scope = scope
+				if scope == nil {
+					evaluator.globals = append(evaluator.globals, variable)
+				} else {
+					evaluator.locals = append(evaluator.locals, variable)
+				}
+			}
+		case dwarf.TagStructType, dwarf.TagUnionType, dwarf.TagEnumerationType, dwarf.TagTypedef, dwarf.TagBaseType:
+			evaluator.addType(data, entry)
+		}
+
+		if entry.Children {
+			scopes = append(scopes, scope)
+			if entry.Tag == dwarf.TagSubprogram {
+				if ranges, err := data.Ranges(entry); err == nil && len(ranges) > 0 {
+					scope = ranges
+				}
+			}
+		}
+	}
+
+	slices.SortFunc(evaluator.globals, func(a, b *Variable) int { return strings.Compare(a.Name, b.Name) })
+	return evaluator, nil
+}
+
+// variable reads a variable entry, variables without a static address are skipped with an error.
+func (evaluator *Evaluator) variable(data *dwarf.Data, firmware *binary.Firmware, entry *dwarf.Entry, addressSize int) (*Variable, error) {
+	name, _ := entry.Val(dwarf.AttrName).(string)
+	location, _ := entry.Val(dwarf.AttrLocation).([]byte)
+	typeOffset, ok := entry.Val(dwarf.AttrType).(dwarf.Offset)
+	if name == "" || !ok {
+		return nil, errLocation
+	}
+
+	// The only supported location is DW_OP_addr, every variable of XC8 has one.
+	const opAddr = 0x03
+	if len(location) != 1+addressSize || location[0] != opAddr {
+		return nil, errLocation
+	}
+	var addr uint32
+	for i := addressSize; i > 0; i-- {
+		addr = addr<<8 | uint32(location[i])
+	}
+
+	typ, err := data.Type(typeOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	// Constants are placed in program memory, the ELF symbol tells which space the address is in.
+	space := binary.DataSpace
+	if symbol, ok := firmware.Lookup(name); ok && symbol.Addr == addr {
+		space = symbol.Space
+	}
+	return &Variable{Name: name, Type: typ, Space: space, Addr: addr}, nil
+}
+
+func (evaluator *Evaluator) addType(data *dwarf.Data, entry *dwarf.Entry) {
+	name, _ := entry.Val(dwarf.AttrName).(string)
+	if name == "" {
+		return
+	}
+
+	switch entry.Tag {
+	case dwarf.TagStructType:
+		name = "struct " + name
+	case dwarf.TagUnionType:
+		name = "union " + name
+	case dwarf.TagEnumerationType:
+		name = "enum " + name
+	}
+
+	// Declarations of incomplete types don't replace definitions.
+	if declaration, _ := entry.Val(dwarf.AttrDeclaration).(bool); declaration {
+		if _, ok := evaluator.types[name]; ok {
+			return
+		}
+	}
+
+	if typ, err := data.Type(entry.Offset); err == nil {
+		evaluator.types[name] = typ
+	}
+}
+
+// Globals returns the global variables sorted by name.
+func (evaluator *Evaluator) Globals() []*Variable {
+	return evaluator.globals
+}
+
+// Locals returns the local variables of the function that contains pc.
+func (evaluator *Evaluator) Locals(pc uint32) []*Variable {
+	var locals []*Variable
+	for _, variable := range evaluator.locals {
+		if variable.inScope(pc) {
+			locals = append(locals, variable)
+		}
+	}
+	return locals
+}
+
+// Value returns the value of a variable.
+func (evaluator *Evaluator) Value(variable *Variable) *Value {
+	return &Value{
+		Name:      variable.Name,
+		Type:      variable.Type,
+		Space:     variable.Space,
+		Addr:      variable.Addr,
+		addressed: true,
+		evaluator: evaluator,
+	}
+}
+
+// lookup resolves a name: the locals of the function at pc, the globals, then the SFRs.
+func (evaluator *Evaluator) lookup(name string, pc uint32) (*Value, error) {
+	for _, variable := range evaluator.locals {
+		if variable.Name == name && variable.inScope(pc) {
+			return evaluator.Value(variable), nil
+		}
+	}
+	for _, variable := range evaluator.globals {
+		if variable.Name == name {
+			return evaluator.Value(variable), nil
+		}
+	}
+
+	fsrs := map[string]uint16{"FSR0": pic18.FSR0L, "FSR1": pic18.FSR1L, "FSR2": pic18.FSR2L}
+	if low, ok := fsrs[strings.ToUpper(name)]; ok {
+		fsr := uint64(evaluator.Memory.ReadData(low)) | uint64(evaluator.Memory.ReadData(low+1)&0x0F)<<8
+		return evaluator.literal(name, fsr, uintType("unsigned short", 2)), nil
+	}
+
+	for addr, sfr := range disasm.RegisterNames {
+		if strings.EqualFold(sfr, name) {
+			return &Value{Name: sfr, Type: uintType("unsigned char", 1), Space: binary.DataSpace, Addr: uint32(addr), addressed: true, evaluator: evaluator}, nil
+		}
+	}
+	return nil, fmt.Errorf("no variable %q", name)
+}
+
+// literal creates a value that isn't stored in memory.
+func (evaluator *Evaluator) literal(name string, value uint64, typ dwarf.Type) *Value {
+	return &Value{Name: name, Type: typ, literal: value, evaluator: evaluator}
+}
+
+// uintType creates an unsigned integer type for values that have no type in the debug information.
+func uintType(name string, size int64) dwarf.Type {
+	return &dwarf.UintType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: size, Name: name}}}
+}
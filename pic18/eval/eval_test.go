@@ -0,0 +1,92 @@
+package eval
+
+import (
+	"debug/dwarf"
+	"testing"
+
+	"github.com/natk64/go-pic-emu/binary"
+)
+
+// newTestEvaluator returns an evaluator with a few globals and a local variable that shadows one of them.
+func newTestEvaluator() *Evaluator {
+	uchar := uintType("unsigned char", 1)
+	integer := &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 2, Name: "int"}}}
+	point := &dwarf.StructType{
+		CommonType: dwarf.CommonType{ByteSize: 4},
+		StructName: "point",
+		Kind:       "struct",
+		Field: []*dwarf.StructField{
+			{Name: "x", Type: integer, ByteOffset: 0},
+			{Name: "y", Type: integer, ByteOffset: 2},
+		},
+	}
+
+	memory := make(testMemory, 0x1000)
+	memory[0x10] = 200
+	copy(memory[0x20:], []byte{0x01, 0x00, 0xFE, 0xFF})
+	copy(memory[0x30:], []byte{7, 8, 9})
+	copy(memory[0x40:], []byte{0x20, 0x00})
+	copy(memory[0x50:], []byte{0xD2, 0x04})
+	memory[0xFE8] = 0x42
+
+	return &Evaluator{
+		Memory: memory,
+		globals: []*Variable{
+			{Name: "counter", Type: uchar, Space: binary.DataSpace, Addr: 0x10},
+			{Name: "pos", Type: point, Space: binary.DataSpace, Addr: 0x20},
+			{Name: "table", Type: &dwarf.ArrayType{Type: uchar, Count: 3}, Space: binary.DataSpace, Addr: 0x30},
+			{Name: "ptr", Type: &dwarf.PtrType{CommonType: dwarf.CommonType{ByteSize: 2}, Type: point}, Space: binary.DataSpace, Addr: 0x40},
+		},
+		locals: []*Variable{
+			{Name: "counter", Type: integer, Space: binary.DataSpace, Addr: 0x50, Scope: [][2]uint64{{0x100, 0x200}}},
+		},
+		types: map[string]dwarf.Type{"unsigned char": uchar, "int": integer, "struct point": point},
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		expr     string
+		pc       uint32
+		value    string
+		typeName string
+	}{
+		{"counter", 0, "200", "unsigned char"},
+		{"counter", 0x180, "1234", "int"},
+		{"pos", 0, "{x = 1, y = -2}", "struct point"},
+		{"pos.y", 0, "-2", "int"},
+		{"ptr->x", 0, "1", "int"},
+		{"(*ptr).y", 0, "-2", "int"},
+		{"ptr[0].y", 0, "-2", "int"},
+		{"table", 0, "{7, 8, 9}", "unsigned char[3]"},
+		{"table[2]", 0, "9", "unsigned char"},
+		{"&table", 0, "0x0030", "unsigned char[3] *"},
+		{"(unsigned char)pos.y", 0, "254", "unsigned char"},
+		{"*(struct point *)0x20", 0, "{x = 1, y = -2}", "struct point"},
+		{"wreg", 0, "66", "unsigned char"},
+	}
+
+	evaluator := newTestEvaluator()
+	for _, test := range tests {
+		value, err := evaluator.Evaluate(test.expr, test.pc)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if got := value.String(); got != test.value {
+			t.Errorf("%s = %s, want %s", test.expr, got, test.value)
+		}
+		if got := value.TypeName(); got != test.typeName {
+			t.Errorf("%s has type %s, want %s", test.expr, got, test.typeName)
+		}
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	evaluator := newTestEvaluator()
+	for _, expr := range []string{"", "missing", "counter.x", "pos.z", "pos[1]", "table[", "pos 1", "*counter", "&5", "(struct line)pos"} {
+		if value, err := evaluator.Evaluate(expr, 0); err == nil {
+			t.Errorf("%q evaluated to %s, want an error", expr, value)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package eval
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/natk64/go-pic-emu/binary"
+)
+
+// baseWords are the words of the names of C's base types.
+var baseWords = map[string]bool{
+	"void": true, "char": true, "short": true, "int": true, "long": true,
+	"signed": true, "unsigned": true, "float": true, "double": true,
+}
+
+// parser is a recursive descent parser that evaluates while parsing.
+//
+//	expr    = unary
+//	unary   = '*' unary | '&' unary | '(' type ')' unary | postfix
+//	postfix = primary { '.' ident | '->' ident | '[' expr ']' }
+//	primary = ident | number | '(' expr ')'
+//	type    = ( ('struct' | 'union' | 'enum') ident | ident { ident } ) { '*' }
+type parser struct {
+	evaluator *Evaluator
+	pc        uint32
+	tokens    []string
+	pos       int
+}
+
+// Evaluate evaluates an expression in the scope of the function at pc.
+func (evaluator *Evaluator) Evaluate(expr string, pc uint32) (*Value, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+
+	p := &parser{evaluator: evaluator, pc: pc, tokens: tokens}
+	value, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	value.Name = expr
+	return value, nil
+}
+
+func tokenize(expr string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			start := i
+			for i < len(expr) && (expr[i] == '_' || unicode.IsLetter(rune(expr[i])) || unicode.IsDigit(rune(expr[i]))) {
+				i++
+			}
+			tokens = append(tokens, expr[start:i])
+		case strings.HasPrefix(expr[i:], "->"):
+			tokens = append(tokens, "->")
+			i += 2
+		case strings.ContainsRune(".[]()*&", c):
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+func (p *parser) peek(offset int) string {
+	if p.pos+offset < len(p.tokens) {
+		return p.tokens[p.pos+offset]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	token := p.peek(0)
+	p.pos++
+	return token
+}
+
+func (p *parser) expect(token string) error {
+	if got := p.next(); got != token {
+		if got == "" {
+			return fmt.Errorf("expected %q at the end", token)
+		}
+		return fmt.Errorf("expected %q, got %q", token, got)
+	}
+	return nil
+}
+
+func isIdent(token string) bool {
+	return token != "" && (token[0] == '_' || unicode.IsLetter(rune(token[0])))
+}
+
+func (p *parser) unary() (*Value, error) {
+	switch p.peek(0) {
+	case "*":
+		p.next()
+		value, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return value.deref()
+	case "&":
+		p.next()
+		value, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return value.address()
+	case "(":
+		if p.isType(1) {
+			p.next()
+			typ, err := p.typeName()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			value, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			return value.cast(typ)
+		}
+	}
+	return p.postfix()
+}
+
+func (p *parser) postfix() (*Value, error) {
+	value, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch p.peek(0) {
+		case ".", "->":
+			op := p.next()
+			name := p.next()
+			if !isIdent(name) {
+				return nil, fmt.Errorf("expected a field name after %q", op)
+			}
+			if op == "->" {
+				if value, err = value.deref(); err != nil {
+					return nil, err
+				}
+			}
+			if value, err = value.member(name); err != nil {
+				return nil, err
+			}
+		case "[":
+			p.next()
+			index, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			if value, err = value.element(index); err != nil {
+				return nil, err
+			}
+		default:
+			return value, nil
+		}
+	}
+}
+
+func (p *parser) primary() (*Value, error) {
+	token := p.next()
+	switch {
+	case token == "(":
+		value, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return value, p.expect(")")
+	case isIdent(token):
+		return p.evaluator.lookup(token, p.pc)
+	case token != "" && unicode.IsDigit(rune(token[0])):
+		n, err := strconv.ParseUint(token, 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", token)
+		}
+		return p.evaluator.literal(token, n, uintType("unsigned long", 4)), nil
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q", token)
+}
+
+// isType returns true if the token at offset starts a type name.
+func (p *parser) isType(offset int) bool {
+	token := p.peek(offset)
+	switch token {
+	case "struct", "union", "enum":
+		return true
+	}
+	if baseWords[token] {
+		return true
+	}
+	if _, ok := p.evaluator.types[token]; ok && isIdent(token) {
+		// A variable with the same name as a type wins.
+		_, err := p.evaluator.lookup(token, p.pc)
+		return err != nil
+	}
+	return false
+}
+
+func (p *parser) typeName() (dwarf.Type, error) {
+	var name string
+	switch token := p.next(); {
+	case token == "struct" || token == "union" || token == "enum":
+		tag := p.next()
+		if !isIdent(tag) {
+			return nil, fmt.Errorf("expected a name after %q", token)
+		}
+		name = token + " " + tag
+	case baseWords[token]:
+		words := []string{token}
+		for baseWords[p.peek(0)] {
+			words = append(words, p.next())
+		}
+		name = strings.Join(words, " ")
+	default:
+		name = token
+	}
+
+	typ, err := p.evaluator.typeByName(name)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek(0) == "*" {
+		p.next()
+		typ = &dwarf.PtrType{CommonType: dwarf.CommonType{ByteSize: 2}, Type: typ}
+	}
+	return typ, nil
+}
+
+// typeByName returns a type of the debug information. Base types may be written without "int",
+// e.g. "unsigned" for "unsigned int", void is returned as nil.
+func (evaluator *Evaluator) typeByName(name string) (dwarf.Type, error) {
+	if name == "void" {
+		return nil, nil
+	}
+	if typ, ok := evaluator.types[name]; ok {
+		return typ, nil
+	}
+	if typ, ok := evaluator.types[name+" int"]; ok {
+		return typ, nil
+	}
+	return nil, fmt.Errorf("unknown type %q", name)
+}
+
+// member returns a field of a struct or union.
+func (value *Value) member(name string) (*Value, error) {
+	typ, ok := resolve(value.Type).(*dwarf.StructType)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't a struct or union", value.Name)
+	}
+	for _, field := range typ.Field {
+		if field.Name == name {
+			child := value.field(field)
+			child.Name = value.Name + "." + name
+			return child, nil
+		}
+	}
+	return nil, fmt.Errorf("%s has no field %q", typeName(value.Type), name)
+}
+
+// element returns an element of an array, or the value at an offset from a pointer.
+func (value *Value) element(index *Value) (*Value, error) {
+	if !isInteger(index.Type) {
+		return nil, fmt.Errorf("index %s isn't an integer", index.Name)
+	}
+	i := index.Int()
+
+	switch t := resolve(value.Type).(type) {
+	case *dwarf.ArrayType:
+		if !value.addressed {
+			return nil, fmt.Errorf("%s isn't in memory", value.Name)
+		}
+		element := value.index(t, i)
+		element.Name = fmt.Sprintf("%s[%d]", value.Name, i)
+		return element, nil
+	case *dwarf.PtrType:
+		target, err := value.deref()
+		if err != nil {
+			return nil, err
+		}
+		target.Addr += uint32(i * target.Type.Size())
+		target.Name = fmt.Sprintf("%s[%d]", value.Name, i)
+		return target, nil
+	}
+	return nil, fmt.Errorf("%s isn't an array or pointer", value.Name)
+}
+
+// address returns a pointer to a value in memory.
+func (value *Value) address() (*Value, error) {
+	if !value.addressed || value.bitSize > 0 {
+		return nil, fmt.Errorf("can't take the address of %s", value.Name)
+	}
+
+	size := int64(2)
+	if value.Space == binary.ProgramSpace {
+		size = 3
+	}
+	typ := &dwarf.PtrType{CommonType: dwarf.CommonType{ByteSize: size}, Type: value.Type}
+	return value.evaluator.literal("&"+value.Name, uint64(value.Addr), typ), nil
+}
+
+// cast converts an integer or pointer value to another scalar type.
+func (value *Value) cast(typ dwarf.Type) (*Value, error) {
+	if !isInteger(value.Type) || !isInteger(typ) {
+		return nil, fmt.Errorf("can't convert %s to %s", typeName(value.Type), typeName(typ))
+	}
+	return value.evaluator.literal(value.Name, value.Uint()&(1<<(8*typ.Size())-1), typ), nil
+}
@@ -0,0 +1,409 @@
+package eval
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/natk64/go-pic-emu/binary"
+)
+
+// maxElements is the number of array elements that are shown in a value or returned as children.
+const maxElements = 256
+
+// maxString is the number of characters that are read for the string a char pointer points to.
+const maxString = 64
+
+// Value is the result of an expression.
+type Value struct {
+	// Name is the expression, or the field or index for the children of a value.
+	Name string
+	Type dwarf.Type
+
+	// Space and Addr are the location of values that are stored in memory.
+	Space binary.Space
+	Addr  uint32
+
+	// addressed is true for values stored in memory, the others have their value in literal.
+	addressed bool
+	literal   uint64
+
+	// bitOffset and bitSize describe a bit field, bitOffset counts from the least significant bit at Addr.
+	bitOffset int64
+	bitSize   int64
+
+	evaluator *Evaluator
+}
+
+// Addressed returns true if the value is stored in memory at Addr.
+func (value *Value) Addressed() bool {
+	return value.addressed
+}
+
+// resolve removes typedefs and qualifiers.
+func resolve(typ dwarf.Type) dwarf.Type {
+	for {
+		switch t := typ.(type) {
+		case *dwarf.TypedefType:
+			typ = t.Type
+		case *dwarf.QualType:
+			typ = t.Type
+		default:
+			return typ
+		}
+	}
+}
+
+// TypeName returns the type of the value in C syntax.
+func (value *Value) TypeName() string {
+	return typeName(value.Type)
+}
+
+func typeName(typ dwarf.Type) string {
+	switch t := typ.(type) {
+	case nil:
+		return "void"
+	case *dwarf.PtrType:
+		return typeName(t.Type) + " *"
+	case *dwarf.ArrayType:
+		// Incomplete arrays, like extern declarations without a size, have a count of -1.
+		if t.Count < 0 {
+			return typeName(t.Type) + "[]"
+		}
+		return fmt.Sprintf("%s[%d]", typeName(t.Type), t.Count)
+	case *dwarf.QualType:
+		// The qualifier of an array is already on its elements.
+		if _, ok := t.Type.(*dwarf.ArrayType); ok {
+			return typeName(t.Type)
+		}
+		return t.Qual + " " + typeName(t.Type)
+	case *dwarf.StructType:
+		if t.StructName == "" {
+			return t.Kind + " {...}"
+		}
+		return t.Kind + " " + t.StructName
+	case *dwarf.EnumType:
+		if t.EnumName == "" {
+			return "enum {...}"
+		}
+		return "enum " + t.EnumName
+	default:
+		return t.String()
+	}
+}
+
+// bytes reads the value's storage, n bytes starting at offset.
+func (value *Value) bytes(offset uint32, n int64) []byte {
+	data := make([]byte, max(n, 0))
+	memory := value.evaluator.Memory
+	for i := range data {
+		addr := value.Addr + offset + uint32(i)
+		if value.Space == binary.ProgramSpace {
+			data[i] = memory.ReadProgram(addr)
+		} else {
+			data[i] = memory.ReadData(uint16(addr))
+		}
+	}
+	return data
+}
+
+// Uint returns the raw value of a scalar, read little endian. Bit fields are extracted and shifted down.
+func (value *Value) Uint() uint64 {
+	if !value.addressed {
+		return value.literal
+	}
+
+	if value.bitSize > 0 {
+		first := value.bitOffset / 8
+		last := (value.bitOffset + value.bitSize - 1) / 8
+		raw := value.bytes(uint32(first), last-first+1)
+		var bits uint64
+		for i := len(raw) - 1; i >= 0; i-- {
+			bits = bits<<8 | uint64(raw[i])
+		}
+		return bits >> (value.bitOffset % 8) & (1<<value.bitSize - 1)
+	}
+
+	size := min(value.Type.Size(), 8)
+	if size <= 0 {
+		return 0
+	}
+	raw := value.bytes(0, size)
+	var v uint64
+	for i := len(raw) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(raw[i])
+	}
+	return v
+}
+
+// Int returns the value of a scalar, sign extended if its type is signed.
+func (value *Value) Int() int64 {
+	v := value.Uint()
+	bits := value.bitSize
+	if bits == 0 {
+		bits = min(value.Type.Size(), 8) * 8
+	}
+	if !isSigned(value.Type) || bits >= 64 {
+		return int64(v)
+	}
+	return int64(v<<(64-bits)) >> (64 - bits)
+}
+
+func isSigned(typ dwarf.Type) bool {
+	switch resolve(typ).(type) {
+	case *dwarf.IntType, *dwarf.CharType:
+		return true
+	}
+	return false
+}
+
+// isInteger returns true for the types that can be used as array indexes and addresses.
+func isInteger(typ dwarf.Type) bool {
+	switch resolve(typ).(type) {
+	case *dwarf.IntType, *dwarf.UintType, *dwarf.CharType, *dwarf.UcharType, *dwarf.BoolType, *dwarf.EnumType, *dwarf.PtrType:
+		return true
+	}
+	return false
+}
+
+// String formats the value like a debugger: numbers in decimal, characters with their glyph, pointers in hex,
+// arrays and structs in braces and char arrays as strings.
+func (value *Value) String() string {
+	var b strings.Builder
+	value.format(&b)
+	return b.String()
+}
+
+func (value *Value) format(b *strings.Builder) {
+	switch t := resolve(value.Type).(type) {
+	case *dwarf.CharType, *dwarf.UcharType:
+		c := value.Int()
+		if _, unsigned := t.(*dwarf.UcharType); unsigned {
+			c = int64(value.Uint())
+		}
+		// Bit fields are small numbers even if their type is a char.
+		if value.bitSize > 0 {
+			fmt.Fprint(b, c)
+			return
+		}
+		fmt.Fprintf(b, "%d %s", c, quoteChar(uint8(c)))
+	case *dwarf.IntType:
+		fmt.Fprint(b, value.Int())
+	case *dwarf.UintType:
+		fmt.Fprint(b, value.Uint())
+	case *dwarf.BoolType:
+		fmt.Fprint(b, value.Uint() != 0)
+	case *dwarf.FloatType:
+		b.WriteString(formatFloat(value.Uint(), t.ByteSize))
+	case *dwarf.EnumType:
+		v := value.Int()
+		for _, enum := range t.Val {
+			if enum.Val == v {
+				b.WriteString(enum.Name)
+				return
+			}
+		}
+		fmt.Fprint(b, v)
+	case *dwarf.PtrType:
+		fmt.Fprintf(b, "0x%0*X", t.ByteSize*2, value.Uint())
+		if isChar(t.Type) && value.Uint() != 0 {
+			if target, err := value.deref(); err == nil {
+				b.WriteString(" " + strconv.Quote(target.cString(maxString)))
+			}
+		}
+	case *dwarf.ArrayType:
+		if t.Count < 0 {
+			// The elements are still available by indexing.
+			b.WriteString("{...}")
+			return
+		}
+		if isChar(t.Type) && value.addressed {
+			b.WriteString(strconv.Quote(value.cString(min(t.Count, maxString))))
+			return
+		}
+		value.formatChildren(b, false)
+	case *dwarf.StructType:
+		value.formatChildren(b, true)
+	case *dwarf.FuncType:
+		fmt.Fprintf(b, "<function at 0x%06X>", value.Addr)
+	default:
+		fmt.Fprintf(b, "<%s>", typeName(value.Type))
+	}
+}
+
+func (value *Value) formatChildren(b *strings.Builder, named bool) {
+	b.WriteString("{")
+	children := value.Children()
+	for i, child := range children {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if named {
+			b.WriteString(child.Name + " = ")
+		}
+		child.format(b)
+	}
+	if array, ok := resolve(value.Type).(*dwarf.ArrayType); ok && array.Count > int64(len(children)) {
+		b.WriteString(", ...")
+	}
+	b.WriteString("}")
+}
+
+func formatFloat(bits uint64, size int64) string {
+	switch size {
+	case 3:
+		// XC8's 24 bit float is a 32 bit float without the lowest byte of the mantissa.
+		return strconv.FormatFloat(float64(math.Float32frombits(uint32(bits)<<8)), 'g', -1, 32)
+	case 4:
+		return strconv.FormatFloat(float64(math.Float32frombits(uint32(bits))), 'g', -1, 32)
+	case 8:
+		return strconv.FormatFloat(math.Float64frombits(bits), 'g', -1, 64)
+	}
+	return fmt.Sprintf("<%d byte float>", size)
+}
+
+// quoteChar quotes a character like C, characters outside of printable ASCII are written in hex.
+func quoteChar(c uint8) string {
+	if c < 0x20 || c > 0x7E {
+		return fmt.Sprintf("'\\x%02x'", c)
+	}
+	return strconv.QuoteRune(rune(c))
+}
+
+func isChar(typ dwarf.Type) bool {
+	switch resolve(typ).(type) {
+	case *dwarf.CharType, *dwarf.UcharType:
+		return true
+	}
+	return false
+}
+
+// cString reads characters from the value's address up to a NUL or n characters.
+func (value *Value) cString(n int64) string {
+	var s []byte
+	for _, c := range value.bytes(0, n) {
+		if c == 0 {
+			break
+		}
+		s = append(s, c)
+	}
+	return string(s)
+}
+
+// HasChildren returns true for structs, unions, arrays and pointers that can be dereferenced.
+func (value *Value) HasChildren() bool {
+	switch t := resolve(value.Type).(type) {
+	case *dwarf.StructType:
+		return len(t.Field) > 0
+	case *dwarf.ArrayType:
+		return t.Count > 0 && value.addressed
+	case *dwarf.PtrType:
+		return canDeref(t) && value.Uint() != 0
+	}
+	return false
+}
+
+func canDeref(ptr *dwarf.PtrType) bool {
+	switch resolve(ptr.Type).(type) {
+	case nil, *dwarf.VoidType, *dwarf.FuncType:
+		return false
+	}
+	return true
+}
+
+// Children returns the fields of a struct or union, the elements of an array (at most 256)
+// or the value a pointer points to.
+func (value *Value) Children() []*Value {
+	switch t := resolve(value.Type).(type) {
+	case *dwarf.StructType:
+		children := make([]*Value, 0, len(t.Field))
+		for _, field := range t.Field {
+			children = append(children, value.field(field))
+		}
+		return children
+	case *dwarf.ArrayType:
+		if !value.addressed {
+			return nil
+		}
+		count := min(max(t.Count, 0), maxElements)
+		children := make([]*Value, 0, count)
+		for i := range count {
+			children = append(children, value.index(t, i))
+		}
+		return children
+	case *dwarf.PtrType:
+		if target, err := value.deref(); err == nil && value.HasChildren() {
+			return []*Value{target}
+		}
+	}
+	return nil
+}
+
+// field returns a field of a struct or union value.
+func (value *Value) field(field *dwarf.StructField) *Value {
+	child := &Value{
+		Name:      field.Name,
+		Type:      field.Type,
+		Space:     value.Space,
+		Addr:      value.Addr + uint32(field.ByteOffset),
+		addressed: value.addressed,
+		evaluator: value.evaluator,
+	}
+	if field.BitSize == 0 {
+		return child
+	}
+
+	// DWARF 4 and later give the offset of the bit field from the start of the struct. DWARF 2 gives the offset
+	// of the most significant bit in a storage unit of ByteSize bytes, counted from its most significant bit.
+	child.bitSize = field.BitSize
+	if field.ByteSize == 0 {
+		child.Addr = value.Addr + uint32(field.DataBitOffset/8)
+		child.bitOffset = field.DataBitOffset % 8
+	} else {
+		child.bitOffset = field.ByteSize*8 - field.BitOffset - field.BitSize
+	}
+	return child
+}
+
+// index returns an element of an array value.
+func (value *Value) index(array *dwarf.ArrayType, i int64) *Value {
+	size := array.Type.Size()
+	if array.StrideBitSize > 0 {
+		size = array.StrideBitSize / 8
+	}
+	return &Value{
+		Name:      fmt.Sprintf("[%d]", i),
+		Type:      array.Type,
+		Space:     value.Space,
+		Addr:      value.Addr + uint32(i*size),
+		addressed: true,
+		evaluator: value.evaluator,
+	}
+}
+
+// deref returns the value a pointer points to. Pointers of 1 and 2 bytes address data memory,
+// 3 byte (far) pointers address program memory.
+func (value *Value) deref() (*Value, error) {
+	ptr, ok := resolve(value.Type).(*dwarf.PtrType)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't a pointer", value.Name)
+	}
+	if !canDeref(ptr) {
+		return nil, fmt.Errorf("%s can't be dereferenced", typeName(value.Type))
+	}
+
+	space := binary.DataSpace
+	if ptr.ByteSize >= 3 {
+		space = binary.ProgramSpace
+	}
+	return &Value{
+		Name:      "*" + value.Name,
+		Type:      ptr.Type,
+		Space:     space,
+		Addr:      uint32(value.Uint()),
+		addressed: true,
+		evaluator: value.evaluator,
+	}, nil
+}
@@ -0,0 +1,62 @@
+package eval
+
+import (
+	"debug/dwarf"
+	"testing"
+
+	"github.com/natk64/go-pic-emu/binary"
+)
+
+// testMemory is data memory without program memory.
+type testMemory []byte
+
+func (memory testMemory) ReadData(addr uint16) uint8    { return memory[addr] }
+func (memory testMemory) ReadProgram(addr uint32) uint8 { return 0xFF }
+
+func TestArrayValues(t *testing.T) {
+	char := &dwarf.CharType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 1, Name: "char"}}}
+	uchar := &dwarf.UintType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 1, Name: "unsigned char"}}}
+
+	tests := []struct {
+		name     string
+		typ      *dwarf.ArrayType
+		typeName string
+		value    string
+		children int
+	}{
+		{"text", &dwarf.ArrayType{Type: char, Count: 4}, "char[4]", `"hi"`, 4},
+		{"bytes", &dwarf.ArrayType{Type: uchar, Count: 3}, "unsigned char[3]", "{104, 105, 0}", 3},
+		{"extern_text", &dwarf.ArrayType{Type: char, Count: -1}, "char[]", "{...}", 0},
+		{"extern_bytes", &dwarf.ArrayType{Type: uchar, Count: -1}, "unsigned char[]", "{...}", 0},
+	}
+
+	evaluator := &Evaluator{Memory: testMemory{'h', 'i', 0, 0}}
+	for _, test := range tests {
+		value := &Value{Name: test.name, Type: test.typ, Space: binary.DataSpace, addressed: true, evaluator: evaluator}
+		if got := value.TypeName(); got != test.typeName {
+			t.Errorf("%s has type %s, want %s", test.name, got, test.typeName)
+		}
+		if got := value.String(); got != test.value {
+			t.Errorf("%s is %s, want %s", test.name, got, test.value)
+		}
+		if got := len(value.Children()); got != test.children {
+			t.Errorf("%s has %d children, want %d", test.name, got, test.children)
+		}
+	}
+}
+
+// The elements of an incomplete array can still be indexed.
+func TestIncompleteArrayElement(t *testing.T) {
+	uchar := &dwarf.UintType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 1, Name: "unsigned char"}}}
+	evaluator := &Evaluator{Memory: testMemory{1, 2, 3}}
+	array := &Value{Name: "buf", Type: &dwarf.ArrayType{Type: uchar, Count: -1}, Space: binary.DataSpace, addressed: true, evaluator: evaluator}
+	index := &Value{Type: uchar, literal: 2}
+
+	element, err := array.element(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if element.Name != "buf[2]" || element.String() != "3" {
+		t.Errorf("%s is %s, want buf[2] = 3", element.Name, element)
+	}
+}
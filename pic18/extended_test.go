@@ -0,0 +1,75 @@
+package pic18_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/natk64/go-pic-emu/pic18"
+)
+
+// illegalCounter counts the illegal instructions the CPU reports.
+type illegalCounter int
+
+func (counter *illegalCounter) IllegalInstruction() {
+	*counter++
+}
+
+// Without XINST, the extended instructions are illegal and execute as a NOP.
+func TestExtendedInstructionsIllegal(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []uint16
+	}{
+		{"ADDFSR", []uint16{0xE845}},        // addfsr 1, 5
+		{"SUBFSR", []uint16{0xE903}},        // subfsr 0, 3
+		{"ADDULNK", []uint16{0xE8C4}},       // addulnk 4
+		{"SUBULNK", []uint16{0xE9C4}},       // subulnk 4
+		{"CALLW", []uint16{0x0014}},         // callw
+		{"MOVSF", []uint16{0xEB02, 0xF010}}, // movsf [2], R
+		{"MOVSS", []uint16{0xEB81, 0xF003}}, // movss [1], [3]
+		{"PUSHL", []uint16{0xEA5A}},         // pushl 0x5A
+	}
+
+	for _, test := range tests {
+		m := newTestMachine(t, slices.Concat(test.program, []uint16{0x0003})...)
+		var illegal illegalCounter
+		m.cpu.EventHandler = &illegal
+		m.write(pic18.FSR2H, 1, 0x01)
+		m.write(0x102, 1, 0x77)
+
+		m.run(t, 10)
+
+		if illegal != 1 {
+			t.Errorf("%s: %d illegal instructions, want 1", test.name, illegal)
+		}
+		if fsr0, fsr1, fsr2 := m.read(pic18.FSR0L, 2), m.read(pic18.FSR1L, 2), m.read(pic18.FSR2L, 2); fsr0 != 0 || fsr1 != 0 || fsr2 != 0x100 {
+			t.Errorf("%s: FSR0 0x%03X, FSR1 0x%03X, FSR2 0x%03X, want them unchanged", test.name, fsr0, fsr1, fsr2)
+		}
+		if stkptr, r := m.read(pic18.Registers.STKPTR, 1), m.read(R, 1); stkptr != 0 || r != 0 {
+			t.Errorf("%s: STKPTR %d, R 0x%02X, want them unchanged", test.name, stkptr, r)
+		}
+	}
+}
+
+// With XINST, access bank addresses below 0x60 are offsets from FSR2. The other addresses aren't affected.
+func TestExecIndexedLiteralOffset(t *testing.T) {
+	fsr2 := map[uint16]uint8{pic18.FSR2H: 0x02, pic18.FSR2L: 0x00, 0x205: 0x77}
+
+	runExecTests(t, []execTest{
+		{name: "write [k]", program: []uint16{0x6E10}, setup: extended, w: 0x42, data: fsr2, // movwf [0x10]
+			wantW: 0x42, want: map[uint16]uint8{0x210: 0x42, 0x010: 0x00}, cycles: 1},
+		{name: "read [k]", program: []uint16{0x5005}, setup: extended, data: fsr2, // movf [0x05], w
+			wantW: 0x77, cycles: 1},
+		{name: "access bank 0x60", program: []uint16{0x6E60}, setup: extended, w: 0x42, data: fsr2, // movwf 0x60
+			wantW: 0x42, want: map[uint16]uint8{0x060: 0x42, 0x260: 0x00}, cycles: 1},
+		{name: "banked", program: []uint16{
+			0x0102, // movlb 2
+			0x6F10, // movwf 0x10, b
+		}, setup: extended, w: 0x42, data: fsr2,
+			wantW: 0x42, want: map[uint16]uint8{0x210: 0x42, 0x010: 0x00}, cycles: 2},
+		{name: "access bank without XINST", program: []uint16{0x6E10}, w: 0x42, data: fsr2, // movwf R
+			wantW: 0x42, want: map[uint16]uint8{0x010: 0x42, 0x210: 0x00}, cycles: 1},
+		{name: "read without XINST", program: []uint16{0x5005}, data: map[uint16]uint8{pic18.FSR2H: 0x02, 0x005: 0x11, 0x205: 0x77}, // movf 0x05, w
+			wantW: 0x11, cycles: 1},
+	})
+}
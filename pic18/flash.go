@@ -5,6 +5,7 @@ type TableRWController struct {
 	tableLatch   uint8
 
 	ProgramBus BusReadWriter[uint32]
+	Config     *ConfigTable
 }
 
 type TableAction int
@@ -23,6 +24,15 @@ const (
 	TABLAT  = 0xFF5
 )
 
+func (controller *TableRWController) reset() {
+	if controller == nil {
+		return
+	}
+
+	controller.tablePointer = 0
+	controller.tableLatch = 0
+}
+
 func (controller *TableRWController) BusRead(addr uint16) (uint8, AddrMask) {
 	switch addr {
 	case TABLAT:
@@ -57,30 +67,38 @@ func (controller *TableRWController) BusWrite(addr uint16, data uint8) AddrMask
 	}
 }
 
-func (controller *TableRWController) TableRead(action TableAction) {
+// TableRead reads program memory into TABLAT.
+// pc is the address of the instruction performing the read, which is needed to apply the table read protection.
+func (controller *TableRWController) TableRead(action TableAction, pc uint32) {
 	if action == TablePreInc {
-		controller.tablePointer = (controller.tablePointer + 1) & 0x3FFF00
+		controller.tablePointer = (controller.tablePointer + 1) & 0x3FFFFF
 	}
 
-	controller.tableLatch, _ = controller.ProgramBus.BusRead(controller.tablePointer)
+	if controller.Config == nil || controller.Config.TableReadAllowed(pc, controller.tablePointer) {
+		controller.tableLatch, _ = controller.ProgramBus.BusRead(controller.tablePointer)
+	} else {
+		controller.tableLatch = 0
+	}
 
 	if action == TablePostInc {
-		controller.tablePointer = (controller.tablePointer + 1) & 0x3FFF00
+		controller.tablePointer = (controller.tablePointer + 1) & 0x3FFFFF
 	} else if action == TablePostDec {
-		controller.tablePointer = (controller.tablePointer - 1) & 0x3FFF00
+		controller.tablePointer = (controller.tablePointer - 1) & 0x3FFFFF
 	}
 }
 
 func (controller *TableRWController) TableWrite(action TableAction) {
 	if action == TablePreInc {
-		controller.tablePointer = (controller.tablePointer + 1) & 0x3FFF00
+		controller.tablePointer = (controller.tablePointer + 1) & 0x3FFFFF
 	}
 
-	controller.ProgramBus.BusWrite(controller.tablePointer, controller.tableLatch)
+	if controller.Config == nil || controller.Config.TableWriteAllowed(controller.tablePointer) {
+		controller.ProgramBus.BusWrite(controller.tablePointer, controller.tableLatch)
+	}
 
 	if action == TablePostInc {
-		controller.tablePointer = (controller.tablePointer + 1) & 0x3FFF00
+		controller.tablePointer = (controller.tablePointer + 1) & 0x3FFFFF
 	} else if action == TablePostDec {
-		controller.tablePointer = (controller.tablePointer - 1) & 0x3FFF00
+		controller.tablePointer = (controller.tablePointer - 1) & 0x3FFFFF
 	}
 }
@@ -0,0 +1,164 @@
+package gdbserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// conn handles the packet framing of the remote serial protocol.
+//
+// A goroutine reads packets from the client and acknowledges them,
+// so an interrupt (Ctrl-C, sent as a single 0x03 byte) is noticed while the target is running.
+// The goroutine exits when the session ends and the next packet arrives, or when reading fails
+// because the connection was closed.
+type conn struct {
+	w       io.Writer
+	writeMu sync.Mutex
+	noAck   bool
+
+	packets   chan string
+	interrupt chan struct{}
+	done      chan struct{}
+	err       error
+}
+
+func newConn(rw io.ReadWriter) *conn {
+	c := &conn{
+		w:         rw,
+		packets:   make(chan string),
+		interrupt: make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	go c.readLoop(bufio.NewReader(rw))
+	return c
+}
+
+func (c *conn) readLoop(r *bufio.Reader) {
+	defer close(c.packets)
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			c.err = err
+			return
+		}
+
+		switch b {
+		case 0x03:
+			select {
+			case c.interrupt <- struct{}{}:
+			default:
+			}
+		case '$':
+			packet, ok, err := readPacket(r)
+			if err != nil {
+				c.err = err
+				return
+			}
+			if !ok {
+				c.writeRaw("-")
+				continue
+			}
+			c.writeMu.Lock()
+			noAck := c.noAck
+			c.writeMu.Unlock()
+			if !noAck {
+				c.writeRaw("+")
+			}
+			select {
+			case c.packets <- packet:
+			case <-c.done:
+				return
+			}
+		}
+		// Acknowledgements from the client are ignored, packets are never retransmitted.
+	}
+}
+
+// readPacket reads the rest of a packet after the '$'.
+// ok is false if the checksum didn't match.
+func readPacket(r *bufio.Reader) (packet string, ok bool, err error) {
+	var data []byte
+	var sum uint8
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", false, err
+		}
+		if b == '#' {
+			break
+		}
+		sum += b
+
+		if b == '}' {
+			escaped, err := r.ReadByte()
+			if err != nil {
+				return "", false, err
+			}
+			sum += escaped
+			b = escaped ^ 0x20
+		}
+		data = append(data, b)
+	}
+
+	var checksum [2]byte
+	if _, err := io.ReadFull(r, checksum[:]); err != nil {
+		return "", false, err
+	}
+
+	expected, err := strconv.ParseUint(string(checksum[:]), 16, 8)
+	if err != nil || uint8(expected) != sum {
+		return "", false, nil
+	}
+	return string(data), true, nil
+}
+
+func (c *conn) writeRaw(s string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	io.WriteString(c.w, s)
+}
+
+// send writes a packet, escaping the characters that have a special meaning.
+func (c *conn) send(packet string) error {
+	var data []byte
+	var sum uint8
+	for i := 0; i < len(packet); i++ {
+		b := packet[i]
+		if b == '$' || b == '#' || b == '}' || b == '*' {
+			data = append(data, '}')
+			sum += '}'
+			b ^= 0x20
+		}
+		data = append(data, b)
+		sum += b
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := fmt.Fprintf(c.w, "$%s#%02x", data, sum)
+	return err
+}
+
+// close ends the session, packets are no longer delivered.
+func (c *conn) close() {
+	close(c.done)
+}
+
+// setNoAck stops acknowledging packets, after QStartNoAckMode.
+func (c *conn) setNoAck() {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.noAck = true
+}
+
+// clearInterrupt discards an interrupt that arrived while the target was stopped.
+func (c *conn) clearInterrupt() {
+	select {
+	case <-c.interrupt:
+	default:
+	}
+}
@@ -0,0 +1,456 @@
+// Package gdbserver implements the GDB remote serial protocol (RSP) for a [debug.Target].
+//
+// There is no PIC18 architecture in GDB, so the server describes its registers with a target description
+// (qXfer:features:read). Registers are numbered like in [debug.Target.Registers] and sent in little endian.
+// Program memory is mapped at address 0 and data memory at [DataOffset], like GDB does for AVR.
+package gdbserver
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/natk64/go-pic-emu/pic18"
+	"github.com/natk64/go-pic-emu/pic18/debug"
+)
+
+// DataOffset is the address where data memory is mapped in the GDB address space.
+const DataOffset = 0x800000
+
+// ErrKilled is returned by [Server.Serve] when the client sent a kill request.
+var ErrKilled = errors.New("gdbserver: killed by client")
+
+// Server serves GDB sessions for a target.
+type Server struct {
+	Target *debug.Target
+
+	conn     *conn
+	lastStop string
+}
+
+// ListenAndServe listens on a TCP address and serves one client after another,
+// until a client kills the target or an error occurs.
+func (server *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		err = server.Serve(client)
+		client.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Serve handles a single session until the client detaches or disconnects.
+// The caller should close rw afterwards, to stop the goroutine that reads from it.
+func (server *Server) Serve(rw io.ReadWriter) error {
+	server.conn = newConn(rw)
+	defer server.conn.close()
+	server.lastStop = "S05"
+
+	for packet := range server.conn.packets {
+		reply, err := server.handle(packet)
+		if err != nil {
+			return err
+		}
+		if err := server.conn.send(reply); err != nil {
+			return err
+		}
+		if packet == "D" {
+			return nil
+		}
+	}
+
+	if errors.Is(server.conn.err, io.EOF) || errors.Is(server.conn.err, net.ErrClosed) {
+		return nil
+	}
+	return server.conn.err
+}
+
+// handle processes a packet and returns the reply.
+func (server *Server) handle(packet string) (string, error) {
+	if packet == "" {
+		return "", nil
+	}
+
+	target := server.Target
+	args := packet[1:]
+	switch packet[0] {
+	case '?':
+		return server.lastStop, nil
+	case 'g':
+		return server.readRegisters(), nil
+	case 'G':
+		return server.writeRegisters(args), nil
+	case 'p':
+		return server.readRegister(args), nil
+	case 'P':
+		return server.writeRegister(args), nil
+	case 'm':
+		return server.readMemory(args), nil
+	case 'M':
+		return server.writeMemory(args), nil
+	case 'c', 's':
+		if args != "" {
+			addr, err := strconv.ParseUint(args, 16, 32)
+			if err != nil {
+				return "E01", nil
+			}
+			target.CPU.SetPC(uint32(addr))
+		}
+		return server.resume(packet[0]), nil
+	case 'b':
+		if !target.CanReverse() || (args != "s" && args != "c") {
+			return "", nil
+		}
+		return server.resume(packet[0], args[0]), nil
+	case 'v':
+		return server.handleV(args), nil
+	case 'Z', 'z':
+		return server.breakpoint(packet[0] == 'Z', args), nil
+	case 'H', 'T', 'D':
+		return "OK", nil
+	case 'k':
+		return "", ErrKilled
+	case 'q', 'Q':
+		return server.query(packet), nil
+	}
+
+	// Empty reply means "unsupported".
+	return "", nil
+}
+
+func (server *Server) query(packet string) string {
+	name, args, _ := strings.Cut(packet, ":")
+	switch name {
+	case "qSupported":
+		features := "PacketSize=4000;qXfer:features:read+;QStartNoAckMode+"
+		if server.Target.CanReverse() {
+			features += ";ReverseStep+;ReverseContinue+"
+		}
+		return features
+	case "QStartNoAckMode":
+		server.conn.setNoAck()
+		return "OK"
+	case "qAttached":
+		return "1"
+	case "qC":
+		return "QC1"
+	case "qfThreadInfo":
+		return "m1"
+	case "qsThreadInfo":
+		return "l"
+	case "qSymbol":
+		return "OK"
+	case "qXfer":
+		return server.readFeatures(args)
+	}
+	return ""
+}
+
+func (server *Server) handleV(args string) string {
+	switch {
+	case args == "Cont?":
+		return "vCont;c;C;s;S"
+	case strings.HasPrefix(args, "Cont;"):
+		// Only one thread, so only the first action matters.
+		action := strings.TrimPrefix(args, "Cont;") + " "
+		switch action[0] {
+		case 'c', 'C':
+			return server.resume('c')
+		case 's', 'S':
+			return server.resume('s')
+		}
+		return "E01"
+	case strings.HasPrefix(args, "Kill"):
+		return "OK"
+	}
+	return ""
+}
+
+// resume runs the target and returns the stop reply.
+func (server *Server) resume(command ...byte) string {
+	target := server.Target
+	server.conn.clearInterrupt()
+
+	var reason debug.StopReason
+	switch string(command) {
+	case "c":
+		reason = target.Continue(server.conn.interrupt)
+	case "s":
+		reason = target.Step()
+	case "bc":
+		reason = target.ContinueBack(server.conn.interrupt)
+	case "bs":
+		reason = target.StepBack()
+	}
+
+	switch reason {
+	case debug.StopInterrupted:
+		server.lastStop = "S02"
+	case debug.StopIllegalInstruction:
+		server.lastStop = "S04"
+	case debug.StopHistoryEnd:
+		server.lastStop = "T05replaylog:begin;"
+	case debug.StopWatchpoint:
+		hit := target.Watchpoints.Hits[0]
+		server.lastStop = watchStop(hit, target.Watchpoints.Kind(hit.Addr))
+	default:
+		server.lastStop = "S05"
+	}
+	return server.lastStop
+}
+
+func (server *Server) breakpoint(insert bool, args string) string {
+	fields := strings.Split(args, ",")
+	if len(fields) < 2 {
+		return "E01"
+	}
+
+	addr, err := strconv.ParseUint(fields[1], 16, 32)
+	if err != nil {
+		return "E01"
+	}
+
+	switch fields[0] {
+	case "0", "1":
+		// Software and hardware breakpoints are the same thing in an emulator.
+	case "2":
+		return server.watchpoint(insert, pic18.WatchWrite, addr, fields)
+	case "3":
+		return server.watchpoint(insert, pic18.WatchRead, addr, fields)
+	case "4":
+		return server.watchpoint(insert, pic18.WatchRead|pic18.WatchWrite, addr, fields)
+	default:
+		return ""
+	}
+
+	if insert {
+		server.Target.SetBreakpoint(uint32(addr))
+	} else {
+		server.Target.ClearBreakpoint(uint32(addr))
+	}
+	return "OK"
+}
+
+// watchpoint handles Z2/z2 (write), Z3/z3 (read) and Z4/z4 (access) packets, they watch every byte of a range.
+func (server *Server) watchpoint(insert bool, kind pic18.WatchKind, addr uint64, fields []string) string {
+	length := uint64(1)
+	if len(fields) > 2 {
+		var err error
+		if length, err = strconv.ParseUint(fields[2], 16, 16); err != nil {
+			return "E01"
+		}
+	}
+
+	if addr < DataOffset || addr+length > DataOffset+0x1000 {
+		// Only data memory can be watched.
+		return "E01"
+	}
+
+	watchpoints := server.Target.Watchpoints
+	for i := range uint16(length) {
+		dataAddr := uint16(addr-DataOffset) + i
+		if insert {
+			watchpoints.Watch(dataAddr, watchpoints.Kind(dataAddr)|kind)
+		} else {
+			watchpoints.Watch(dataAddr, watchpoints.Kind(dataAddr)&^kind)
+		}
+	}
+	return "OK"
+}
+
+// watchStop formats the stop reply for a watchpoint hit, kind is the watchpoint at the address.
+func watchStop(hit pic18.WatchHit, kind pic18.WatchKind) string {
+	name := "rwatch"
+	if kind&pic18.WatchRead != 0 && kind&pic18.WatchWrite != 0 {
+		name = "awatch"
+	} else if hit.Write {
+		name = "watch"
+	}
+	return fmt.Sprintf("T05%s:%x;", name, DataOffset+uint32(hit.Addr))
+}
+
+func appendRegister(buf []byte, value uint32, size int) []byte {
+	for i := range size {
+		buf = fmt.Appendf(buf, "%02x", uint8(value>>(8*i)))
+	}
+	return buf
+}
+
+func parseRegister(data []byte) uint32 {
+	var value uint32
+	for i, b := range data {
+		value |= uint32(b) << (8 * i)
+	}
+	return value
+}
+
+func (server *Server) readRegisters() string {
+	var buf []byte
+	for i, reg := range server.Target.Registers() {
+		buf = appendRegister(buf, server.Target.ReadRegister(i), reg.Size)
+	}
+	return string(buf)
+}
+
+func (server *Server) writeRegisters(args string) string {
+	data, err := hex.DecodeString(args)
+	if err != nil {
+		return "E01"
+	}
+
+	for i, reg := range server.Target.Registers() {
+		if len(data) < reg.Size {
+			break
+		}
+		server.Target.WriteRegister(i, parseRegister(data[:reg.Size]))
+		data = data[reg.Size:]
+	}
+	return "OK"
+}
+
+func (server *Server) readRegister(args string) string {
+	num, err := strconv.ParseUint(args, 16, 16)
+	registers := server.Target.Registers()
+	if err != nil || int(num) >= len(registers) {
+		return "E01"
+	}
+	return string(appendRegister(nil, server.Target.ReadRegister(int(num)), registers[num].Size))
+}
+
+func (server *Server) writeRegister(args string) string {
+	numStr, valueStr, _ := strings.Cut(args, "=")
+	num, err := strconv.ParseUint(numStr, 16, 16)
+	registers := server.Target.Registers()
+	if err != nil || int(num) >= len(registers) {
+		return "E01"
+	}
+
+	data, err := hex.DecodeString(valueStr)
+	if err != nil || len(data) != registers[num].Size {
+		return "E01"
+	}
+	server.Target.WriteRegister(int(num), parseRegister(data))
+	return "OK"
+}
+
+// parseRange parses the "addr,length" argument of memory packets.
+func parseRange(args string) (addr uint32, length int, err error) {
+	addrStr, lengthStr, _ := strings.Cut(args, ",")
+	addr64, err := strconv.ParseUint(addrStr, 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	length64, err := strconv.ParseUint(lengthStr, 16, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint32(addr64), int(length64), nil
+}
+
+func (server *Server) readMemory(args string) string {
+	addr, length, err := parseRange(args)
+	if err != nil {
+		return "E01"
+	}
+
+	data := make([]byte, length)
+	for i := range data {
+		data[i] = server.readByte(addr + uint32(i))
+	}
+	return hex.EncodeToString(data)
+}
+
+func (server *Server) writeMemory(args string) string {
+	rangeStr, dataStr, _ := strings.Cut(args, ":")
+	addr, length, err := parseRange(rangeStr)
+	if err != nil {
+		return "E01"
+	}
+
+	data, err := hex.DecodeString(dataStr)
+	if err != nil || len(data) != length {
+		return "E01"
+	}
+
+	for i, b := range data {
+		server.writeByte(addr+uint32(i), b)
+	}
+	return "OK"
+}
+
+func (server *Server) readByte(addr uint32) uint8 {
+	if addr >= DataOffset {
+		return server.Target.ReadData(uint16(addr - DataOffset))
+	}
+	return server.Target.ReadProgram(addr)
+}
+
+func (server *Server) writeByte(addr uint32, data uint8) {
+	if addr >= DataOffset {
+		server.Target.WriteData(uint16(addr-DataOffset), data)
+	} else {
+		server.Target.WriteProgram(addr, data)
+	}
+}
+
+// readFeatures handles qXfer:features:read:target.xml:offset,length.
+func (server *Server) readFeatures(args string) string {
+	annex, ok := strings.CutPrefix(args, "features:read:")
+	if !ok {
+		return ""
+	}
+
+	annex, rangeStr, _ := strings.Cut(annex, ":")
+	if annex != "target.xml" {
+		return "E00"
+	}
+
+	offset, length, err := parseRange(rangeStr)
+	if err != nil {
+		return "E01"
+	}
+
+	xml := server.targetDescription()
+	if int(offset) >= len(xml) {
+		return "l"
+	}
+
+	chunk := xml[offset:]
+	if len(chunk) > length {
+		return "m" + chunk[:length]
+	}
+	return "l" + chunk
+}
+
+func (server *Server) targetDescription() string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0"?>` + "\n")
+	sb.WriteString(`<!DOCTYPE target SYSTEM "gdb-target.dtd">` + "\n")
+	sb.WriteString(`<target version="1.0">` + "\n")
+	sb.WriteString(`<feature name="org.gnu.gdb.pic18.core">` + "\n")
+	for i, reg := range server.Target.Registers() {
+		regType := fmt.Sprintf("uint%d", reg.Size*8)
+		if i == debug.RegPC {
+			regType = "code_ptr"
+		}
+		fmt.Fprintf(&sb, `<reg name="%s" bitsize="%d" type="%s" regnum="%d"/>`+"\n", reg.Name, reg.Size*8, regType, i)
+	}
+	sb.WriteString("</feature>\n</target>\n")
+	return sb.String()
+}
@@ -0,0 +1,125 @@
+package gdbserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/natk64/go-pic-emu/pic18/debug"
+	"github.com/natk64/go-pic-emu/pic18/internal/testcpu"
+)
+
+// client is the GDB side of a session.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// exchange sends a packet and returns the reply.
+func (c *client) exchange(packet string) string {
+	c.t.Helper()
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var sum uint8
+	for i := range len(packet) {
+		sum += packet[i]
+	}
+	if _, err := fmt.Fprintf(c.conn, "$%s#%02x", packet, sum); err != nil {
+		c.t.Fatalf("sending %q: %v", packet, err)
+	}
+
+	if ack, err := c.r.ReadByte(); err != nil || ack != '+' {
+		c.t.Fatalf("%q wasn't acknowledged: %q, %v", packet, ack, err)
+	}
+	if _, err := c.r.ReadString('$'); err != nil {
+		c.t.Fatalf("reading the reply to %q: %v", packet, err)
+	}
+	reply, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatalf("reading the reply to %q: %v", packet, err)
+	}
+	if _, err := io.ReadFull(c.r, make([]byte, 2)); err != nil {
+		c.t.Fatalf("reading the checksum of the reply to %q: %v", packet, err)
+	}
+	return reply[:len(reply)-1]
+}
+
+func TestServeSession(t *testing.T) {
+	cpu, _ := testcpu.New(t, `
+	org 0
+	movlw 0x42
+	movwf 0x10
+	nop
+loop
+	bra loop
+`)
+	server := &Server{Target: debug.New(cpu, nil)}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(serverConn) }()
+	c := &client{t: t, conn: clientConn, r: bufio.NewReader(clientConn)}
+
+	if reply := c.exchange("?"); reply != "S05" {
+		t.Errorf("? replied %q, want S05", reply)
+	}
+
+	// wreg, status, bsr, fsr0-2, stkptr, pc and 31 stack levels.
+	registers := c.exchange("g")
+	if want := 2 * (1 + 1 + 1 + 3*2 + 1 + 4 + 31*4); len(registers) != want {
+		t.Fatalf("g replied %d hex digits, want %d", len(registers), want)
+	}
+	if pc := registers[20:28]; pc != "00000000" {
+		t.Errorf("PC is %s after the reset, want 00000000", pc)
+	}
+
+	if reply := c.exchange("m0,4"); reply != "420e106e" {
+		t.Errorf("m0,4 replied %q, want 420e106e", reply)
+	}
+	if reply := c.exchange("Z0,4,2"); reply != "OK" {
+		t.Errorf("Z0 replied %q, want OK", reply)
+	}
+	if reply := c.exchange("c"); reply != "S05" {
+		t.Errorf("c replied %q, want S05", reply)
+	}
+
+	registers = c.exchange("g")
+	if wreg, pc := registers[0:2], registers[20:28]; wreg != "42" || pc != "04000000" {
+		t.Errorf("W %s, PC %s at the breakpoint, want 42 and 04000000", wreg, pc)
+	}
+	if reply := c.exchange("m800010,1"); reply != "42" {
+		t.Errorf("m800010,1 replied %q, want 42", reply)
+	}
+
+	if reply := c.exchange("D"); reply != "OK" {
+		t.Errorf("D replied %q, want OK", reply)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve returned %v after detaching", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return after detaching")
+	}
+
+	// A packet after the session ended must not block the reader.
+	clientConn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(clientConn, "$?#3f")
+	if ack, err := c.r.ReadByte(); err != nil || ack != '+' {
+		t.Fatalf("late packet wasn't acknowledged: %q, %v", ack, err)
+	}
+	select {
+	case _, ok := <-server.conn.packets:
+		if ok {
+			t.Error("a packet was delivered after the session ended")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the packet reader didn't exit after the session ended")
+	}
+}
@@ -0,0 +1,265 @@
+package pic18
+
+import "slices"
+
+// DefaultHistoryLimit is the number of instructions a [History] keeps if no limit is set.
+const DefaultHistoryLimit = 100000
+
+// HistoryWrite is a single data bus write recorded by a [History].
+type HistoryWrite struct {
+	Addr uint16
+	Old  uint8
+	New  uint8
+
+	// Undoable is true if the old value could be read back from the history's memory.
+	// Other writes (SFRs, peripherals) are recorded, but not reverted.
+	Undoable bool
+}
+
+type historyEntry struct {
+	core   cpuState
+	writes []HistoryWrite
+
+	// programWrites are the table writes to program memory.
+	programWrites []programWrite
+}
+
+// programWrite is a table write to program memory, only the old value is needed to revert it.
+type programWrite struct {
+	addr uint32
+	old  uint8
+}
+
+// History records the execution of a CPU so it can be stepped backwards.
+//
+// For every instruction the state of the core is saved, together with all data bus writes made by the instruction.
+// Stepping back restores the core state and writes the old values back to memory.
+// Only writes to the history's Memory can be reverted, the state of other peripherals (e.g. the EUSART)
+// stays as it is. The core SFRs (WREG, STATUS, FSRs, stack, ...) are part of the core state and always restored.
+// Table writes to program memory are recorded as well and reverted like data writes.
+//
+// Any state change that bypasses the CPU (e.g. a debugger writing memory) isn't recorded, so [History.Clear]
+// should be called after modifying the machine.
+type History struct {
+	// Limit is the maximum number of instructions to keep, the oldest ones are discarded first.
+	// If it is zero, DefaultHistoryLimit is used.
+	Limit int
+
+	// Memory is used to read the old value before a write.
+	// Reads must not have side effects, which rules out most peripherals.
+	Memory BusReader[uint16]
+
+	cpu   *CPU
+	inner BusReadWriter[uint16]
+
+	// program is the program bus of the table controller, which the history replaced.
+	program BusReadWriter[uint32]
+
+	// entries is a ring buffer, the oldest entry is at index start.
+	entries []historyEntry
+	start   int
+	count   int
+
+	// recording is true while the CPU is executing the newest entry.
+	recording bool
+}
+
+// NewHistory attaches a new history to the CPU.
+// The CPU's data bus is replaced with the history, which forwards everything to the previous bus.
+// The program bus of the table controller is replaced the same way to record table writes.
+func NewHistory(cpu *CPU, memory BusReader[uint16]) *History {
+	history := &History{
+		Memory: memory,
+		cpu:    cpu,
+		inner:  cpu.DataBus,
+	}
+
+	cpu.DataBus = history
+	cpu.BankController.Bus = history
+	if cpu.Table != nil {
+		history.program = cpu.Table.ProgramBus
+		cpu.Table.ProgramBus = historyProgramBus{history}
+	}
+	return history
+}
+
+// Bus returns the bus that the history forwards to, writes made through it aren't recorded.
+func (history *History) Bus() BusReadWriter[uint16] {
+	return history.inner
+}
+
+func (history *History) BusRead(addr uint16) (uint8, AddrMask) {
+	return history.inner.BusRead(addr)
+}
+
+func (history *History) BusWrite(addr uint16, data uint8) AddrMask {
+	if history.recording {
+		write := HistoryWrite{Addr: addr, New: data}
+		if history.Memory != nil {
+			old, mask := history.Memory.BusRead(addr)
+			write.Old = old
+			write.Undoable = mask == 0xFF
+		}
+
+		entry := &history.entries[history.index(history.count-1)]
+		entry.writes = append(entry.writes, write)
+	}
+
+	return history.inner.BusWrite(addr, data)
+}
+
+// historyProgramBus records the table writes to program memory.
+type historyProgramBus struct {
+	history *History
+}
+
+func (bus historyProgramBus) BusRead(addr uint32) (uint8, AddrMask) {
+	return bus.history.program.BusRead(addr)
+}
+
+func (bus historyProgramBus) BusWrite(addr uint32, data uint8) AddrMask {
+	history := bus.history
+	if history.recording {
+		// Program memory reads don't have side effects.
+		old, _ := history.program.BusRead(addr)
+		entry := &history.entries[history.index(history.count-1)]
+		entry.programWrites = append(entry.programWrites, programWrite{addr: addr, old: old})
+	}
+	return history.program.BusWrite(addr, data)
+}
+
+// Tick advances the CPU by one instruction cycle, just like [CPU.Tick], and records it.
+func (history *History) Tick() {
+	cpu := history.cpu
+	if cpu.Sleep.Asleep() {
+		// Sleeping doesn't write to the bus, undoing the SLEEP instruction restores the watchdog as well.
+		history.recording = false
+		cpu.Tick()
+		return
+	}
+
+	if cpu.AtInstructionBoundary() || !history.recording {
+		history.push()
+	}
+
+	history.recording = true
+	cpu.Tick()
+}
+
+func (history *History) push() {
+	limit := history.Limit
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+
+	if len(history.entries) != limit {
+		history.resize(limit)
+	}
+
+	var previous *historyEntry
+	if history.count > 0 {
+		previous = &history.entries[history.index(history.count-1)]
+	}
+
+	if history.count == limit {
+		history.start = (history.start + 1) % limit
+		history.count--
+	}
+
+	entry := &history.entries[history.index(history.count)]
+	history.count++
+	if previous == entry {
+		// With a limit of 1 the new entry replaces the previous one, which has nothing to share.
+		previous = nil
+	}
+
+	entry.core = history.cpu.coreState()
+	entry.writes = entry.writes[:0]
+	entry.programWrites = entry.programWrites[:0]
+
+	// Most instructions don't touch the stack or the interrupt sources, share them with the previous entry.
+	if previous != nil && slices.Equal(previous.core.StackData, entry.core.StackData) {
+		entry.core.StackData = previous.core.StackData
+	} else {
+		entry.core.StackData = slices.Clone(entry.core.StackData)
+	}
+	if previous != nil && slices.Equal(previous.core.InterruptSources, entry.core.InterruptSources) {
+		entry.core.InterruptSources = previous.core.InterruptSources
+	}
+}
+
+// resize changes the capacity of the ring buffer, keeping the newest entries.
+func (history *History) resize(limit int) {
+	keep := min(history.count, limit)
+	entries := make([]historyEntry, limit)
+	for i := range keep {
+		entries[i] = history.entries[history.index(history.count-keep+i)]
+	}
+
+	history.entries = entries
+	history.start = 0
+	history.count = keep
+}
+
+func (history *History) index(i int) int {
+	return (history.start + i) % len(history.entries)
+}
+
+// Len returns the number of instructions that can be stepped back.
+func (history *History) Len() int {
+	return history.count
+}
+
+// Clear discards the recorded history.
+func (history *History) Clear() {
+	history.start = 0
+	history.count = 0
+	history.recording = false
+}
+
+// undo reverts the newest instruction and returns its writes, newest first.
+func (history *History) undo() []HistoryWrite {
+	entry := &history.entries[history.index(history.count-1)]
+	history.count--
+	history.recording = false
+
+	slices.Reverse(entry.writes)
+	for _, write := range entry.writes {
+		if write.Undoable {
+			history.inner.BusWrite(write.Addr, write.Old)
+		}
+	}
+	for i := len(entry.programWrites) - 1; i >= 0; i-- {
+		write := entry.programWrites[i]
+		history.program.BusWrite(write.addr, write.old)
+	}
+
+	// The state was captured from this CPU, so it always matches.
+	_ = history.cpu.setCoreState(&entry.core)
+	return entry.writes
+}
+
+// StepBack reverts up to n instructions and returns the number of instructions that were actually reverted.
+// Interrupt entries count as instructions.
+func (history *History) StepBack(n int) int {
+	undone := 0
+	for undone < n && history.count > 0 {
+		history.undo()
+		undone++
+	}
+	return undone
+}
+
+// RunBackToWrite reverts instructions until one that wrote addr has been reverted,
+// leaving the CPU just before the write. It returns false if no write was found,
+// in that case the CPU is at the oldest recorded state.
+func (history *History) RunBackToWrite(addr uint16) bool {
+	for history.count > 0 {
+		for _, write := range history.undo() {
+			if write.Addr == addr {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package pic18_test
+
+import (
+	"testing"
+
+	"github.com/natk64/go-pic-emu/pic18"
+)
+
+// step executes n instructions with the history recording them.
+func step(history *pic18.History, cpu *pic18.CPU, n int) {
+	for range n {
+		history.Tick()
+		for !cpu.AtInstructionBoundary() {
+			history.Tick()
+		}
+	}
+}
+
+func TestHistoryStepBack(t *testing.T) {
+	m := newTestMachine(t, assemble(t, `
+	org 0
+	movlw 0x42
+	movwf 0x10
+	movwf TABLAT
+	movlw 0x08
+	movwf TBLPTRH
+	tblwt*
+	sleep
+`)...)
+	history := pic18.NewHistory(m.cpu, m.ram)
+	m.cpu.Tick() // the fetch after the reset
+	step(history, m.cpu, 6)
+
+	if m.ram.Data[0x10] != 0x42 || m.program.Data[0x800] != 0x42 {
+		t.Fatalf("program didn't write: RAM 0x%02X, flash 0x%02X", m.ram.Data[0x10], m.program.Data[0x800])
+	}
+
+	if n := history.StepBack(6); n != 6 {
+		t.Fatalf("stepped back %d instructions, want 6", n)
+	}
+	if m.ram.Data[0x10] != 0 {
+		t.Errorf("RAM is 0x%02X after stepping back, want 0", m.ram.Data[0x10])
+	}
+	if m.program.Data[0x800] != 0xFF {
+		t.Errorf("flash is 0x%02X after stepping back, want 0xFF", m.program.Data[0x800])
+	}
+	if m.cpu.WReg != 0 || m.cpu.PC() != 0 {
+		t.Errorf("W 0x%02X, PC 0x%06X after stepping back, want 0", m.cpu.WReg, m.cpu.PC())
+	}
+}
+
+// With a limit of 1, the only entry must not share the stack with the running CPU.
+func TestHistoryLimitOne(t *testing.T) {
+	m := newTestMachine(t, assemble(t, `
+	org 0
+	nop
+	call sub
+	sleep
+sub
+	nop
+`)...)
+	history := pic18.NewHistory(m.cpu, m.ram)
+	history.Limit = 1
+	m.cpu.Tick()
+	step(history, m.cpu, 2)
+
+	if m.cpu.Stack.Data[0] != 0x06 {
+		t.Fatalf("return address is 0x%06X, want 0x000006", m.cpu.Stack.Data[0])
+	}
+	if n := history.StepBack(1); n != 1 {
+		t.Fatalf("stepped back %d instructions, want 1", n)
+	}
+	if m.cpu.Stack.Data[0] != 0 || m.cpu.PC() != 0x02 {
+		t.Errorf("stack 0x%06X, PC 0x%06X after stepping back over the CALL, want 0 and 0x000002", m.cpu.Stack.Data[0], m.cpu.PC())
+	}
+}
@@ -87,7 +87,132 @@ const (
 	SUBULNK
 )
 
+var opcodeNames = [...]string{
+	ILLEGAL: "ILLEGAL",
+	ADDWF:   "ADDWF",
+	ADDWFC:  "ADDWFC",
+	ANDWF:   "ANDWF",
+	CLRF:    "CLRF",
+	COMF:    "COMF",
+	CPFSEQ:  "CPFSEQ",
+	CPFSGT:  "CPFSGT",
+	CPFSLT:  "CPFSLT",
+	DECF:    "DECF",
+	DECFSZ:  "DECFSZ",
+	DCFSNZ:  "DCFSNZ",
+	INCF:    "INCF",
+	INCFSZ:  "INCFSZ",
+	INFSNZ:  "INFSNZ",
+	IORWF:   "IORWF",
+	MOVF:    "MOVF",
+	MOVFF:   "MOVFF",
+	MOVWF:   "MOVWF",
+	MULWF:   "MULWF",
+	NEGF:    "NEGF",
+	RLCF:    "RLCF",
+	RLNCF:   "RLNCF",
+	RRCF:    "RRCF",
+	RRNCF:   "RRNCF",
+	SETF:    "SETF",
+	SUBFWB:  "SUBFWB",
+	SUBWF:   "SUBWF",
+	SUBWFB:  "SUBWFB",
+	SWAPF:   "SWAPF",
+	TSTFSZ:  "TSTFSZ",
+	XORWF:   "XORWF",
+	BCF:     "BCF",
+	BSF:     "BSF",
+	BTFSC:   "BTFSC",
+	BTFSS:   "BTFSS",
+	BTG:     "BTG",
+	BC:      "BC",
+	BN:      "BN",
+	BNC:     "BNC",
+	BNN:     "BNN",
+	BNOV:    "BNOV",
+	BNZ:     "BNZ",
+	BOV:     "BOV",
+	BRA:     "BRA",
+	BZ:      "BZ",
+	CALL:    "CALL",
+	CLRWDT:  "CLRWDT",
+	DAW:     "DAW",
+	GOTO:    "GOTO",
+	NOP:     "NOP",
+	NOP1:    "NOP1",
+	POP:     "POP",
+	PUSH:    "PUSH",
+	RCALL:   "RCALL",
+	RESET:   "RESET",
+	RETFIE:  "RETFIE",
+	RETLW:   "RETLW",
+	RETURN:  "RETURN",
+	SLEEP:   "SLEEP",
+	ADDLW:   "ADDLW",
+	ANDLW:   "ANDLW",
+	IORLW:   "IORLW",
+	LFSR:    "LFSR",
+	MOVLB:   "MOVLB",
+	MOVLW:   "MOVLW",
+	MULLW:   "MULLW",
+	SUBLW:   "SUBLW",
+	XORLW:   "XORLW",
+	TBLRD:   "TBLRD",
+	TBLWT:   "TBLWT",
+	ADDFSR:  "ADDFSR",
+	ADDULNK: "ADDULNK",
+	CALLW:   "CALLW",
+	MOVSF:   "MOVSF",
+	MOVSS:   "MOVSS",
+	PUSHL:   "PUSHL",
+	SUBFSR:  "SUBFSR",
+	SUBULNK: "SUBULNK",
+}
+
+// String returns the mnemonic of the opcode.
+func (op Opcode) String() string {
+	if int(op) < len(opcodeNames) {
+		return opcodeNames[op]
+	}
+	return "ILLEGAL"
+}
+
+// Extended reports whether the opcode is part of the extended instruction set,
+// which is only available if the XINST configuration bit is set.
+func (op Opcode) Extended() bool {
+	return op >= ADDFSR && op <= SUBULNK
+}
+
+// Conditional reports whether the opcode is a conditional branch or skip.
+func (op Opcode) Conditional() bool {
+	switch op {
+	case BC, BN, BNC, BNN, BNOV, BNZ, BOV, BZ,
+		CPFSEQ, CPFSGT, CPFSLT, DECFSZ, DCFSNZ, INCFSZ, INFSNZ, TSTFSZ, BTFSC, BTFSS:
+		return true
+	default:
+		return false
+	}
+}
+
+// opcodeTable maps every possible instruction word to its opcode.
+var opcodeTable = buildOpcodeTable()
+
+func buildOpcodeTable() *[1 << 16]Opcode {
+	table := new([1 << 16]Opcode)
+	for raw := range table {
+		table[raw] = Instruction(raw).decode()
+	}
+	return table
+}
+
+// Opcode decodes the instruction using a precomputed lookup table.
 func (raw Instruction) Opcode() Opcode {
+	return opcodeTable[raw]
+}
+
+// decode is the reference decoder which compares the instruction against the encoding masks from the datasheet.
+// It is only used to build the lookup table.
+func (raw Instruction) decode() Opcode {
 	if (raw & 0b1111111111111111) == 0b0000000000000100 {
 		return CLRWDT
 	} else if (raw & 0b1111111111111111) == 0b0000000000000111 {
@@ -0,0 +1,140 @@
+package instruction
+
+import "testing"
+
+// TestOpcodeTable checks the lookup table against the reference decoder for every instruction word.
+func TestOpcodeTable(t *testing.T) {
+	seen := make(map[Opcode]bool)
+	for raw := range 1 << 16 {
+		inst := Instruction(raw)
+		want := inst.decode()
+		if got := inst.Opcode(); got != want {
+			t.Errorf("0x%04X decodes to %v, the reference decoder gives %v", raw, got, want)
+		}
+		seen[want] = true
+	}
+
+	// SUBULNK is the last opcode.
+	for op := range SUBULNK + 1 {
+		if !seen[op] {
+			t.Errorf("no instruction word decodes to %v", op)
+		}
+	}
+}
+
+// TestDecode checks encodings from the instruction set summary of the datasheet.
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		raw  Instruction
+		want Opcode
+	}{
+		{0x0000, NOP},
+		{0x0001, ILLEGAL},
+		{0x0003, SLEEP},
+		{0x0004, CLRWDT},
+		{0x0005, PUSH},
+		{0x0006, POP},
+		{0x0007, DAW},
+		{0x0008, TBLRD},
+		{0x000B, TBLRD},
+		{0x000C, TBLWT},
+		{0x000F, TBLWT},
+		{0x0010, RETFIE},
+		{0x0011, RETFIE},
+		{0x0012, RETURN},
+		{0x0013, RETURN},
+		{0x0014, CALLW},
+		{0x00FF, RESET},
+		{0x010F, MOVLB},
+		{0x0234, MULWF},
+		{0x0534, DECF},
+		{0x0812, SUBLW},
+		{0x0912, IORLW},
+		{0x0A12, XORLW},
+		{0x0B12, ANDLW},
+		{0x0C12, RETLW},
+		{0x0D12, MULLW},
+		{0x0E12, MOVLW},
+		{0x0F12, ADDLW},
+		{0x1034, IORWF},
+		{0x1434, ANDWF},
+		{0x1834, XORWF},
+		{0x1C34, COMF},
+		{0x2034, ADDWFC},
+		{0x2434, ADDWF},
+		{0x2834, INCF},
+		{0x2C34, DECFSZ},
+		{0x3034, RRCF},
+		{0x3434, RLCF},
+		{0x3834, SWAPF},
+		{0x3C34, INCFSZ},
+		{0x4034, RRNCF},
+		{0x4434, RLNCF},
+		{0x4834, INFSNZ},
+		{0x4C34, DCFSNZ},
+		{0x5034, MOVF},
+		{0x5434, SUBFWB},
+		{0x5834, SUBWFB},
+		{0x5C34, SUBWF},
+		{0x6034, CPFSLT},
+		{0x6234, CPFSEQ},
+		{0x6434, CPFSGT},
+		{0x6634, TSTFSZ},
+		{0x6834, SETF},
+		{0x6A34, CLRF},
+		{0x6C34, NEGF},
+		{0x6E34, MOVWF},
+		{0x7E34, BTG},
+		{0x8E34, BSF},
+		{0x9E34, BCF},
+		{0xAE34, BTFSS},
+		{0xBE34, BTFSC},
+		{0xC123, MOVFF},
+		{0xD012, BRA},
+		{0xD712, BRA},
+		{0xD812, RCALL},
+		{0xE012, BZ},
+		{0xE112, BNZ},
+		{0xE212, BC},
+		{0xE312, BNC},
+		{0xE412, BOV},
+		{0xE512, BNOV},
+		{0xE612, BN},
+		{0xE712, BNN},
+		{0xE812, ADDFSR},
+		{0xE8C1, ADDULNK},
+		{0xE912, SUBFSR},
+		{0xE9C1, SUBULNK},
+		{0xEA12, PUSHL},
+		{0xEB12, MOVSF},
+		{0xEB92, MOVSS},
+		{0xEC12, CALL},
+		{0xED12, CALL},
+		{0xEE12, LFSR},
+		{0xEF12, GOTO},
+		{0xF123, NOP1},
+	}
+
+	for _, test := range tests {
+		if got := test.raw.Opcode(); got != test.want {
+			t.Errorf("0x%04X decodes to %v, want %v", test.raw, got, test.want)
+		}
+	}
+}
+
+// sink keeps the benchmarks from being optimized away.
+var sink Opcode
+
+// BenchmarkDecode compares the lookup table with the reference decoder on all instruction words.
+func BenchmarkDecode(b *testing.B) {
+	b.Run("table", func(b *testing.B) {
+		for i := range b.N {
+			sink = Instruction(i).Opcode()
+		}
+	})
+	b.Run("reference", func(b *testing.B) {
+		for i := range b.N {
+			sink = Instruction(i).decode()
+		}
+	})
+}
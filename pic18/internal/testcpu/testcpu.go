@@ -0,0 +1,58 @@
+// Package testcpu builds CPUs for the tests of the packages that drive a [pic18.CPU].
+package testcpu
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/natk64/go-pic-emu/pic18"
+	"github.com/natk64/go-pic-emu/pic18/asm"
+)
+
+// New assembles source and returns a CPU in the Power-on Reset state that runs it, along with the assembled program.
+// The CPU has 2 KiB of RAM, 64 KiB of flash and no peripherals, the configuration bits are taken from the source.
+func New(t testing.TB, source string) (*pic18.CPU, *asm.Program) {
+	t.Helper()
+	assembled, err := asm.Assemble(source, nil)
+	if err != nil {
+		t.Fatalf("assembling: %v", err)
+	}
+
+	image := bytes.Repeat([]byte{0xFF}, 0x10000)
+	copy(image, assembled.Image)
+	config := pic18.DecodeConfig(assembled.Config)
+	ram := pic18.Memory[uint16]{Data: make([]byte, 2048)}
+	program := pic18.Memory[uint32]{Data: image}
+
+	sleep := &pic18.SleepController{}
+	cpu := &pic18.CPU{
+		Config:     &config,
+		Stack:      pic18.Stack{Data: make([]uint32, 31)},
+		Sleep:      sleep,
+		Watchdog:   &pic18.WatchdogTimer{Mode: config.Watchdog, Postscaler: config.WatchdogPostscaler},
+		Interrupts: pic18.InterruptController{Sleep: sleep},
+		Table:      &pic18.TableRWController{Config: &config},
+	}
+
+	dataBus := pic18.MultiBusReadWriter[uint16]{
+		ram,
+		cpu,
+		cpu.Table,
+		&cpu.Alu,
+		&cpu.Stack,
+		&cpu.BankController,
+		&cpu.Interrupts,
+		&cpu.Resets,
+		cpu.Watchdog,
+	}
+	programBus := pic18.MultiBusReadWriter[uint32]{program}
+
+	cpu.DataBus = dataBus
+	cpu.ProgramBus = programBus
+	cpu.BankController.Bus = dataBus
+	cpu.BankController.WReg = &cpu.WReg
+	cpu.Table.ProgramBus = programBus
+
+	cpu.PowerOnReset()
+	return cpu, assembled
+}
@@ -49,20 +49,27 @@ func (src interruptSource) BusRead(addr uint16) (data uint8, mask AddrMask) {
 
 func (src *interruptSource) BusWrite(addr uint16, data uint8) (mask AddrMask) {
 	if addr == src.config.Request.Register {
-		src.Flag = addr&uint16(1<<src.config.Request.Bit) != 0
+		src.Flag = data&(1<<src.config.Request.Bit) != 0
 		mask |= 1 << src.config.Request.Bit
 	}
 	if addr == src.config.Enable.Register {
-		src.Flag = addr&uint16(1<<src.config.Enable.Bit) != 0
+		src.Enable = data&(1<<src.config.Enable.Bit) != 0
 		mask |= 1 << src.config.Enable.Bit
 	}
 	if addr == src.config.Priority.Register {
-		src.Flag = addr&uint16(1<<src.config.Priority.Bit) != 0
+		src.HighPriority = data&(1<<src.config.Priority.Bit) != 0
 		mask |= 1 << src.config.Priority.Bit
 	}
 	return
 }
 
+// reset restores the reset values of the PIR (0), PIE (0) and IPR (1) bits.
+func (src *interruptSource) reset() {
+	src.Flag = false
+	src.Enable = false
+	src.HighPriority = true
+}
+
 func (src *interruptSource) Raise() {
 	if src.controller == nil || src.index >= len(src.controller.sources) {
 		panic("illegal interrupt")
@@ -136,6 +143,18 @@ func (controller *InterruptController) raiseInterruptCompatibilityMode(src *inte
 	controller.Sleep.WakeUp()
 }
 
+func (controller *InterruptController) reset() {
+	controller.InterruptPriorityEnable = false
+	controller.HighPriorityEnable = false
+	controller.LowPriorityEnable = false
+	controller.DoGotoHighPriority = false
+	controller.DoGotoLowPriority = false
+
+	for _, src := range controller.sources {
+		src.reset()
+	}
+}
+
 func (controller *InterruptController) CheckHighPriority() bool {
 	tmp := controller.DoGotoHighPriority
 	controller.DoGotoHighPriority = false
@@ -234,6 +253,26 @@ func (controller *InterruptController) CreateInterrupt(config InterruptConfig) I
 	return src
 }
 
+// InterruptLabels returns the debug labels of all interrupt sources, in the order they were created.
+func (controller *InterruptController) InterruptLabels() []string {
+	labels := make([]string, len(controller.sources))
+	for i, src := range controller.sources {
+		labels[i] = src.config.DebugLabel
+	}
+	return labels
+}
+
+// RequestFlag returns the interrupt request flag of the source with the debug label.
+// ok is false if there is no such source.
+func (controller *InterruptController) RequestFlag(label string) (flag bool, ok bool) {
+	for _, src := range controller.sources {
+		if src.config.DebugLabel == label {
+			return src.Flag, true
+		}
+	}
+	return false, false
+}
+
 type interruptSourceReg struct {
 	enable   uint16
 	request  uint16
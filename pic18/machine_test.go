@@ -0,0 +1,123 @@
+package pic18_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/natk64/go-pic-emu/pic18"
+	"github.com/natk64/go-pic-emu/pic18/asm"
+)
+
+// testMachine is a CPU with 2 KiB of RAM and 64 KiB of program memory, without peripherals.
+type testMachine struct {
+	cpu     *pic18.CPU
+	config  pic18.ConfigTable
+	ram     pic18.Memory[uint16]
+	program pic18.Memory[uint32]
+}
+
+// newTestMachine returns a machine in the Power-on Reset state that runs the instruction words of program,
+// starting at address 0. The rest of the flash is erased and the configuration words have their default values.
+func newTestMachine(t testing.TB, program ...uint16) *testMachine {
+	t.Helper()
+	image := bytes.Repeat([]byte{0xFF}, 0x10000)
+	for i, word := range program {
+		image[2*i] = uint8(word)
+		image[2*i+1] = uint8(word >> 8)
+	}
+
+	m := &testMachine{
+		config:  pic18.DecodeConfig(pic18.DefaultConfigWords[:]),
+		ram:     pic18.Memory[uint16]{Data: make([]byte, 2048)},
+		program: pic18.Memory[uint32]{Data: image},
+	}
+
+	sleep := &pic18.SleepController{}
+	cpu := &pic18.CPU{
+		Config:     &m.config,
+		Stack:      pic18.Stack{Data: make([]uint32, 31)},
+		Sleep:      sleep,
+		Watchdog:   &pic18.WatchdogTimer{Mode: m.config.Watchdog, Postscaler: m.config.WatchdogPostscaler},
+		Interrupts: pic18.InterruptController{Sleep: sleep},
+		Table:      &pic18.TableRWController{Config: &m.config},
+	}
+	m.cpu = cpu
+
+	dataBus := pic18.MultiBusReadWriter[uint16]{
+		m.ram,
+		cpu,
+		cpu.Table,
+		&cpu.Alu,
+		&cpu.Stack,
+		&cpu.BankController,
+		&cpu.Interrupts,
+		&cpu.Resets,
+		cpu.Watchdog,
+	}
+	programBus := pic18.MultiBusReadWriter[uint32]{m.program}
+
+	cpu.DataBus = dataBus
+	cpu.ProgramBus = programBus
+	cpu.BankController.Bus = dataBus
+	cpu.BankController.WReg = &cpu.WReg
+	cpu.Table.ProgramBus = programBus
+
+	cpu.PowerOnReset()
+	return m
+}
+
+// assemble returns the instruction words of source, for programs that are too long to list as words.
+func assemble(t testing.TB, source string) []uint16 {
+	t.Helper()
+	program, err := asm.Assemble(source, nil)
+	if err != nil {
+		t.Fatalf("assembling: %v", err)
+	}
+
+	words := make([]uint16, (len(program.Image)+1)/2)
+	for i, b := range program.Image {
+		words[i/2] |= uint16(b) << (8 * (i % 2))
+	}
+	return words
+}
+
+// run executes the program until it executes SLEEP. The test fails if that takes more than maxCycles.
+func (m *testMachine) run(t testing.TB, maxCycles int) {
+	t.Helper()
+	for range maxCycles {
+		if m.cpu.Sleep.Asleep() {
+			return
+		}
+		m.cpu.Tick()
+	}
+	t.Fatalf("program didn't sleep within %d cycles", maxCycles)
+}
+
+// tickUntil ticks the machine until done returns true. The test fails if that takes more than maxCycles.
+func (m *testMachine) tickUntil(t testing.TB, what string, maxCycles int, done func() bool) {
+	t.Helper()
+	for range maxCycles {
+		m.cpu.Tick()
+		if done() {
+			return
+		}
+	}
+	t.Fatalf("%s didn't happen within %d cycles, PC 0x%06X", what, maxCycles, m.cpu.PC())
+}
+
+// read returns the little endian value of n bytes at addr in the data space.
+func (m *testMachine) read(addr uint16, n int) uint32 {
+	var value uint32
+	for i := n - 1; i >= 0; i-- {
+		b, _ := m.cpu.DataBus.BusRead(addr + uint16(i))
+		value = value<<8 | uint32(b)
+	}
+	return value
+}
+
+// write stores the little endian value in n bytes at addr in the data space.
+func (m *testMachine) write(addr uint16, n int, value uint32) {
+	for i := range n {
+		m.cpu.DataBus.BusWrite(addr+uint16(i), uint8(value>>(8*i)))
+	}
+}
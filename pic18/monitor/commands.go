@@ -0,0 +1,427 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/natk64/go-pic-emu/pic18"
+	"github.com/natk64/go-pic-emu/pic18/debug"
+	"github.com/natk64/go-pic-emu/pic18/disasm"
+)
+
+func (m *Monitor) disasmOptions() *disasm.Options {
+	return &disasm.Options{
+		ExtendedSet: m.Target.CPU.BankController.ExtendedSet,
+		Symbols:     m.Labels,
+	}
+}
+
+// clearInterrupt discards an interrupt that was requested while the target wasn't running.
+func (m *Monitor) clearInterrupt() {
+	select {
+	case <-m.Interrupt:
+	default:
+	}
+}
+
+// stopped prints why execution stopped and the next instruction.
+func (m *Monitor) stopped(reason debug.StopReason) {
+	if reason != debug.StopStep {
+		m.printf("stopped: %v\n", reason)
+	}
+	if reason == debug.StopWatchpoint {
+		for _, hit := range m.Target.Watchpoints.Hits {
+			m.printHit(hit)
+		}
+	}
+
+	cpu := m.Target.CPU
+	line := disasm.Instruction(m.Target.ProgramBus, cpu.PC(), m.disasmOptions())
+	if cpu.Sleep.Asleep() {
+		m.printf("%s  (asleep)\n", m.symbolize(cpu.PC()))
+		return
+	}
+	m.printf("%s  %s\n", m.symbolize(cpu.PC()), line.Text)
+	if location := m.sourceLocation(cpu.PC()); location != "" {
+		m.printf("  at %s\n", location)
+	}
+}
+
+// sourceLocation returns the file and line of an address, or an empty string if it is unknown.
+func (m *Monitor) sourceLocation(addr uint32) string {
+	if m.Target.Lines == nil {
+		return ""
+	}
+	entry, ok := m.Target.Lines.Lookup(addr)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", filepath.Base(entry.File), entry.Line)
+}
+
+// repeat runs a step command n times (default 1), it stops early if a step doesn't complete.
+func (m *Monitor) repeat(args []string, step func() debug.StopReason) error {
+	n, err := count(args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	m.clearInterrupt()
+	reason := debug.StopStep
+	for range n {
+		if reason = step(); reason != debug.StopStep {
+			break
+		}
+	}
+	m.stopped(reason)
+	return nil
+}
+
+func (m *Monitor) step(args []string) error {
+	return m.repeat(args, func() debug.StopReason { return m.Target.StepLine(m.Interrupt) })
+}
+
+func (m *Monitor) next(args []string) error {
+	return m.repeat(args, func() debug.StopReason { return m.Target.NextLine(m.Interrupt) })
+}
+
+func (m *Monitor) stepInstruction(args []string) error {
+	return m.repeat(args, m.Target.Step)
+}
+
+func (m *Monitor) nextInstruction(args []string) error {
+	return m.repeat(args, func() debug.StopReason { return m.Target.StepOver(m.Interrupt) })
+}
+
+func (m *Monitor) finish(args []string) error {
+	m.clearInterrupt()
+	m.stopped(m.Target.StepOut(m.Interrupt))
+	return nil
+}
+
+func (m *Monitor) cont(args []string) error {
+	m.clearInterrupt()
+	m.stopped(m.Target.Continue(m.Interrupt))
+	return nil
+}
+
+func (m *Monitor) back(args []string) error {
+	if !m.Target.CanReverse() {
+		return errors.New("stepping back is disabled")
+	}
+
+	n, err := count(args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	reason := debug.StopStep
+	for range n {
+		if reason = m.Target.StepBack(); reason != debug.StopStep {
+			break
+		}
+	}
+	m.stopped(reason)
+	return nil
+}
+
+func (m *Monitor) breakpoint(args []string) error {
+	if len(args) == 0 {
+		breakpoints := m.Target.Breakpoints()
+		if len(breakpoints) == 0 {
+			m.printf("no breakpoints\n")
+		}
+		for _, addr := range breakpoints {
+			m.printf("  %s\n", m.symbolize(addr))
+		}
+		return nil
+	}
+
+	addr, err := m.programAddress(args[0])
+	if err != nil {
+		return err
+	}
+	m.Target.SetBreakpoint(addr)
+	m.printf("breakpoint at %s\n", m.symbolize(addr))
+	return nil
+}
+
+func (m *Monitor) delete(args []string) error {
+	if len(args) == 0 {
+		m.Target.SetBreakpoints(nil)
+		return nil
+	}
+
+	addr, err := m.programAddress(args[0])
+	if err != nil {
+		return err
+	}
+	if !m.Target.HasBreakpoint(addr) {
+		return fmt.Errorf("no breakpoint at %s", m.symbolize(addr))
+	}
+	m.Target.ClearBreakpoint(addr)
+	return nil
+}
+
+// printHit prints an access that triggered a watchpoint and the instruction that made it.
+func (m *Monitor) printHit(hit pic18.WatchHit) {
+	line := disasm.Instruction(m.Target.ProgramBus, hit.PC, m.disasmOptions())
+	if hit.Write {
+		m.printf("  write %s: 0x%02X -> 0x%02X", m.dataName(hit.Addr), hit.Old, hit.Value)
+	} else {
+		m.printf("  read  %s: 0x%02X", m.dataName(hit.Addr), hit.Value)
+	}
+	m.printf(" by %s  %s\n", m.symbolize(hit.PC), line.Text)
+}
+
+// dataName formats a data memory address with the name of the SFR or variable.
+func (m *Monitor) dataName(addr uint16) string {
+	if name, ok := m.Variables[addr]; ok {
+		return fmt.Sprintf("0x%03X <%s>", addr, name)
+	}
+	if name, ok := disasm.RegisterNames[addr]; ok {
+		return fmt.Sprintf("0x%03X <%s>", addr, name)
+	}
+	return fmt.Sprintf("0x%03X", addr)
+}
+
+// watchKinds are the arguments of the watch command.
+var watchKinds = map[string]pic18.WatchKind{
+	"r":      pic18.WatchRead,
+	"w":      pic18.WatchWrite,
+	"rw":     pic18.WatchRead | pic18.WatchWrite,
+	"change": pic18.WatchChange,
+}
+
+func (m *Monitor) watch(args []string) error {
+	watchpoints := m.Target.Watchpoints
+	if len(args) == 0 {
+		addrs := watchpoints.Addresses()
+		if len(addrs) == 0 {
+			m.printf("no watchpoints\n")
+		}
+		for _, addr := range addrs {
+			m.printf("  %s  %v\n", m.dataName(addr), watchpoints.Kind(addr))
+		}
+		return nil
+	}
+
+	addr, err := m.dataAddress(args[0])
+	if err != nil {
+		return err
+	}
+	if pic18.IsIndirectRegister(addr) {
+		return fmt.Errorf("%s can't be watched, watch the address in the FSR instead", m.dataName(addr))
+	}
+
+	kind := pic18.WatchWrite
+	if len(args) > 1 {
+		var ok bool
+		if kind, ok = watchKinds[args[1]]; !ok {
+			return fmt.Errorf("unknown watchpoint kind %q", args[1])
+		}
+	}
+
+	watchpoints.Watch(addr, watchpoints.Kind(addr)|kind)
+	m.printf("watchpoint at %s: %v\n", m.dataName(addr), watchpoints.Kind(addr))
+	return nil
+}
+
+func (m *Monitor) unwatch(args []string) error {
+	watchpoints := m.Target.Watchpoints
+	if len(args) == 0 {
+		watchpoints.Clear()
+		return nil
+	}
+
+	addr, err := m.dataAddress(args[0])
+	if err != nil {
+		return err
+	}
+	if watchpoints.Kind(addr) == 0 {
+		return fmt.Errorf("no watchpoint at %s", m.dataName(addr))
+	}
+	watchpoints.Watch(addr, 0)
+	return nil
+}
+
+func (m *Monitor) regs(args []string) error {
+	registers := m.Target.Registers()
+	for i, reg := range registers[:debug.RegStack] {
+		value := m.Target.ReadRegister(i)
+		switch i {
+		case debug.RegPC:
+			m.printf("  %-8s %s\n", reg.Name, m.symbolize(value))
+		case debug.RegSTATUS:
+			m.printf("  %-8s 0x%02X  [%s]\n", reg.Name, value, statusFlags(uint8(value)))
+		default:
+			m.printf("  %-8s 0x%0*X\n", reg.Name, reg.Size*2, value)
+		}
+	}
+	return nil
+}
+
+// statusFlags formats the bits of STATUS, set flags are upper case.
+func statusFlags(status uint8) string {
+	names := []string{"c", "dc", "z", "ov", "n"}
+	flags := make([]string, 0, len(names))
+	for bit := len(names) - 1; bit >= 0; bit-- {
+		if status&(1<<bit) != 0 {
+			flags = append(flags, strings.ToUpper(names[bit]))
+		} else {
+			flags = append(flags, names[bit])
+		}
+	}
+	return strings.Join(flags, " ")
+}
+
+// examineArgs parses the arguments of x and xp: an optional /n and an address.
+func examineArgs(args []string) (n int, addr string, err error) {
+	n = 16
+	if len(args) > 0 && strings.HasPrefix(args[0], "/") {
+		parsed, err := strconv.ParseUint(args[0][1:], 0, 16)
+		if err != nil {
+			return 0, "", fmt.Errorf("invalid count %q", args[0][1:])
+		}
+		n = int(parsed)
+		args = args[1:]
+	}
+
+	if len(args) != 1 {
+		return 0, "", errors.New("missing address")
+	}
+	return n, args[0], nil
+}
+
+// dump prints bytes in rows of 16.
+func (m *Monitor) dump(start uint32, n int, width int, read func(addr uint32) uint8) {
+	for row := 0; row < n; row += 16 {
+		m.printf("%0*X:", width, start+uint32(row))
+		for i := row; i < min(row+16, n); i++ {
+			m.printf(" %02X", read(start+uint32(i)))
+		}
+		m.printf("\n")
+	}
+}
+
+func (m *Monitor) examineData(args []string) error {
+	n, addrStr, err := examineArgs(args)
+	if err != nil {
+		return err
+	}
+	addr, err := m.dataAddress(addrStr)
+	if err != nil {
+		return err
+	}
+
+	m.dump(uint32(addr), n, 3, func(addr uint32) uint8 {
+		return m.Target.ReadData(uint16(addr & 0xFFF))
+	})
+	return nil
+}
+
+func (m *Monitor) examineProgram(args []string) error {
+	n, addrStr, err := examineArgs(args)
+	if err != nil {
+		return err
+	}
+	addr, err := m.programAddress(addrStr)
+	if err != nil {
+		return err
+	}
+
+	m.dump(addr, n, 6, m.Target.ReadProgram)
+	return nil
+}
+
+func (m *Monitor) set(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: set <reg|sfr|addr> <value>")
+	}
+
+	value, err := strconv.ParseUint(args[1], 0, 32)
+	if err != nil {
+		return fmt.Errorf("invalid value %q", args[1])
+	}
+
+	for i, reg := range m.Target.Registers() {
+		if strings.EqualFold(reg.Name, args[0]) {
+			m.Target.WriteRegister(i, uint32(value))
+			return nil
+		}
+	}
+
+	addr, err := m.dataAddress(args[0])
+	if err != nil {
+		return err
+	}
+	if value > 0xFF {
+		return fmt.Errorf("value %d doesn't fit in a byte", value)
+	}
+	m.Target.WriteData(addr, uint8(value))
+	return nil
+}
+
+func (m *Monitor) print(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: print <expr>")
+	}
+	if m.Evaluator == nil {
+		return errors.New("no debug information")
+	}
+
+	value, err := m.Evaluator.Evaluate(strings.Join(args, " "), m.Target.CPU.PC())
+	if err != nil {
+		return err
+	}
+	m.printf("%s = %s\n", value.Name, value)
+	return nil
+}
+
+func (m *Monitor) stack(args []string) error {
+	cpu := m.Target.CPU
+	depth := int(m.Target.ReadRegister(debug.RegSTKPTR) & 0x1F)
+	depth = min(depth, len(cpu.Stack.Data))
+
+	m.printf("#0   %s\n", m.symbolize(cpu.PC()))
+	for i := depth - 1; i >= 0; i-- {
+		m.printf("#%-3d %s\n", depth-i, m.symbolize(cpu.Stack.Data[i]))
+	}
+	return nil
+}
+
+func (m *Monitor) disasm(args []string) error {
+	addr := m.Target.CPU.PC()
+	if len(args) > 0 {
+		var err error
+		if addr, err = m.programAddress(args[0]); err != nil {
+			return err
+		}
+	}
+
+	n, err := count(args, 1, 10)
+	if err != nil {
+		return err
+	}
+
+	opts := m.disasmOptions()
+	for range n {
+		line := disasm.Instruction(m.Target.ProgramBus, addr, opts)
+		if label, ok := m.Labels[addr]; ok {
+			m.printf("%s:\n", label)
+		}
+
+		marker := "  "
+		if addr == m.Target.CPU.PC() {
+			marker = "=>"
+		} else if m.Target.HasBreakpoint(addr) {
+			marker = "* "
+		}
+		m.printf("%s %s\n", marker, line)
+		addr += line.Size()
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+// Package monitor implements an interactive command-line debugger for a [debug.Target].
+//
+// Commands are read line by line, from a terminal or from a script. Addresses and values are numbers
+// in Go syntax (0x10, 16, 0b10000) or names: program labels for program memory, SFR and variable names for data memory.
+// With line information, program addresses can also be source lines (main.c:42) and step and next work on lines.
+// With DWARF debug information, print shows variables and expressions using their C types.
+// An empty line repeats the previous command, "!!" and "!n" repeat commands from the history.
+package monitor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/natk64/go-pic-emu/pic18/debug"
+	"github.com/natk64/go-pic-emu/pic18/disasm"
+	"github.com/natk64/go-pic-emu/pic18/eval"
+)
+
+// ErrQuit is returned by [Monitor.Execute], [Monitor.Run] and [Monitor.RunScript] for the quit command.
+var ErrQuit = errors.New("quit")
+
+// Monitor executes debugger commands.
+type Monitor struct {
+	Target *debug.Target
+
+	// Labels maps program memory addresses to names, it may be nil.
+	Labels map[uint32]string
+
+	// Variables maps data memory addresses to the names of variables, it may be nil.
+	Variables map[uint16]string
+
+	// Evaluator evaluates the expressions of the print command, it is nil without debug information.
+	Evaluator *eval.Evaluator
+
+	// Out receives the output of all commands.
+	Out io.Writer
+
+	// Interrupt stops continue and similar commands, e.g. when the user presses Ctrl-C. It may be nil.
+	Interrupt <-chan struct{}
+
+	history []string
+}
+
+type command struct {
+	names []string
+	args  string
+	help  string
+	run   func(m *Monitor, args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{[]string{"step", "s"}, "[n]", "execute n source lines (default 1), instructions if there is no line information", (*Monitor).step},
+		{[]string{"next", "n"}, "[n]", "like step, but step over calls", (*Monitor).next},
+		{[]string{"stepi", "si"}, "[n]", "execute n instructions (default 1)", (*Monitor).stepInstruction},
+		{[]string{"nexti", "ni"}, "[n]", "like stepi, but step over calls", (*Monitor).nextInstruction},
+		{[]string{"finish"}, "", "run until the current function returns", (*Monitor).finish},
+		{[]string{"continue", "c"}, "", "run until a breakpoint is reached or the program is interrupted", (*Monitor).cont},
+		{[]string{"back"}, "[n]", "step back n instructions (default 1)", (*Monitor).back},
+		{[]string{"break", "b"}, "[addr|label|file:line]", "set a breakpoint, or list breakpoints without argument", (*Monitor).breakpoint},
+		{[]string{"delete", "d"}, "[addr|label|file:line]", "delete a breakpoint, or all breakpoints without argument", (*Monitor).delete},
+		{[]string{"watch", "w"}, "[addr|sfr|var] [r|w|rw|change]", "watch data memory (default w), or list watchpoints without argument", (*Monitor).watch},
+		{[]string{"unwatch"}, "[addr|sfr|var]", "delete a watchpoint, or all watchpoints without argument", (*Monitor).unwatch},
+		{[]string{"regs", "r"}, "", "show the core registers", (*Monitor).regs},
+		{[]string{"x"}, "[/n] <addr|sfr|var>", "show n bytes of data memory (default 16)", (*Monitor).examineData},
+		{[]string{"xp"}, "[/n] <addr|label>", "show n bytes of program memory (default 16)", (*Monitor).examineProgram},
+		{[]string{"print", "p"}, "<expr>", "show the value of a C expression, e.g. sensor.readings[3] or *(struct reading *)FSR0", (*Monitor).print},
+		{[]string{"set"}, "<reg|sfr|var|addr> <value>", "change a core register or a byte of data memory", (*Monitor).set},
+		{[]string{"stack", "bt"}, "", "show the hardware stack", (*Monitor).stack},
+		{[]string{"disasm", "l"}, "[addr|label] [n]", "disassemble n instructions (default 10) at addr (default PC)", (*Monitor).disasm},
+		{[]string{"source"}, "<file>", "execute commands from a file", (*Monitor).source},
+		{[]string{"history"}, "", "show the command history", (*Monitor).showHistory},
+		{[]string{"help", "h", "?"}, "", "show this help", (*Monitor).help},
+		{[]string{"quit", "q"}, "", "exit the monitor", func(*Monitor, []string) error { return ErrQuit }},
+	}
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		for _, n := range commands[i].names {
+			if n == name {
+				return &commands[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Run reads commands from r until the end of the input or the quit command, which returns [ErrQuit].
+// If interactive is true, a prompt is shown and errors don't stop the monitor.
+func (m *Monitor) Run(r io.Reader, interactive bool) error {
+	scanner := bufio.NewScanner(r)
+	for {
+		if interactive {
+			fmt.Fprint(m.Out, "(pic18) ")
+		}
+		if !scanner.Scan() {
+			if interactive {
+				fmt.Fprintln(m.Out)
+			}
+			return scanner.Err()
+		}
+
+		line, err := m.expandHistory(scanner.Text(), interactive)
+		if err == nil {
+			err = m.Execute(line)
+		}
+
+		if errors.Is(err, ErrQuit) {
+			return err
+		}
+		if err != nil {
+			if !interactive {
+				return err
+			}
+			fmt.Fprintln(m.Out, "error:", err)
+		}
+	}
+}
+
+// expandHistory handles history references and adds the command to the history.
+func (m *Monitor) expandHistory(line string, interactive bool) (string, error) {
+	line = strings.TrimSpace(line)
+	if !interactive {
+		return line, nil
+	}
+
+	switch {
+	case line == "" || line == "!!":
+		if len(m.history) == 0 {
+			return "", nil
+		}
+		// Repeating a command doesn't add it to the history again.
+		return m.history[len(m.history)-1], nil
+	case strings.HasPrefix(line, "!"):
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 1 || n > len(m.history) {
+			return "", fmt.Errorf("no command %s in the history", line)
+		}
+		line = m.history[n-1]
+	}
+
+	m.history = append(m.history, line)
+	return line, nil
+}
+
+// Execute executes a single command.
+func (m *Monitor) Execute(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	// Like gdb, the count of x can be attached to the command: x/16.
+	name, rest, _ := strings.Cut(line, " ")
+	if base, count, ok := strings.Cut(name, "/"); ok {
+		name = base
+		rest = "/" + count + " " + rest
+	}
+
+	cmd := findCommand(name)
+	if cmd == nil {
+		return fmt.Errorf("unknown command %q, try help", name)
+	}
+	return cmd.run(m, strings.Fields(rest))
+}
+
+func (m *Monitor) printf(format string, args ...any) {
+	fmt.Fprintf(m.Out, format, args...)
+}
+
+func (m *Monitor) help(args []string) error {
+	for _, cmd := range commands {
+		usage := strings.Join(cmd.names, ", ")
+		if cmd.args != "" {
+			usage += " " + cmd.args
+		}
+		m.printf("  %-32s %s\n", usage, cmd.help)
+	}
+	return nil
+}
+
+func (m *Monitor) showHistory(args []string) error {
+	for i, line := range m.history {
+		m.printf("%4d  %s\n", i+1, line)
+	}
+	return nil
+}
+
+func (m *Monitor) source(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: source <file>")
+	}
+	return m.RunScript(args[0])
+}
+
+// RunScript executes the commands in a file, it stops at the first error.
+func (m *Monitor) RunScript(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := m.Run(file, false); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// count parses an optional count argument.
+func count(args []string, index, def int) (int, error) {
+	if len(args) <= index {
+		return def, nil
+	}
+	n, err := strconv.ParseUint(args[index], 0, 31)
+	if err != nil {
+		return 0, fmt.Errorf("invalid count %q", args[index])
+	}
+	return int(n), nil
+}
+
+// programAddress resolves a label, a source line (file:line) or a number.
+func (m *Monitor) programAddress(s string) (uint32, error) {
+	if file, lineStr, ok := strings.Cut(s, ":"); ok && m.Target.Lines != nil {
+		line, err := strconv.Atoi(lineStr)
+		if err != nil {
+			return 0, fmt.Errorf("invalid line %q", lineStr)
+		}
+		if _, addr, ok := m.Target.Lines.LineAddress(file, line); ok {
+			return addr, nil
+		}
+		return 0, fmt.Errorf("no code at or after %s", s)
+	}
+
+	for addr, label := range m.Labels {
+		if label == s {
+			return addr, nil
+		}
+	}
+
+	addr, err := strconv.ParseUint(s, 0, 21)
+	if err != nil {
+		return 0, fmt.Errorf("unknown address %q", s)
+	}
+	return uint32(addr), nil
+}
+
+// dataAddress resolves a variable, an SFR name or a number.
+func (m *Monitor) dataAddress(s string) (uint16, error) {
+	for addr, name := range m.Variables {
+		if name == s {
+			return addr, nil
+		}
+	}
+	for addr, name := range disasm.RegisterNames {
+		if strings.EqualFold(name, s) {
+			return addr, nil
+		}
+	}
+
+	addr, err := strconv.ParseUint(s, 0, 12)
+	if err != nil {
+		return 0, fmt.Errorf("unknown address %q", s)
+	}
+	return uint16(addr), nil
+}
+
+// symbolize formats a program address with the closest label before it.
+func (m *Monitor) symbolize(addr uint32) string {
+	best, found := uint32(0), false
+	for labelAddr := range m.Labels {
+		if labelAddr <= addr && (!found || labelAddr > best) {
+			best, found = labelAddr, true
+		}
+	}
+
+	switch {
+	case !found:
+		return fmt.Sprintf("0x%06X", addr)
+	case best == addr:
+		return fmt.Sprintf("0x%06X <%s>", addr, m.Labels[best])
+	default:
+		return fmt.Sprintf("0x%06X <%s+0x%X>", addr, m.Labels[best], addr-best)
+	}
+}
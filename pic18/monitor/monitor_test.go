@@ -0,0 +1,105 @@
+package monitor
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/natk64/go-pic-emu/pic18/debug"
+	"github.com/natk64/go-pic-emu/pic18/internal/testcpu"
+)
+
+func newTestMonitor(t *testing.T) (*Monitor, *bytes.Buffer) {
+	t.Helper()
+	cpu, program := testcpu.New(t, `
+	org 0
+main
+	movlw 0x42
+	call sub
+	movwf 0x10
+loop
+	bra loop
+sub
+	addlw 1
+	return
+`)
+
+	var out bytes.Buffer
+	return &Monitor{Target: debug.New(cpu, nil), Labels: program.Labels(), Out: &out}, &out
+}
+
+// expectOutput checks that the lines of want appear in the output in that order.
+func expectOutput(t *testing.T, output string, want ...string) {
+	t.Helper()
+	rest := output
+	for _, line := range want {
+		i := strings.Index(rest, line)
+		if i < 0 {
+			t.Errorf("the output doesn't contain %q in the expected order:\n%s", line, output)
+			return
+		}
+		rest = rest[i+len(line):]
+	}
+}
+
+func TestScript(t *testing.T) {
+	m, out := newTestMonitor(t)
+	script := `
+break sub
+continue
+finish
+stepi
+x/2 0x10
+set wreg 5
+regs
+stack
+quit
+stepi
+`
+
+	if err := m.Run(strings.NewReader(script), false); !errors.Is(err, ErrQuit) {
+		t.Errorf("Run returned %v, want ErrQuit", err)
+	}
+	expectOutput(t, out.String(),
+		"breakpoint at 0x00000A <sub>",
+		"stopped: breakpoint\n0x00000A <sub>  ADDLW 0x01",
+		"0x000006 <main+0x6>  MOVWF 0x010, ACCESS",
+		"0x000008 <loop>  BRA loop",
+		"010: 43 00",
+		"wreg     0x05",
+		"stkptr   0x00",
+		"pc       0x000008 <loop>",
+	)
+	// Nothing runs after quit.
+	if m.Target.CPU.PC() != 0x08 || strings.Count(out.String(), "BRA loop") != 1 {
+		t.Errorf("a command after quit was executed:\n%s", out.String())
+	}
+}
+
+// A script stops at the first error, interactive sessions show it and continue.
+func TestErrors(t *testing.T) {
+	m, _ := newTestMonitor(t)
+	if err := m.Run(strings.NewReader("bogus\nstepi\n"), false); err == nil || m.Target.CPU.PC() != 0 {
+		t.Errorf("the script returned %v at PC 0x%06X, want an error at 0", err, m.Target.CPU.PC())
+	}
+
+	m, out := newTestMonitor(t)
+	if err := m.Run(strings.NewReader("bogus\nstepi\n"), true); err != nil {
+		t.Errorf("the interactive session returned %v at the end of the input", err)
+	}
+	expectOutput(t, out.String(), `error: unknown command "bogus"`, "0x000002 <main+0x2>  CALL sub")
+}
+
+// An empty line and !! repeat the previous command in interactive sessions.
+func TestRepeat(t *testing.T) {
+	m, out := newTestMonitor(t)
+	if err := m.Run(strings.NewReader("stepi\n\n!!\nhistory\n"), true); err != nil {
+		t.Fatalf("Run returned %v", err)
+	}
+
+	if pc := m.Target.CPU.PC(); pc != 0x0C {
+		t.Errorf("PC is 0x%06X after three steps, want 0x00000C", pc)
+	}
+	expectOutput(t, out.String(), "1  stepi\n", "2  history\n")
+}
@@ -0,0 +1,174 @@
+package pic18_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/natk64/go-pic-emu/pic18"
+)
+
+// The multiply routines below are the ones XC8 emits for the hardware multiplier,
+// which are also the examples of the datasheet (section 8.2). The operands are at 0x00 (ARG1L, ARG1H)
+// and 0x02 (ARG2L, ARG2H), 32 bit results are stored at 0x04 (RES0 to RES3).
+
+var mul8x8u = []uint16{
+	0x5000, // movf ARG1L, w
+	0x0202, // mulwf ARG2L
+	0x0003, // sleep
+}
+
+var mul8x8s = []uint16{
+	0x5000, // movf ARG1L, w
+	0x0202, // mulwf ARG2L
+	0xBE02, // btfsc ARG2L, 7
+	0x5EF4, // subwf PRODH, f
+	0x5002, // movf ARG2L, w
+	0xBE00, // btfsc ARG1L, 7
+	0x5EF4, // subwf PRODH, f
+	0x0003, // sleep
+}
+
+var mul16x16u = []uint16{
+	0x5000,         // movf ARG1L, w
+	0x0202,         // mulwf ARG2L
+	0xCFF4, 0xF005, // movff PRODH, RES1
+	0xCFF3, 0xF004, // movff PRODL, RES0
+	0x5001,         // movf ARG1H, w
+	0x0203,         // mulwf ARG2H
+	0xCFF4, 0xF007, // movff PRODH, RES3
+	0xCFF3, 0xF006, // movff PRODL, RES2
+	0x5000, // movf ARG1L, w
+	0x0203, // mulwf ARG2H
+	0x50F3, // movf PRODL, w
+	0x2605, // addwf RES1, f
+	0x50F4, // movf PRODH, w
+	0x2206, // addwfc RES2, f
+	0x6AE8, // clrf WREG
+	0x2207, // addwfc RES3, f
+	0x5001, // movf ARG1H, w
+	0x0202, // mulwf ARG2L
+	0x50F3, // movf PRODL, w
+	0x2605, // addwf RES1, f
+	0x50F4, // movf PRODH, w
+	0x2206, // addwfc RES2, f
+	0x6AE8, // clrf WREG
+	0x2207, // addwfc RES3, f
+}
+
+var mul16x16s = slices.Concat(mul16x16u, []uint16{
+	0xAE03, // btfss ARG2H, 7
+	0xD004, // bra sign_arg1
+	0x5000, // movf ARG1L, w
+	0x5E06, // subwf RES2, f
+	0x5001, // movf ARG1H, w
+	0x5A07, // subwfb RES3, f
+	// sign_arg1:
+	0xAE01, // btfss ARG1H, 7
+	0xD004, // bra done
+	0x5002, // movf ARG2L, w
+	0x5E06, // subwf RES2, f
+	0x5003, // movf ARG2H, w
+	0x5A07, // subwfb RES3, f
+	// done:
+	0x0003, // sleep
+})
+
+// wmul is XC8's ___wmul, the 16 bit product of C's int multiplication. It is the same for signed and unsigned operands.
+var wmul = []uint16{
+	0x5000,         // movf ARG1L, w
+	0x0202,         // mulwf ARG2L
+	0xCFF3, 0xF004, // movff PRODL, RES0
+	0xCFF4, 0xF005, // movff PRODH, RES1
+	0x5001, // movf ARG1H, w
+	0x0202, // mulwf ARG2L
+	0x50F3, // movf PRODL, w
+	0x2605, // addwf RES1, f
+	0x5000, // movf ARG1L, w
+	0x0203, // mulwf ARG2H
+	0x50F3, // movf PRODL, w
+	0x2605, // addwf RES1, f
+	0x0003, // sleep
+}
+
+func TestMultiply8x8(t *testing.T) {
+	tests := []struct {
+		name     string
+		program  []uint16
+		operands []int
+	}{
+		{"unsigned", mul8x8u, []int{0, 1, 10, 127, 128, 200, 255}},
+		{"signed", mul8x8s, []int{0, 1, 10, 127, -1, -5, -128}},
+	}
+
+	for _, test := range tests {
+		for _, a := range test.operands {
+			for _, b := range test.operands {
+				m := newTestMachine(t, test.program...)
+				m.write(0x00, 1, uint32(a))
+				m.write(0x02, 1, uint32(b))
+				m.run(t, 100)
+
+				want := uint16(a * b)
+				if got := uint16(m.read(pic18.Registers.PRODL, 2)); got != want {
+					t.Errorf("%s %d * %d: PRODH:PRODL = 0x%04X, want 0x%04X", test.name, a, b, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestMultiply16x16(t *testing.T) {
+	tests := []struct {
+		name     string
+		program  []uint16
+		operands []int
+	}{
+		{"unsigned", slices.Concat(mul16x16u, []uint16{0x0003}), []int{0, 1, 1000, 0x1234, 32767, 0x8000, 0xFFFF}},
+		{"signed", mul16x16s, []int{0, 1, 1000, 32767, -1, -1000, -0x1234, -32768}},
+	}
+
+	for _, test := range tests {
+		for _, a := range test.operands {
+			for _, b := range test.operands {
+				m := newTestMachine(t, test.program...)
+				m.write(0x00, 2, uint32(a))
+				m.write(0x02, 2, uint32(b))
+				m.run(t, 100)
+
+				want := uint32(a * b)
+				if got := m.read(0x04, 4); got != want {
+					t.Errorf("%s %d * %d = 0x%08X, want 0x%08X", test.name, a, b, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestMultiplyWmul(t *testing.T) {
+	tests := []struct{ a, b int16 }{
+		{0, 0},
+		{3, 4},
+		{-3, 4},
+		{3, -4},
+		{-3, -4},
+		{300, 200},
+		{-300, 200},
+		{0x1234, 0x5678},
+		{-32768, -1},
+		{-1, -1},
+		{255, 255},
+		{-256, 256},
+	}
+
+	for _, test := range tests {
+		m := newTestMachine(t, wmul...)
+		m.write(0x00, 2, uint32(uint16(test.a)))
+		m.write(0x02, 2, uint32(uint16(test.b)))
+		m.run(t, 100)
+
+		want := uint16(test.a * test.b)
+		if got := uint16(m.read(0x04, 2)); got != want {
+			t.Errorf("%d * %d = 0x%04X, want 0x%04X", test.a, test.b, got, want)
+		}
+	}
+}
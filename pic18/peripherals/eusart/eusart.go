@@ -62,6 +62,18 @@ func (eusart *EUSART) loadTSR() {
 	eusart.TxInterrupt.Raise()
 }
 
+// Reset restores the reset values of all EUSART registers.
+// Pending transmissions are discarded.
+func (eusart *EUSART) Reset() {
+	*eusart = EUSART{
+		ModeChange:  eusart.ModeChange,
+		Transmit:    eusart.Transmit,
+		RxInterrupt: eusart.RxInterrupt,
+		TxInterrupt: eusart.TxInterrupt,
+		Registers:   eusart.Registers,
+	}
+}
+
 func (eusart *EUSART) BusRead(addr uint16) (uint8, pic18.AddrMask) {
 	read_bits := func(b7, b6, b5, b4, b3, b2, b1, b0 bool) uint8 {
 		return (bInt(b7) << 7) | (bInt(b6) << 6) | (bInt(b5) << 5) | (bInt(b4) << 4) |
@@ -211,3 +223,106 @@ func bInt(b bool) uint8 {
 func bitTest(val uint8, bit int) bool {
 	return val&(1<<bit) != 0
 }
+
+type eusartState struct {
+	Synchronous       bool
+	TxEn              bool
+	Tx9BitEn          bool
+	SyncClkMasterMode bool
+	AsyncSendBreak    bool
+	AsyncHighSpeed    bool
+
+	SpEn              bool
+	Rx9BitEn          bool
+	SingleReceive     bool
+	ContinuousReceive bool
+	AddressDetect     bool
+
+	FramingErr bool
+	OverrunErr bool
+
+	RxActive          bool
+	AsyncInvertRx     bool
+	WakeupEn          bool
+	ClockDataPolarity bool
+	BaudRate16BitEn   bool
+
+	BaudRate uint16
+
+	TxReg  uint8
+	RxReg  uint8
+	TxBit9 bool
+	RxBit9 bool
+
+	TsrLoaded   bool
+	TxregLoaded bool
+}
+
+func (eusart *EUSART) SaveState() ([]byte, error) {
+	return pic18.EncodeState(eusartState{
+		Synchronous:       eusart.synchronous,
+		TxEn:              eusart.tx_en,
+		Tx9BitEn:          eusart.tx_9bit_en,
+		SyncClkMasterMode: eusart.sync_clk_master_mode,
+		AsyncSendBreak:    eusart.async_send_break,
+		AsyncHighSpeed:    eusart.async_high_speed,
+		SpEn:              eusart.sp_en,
+		Rx9BitEn:          eusart.rx_9bit_en,
+		SingleReceive:     eusart.single_receive,
+		ContinuousReceive: eusart.continuous_receive,
+		AddressDetect:     eusart.address_detect,
+		FramingErr:        eusart.framing_err,
+		OverrunErr:        eusart.overrun_err,
+		RxActive:          eusart.rx_active,
+		AsyncInvertRx:     eusart.async_invert_rx,
+		WakeupEn:          eusart.wakeup_en,
+		ClockDataPolarity: eusart.clock_data_polarity,
+		BaudRate16BitEn:   eusart.baud_rate_16bit_en,
+		BaudRate:          eusart.baud_rate,
+		TxReg:             eusart.tx_reg,
+		RxReg:             eusart.rx_reg,
+		TxBit9:            eusart.tx_bit9,
+		RxBit9:            eusart.rx_bit9,
+		TsrLoaded:         eusart.tsr_loaded,
+		TxregLoaded:       eusart.txreg_loaded,
+	})
+}
+
+// LoadState restores a state saved with [EUSART.SaveState].
+// It has no side effects: nothing is transmitted and no interrupt is raised, the TX interrupt flag is part of the CPU state.
+// A transmission that was in progress when the state was saved isn't restarted, the shift register stays
+// busy until [EUSART.TXDone] is called.
+func (eusart *EUSART) LoadState(data []byte) error {
+	var state eusartState
+	if err := pic18.DecodeState(data, &state); err != nil {
+		return err
+	}
+
+	eusart.synchronous = state.Synchronous
+	eusart.tx_en = state.TxEn
+	eusart.tx_9bit_en = state.Tx9BitEn
+	eusart.sync_clk_master_mode = state.SyncClkMasterMode
+	eusart.async_send_break = state.AsyncSendBreak
+	eusart.async_high_speed = state.AsyncHighSpeed
+	eusart.sp_en = state.SpEn
+	eusart.rx_9bit_en = state.Rx9BitEn
+	eusart.single_receive = state.SingleReceive
+	eusart.continuous_receive = state.ContinuousReceive
+	eusart.address_detect = state.AddressDetect
+	eusart.framing_err = state.FramingErr
+	eusart.overrun_err = state.OverrunErr
+	eusart.rx_active = state.RxActive
+	eusart.async_invert_rx = state.AsyncInvertRx
+	eusart.wakeup_en = state.WakeupEn
+	eusart.clock_data_polarity = state.ClockDataPolarity
+	eusart.baud_rate_16bit_en = state.BaudRate16BitEn
+	eusart.baud_rate = state.BaudRate
+	eusart.tx_reg = state.TxReg
+	eusart.rx_reg = state.RxReg
+	eusart.tx_bit9 = state.TxBit9
+	eusart.rx_bit9 = state.RxBit9
+	eusart.tsr_loaded = state.TsrLoaded
+	eusart.txreg_loaded = state.TxregLoaded
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package eusart
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/natk64/go-pic-emu/pic18"
+)
+
+// testInterrupt records the state of an interrupt flag and how often it was raised.
+type testInterrupt struct {
+	flag   bool
+	raised int
+}
+
+func (interrupt *testInterrupt) Raise() {
+	interrupt.flag = true
+	interrupt.raised++
+}
+
+func (interrupt *testInterrupt) Clear() {
+	interrupt.flag = false
+}
+
+// newTestEUSART returns EUSART1 with a transmitter that never completes, like a slow serial line.
+func newTestEUSART() (*EUSART, *[]uint8, *testInterrupt) {
+	var sent []uint8
+	tx := &testInterrupt{}
+	eusart := &EUSART{
+		ModeChange:  func() {},
+		Transmit:    func(data uint8, bit9 bool) { sent = append(sent, data) },
+		TxInterrupt: tx,
+		RxInterrupt: &testInterrupt{},
+		Registers: Registers{
+			TXSTAx:   pic18.Registers.TXSTA1,
+			RCSTAx:   pic18.Registers.RCSTA1,
+			TXREGx:   pic18.Registers.TXREG1,
+			RCREGx:   pic18.Registers.RCREG1,
+			BAUDCONx: pic18.Registers.BAUDCON1,
+			SPBRGHx:  pic18.Registers.SPBRGH1,
+			SPBRGx:   pic18.Registers.SPBRG1,
+		},
+	}
+	return eusart, &sent, tx
+}
+
+func TestTransmit(t *testing.T) {
+	eusart, sent, tx := newTestEUSART()
+	eusart.BusWrite(pic18.Registers.RCSTA1, 0x80) // SPEN
+	eusart.BusWrite(pic18.Registers.TXSTA1, 0x20) // TXEN
+
+	// The first byte moves to the shift register immediately, the second one waits in TXREG.
+	eusart.BusWrite(pic18.Registers.TXREG1, 'a')
+	eusart.BusWrite(pic18.Registers.TXREG1, 'b')
+	if string(*sent) != "a" || tx.flag {
+		t.Fatalf("sent %q, TXIF %v while the shift register is busy, want \"a\" and TXIF cleared", *sent, tx.flag)
+	}
+	if txsta, _ := eusart.BusRead(pic18.Registers.TXSTA1); txsta&0x02 != 0 {
+		t.Errorf("TRMT is set while the shift register is busy")
+	}
+
+	eusart.TXDone()
+	eusart.TXDone()
+	if string(*sent) != "ab" || !tx.flag {
+		t.Errorf("sent %q, TXIF %v, want \"ab\" and TXIF set", *sent, tx.flag)
+	}
+	if txsta, _ := eusart.BusRead(pic18.Registers.TXSTA1); txsta&0x02 == 0 {
+		t.Errorf("TRMT is cleared after the transmission")
+	}
+}
+
+// Loading a state must not transmit anything or raise interrupts, and saving it again gives the same bytes.
+func TestSaveLoadState(t *testing.T) {
+	eusart, _, _ := newTestEUSART()
+	eusart.BusWrite(pic18.Registers.RCSTA1, 0x90) // SPEN, CREN
+	eusart.BusWrite(pic18.Registers.TXSTA1, 0x24) // TXEN, BRGH
+	eusart.BusWrite(pic18.Registers.SPBRG1, 25)
+	eusart.BusWrite(pic18.Registers.TXREG1, 'a')
+	eusart.BusWrite(pic18.Registers.TXREG1, 'b')
+	eusart.ImportRX('c', false)
+
+	saved, err := eusart.SaveState()
+	if err != nil {
+		t.Fatalf("saving: %v", err)
+	}
+
+	loaded, sent, tx := newTestEUSART()
+	if err := loaded.LoadState(saved); err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	if len(*sent) != 0 || tx.raised != 0 {
+		t.Errorf("loading transmitted %q and raised TXIF %d times, want nothing", *sent, tx.raised)
+	}
+
+	resaved, err := loaded.SaveState()
+	if err != nil {
+		t.Fatalf("saving the loaded state: %v", err)
+	}
+	if !bytes.Equal(resaved, saved) {
+		t.Errorf("the loaded state saves as\n% X\nwant\n% X", resaved, saved)
+	}
+
+	// The byte waiting in TXREG is sent when the restored transmission completes.
+	loaded.TXDone()
+	if string(*sent) != "b" {
+		t.Errorf("sent %q after the restored transmission completed, want \"b\"", *sent)
+	}
+}
@@ -0,0 +1,316 @@
+// Package profile attributes the instruction cycles of a [pic18.CPU] to the executing code and
+// writes them as a pprof profile, which can be viewed with go tool pprof.
+//
+// Every cycle is attributed to the instruction that uses it and to the call chain on the hardware stack.
+// The caller frames are the instructions before the return addresses, which are the calls
+// (or the interrupted instructions, for interrupt service routines).
+package profile
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/natk64/go-pic-emu/pic18"
+)
+
+// maxDepth is the size of the largest hardware stack.
+const maxDepth = 31
+
+// stackKey identifies a call chain: the PC followed by the return addresses, newest first.
+type stackKey struct {
+	depth int
+	addrs [maxDepth + 1]uint32
+}
+
+// Profiler counts the cycles spent in each call chain.
+type Profiler struct {
+	cpu   *pic18.CPU
+	start time.Time
+
+	// current is the call chain of the instruction that is executing.
+	current stackKey
+	cycles  map[stackKey]uint64
+}
+
+// New creates a profiler for the CPU, Record must be called before every tick.
+func New(cpu *pic18.CPU) *Profiler {
+	return &Profiler{
+		cpu:    cpu,
+		start:  time.Now(),
+		cycles: make(map[stackKey]uint64),
+	}
+}
+
+// Record attributes the next cycle of the CPU, it must be called before [pic18.CPU.Tick].
+func (profiler *Profiler) Record() {
+	cpu := profiler.cpu
+	if cpu.Sleep.Asleep() {
+		// No cycles are counted while the CPU sleeps.
+		return
+	}
+
+	// The call chain is captured at the start of an instruction, so the remaining cycles of calls
+	// and returns are attributed to the caller, not to the function that is entered or left.
+	// The cycle that fetches the first instruction after a Power-on Reset belongs to that instruction.
+	if cpu.AtInstructionBoundary() || profiler.current.depth == 0 {
+		key := &profiler.current
+		key.addrs[0] = cpu.PC()
+		key.depth = 1
+
+		stack := &cpu.Stack
+		for i := min(stack.Depth(), len(stack.Data), maxDepth) - 1; i >= 0; i-- {
+			key.addrs[key.depth] = stack.Data[i] - 2
+			key.depth++
+		}
+		clear(key.addrs[key.depth:])
+	}
+
+	profiler.cycles[profiler.current]++
+}
+
+// Symbols describes the program for the profile. All fields are optional.
+type Symbols struct {
+	// Labels maps addresses to function names, an address belongs to the closest label before it.
+	Labels map[uint32]string
+
+	// Source is the path of the source file and Lines maps its line numbers to addresses.
+	Source string
+	Lines  map[int]uint32
+
+	// Program is the path of the program, it is shown as the mapping.
+	Program string
+}
+
+// symbolizer finds the function and line of an address.
+type symbolizer struct {
+	labelAddrs []uint32
+	labels     map[uint32]string
+	lineAddrs  []uint32
+	lines      map[uint32]int
+}
+
+func newSymbolizer(symbols *Symbols) *symbolizer {
+	s := &symbolizer{labels: symbols.Labels, lines: make(map[uint32]int)}
+	for addr := range symbols.Labels {
+		s.labelAddrs = append(s.labelAddrs, addr)
+	}
+	slices.Sort(s.labelAddrs)
+
+	for line, addr := range symbols.Lines {
+		if existing, ok := s.lines[addr]; !ok || line < existing {
+			s.lines[addr] = line
+		}
+	}
+	for addr := range s.lines {
+		s.lineAddrs = append(s.lineAddrs, addr)
+	}
+	slices.Sort(s.lineAddrs)
+	return s
+}
+
+// floor returns the largest address in addrs that isn't greater than addr.
+func floor(addrs []uint32, addr uint32) (uint32, bool) {
+	i, found := slices.BinarySearch(addrs, addr)
+	if found {
+		return addr, true
+	}
+	if i == 0 {
+		return 0, false
+	}
+	return addrs[i-1], true
+}
+
+func (s *symbolizer) function(addr uint32) string {
+	if labelAddr, ok := floor(s.labelAddrs, addr); ok {
+		return s.labels[labelAddr]
+	}
+	return "unknown"
+}
+
+func (s *symbolizer) line(addr uint32) int {
+	// Instructions are at most 4 bytes, so the second word of a call still finds its line.
+	if lineAddr, ok := floor(s.lineAddrs, addr); ok && addr-lineAddr < 4 {
+		return s.lines[lineAddr]
+	}
+	return 0
+}
+
+// Field numbers of profile.proto.
+const (
+	profileSampleType        = 1
+	profileSample            = 2
+	profileMapping           = 3
+	profileLocation          = 4
+	profileFunction          = 5
+	profileStringTable       = 6
+	profileTimeNanos         = 9
+	profileDurationNanos     = 10
+	profilePeriodType        = 11
+	profilePeriod            = 12
+	profileDefaultSampleType = 14
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	mappingID             = 1
+	mappingMemoryStart    = 2
+	mappingMemoryLimit    = 3
+	mappingFilename       = 5
+	mappingHasFunctions   = 7
+	mappingHasFilenames   = 8
+	mappingHasLineNumbers = 9
+
+	locationID        = 1
+	locationMappingID = 2
+	locationAddress   = 3
+	locationLine      = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+)
+
+// profileBuilder collects the strings, functions and locations of a profile.
+type profileBuilder struct {
+	symbols *symbolizer
+	source  string
+
+	strings     []string
+	stringIndex map[string]int64
+
+	functions     protoBuffer
+	functionIndex map[string]uint64
+
+	locations     protoBuffer
+	locationIndex map[uint32]uint64
+}
+
+func (b *profileBuilder) string(s string) int64 {
+	if index, ok := b.stringIndex[s]; ok {
+		return index
+	}
+	index := int64(len(b.strings))
+	b.strings = append(b.strings, s)
+	b.stringIndex[s] = index
+	return index
+}
+
+func (b *profileBuilder) function(name string) uint64 {
+	if id, ok := b.functionIndex[name]; ok {
+		return id
+	}
+
+	id := uint64(len(b.functionIndex) + 1)
+	b.functionIndex[name] = id
+	b.functions.message(profileFunction, func(msg *protoBuffer) {
+		msg.uint64(functionID, id)
+		msg.int64(functionName, b.string(name))
+		msg.int64(functionSystemName, b.string(name))
+		msg.int64(functionFilename, b.string(b.source))
+	})
+	return id
+}
+
+func (b *profileBuilder) location(addr uint32) uint64 {
+	if id, ok := b.locationIndex[addr]; ok {
+		return id
+	}
+
+	id := uint64(len(b.locationIndex) + 1)
+	b.locationIndex[addr] = id
+	function := b.function(b.symbols.function(addr))
+	b.locations.message(profileLocation, func(msg *protoBuffer) {
+		msg.uint64(locationID, id)
+		msg.uint64(locationMappingID, 1)
+		msg.uint64(locationAddress, uint64(addr))
+		msg.message(locationLine, func(line *protoBuffer) {
+			line.uint64(lineFunctionID, function)
+			line.int64(lineLine, int64(b.symbols.line(addr)))
+		})
+	})
+	return id
+}
+
+// WriteProfile writes the recorded cycles as a gzip compressed pprof profile.
+func (profiler *Profiler) WriteProfile(w io.Writer, symbols *Symbols) error {
+	if symbols == nil {
+		symbols = &Symbols{}
+	}
+
+	b := &profileBuilder{
+		symbols:       newSymbolizer(symbols),
+		source:        symbols.Source,
+		stringIndex:   make(map[string]int64),
+		functionIndex: make(map[string]uint64),
+		locationIndex: make(map[uint32]uint64),
+	}
+	b.string("")
+
+	// Samples are sorted by their call chain, so the output doesn't depend on the map order.
+	keys := make([]stackKey, 0, len(profiler.cycles))
+	for key := range profiler.cycles {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b stackKey) int {
+		return slices.Compare(a.addrs[:a.depth], b.addrs[:b.depth])
+	})
+
+	var buf protoBuffer
+	valueType := func(field int, typ, unit string) {
+		buf.message(field, func(msg *protoBuffer) {
+			msg.int64(valueTypeType, b.string(typ))
+			msg.int64(valueTypeUnit, b.string(unit))
+		})
+	}
+	valueType(profileSampleType, "cycles", "count")
+
+	for _, key := range keys {
+		ids := make([]uint64, key.depth)
+		for i, addr := range key.addrs[:key.depth] {
+			ids[i] = b.location(addr)
+		}
+		buf.message(profileSample, func(msg *protoBuffer) {
+			msg.packedUint64(sampleLocationID, ids)
+			msg.packedInt64(sampleValue, []int64{int64(profiler.cycles[key])})
+		})
+	}
+
+	buf.message(profileMapping, func(msg *protoBuffer) {
+		msg.uint64(mappingID, 1)
+		msg.uint64(mappingMemoryStart, 0)
+		msg.uint64(mappingMemoryLimit, 0x200000)
+		msg.int64(mappingFilename, b.string(symbols.Program))
+		msg.bool(mappingHasFunctions, len(symbols.Labels) > 0)
+		msg.bool(mappingHasFilenames, symbols.Source != "")
+		msg.bool(mappingHasLineNumbers, len(symbols.Lines) > 0)
+	})
+	buf = append(buf, b.locations...)
+	buf = append(buf, b.functions...)
+
+	buf.int64(profileTimeNanos, profiler.start.UnixNano())
+	buf.int64(profileDurationNanos, int64(time.Since(profiler.start)))
+	valueType(profilePeriodType, "cycles", "count")
+	buf.int64(profilePeriod, 1)
+	buf.int64(profileDefaultSampleType, b.string("cycles"))
+
+	// The string table is written last, every other part has been encoded by now.
+	for _, s := range b.strings {
+		buf.string(profileStringTable, s)
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(buf); err != nil {
+		return fmt.Errorf("writing profile: %w", err)
+	}
+	return zw.Close()
+}
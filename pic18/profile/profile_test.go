@@ -0,0 +1,146 @@
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/natk64/go-pic-emu/pic18/internal/testcpu"
+)
+
+const testSource = `
+	org 0
+main
+	call sub
+	sleep
+sub
+	nop
+	return
+`
+
+// runProfiled runs testSource until SLEEP and returns its profile.
+func runProfiled(t *testing.T) (*Profiler, *Symbols) {
+	t.Helper()
+	cpu, program := testcpu.New(t, testSource)
+	profiler := New(cpu)
+	for range 100 {
+		profiler.Record()
+		cpu.Tick()
+		if cpu.Sleep.Asleep() {
+			return profiler, &Symbols{Labels: program.Labels(), Source: "test.asm", Lines: program.Lines, Program: "test.hex"}
+		}
+	}
+	t.Fatal("the program didn't reach SLEEP")
+	return nil, nil
+}
+
+// chain returns the key of a call chain.
+func chain(addrs ...uint32) stackKey {
+	key := stackKey{depth: len(addrs)}
+	copy(key.addrs[:], addrs)
+	return key
+}
+
+func TestRecord(t *testing.T) {
+	profiler, _ := runProfiled(t)
+
+	// The first cycle fetches the CALL after the reset.
+	// The caller frame of sub is the second word of the CALL, the instruction before the return address.
+	want := map[stackKey]uint64{
+		chain(0x00):       3,
+		chain(0x06, 0x02): 1,
+		chain(0x08, 0x02): 2,
+		chain(0x04):       1,
+	}
+	for key, cycles := range want {
+		if profiler.cycles[key] != cycles {
+			t.Errorf("%X has %d cycles, want %d", key.addrs[:key.depth], profiler.cycles[key], cycles)
+		}
+	}
+	if len(profiler.cycles) != len(want) {
+		t.Errorf("recorded %d call chains, want %d", len(profiler.cycles), len(want))
+	}
+}
+
+// protoFields decodes the top level fields of a protocol buffer message,
+// varints as their value and length delimited fields as their bytes.
+func protoFields(t *testing.T, data []byte) map[int][]any {
+	t.Helper()
+	fields := make(map[int][]any)
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		data = data[n:]
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(data)
+			fields[int(key>>3)] = append(fields[int(key>>3)], v)
+			data = data[n:]
+		case 2:
+			length, n := binary.Uvarint(data)
+			data = data[n:]
+			fields[int(key>>3)] = append(fields[int(key>>3)], data[:length])
+			data = data[length:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		if n <= 0 {
+			t.Fatal("invalid varint")
+		}
+	}
+	return fields
+}
+
+func TestWriteProfile(t *testing.T) {
+	profiler, symbols := runProfiled(t)
+
+	var buf bytes.Buffer
+	if err := profiler.WriteProfile(&buf, symbols); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("the profile isn't compressed: %v", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := protoFields(t, data)
+	var strings []string
+	for _, s := range fields[profileStringTable] {
+		strings = append(strings, string(s.([]byte)))
+	}
+	if len(strings) == 0 || strings[0] != "" {
+		t.Fatalf("the string table %q doesn't start with an empty string", strings)
+	}
+	for _, want := range []string{"cycles", "count", "main", "sub", "test.asm", "test.hex"} {
+		if !slices.Contains(strings, want) {
+			t.Errorf("the string table %q doesn't contain %q", strings, want)
+		}
+	}
+
+	if samples := len(fields[profileSample]); samples != len(profiler.cycles) {
+		t.Errorf("the profile has %d samples, want %d", samples, len(profiler.cycles))
+	}
+	// The locations are 0x00, 0x06, 0x02, 0x08 and 0x04, the functions main and sub.
+	if locations := len(fields[profileLocation]); locations != 5 {
+		t.Errorf("the profile has %d locations, want 5", locations)
+	}
+	if functions := len(fields[profileFunction]); functions != 2 {
+		t.Errorf("the profile has %d functions, want 2", functions)
+	}
+
+	var total uint64
+	for _, sample := range fields[profileSample] {
+		value := protoFields(t, sample.([]byte))[sampleValue][0].([]byte)
+		cycles, _ := binary.Uvarint(value)
+		total += cycles
+	}
+	if total != 7 {
+		t.Errorf("the samples add up to %d cycles, want 7", total)
+	}
+}
@@ -0,0 +1,72 @@
+package profile
+
+// protoBuffer encodes protocol buffer messages, only the wire types used by profile.proto are supported.
+type protoBuffer []byte
+
+func (buf *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		*buf = append(*buf, byte(v)|0x80)
+		v >>= 7
+	}
+	*buf = append(*buf, byte(v))
+}
+
+func (buf *protoBuffer) key(field int, wireType int) {
+	buf.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+// uint64 writes a varint field, zero values are omitted like in proto3.
+func (buf *protoBuffer) uint64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	buf.key(field, 0)
+	buf.varint(v)
+}
+
+func (buf *protoBuffer) int64(field int, v int64) {
+	buf.uint64(field, uint64(v))
+}
+
+func (buf *protoBuffer) bool(field int, v bool) {
+	if v {
+		buf.uint64(field, 1)
+	}
+}
+
+func (buf *protoBuffer) bytes(field int, b []byte) {
+	buf.key(field, 2)
+	buf.varint(uint64(len(b)))
+	*buf = append(*buf, b...)
+}
+
+// string writes a string field, unlike the other types empty strings are written,
+// because the string table must start with one.
+func (buf *protoBuffer) string(field int, s string) {
+	buf.key(field, 2)
+	buf.varint(uint64(len(s)))
+	*buf = append(*buf, s...)
+}
+
+func (buf *protoBuffer) packedUint64(field int, values []uint64) {
+	var packed protoBuffer
+	for _, v := range values {
+		packed.varint(v)
+	}
+	buf.bytes(field, packed)
+}
+
+func (buf *protoBuffer) packedInt64(field int, values []int64) {
+	var packed protoBuffer
+	for _, v := range values {
+		packed.varint(uint64(v))
+	}
+	buf.bytes(field, packed)
+}
+
+// message writes a nested message.
+func (buf *protoBuffer) message(field int, encode func(msg *protoBuffer)) {
+	var msg protoBuffer
+	encode(&msg)
+	buf.bytes(field, msg)
+}
@@ -10,6 +10,8 @@ type RegisterTable struct {
 	TOSU     uint16
 	WREG     uint16
 	STATUS   uint16
+	PRODH    uint16
+	PRODL    uint16
 	FSR0H    uint16
 	FSR0L    uint16
 	FSR1H    uint16
@@ -21,6 +23,7 @@ type RegisterTable struct {
 	INTCON2  uint16
 	INTCON3  uint16
 	RCON     uint16
+	WDTCON   uint16
 	TXSTA1   uint16
 	TXSTA2   uint16
 	RCSTA1   uint16
@@ -47,11 +50,14 @@ var Registers = RegisterTable{
 	PCL:      0xFF9,
 	WREG:     0xFE8,
 	STATUS:   0xFD8,
+	PRODH:    0xFF4,
+	PRODL:    0xFF3,
 	BSR:      0xFE0,
 	INTCON:   0xFF2,
 	INTCON2:  0xFF1,
 	INTCON3:  0xFF0,
 	RCON:     0xFD0,
+	WDTCON:   0xFD1,
 	TXSTA1:   0xFAC,
 	TXSTA2:   0xFBA,
 	RCSTA1:   0xFAB,
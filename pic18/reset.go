@@ -0,0 +1,118 @@
+package pic18
+
+type ResetCause int
+
+const (
+	ResetPowerOn ResetCause = iota
+	ResetBrownOut
+	ResetMCLR
+	ResetInstruction
+	ResetStackFull
+	ResetStackUnderflow
+	ResetWatchdog
+)
+
+func (cause ResetCause) String() string {
+	switch cause {
+	case ResetPowerOn:
+		return "Power-on Reset"
+	case ResetBrownOut:
+		return "Brown-out Reset"
+	case ResetMCLR:
+		return "MCLR Reset"
+	case ResetInstruction:
+		return "RESET Instruction"
+	case ResetStackFull:
+		return "Stack Full Reset"
+	case ResetStackUnderflow:
+		return "Stack Underflow Reset"
+	case ResetWatchdog:
+		return "WDT Time-out Reset"
+	default:
+		return "Unknown Reset"
+	}
+}
+
+// ResetController holds the reset status bits of the RCON register.
+// The IPEN bit of RCON is handled by the [InterruptController].
+type ResetController struct {
+	// OnReset is called after the core has been reset.
+	// It can be used to reset peripherals that aren't part of the CPU.
+	OnReset func(cause ResetCause)
+
+	cause ResetCause
+
+	resetInstruction bool // RI, cleared by the RESET instruction
+	timeOut          bool // TO, cleared by a WDT time-out
+	powerDown        bool // PD, cleared by the SLEEP instruction
+	powerOn          bool // POR, cleared by a Power-on Reset
+	brownOut         bool // BOR, cleared by a Brown-out Reset
+}
+
+// Cause returns the cause of the last reset.
+func (controller *ResetController) Cause() ResetCause {
+	return controller.cause
+}
+
+// reset updates the status bits according to the reset cause.
+func (controller *ResetController) reset(cause ResetCause) {
+	controller.cause = cause
+
+	switch cause {
+	case ResetPowerOn:
+		controller.resetInstruction = true
+		controller.timeOut = true
+		controller.powerDown = true
+		controller.powerOn = false
+		controller.brownOut = false
+	case ResetBrownOut:
+		controller.resetInstruction = true
+		controller.timeOut = true
+		controller.powerDown = true
+		controller.brownOut = false
+	case ResetInstruction:
+		controller.resetInstruction = false
+	case ResetWatchdog:
+		controller.timeOut = false
+	}
+
+	if controller.OnReset != nil {
+		controller.OnReset(cause)
+	}
+}
+
+func (controller *ResetController) BusRead(addr uint16) (uint8, AddrMask) {
+	if addr != Registers.RCON {
+		return 0, 0
+	}
+
+	var data uint8
+	if controller.resetInstruction {
+		data |= 1 << 4
+	}
+	if controller.timeOut {
+		data |= 1 << 3
+	}
+	if controller.powerDown {
+		data |= 1 << 2
+	}
+	if controller.powerOn {
+		data |= 1 << 1
+	}
+	if controller.brownOut {
+		data |= 1 << 0
+	}
+	return data, 0x1F
+}
+
+func (controller *ResetController) BusWrite(addr uint16, data uint8) AddrMask {
+	if addr != Registers.RCON {
+		return 0
+	}
+
+	// TO and PD are read only.
+	controller.resetInstruction = data&(1<<4) != 0
+	controller.powerOn = data&(1<<1) != 0
+	controller.brownOut = data&(1<<0) != 0
+	return 0x1F
+}
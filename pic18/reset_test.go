@@ -0,0 +1,151 @@
+package pic18_test
+
+import (
+	"testing"
+
+	"github.com/natk64/go-pic-emu/pic18"
+)
+
+// RCON reset status bits.
+const (
+	rconBOR = 1 << 0
+	rconPOR = 1 << 1
+	rconPD  = 1 << 2
+	rconTO  = 1 << 3
+	rconRI  = 1 << 4
+)
+
+// Each test sets RI, POR and BOR from firmware first, so the bits a reset clears are visible.
+func TestResetCauses(t *testing.T) {
+	tests := []struct {
+		name  string
+		reset func(m *testMachine)
+		cause pic18.ResetCause
+		rcon  uint8
+	}{
+		{"Power-on", func(m *testMachine) { m.cpu.PowerOnReset() },
+			pic18.ResetPowerOn, rconRI | rconTO | rconPD},
+		{"Brown-out", func(m *testMachine) { m.cpu.BrownOutReset() },
+			pic18.ResetBrownOut, rconRI | rconTO | rconPD | rconPOR},
+		{"MCLR", func(m *testMachine) { m.cpu.MclrReset() },
+			pic18.ResetMCLR, rconRI | rconTO | rconPD | rconPOR | rconBOR},
+		{"RESET instruction", func(m *testMachine) { m.cpu.Tick() },
+			pic18.ResetInstruction, rconTO | rconPD | rconPOR | rconBOR},
+		{"WDT", func(m *testMachine) { m.cpu.WatchdogReset() },
+			pic18.ResetWatchdog, rconRI | rconPD | rconPOR | rconBOR},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newTestMachine(t, assemble(t, `
+	org 0
+	reset
+`)...)
+			m.cpu.Tick() // fetch
+			m.write(pic18.Registers.RCON, 1, rconRI|rconPOR|rconBOR)
+			m.write(pic18.Registers.BSR, 1, 0x05)
+
+			test.reset(m)
+
+			if cause := m.cpu.Resets.Cause(); cause != test.cause {
+				t.Errorf("reset cause is %v, want %v", cause, test.cause)
+			}
+			if rcon := uint8(m.read(pic18.Registers.RCON, 1)); rcon != test.rcon {
+				t.Errorf("RCON = 0x%02X, want 0x%02X", rcon, test.rcon)
+			}
+			if pc, bsr := m.cpu.PC(), m.read(pic18.Registers.BSR, 1); pc != 0 || bsr != 0 {
+				t.Errorf("PC 0x%06X, BSR 0x%02X after the reset, want 0", pc, bsr)
+			}
+		})
+	}
+}
+
+// The MCLR pin is a digital input when MCLRE is cleared, and BOR can be disabled.
+func TestResetDisabled(t *testing.T) {
+	m := newTestMachine(t)
+	m.config.MclrEnable = false
+	m.config.BrownOut = pic18.BrownOutDisabled
+
+	m.cpu.MclrReset()
+	m.cpu.BrownOutReset()
+	if cause := m.cpu.Resets.Cause(); cause != pic18.ResetPowerOn {
+		t.Errorf("reset cause is %v, want %v", cause, pic18.ResetPowerOn)
+	}
+}
+
+// STKPTR status bits.
+const (
+	stkptrSTKUNF = 1 << 6
+	stkptrSTKFUL = 1 << 7
+)
+
+func TestStackOverflow(t *testing.T) {
+	program := assemble(t, `
+	org 0
+loop
+	push
+	bra loop
+`)
+
+	t.Run("STVREN set", func(t *testing.T) {
+		m := newTestMachine(t, program...)
+		m.config.StackOverflowReset = true
+		m.tickUntil(t, "stack full reset", 200, func() bool { return m.cpu.Resets.Cause() == pic18.ResetStackFull })
+
+		// STKFUL survives the reset, so firmware can tell why it restarted.
+		if stkptr := m.read(pic18.Registers.STKPTR, 1); stkptr != stkptrSTKFUL {
+			t.Errorf("STKPTR = 0x%02X after the reset, want 0x%02X", stkptr, stkptrSTKFUL)
+		}
+	})
+
+	t.Run("STVREN cleared", func(t *testing.T) {
+		m := newTestMachine(t, program...)
+		m.config.StackOverflowReset = false
+		for range 200 {
+			m.cpu.Tick()
+		}
+
+		// The pointer stays at 31, further pushes are discarded.
+		if cause := m.cpu.Resets.Cause(); cause != pic18.ResetPowerOn {
+			t.Errorf("reset cause is %v, want %v", cause, pic18.ResetPowerOn)
+		}
+		if stkptr := m.read(pic18.Registers.STKPTR, 1); stkptr != stkptrSTKFUL|31 {
+			t.Errorf("STKPTR = 0x%02X, want 0x%02X", stkptr, stkptrSTKFUL|31)
+		}
+	})
+}
+
+func TestStackUnderflow(t *testing.T) {
+	program := assemble(t, `
+	org 0
+	pop
+	movlw 0x42
+	sleep
+`)
+
+	t.Run("STVREN set", func(t *testing.T) {
+		m := newTestMachine(t, program...)
+		m.config.StackOverflowReset = true
+		m.tickUntil(t, "stack underflow reset", 10, func() bool { return m.cpu.Resets.Cause() == pic18.ResetStackUnderflow })
+
+		if stkptr := m.read(pic18.Registers.STKPTR, 1); stkptr != stkptrSTKUNF {
+			t.Errorf("STKPTR = 0x%02X after the reset, want 0x%02X", stkptr, stkptrSTKUNF)
+		}
+	})
+
+	t.Run("STVREN cleared", func(t *testing.T) {
+		m := newTestMachine(t, program...)
+		m.config.StackOverflowReset = false
+		m.run(t, 10)
+
+		if cause := m.cpu.Resets.Cause(); cause != pic18.ResetPowerOn {
+			t.Errorf("reset cause is %v, want %v", cause, pic18.ResetPowerOn)
+		}
+		if stkptr := m.read(pic18.Registers.STKPTR, 1); stkptr != stkptrSTKUNF {
+			t.Errorf("STKPTR = 0x%02X, want 0x%02X", stkptr, stkptrSTKUNF)
+		}
+		if m.cpu.WReg != 0x42 {
+			t.Errorf("W = 0x%02X, want 0x42, the program should continue after the underflow", m.cpu.WReg)
+		}
+	})
+}
@@ -3,16 +3,34 @@ package pic18
 type SleepController struct {
 	OnSleep  func()
 	OnWakeUp func()
+
+	asleep bool
+}
+
+// Asleep reports whether the device is in sleep mode.
+func (sleep *SleepController) Asleep() bool {
+	return sleep != nil && sleep.asleep
 }
 
 func (sleep *SleepController) Sleep() {
-	if sleep != nil && sleep.OnSleep != nil {
+	if sleep == nil {
+		return
+	}
+
+	sleep.asleep = true
+	if sleep.OnSleep != nil {
 		sleep.OnSleep()
 	}
 }
 
+// WakeUp leaves sleep mode. It does nothing if the device isn't asleep.
 func (sleep *SleepController) WakeUp() {
-	if sleep != nil && sleep.OnWakeUp != nil {
+	if sleep == nil || !sleep.asleep {
+		return
+	}
+
+	sleep.asleep = false
+	if sleep.OnWakeUp != nil {
 		sleep.OnWakeUp()
 	}
 }
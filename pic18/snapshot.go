@@ -0,0 +1,339 @@
+package pic18
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// SnapshotVersion is the version of the snapshot format.
+// It must be incremented whenever the saved state of any component changes.
+const SnapshotVersion = 2
+
+const snapshotMagic = "PIC18SNAP"
+
+// Stateful is implemented by components whose state can be saved in a snapshot.
+type Stateful interface {
+	SaveState() ([]byte, error)
+	LoadState(data []byte) error
+}
+
+type snapshotFile struct {
+	Magic      string
+	Version    int
+	Components map[string][]byte
+}
+
+// SaveSnapshot writes the state of all components to w.
+// The names are used to match the components when the snapshot is loaded.
+func SaveSnapshot(w io.Writer, components map[string]Stateful) error {
+	file := snapshotFile{
+		Magic:      snapshotMagic,
+		Version:    SnapshotVersion,
+		Components: make(map[string][]byte, len(components)),
+	}
+
+	for name, component := range components {
+		data, err := component.SaveState()
+		if err != nil {
+			return fmt.Errorf("saving %s: %w", name, err)
+		}
+		file.Components[name] = data
+	}
+
+	return gob.NewEncoder(w).Encode(file)
+}
+
+// LoadSnapshot restores the state of all components from r.
+// The snapshot must contain exactly the given components.
+func LoadSnapshot(r io.Reader, components map[string]Stateful) error {
+	var file snapshotFile
+	if err := gob.NewDecoder(r).Decode(&file); err != nil {
+		return err
+	}
+
+	if file.Magic != snapshotMagic {
+		return fmt.Errorf("not a snapshot")
+	}
+	if file.Version != SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d, expected %d", file.Version, SnapshotVersion)
+	}
+
+	for name := range file.Components {
+		if _, ok := components[name]; !ok {
+			return fmt.Errorf("snapshot contains unknown component %s", name)
+		}
+	}
+
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		data, ok := file.Components[name]
+		if !ok {
+			return fmt.Errorf("snapshot is missing component %s", name)
+		}
+		if err := components[name].LoadState(data); err != nil {
+			return fmt.Errorf("loading %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// EncodeState is a helper for implementing [Stateful], it encodes state using encoding/gob.
+func EncodeState(state any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(state); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeState decodes state encoded with [EncodeState].
+func DecodeState(data []byte, state any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(state)
+}
+
+type interruptSourceState struct {
+	Enable       bool
+	Flag         bool
+	HighPriority bool
+}
+
+// cpuState contains everything inside the CPU that isn't mapped to a bus, including the core peripherals.
+type cpuState struct {
+	PC                 uint32
+	FetchedInstruction uint16
+	WReg               uint8
+	PCLatchHigh        uint8
+	PCLatchUpper       uint8
+	Flush              bool
+	InterruptState     InterruptState
+	InterruptedState   InterruptState
+	Cycles             uint64
+	ShadowWreg         uint8
+	ShadowStatus       uint8
+	ShadowBsr          uint8
+	Pending            pendingInstruction
+
+	Status      AluStatus
+	ProductHigh uint8
+	ProductLow  uint8
+
+	StackData      []uint32
+	StackPointer   uint8
+	StackFull      bool
+	StackUnderflow bool
+
+	ExtendedSet bool
+	BSR         uint8
+	FSR         [3]uint16
+	NewFSR      [3]uint16
+
+	InterruptPriorityEnable bool
+	HighPriorityEnable      bool
+	LowPriorityEnable       bool
+	DoGotoHighPriority      bool
+	DoGotoLowPriority       bool
+	InterruptSources        []interruptSourceState
+
+	ResetCause       ResetCause
+	ResetInstruction bool
+	TimeOut          bool
+	PowerDown        bool
+	PowerOn          bool
+	BrownOut         bool
+
+	TablePointer uint32
+	TableLatch   uint8
+
+	WatchdogSoftwareEnable bool
+	WatchdogCounter        uint64
+
+	Asleep bool
+}
+
+// SaveState saves the state of the CPU and its core peripherals (ALU, stack, FSRs, interrupts, resets,
+// table pointer, watchdog and sleep state). Memories and other peripherals must be saved separately.
+func (cpu *CPU) SaveState() ([]byte, error) {
+	return EncodeState(cpu.coreState())
+}
+
+// LoadState restores a state saved with [CPU.SaveState].
+// The CPU must be set up the same way as the one that was saved (stack size, interrupt sources).
+// The sleep callbacks are invoked if the sleep state changes.
+func (cpu *CPU) LoadState(data []byte) error {
+	var state cpuState
+	if err := DecodeState(data, &state); err != nil {
+		return err
+	}
+	return cpu.setCoreState(&state)
+}
+
+// coreState captures the current state of the core.
+// The stack data is not copied, the caller must not keep it across ticks.
+func (cpu *CPU) coreState() cpuState {
+	state := cpuState{
+		PC:                 cpu.pc,
+		FetchedInstruction: cpu.fetchedInstruction,
+		WReg:               cpu.WReg,
+		PCLatchHigh:        cpu.pcLatchHigh,
+		PCLatchUpper:       cpu.pcLatchUpper,
+		Flush:              cpu.flush,
+		InterruptState:     cpu.interruptState,
+		InterruptedState:   cpu.interruptedState,
+		Cycles:             cpu.cycles,
+		ShadowWreg:         cpu.shadowWreg,
+		ShadowStatus:       cpu.shadowStatus,
+		ShadowBsr:          cpu.shadowBsr,
+		Pending:            cpu.pending,
+
+		Status:      cpu.Alu.status,
+		ProductHigh: cpu.Alu.productHigh,
+		ProductLow:  cpu.Alu.productLow,
+
+		StackData:      cpu.Stack.Data,
+		StackPointer:   cpu.Stack.pointer,
+		StackFull:      cpu.Stack.full,
+		StackUnderflow: cpu.Stack.underflow,
+
+		ExtendedSet: cpu.BankController.ExtendedSet,
+		BSR:         cpu.BankController.BSR,
+		FSR:         cpu.BankController.FSR,
+		NewFSR:      cpu.BankController.newFSR,
+
+		InterruptPriorityEnable: cpu.Interrupts.InterruptPriorityEnable,
+		HighPriorityEnable:      cpu.Interrupts.HighPriorityEnable,
+		LowPriorityEnable:       cpu.Interrupts.LowPriorityEnable,
+		DoGotoHighPriority:      cpu.Interrupts.DoGotoHighPriority,
+		DoGotoLowPriority:       cpu.Interrupts.DoGotoLowPriority,
+
+		ResetCause:       cpu.Resets.cause,
+		ResetInstruction: cpu.Resets.resetInstruction,
+		TimeOut:          cpu.Resets.timeOut,
+		PowerDown:        cpu.Resets.powerDown,
+		PowerOn:          cpu.Resets.powerOn,
+		BrownOut:         cpu.Resets.brownOut,
+
+		Asleep: cpu.Sleep.Asleep(),
+	}
+
+	for _, src := range cpu.Interrupts.sources {
+		state.InterruptSources = append(state.InterruptSources, interruptSourceState{
+			Enable:       src.Enable,
+			Flag:         src.Flag,
+			HighPriority: src.HighPriority,
+		})
+	}
+
+	if cpu.Table != nil {
+		state.TablePointer = cpu.Table.tablePointer
+		state.TableLatch = cpu.Table.tableLatch
+	}
+
+	if cpu.Watchdog != nil {
+		state.WatchdogSoftwareEnable = cpu.Watchdog.softwareEnable
+		state.WatchdogCounter = cpu.Watchdog.counter
+	}
+
+	return state
+}
+
+// setCoreState restores a state captured with coreState.
+func (cpu *CPU) setCoreState(state *cpuState) error {
+	if len(state.StackData) != len(cpu.Stack.Data) {
+		return fmt.Errorf("stack size mismatch: snapshot has %d levels, CPU has %d", len(state.StackData), len(cpu.Stack.Data))
+	}
+	if len(state.InterruptSources) != len(cpu.Interrupts.sources) {
+		return fmt.Errorf("interrupt source mismatch: snapshot has %d sources, CPU has %d", len(state.InterruptSources), len(cpu.Interrupts.sources))
+	}
+
+	cpu.pc = state.PC
+	cpu.fetchedInstruction = state.FetchedInstruction
+	cpu.WReg = state.WReg
+	cpu.pcLatchHigh = state.PCLatchHigh
+	cpu.pcLatchUpper = state.PCLatchUpper
+	cpu.flush = state.Flush
+	cpu.interruptState = state.InterruptState
+	cpu.interruptedState = state.InterruptedState
+	cpu.cycles = state.Cycles
+	cpu.shadowWreg = state.ShadowWreg
+	cpu.shadowStatus = state.ShadowStatus
+	cpu.shadowBsr = state.ShadowBsr
+	cpu.pending = state.Pending
+
+	cpu.Alu.status = state.Status
+	cpu.Alu.productHigh = state.ProductHigh
+	cpu.Alu.productLow = state.ProductLow
+
+	copy(cpu.Stack.Data, state.StackData)
+	cpu.Stack.pointer = state.StackPointer
+	cpu.Stack.full = state.StackFull
+	cpu.Stack.underflow = state.StackUnderflow
+
+	cpu.BankController.ExtendedSet = state.ExtendedSet
+	cpu.BankController.BSR = state.BSR
+	cpu.BankController.FSR = state.FSR
+	cpu.BankController.newFSR = state.NewFSR
+
+	cpu.Interrupts.InterruptPriorityEnable = state.InterruptPriorityEnable
+	cpu.Interrupts.HighPriorityEnable = state.HighPriorityEnable
+	cpu.Interrupts.LowPriorityEnable = state.LowPriorityEnable
+	cpu.Interrupts.DoGotoHighPriority = state.DoGotoHighPriority
+	cpu.Interrupts.DoGotoLowPriority = state.DoGotoLowPriority
+	for i, src := range cpu.Interrupts.sources {
+		src.Enable = state.InterruptSources[i].Enable
+		src.Flag = state.InterruptSources[i].Flag
+		src.HighPriority = state.InterruptSources[i].HighPriority
+	}
+
+	cpu.Resets.cause = state.ResetCause
+	cpu.Resets.resetInstruction = state.ResetInstruction
+	cpu.Resets.timeOut = state.TimeOut
+	cpu.Resets.powerDown = state.PowerDown
+	cpu.Resets.powerOn = state.PowerOn
+	cpu.Resets.brownOut = state.BrownOut
+
+	if cpu.Table != nil {
+		cpu.Table.tablePointer = state.TablePointer
+		cpu.Table.tableLatch = state.TableLatch
+	}
+
+	if cpu.Watchdog != nil {
+		cpu.Watchdog.softwareEnable = state.WatchdogSoftwareEnable
+		cpu.Watchdog.counter = state.WatchdogCounter
+	}
+
+	if state.Asleep {
+		if !cpu.Sleep.Asleep() {
+			cpu.Sleep.Sleep()
+		}
+	} else {
+		cpu.Sleep.WakeUp()
+	}
+
+	return nil
+}
+
+func (memory Memory[T]) SaveState() ([]byte, error) {
+	return EncodeState(memory.Data)
+}
+
+// LoadState restores the contents of the memory, the size must match.
+func (memory Memory[T]) LoadState(data []byte) error {
+	var contents []byte
+	if err := DecodeState(data, &contents); err != nil {
+		return err
+	}
+	if len(contents) != len(memory.Data) {
+		return fmt.Errorf("memory size mismatch: snapshot has %d bytes, memory has %d", len(contents), len(memory.Data))
+	}
+	copy(memory.Data, contents)
+	return nil
+}
@@ -8,11 +8,14 @@ type Stack struct {
 	underflow bool
 }
 
+// Push pushes a value onto the stack.
+// It returns true if this push filled the stack (STKFUL was set), which causes a reset if STVREN is enabled.
+// Once the stack is full, further pushes are discarded.
 func (stack *Stack) Push(value uint32) bool {
 	if int(stack.pointer) == len(stack.Data) {
-		// Stack was already full, this doesn't cause a reset so just return true
+		// Stack was already full, this doesn't cause a reset
 		stack.full = true
-		return true
+		return false
 	}
 
 	stack.Data[stack.pointer] = value
@@ -21,19 +24,37 @@ func (stack *Stack) Push(value uint32) bool {
 	if int(stack.pointer) == len(stack.Data) {
 		// Stack became full
 		stack.full = true
+		return true
 	}
 
-	return !stack.full
+	return false
 }
 
+// Pop removes the top of the stack.
+// It returns true if the stack was already empty (STKUNF was set), which causes a reset if STVREN is enabled.
 func (stack *Stack) Pop() bool {
 	if stack.pointer == 0 {
 		stack.underflow = true
-		return false
+		return true
 	}
 
 	stack.pointer--
-	return true
+	return false
+}
+
+// reset clears the stack pointer.
+// STKFUL and STKUNF are only cleared by a Power-on Reset, so firmware can detect a stack reset.
+func (stack *Stack) reset(powerOn bool) {
+	stack.pointer = 0
+	if powerOn {
+		stack.full = false
+		stack.underflow = false
+	}
+}
+
+// Depth returns the number of values on the stack.
+func (stack *Stack) Depth() int {
+	return int(stack.pointer)
 }
 
 func (stack *Stack) Top() uint32 {
@@ -0,0 +1,187 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/natk64/go-pic-emu/pic18"
+	"github.com/natk64/go-pic-emu/pic18/disasm"
+)
+
+// The binary format starts with a header: the magic, a version byte and a flags byte.
+// Each record is encoded as:
+//
+//	uvarint  cycles since the previous record (since 0 for the first one)
+//	3 bytes  PC, little endian
+//	1 byte   number of instruction words (1 or 2)
+//	2 bytes  per instruction word, little endian
+//	3 bytes  WREG, STATUS, BSR
+//	uvarint  number of accesses
+//	3 bytes  per access: address (little endian, bit 15 set for writes) and value
+//
+// The disassembly isn't stored, the reader recreates it from the instruction words.
+const (
+	binaryMagic   = "PIC18TRC"
+	binaryVersion = 1
+
+	// flagExtendedSet is set if the extended instruction set was enabled.
+	flagExtendedSet = 1 << 0
+)
+
+// ErrInvalidTrace is returned by [NewReader] if the input doesn't start with the header of a binary trace.
+var ErrInvalidTrace = errors.New("not a binary trace")
+
+// BinaryWriter writes records in the compact binary format.
+type BinaryWriter struct {
+	w         *bufio.Writer
+	lastCycle uint64
+	buf       []byte
+}
+
+// NewBinaryWriter writes the header and returns a writer for the records.
+// extendedSet tells readers how to disassemble the instructions.
+func NewBinaryWriter(w io.Writer, extendedSet bool) (*BinaryWriter, error) {
+	writer := &BinaryWriter{w: bufio.NewWriter(w)}
+
+	var flags byte
+	if extendedSet {
+		flags |= flagExtendedSet
+	}
+
+	header := append([]byte(binaryMagic), binaryVersion, flags)
+	if _, err := writer.w.Write(header); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (writer *BinaryWriter) WriteRecord(record *Record) error {
+	buf := writer.buf[:0]
+	buf = binary.AppendUvarint(buf, record.Cycle-writer.lastCycle)
+	buf = append(buf, byte(record.PC), byte(record.PC>>8), byte(record.PC>>16))
+
+	buf = append(buf, byte(len(record.Words)))
+	for _, word := range record.Words {
+		buf = binary.LittleEndian.AppendUint16(buf, word)
+	}
+
+	buf = append(buf, record.WREG, record.STATUS, record.BSR)
+
+	buf = binary.AppendUvarint(buf, uint64(len(record.Accesses)))
+	for _, access := range record.Accesses {
+		addr := access.Addr & 0x7FFF
+		if access.Write {
+			addr |= 0x8000
+		}
+		buf = binary.LittleEndian.AppendUint16(buf, addr)
+		buf = append(buf, access.Value)
+	}
+
+	writer.buf = buf
+	writer.lastCycle = record.Cycle
+	_, err := writer.w.Write(buf)
+	return err
+}
+
+func (writer *BinaryWriter) Flush() error {
+	return writer.w.Flush()
+}
+
+// Reader reads records in the binary format.
+type Reader struct {
+	// ExtendedSet is true if the trace was recorded with the extended instruction set enabled.
+	ExtendedSet bool
+
+	r         *bufio.Reader
+	lastCycle uint64
+}
+
+// NewReader reads the header of a binary trace.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{r: bufio.NewReader(r)}
+
+	header := make([]byte, len(binaryMagic)+2)
+	if _, err := io.ReadFull(reader.r, header); err != nil || string(header[:len(binaryMagic)]) != binaryMagic {
+		return nil, ErrInvalidTrace
+	}
+	if version := header[len(binaryMagic)]; version != binaryVersion {
+		return nil, fmt.Errorf("unsupported trace version %d", version)
+	}
+
+	reader.ExtendedSet = header[len(binaryMagic)+1]&flagExtendedSet != 0
+	return reader, nil
+}
+
+// Read reads the next record. It returns io.EOF at the end of the trace.
+func (reader *Reader) Read() (*Record, error) {
+	delta, err := binary.ReadUvarint(reader.r)
+	if err != nil {
+		// The end of the trace is only valid between records.
+		return nil, err
+	}
+
+	record := &Record{Cycle: reader.lastCycle + delta}
+	reader.lastCycle = record.Cycle
+
+	var fixed [4]byte
+	if _, err := io.ReadFull(reader.r, fixed[:]); err != nil {
+		return nil, truncated(err)
+	}
+	record.PC = uint32(fixed[0]) | uint32(fixed[1])<<8 | uint32(fixed[2])<<16
+
+	words := int(fixed[3])
+	if words < 1 || words > 2 {
+		return nil, fmt.Errorf("invalid instruction length %d", words)
+	}
+	var wordBytes [4]byte
+	if _, err := io.ReadFull(reader.r, wordBytes[:words*2]); err != nil {
+		return nil, truncated(err)
+	}
+	for i := range words {
+		record.Words = append(record.Words, binary.LittleEndian.Uint16(wordBytes[i*2:]))
+	}
+
+	var registers [3]byte
+	if _, err := io.ReadFull(reader.r, registers[:]); err != nil {
+		return nil, truncated(err)
+	}
+	record.WREG, record.STATUS, record.BSR = registers[0], registers[1], registers[2]
+
+	count, err := binary.ReadUvarint(reader.r)
+	if err != nil {
+		return nil, truncated(err)
+	}
+	for range count {
+		var access [3]byte
+		if _, err := io.ReadFull(reader.r, access[:]); err != nil {
+			return nil, truncated(err)
+		}
+		addr := binary.LittleEndian.Uint16(access[:])
+		record.Accesses = append(record.Accesses, Access{Addr: addr & 0x7FFF, Value: access[2], Write: addr&0x8000 != 0})
+	}
+
+	record.Text = reader.disassemble(record)
+	return record, nil
+}
+
+// disassemble disassembles the instruction words of a record.
+func (reader *Reader) disassemble(record *Record) string {
+	data := make([]byte, 0, 4)
+	for _, word := range record.Words {
+		data = binary.LittleEndian.AppendUint16(data, word)
+	}
+
+	// The words are mapped at the PC, so branch targets are correct.
+	bus := pic18.Memory[uint32]{Offset: int(record.PC), Data: data}
+	return disasm.Instruction(bus, record.PC, &disasm.Options{ExtendedSet: reader.ExtendedSet}).Text
+}
+
+func truncated(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,27 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// JSONWriter writes records as JSON Lines, one object per line.
+type JSONWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// NewJSONWriter returns a writer that buffers its output until Flush is called.
+func NewJSONWriter(w io.Writer) *JSONWriter {
+	buffered := bufio.NewWriter(w)
+	return &JSONWriter{w: buffered, enc: json.NewEncoder(buffered)}
+}
+
+func (writer *JSONWriter) WriteRecord(record *Record) error {
+	return writer.enc.Encode(record)
+}
+
+func (writer *JSONWriter) Flush() error {
+	return writer.w.Flush()
+}
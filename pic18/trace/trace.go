@@ -0,0 +1,173 @@
+// Package trace records the execution of a [pic18.CPU], one record per executed instruction.
+//
+// Records can be written as JSON Lines, which is easy to process with other tools,
+// or in a compact binary format that is read back with [Reader].
+package trace
+
+import (
+	"github.com/natk64/go-pic-emu/pic18"
+	"github.com/natk64/go-pic-emu/pic18/disasm"
+)
+
+// Access is a data bus access made by an instruction.
+type Access struct {
+	Addr  uint16 `json:"addr"`
+	Value uint8  `json:"value"`
+	Write bool   `json:"write,omitempty"`
+}
+
+// Record describes an executed instruction.
+type Record struct {
+	// Cycle is the instruction cycle in which the instruction started.
+	Cycle uint64   `json:"cycle"`
+	PC    uint32   `json:"pc"`
+	Words []uint16 `json:"words"`
+	Text  string   `json:"disasm"`
+
+	// WREG, STATUS and BSR are the values after the instruction.
+	WREG   uint8 `json:"wreg"`
+	STATUS uint8 `json:"status"`
+	BSR    uint8 `json:"bsr"`
+
+	// Accesses are the data bus accesses in the order they were made.
+	// Indirect accesses appear twice, at the indirect register and at the address in the FSR.
+	Accesses []Access `json:"accesses,omitempty"`
+}
+
+// Range is a range of program memory addresses, End is exclusive.
+type Range struct {
+	Start, End uint32
+}
+
+// Contains reports whether addr is in the range.
+func (r Range) Contains(addr uint32) bool {
+	return addr >= r.Start && addr < r.End
+}
+
+// Filter selects instructions by their address, an empty filter includes everything.
+type Filter []Range
+
+// Includes reports whether addr is in one of the ranges.
+func (filter Filter) Includes(addr uint32) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, r := range filter {
+		if r.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Writer writes records, Flush must be called after the last one.
+type Writer interface {
+	WriteRecord(record *Record) error
+	Flush() error
+}
+
+// Tracer intercepts the data bus of a CPU like [pic18.BusPrinter] and writes a record for every instruction.
+type Tracer struct {
+	// Filter limits the trace to instructions in some program memory ranges.
+	Filter Filter
+
+	// Options are used to disassemble the instructions.
+	Options *disasm.Options
+
+	cpu   *pic18.CPU
+	inner pic18.BusReadWriter[uint16]
+	out   Writer
+	err   error
+
+	record       Record
+	tracing      bool
+	instructions uint64
+}
+
+// New attaches a tracer to the CPU, it writes to out.
+// The CPU's data bus is replaced with the tracer, which forwards everything to the previous bus.
+func New(cpu *pic18.CPU, out Writer) *Tracer {
+	tracer := &Tracer{
+		Options: &disasm.Options{ExtendedSet: cpu.BankController.ExtendedSet},
+		cpu:     cpu,
+		inner:   cpu.DataBus,
+		out:     out,
+	}
+
+	cpu.DataBus = tracer
+	cpu.BankController.Bus = tracer
+	return tracer
+}
+
+// Err returns the first error returned by the writer. Once it fails, nothing is traced anymore.
+func (tracer *Tracer) Err() error {
+	return tracer.err
+}
+
+func (tracer *Tracer) BusRead(addr uint16) (uint8, pic18.AddrMask) {
+	data, mask := tracer.inner.BusRead(addr)
+	if tracer.tracing {
+		tracer.record.Accesses = append(tracer.record.Accesses, Access{Addr: addr, Value: data & uint8(mask)})
+	}
+	return data, mask
+}
+
+func (tracer *Tracer) BusWrite(addr uint16, data uint8) pic18.AddrMask {
+	if tracer.tracing {
+		tracer.record.Accesses = append(tracer.record.Accesses, Access{Addr: addr, Value: data, Write: true})
+	}
+	return tracer.inner.BusWrite(addr, data)
+}
+
+// Tick advances the CPU by one instruction cycle, just like [pic18.CPU.Tick], and traces it.
+func (tracer *Tracer) Tick() {
+	cpu := tracer.cpu
+	if cpu.Sleep.Asleep() {
+		cpu.Tick()
+		return
+	}
+
+	if cpu.AtInstructionBoundary() {
+		tracer.begin()
+	}
+
+	cpu.Tick()
+
+	if tracer.tracing && cpu.AtInstructionBoundary() {
+		tracer.finish()
+	}
+}
+
+func (tracer *Tracer) begin() {
+	cpu := tracer.cpu
+	tracer.tracing = tracer.err == nil && tracer.Filter.Includes(cpu.PC())
+	if !tracer.tracing {
+		return
+	}
+
+	line := disasm.Instruction(cpu.ProgramBus, cpu.PC(), tracer.Options)
+	tracer.record = Record{
+		Cycle:    cpu.Cycles(),
+		PC:       cpu.PC(),
+		Words:    line.Words,
+		Text:     line.Text,
+		Accesses: tracer.record.Accesses[:0],
+	}
+	tracer.instructions = cpu.Instructions()
+}
+
+func (tracer *Tracer) finish() {
+	tracer.tracing = false
+
+	// Interrupt entries and resets don't execute the fetched instruction.
+	cpu := tracer.cpu
+	if cpu.Instructions() == tracer.instructions {
+		return
+	}
+
+	record := &tracer.record
+	record.WREG = cpu.WReg
+	record.STATUS, _ = tracer.inner.BusRead(pic18.Registers.STATUS)
+	record.BSR = cpu.BankController.BSR
+	tracer.err = tracer.out.WriteRecord(record)
+}
@@ -0,0 +1,187 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/natk64/go-pic-emu/pic18/internal/testcpu"
+)
+
+const testSource = `
+	org 0
+main
+	lfsr 0, 0x20
+	movlw 5
+	movwf INDF0
+	bra skip
+	nop
+skip
+	incf 0x20, f
+	sleep
+`
+
+// recorder keeps copies of the records written to it.
+type recorder struct {
+	records []*Record
+}
+
+func (r *recorder) WriteRecord(record *Record) error {
+	saved := *record
+	saved.Accesses = nil
+	if len(record.Accesses) > 0 {
+		saved.Accesses = slices.Clone(record.Accesses)
+	}
+	r.records = append(r.records, &saved)
+	return nil
+}
+
+func (r *recorder) Flush() error {
+	return nil
+}
+
+// traceProgram runs testSource until SLEEP and returns its trace.
+func traceProgram(t *testing.T, filter Filter) []*Record {
+	t.Helper()
+	cpu, _ := testcpu.New(t, testSource)
+	out := &recorder{}
+	tracer := New(cpu, out)
+	tracer.Filter = filter
+
+	for range 100 {
+		tracer.Tick()
+		if cpu.Sleep.Asleep() {
+			return out.records
+		}
+	}
+	t.Fatal("the program didn't reach SLEEP")
+	return nil
+}
+
+func TestTracer(t *testing.T) {
+	records := traceProgram(t, nil)
+
+	var pcs []uint32
+	for _, record := range records {
+		pcs = append(pcs, record.PC)
+	}
+	// The NOP at 0x0A is skipped by the BRA.
+	if want := []uint32{0x00, 0x04, 0x06, 0x08, 0x0C, 0x0E}; !slices.Equal(pcs, want) {
+		t.Fatalf("traced the instructions at %X, want %X", pcs, want)
+	}
+
+	lfsr := records[0]
+	if len(lfsr.Words) != 2 || lfsr.Text == "" {
+		t.Errorf("LFSR was traced with the words %X and the text %q, want two words and a disassembly", lfsr.Words, lfsr.Text)
+	}
+	if movlw := records[1]; movlw.WREG != 5 {
+		t.Errorf("WREG after MOVLW is %d, want 5", movlw.WREG)
+	}
+	if !slices.Contains(records[2].Accesses, Access{Addr: 0x20, Value: 5, Write: true}) {
+		t.Errorf("MOVWF INDF0 made the accesses %+v, want a write of 5 to 0x20", records[2].Accesses)
+	}
+	if cycles := records[4].Cycle - records[3].Cycle; cycles != 2 {
+		t.Errorf("BRA took %d cycles, want 2", cycles)
+	}
+
+	filtered := traceProgram(t, Filter{{Start: 0x08, End: 0x0E}})
+	if len(filtered) != 2 || filtered[0].PC != 0x08 || filtered[1].PC != 0x0C {
+		t.Errorf("the filtered trace has %d records, want the BRA and the INCF", len(filtered))
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	records := traceProgram(t, nil)
+
+	var buf bytes.Buffer
+	writer, err := NewBinaryWriter(&buf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if err := writer.WriteRecord(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("reading the header: %v", err)
+	}
+	if reader.ExtendedSet {
+		t.Error("the trace claims to use the extended instruction set")
+	}
+	for i, want := range records {
+		record, err := reader.Read()
+		if err != nil {
+			t.Fatalf("reading record %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(record, want) {
+			t.Errorf("record %d reads as\n%+v\nwant\n%+v", i, record, want)
+		}
+	}
+	if _, err := reader.Read(); err != io.EOF {
+		t.Errorf("reading after the last record returned %v, want EOF", err)
+	}
+}
+
+func TestBinaryHeader(t *testing.T) {
+	if _, err := NewReader(strings.NewReader("PIC18")); !errors.Is(err, ErrInvalidTrace) {
+		t.Errorf("a short header returned %v, want %v", err, ErrInvalidTrace)
+	}
+	if _, err := NewReader(strings.NewReader("PIC18TRC\x02\x00")); err == nil {
+		t.Error("an unknown version was accepted")
+	}
+
+	buf := &bytes.Buffer{}
+	writer, _ := NewBinaryWriter(buf, true)
+	writer.Flush()
+	if reader, err := NewReader(buf); err != nil || !reader.ExtendedSet {
+		t.Errorf("the extended instruction set flag was lost (%v)", err)
+	}
+
+	// A record cut off after its PC.
+	reader, err := NewReader(strings.NewReader("PIC18TRC\x01\x00\x00\x00\x00\x00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Read(); err != io.ErrUnexpectedEOF {
+		t.Errorf("a truncated record returned %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestJSONWriter(t *testing.T) {
+	records := traceProgram(t, nil)
+
+	var buf bytes.Buffer
+	writer := NewJSONWriter(&buf)
+	for _, record := range records {
+		writer.WriteRecord(record)
+	}
+	if buf.Len() != 0 {
+		t.Error("the JSON writer wrote before Flush")
+	}
+	writer.Flush()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(records) {
+		t.Fatalf("wrote %d lines for %d records", len(lines), len(records))
+	}
+	for i, line := range lines {
+		var record Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("line %d: %v", i+1, err)
+		}
+		if !reflect.DeepEqual(&record, records[i]) {
+			t.Errorf("line %d decodes as %+v, want %+v", i+1, record, records[i])
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// Package vcd records signals of a running [pic18.CPU] as a Value Change Dump,
+// which can be viewed in waveform viewers like GTKWave.
+//
+// Signals are sampled after every instruction cycle, time stamps are instruction cycles
+// converted to picoseconds with the oscillator frequency.
+package vcd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/natk64/go-pic-emu/pic18"
+)
+
+// Signal is a value that is recorded.
+type Signal struct {
+	// Name is shown in the viewer, it must not contain spaces.
+	Name  string
+	Width int
+
+	// Value returns the current value, it must not have side effects.
+	Value func() uint64
+}
+
+// Register samples an 8 bit register. Reading the register must not have side effects,
+// which rules out the indirect addressing registers and PCL.
+func Register(name string, bus pic18.BusReader[uint16], addr uint16) Signal {
+	return Signal{Name: name, Width: 8, Value: func() uint64 {
+		data, mask := bus.BusRead(addr)
+		return uint64(data & uint8(mask))
+	}}
+}
+
+// Bit samples a single bit of a register, like [Register].
+func Bit(name string, bus pic18.BusReader[uint16], addr uint16, bit uint8) Signal {
+	return Signal{Name: name, Width: 1, Value: func() uint64 {
+		data, mask := bus.BusRead(addr)
+		return uint64((data&uint8(mask))>>bit) & 1
+	}}
+}
+
+// InterruptRequest samples the request flag of the interrupt source with a debug label.
+// ok is false if there is no such source.
+func InterruptRequest(interrupts *pic18.InterruptController, label string) (signal Signal, ok bool) {
+	if _, ok := interrupts.RequestFlag(label); !ok {
+		return Signal{}, false
+	}
+
+	return Signal{Name: label + "IF", Width: 1, Value: func() uint64 {
+		flag, _ := interrupts.RequestFlag(label)
+		return boolValue(flag)
+	}}, true
+}
+
+// Sleeping is high while the CPU is in sleep mode.
+func Sleeping(cpu *pic18.CPU) Signal {
+	return Signal{Name: "sleeping", Width: 1, Value: func() uint64 {
+		return boolValue(cpu.Sleep.Asleep())
+	}}
+}
+
+// InISR is high while the CPU executes an interrupt service routine.
+func InISR(cpu *pic18.CPU) Signal {
+	return Signal{Name: "in_isr", Width: 1, Value: func() uint64 {
+		return boolValue(cpu.InterruptState() != pic18.InterruptStateNone)
+	}}
+}
+
+func boolValue(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Writer writes a value change dump.
+type Writer struct {
+	w       *bufio.Writer
+	signals []Signal
+	ids     []string
+	last    []uint64
+
+	// fosc is the oscillator frequency, 0 if time stamps count cycles.
+	fosc     uint64
+	started  bool
+	lastTime uint64
+}
+
+// NewWriter writes the header of the dump.
+// fosc is the oscillator frequency in Hz, an instruction cycle takes four oscillator periods.
+// If it is zero, one nanosecond per cycle is used, so the time axis counts cycles.
+func NewWriter(w io.Writer, signals []Signal, fosc uint64) (*Writer, error) {
+	writer := &Writer{
+		w:       bufio.NewWriter(w),
+		signals: signals,
+		ids:     make([]string, len(signals)),
+		last:    make([]uint64, len(signals)),
+		fosc:    fosc,
+	}
+
+	timescale := "1 ns"
+	if fosc > 0 {
+		timescale = "1 ps"
+	}
+
+	fmt.Fprintf(writer.w, "$date %s $end\n", time.Now().Format(time.RFC1123))
+	fmt.Fprintf(writer.w, "$version go-pic-emu $end\n")
+	fmt.Fprintf(writer.w, "$timescale %s $end\n", timescale)
+	fmt.Fprintf(writer.w, "$scope module pic18 $end\n")
+	for i, signal := range signals {
+		writer.ids[i] = identifier(i)
+		fmt.Fprintf(writer.w, "$var wire %d %s %s $end\n", signal.Width, writer.ids[i], strings.ReplaceAll(signal.Name, " ", "_"))
+	}
+	fmt.Fprintf(writer.w, "$upscope $end\n")
+	fmt.Fprintf(writer.w, "$enddefinitions $end\n")
+
+	if err := writer.w.Flush(); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// identifier returns the short code of a signal, made from printable ASCII characters.
+func identifier(i int) string {
+	const first, count = '!', '~' - '!' + 1
+	id := []byte{byte(first + i%count)}
+	for i /= count; i > 0; i /= count {
+		id = append(id, byte(first+i%count))
+	}
+	return string(id)
+}
+
+// time converts an instruction cycle to a time stamp. The time is computed for every cycle,
+// because the period of an instruction cycle is usually not a whole number of picoseconds.
+func (writer *Writer) time(cycle uint64) uint64 {
+	// An instruction cycle takes 4/fosc seconds, which is 4e12/fosc picoseconds.
+	const cyclePicoseconds = 4_000_000_000_000
+	if writer.fosc == 0 {
+		return cycle
+	}
+
+	// cycle*cyclePicoseconds overflows after a few million cycles, so only the remainder is multiplied in 128 bits.
+	whole, rest := cycle/writer.fosc, cycle%writer.fosc
+	hi, lo := bits.Mul64(rest, cyclePicoseconds)
+	fraction, _ := bits.Div64(hi, lo, writer.fosc)
+	return whole*cyclePicoseconds + fraction
+}
+
+// Sample records the values of all signals at an instruction cycle.
+// Only changes are written, the first sample writes all values.
+// Cycles must not decrease. Write errors are reported by Flush.
+func (writer *Writer) Sample(cycle uint64) {
+	t := writer.time(cycle)
+	timeWritten := false
+	for i, signal := range writer.signals {
+		value := signal.Value()
+		if writer.started && value == writer.last[i] {
+			continue
+		}
+		writer.last[i] = value
+
+		if !timeWritten {
+			if !writer.started {
+				fmt.Fprintf(writer.w, "#%d\n$dumpvars\n", t)
+			} else if t != writer.lastTime {
+				fmt.Fprintf(writer.w, "#%d\n", t)
+			}
+			timeWritten = true
+			writer.lastTime = t
+		}
+		writer.writeValue(i, value)
+	}
+
+	if !writer.started {
+		writer.started = true
+		if timeWritten {
+			fmt.Fprintf(writer.w, "$end\n")
+		}
+	}
+}
+
+func (writer *Writer) writeValue(i int, value uint64) {
+	if writer.signals[i].Width == 1 {
+		fmt.Fprintf(writer.w, "%d%s\n", value&1, writer.ids[i])
+		return
+	}
+	fmt.Fprintf(writer.w, "b%s %s\n", strconv.FormatUint(value, 2), writer.ids[i])
+}
+
+// Flush writes the last time stamp and buffered changes.
+// The time stamp marks the end of the recording, so viewers show the last values until then.
+func (writer *Writer) Flush(cycle uint64) error {
+	if t := writer.time(cycle); writer.started && t > writer.lastTime {
+		fmt.Fprintf(writer.w, "#%d\n", t)
+		writer.lastTime = t
+	}
+	return writer.w.Flush()
+}
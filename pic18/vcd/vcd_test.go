@@ -0,0 +1,97 @@
+package vcd
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/natk64/go-pic-emu/pic18"
+)
+
+func TestWriter(t *testing.T) {
+	ram := pic18.Memory[uint16]{Data: make([]byte, 16)}
+	signals := []Signal{
+		Register("count", ram, 0x01),
+		Bit("flag", ram, 0x02, 3),
+	}
+
+	var out strings.Builder
+	writer, err := NewWriter(&out, signals, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Sample(0)
+	ram.Data[0x01] = 5
+	writer.Sample(3)
+	writer.Sample(4)
+	ram.Data[0x02] = 0x08
+	ram.Data[0x01] = 6
+	writer.Sample(7)
+	// Bits other than the sampled one don't change the signal.
+	ram.Data[0x02] = 0x0C
+	writer.Sample(8)
+	if err := writer.Flush(10); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first line is the date.
+	_, dump, _ := strings.Cut(out.String(), "\n")
+	want := `$version go-pic-emu $end
+$timescale 1 ns $end
+$scope module pic18 $end
+$var wire 8 ! count $end
+$var wire 1 " flag $end
+$upscope $end
+$enddefinitions $end
+#0
+$dumpvars
+b0 !
+0"
+$end
+#3
+b101 !
+#7
+b110 !
+1"
+#10
+`
+	if dump != want {
+		t.Errorf("dump is\n%s\nwant\n%s", dump, want)
+	}
+}
+
+func TestTime(t *testing.T) {
+	// At 4 MHz, an instruction cycle takes a microsecond.
+	writer := &Writer{fosc: 4_000_000}
+	if got := writer.time(3); got != 3_000_000 {
+		t.Errorf("cycle 3 at 4 MHz is at %d ps, want 3000000", got)
+	}
+
+	// At 48 MHz, a cycle takes 83333.3 ps, which is rounded down at each cycle but doesn't accumulate errors.
+	writer = &Writer{fosc: 48_000_000}
+	for _, cycle := range []uint64{1, 3, 1_000_000_007, 1 << 40} {
+		want := new(big.Int).Mul(new(big.Int).SetUint64(cycle), big.NewInt(4_000_000_000_000))
+		want.Div(want, big.NewInt(48_000_000))
+		if got := writer.time(cycle); got != want.Uint64() {
+			t.Errorf("cycle %d at 48 MHz is at %d ps, want %d", cycle, got, want)
+		}
+	}
+}
+
+func TestIdentifier(t *testing.T) {
+	if id := identifier(0); id != "!" {
+		t.Errorf("the first identifier is %q, want \"!\"", id)
+	}
+	if id := identifier(94); id != `!"` {
+		t.Errorf("identifier 94 is %q, want %q", id, `!"`)
+	}
+
+	seen := make(map[string]int)
+	for i := range 10000 {
+		id := identifier(i)
+		if other, ok := seen[id]; ok {
+			t.Fatalf("signals %d and %d have the identifier %q", other, i, id)
+		}
+		seen[id] = i
+	}
+}
@@ -0,0 +1,121 @@
+package pic18
+
+// WatchdogMode selects when the watchdog timer runs, it corresponds to the WDTEN<1:0> configuration bits.
+type WatchdogMode int
+
+const (
+	// WatchdogDisabled disables the WDT in hardware, SWDTEN has no effect.
+	WatchdogDisabled WatchdogMode = iota
+	// WatchdogSoftware lets firmware control the WDT using the SWDTEN bit of WDTCON.
+	WatchdogSoftware
+	// WatchdogActive enables the WDT while the device is running and disables it during sleep.
+	WatchdogActive
+	// WatchdogAlways enables the WDT in hardware, SWDTEN has no effect.
+	WatchdogAlways
+)
+
+// DefaultWatchdogPeriod is the nominal 4 ms WDT period measured in instruction cycles,
+// assuming an instruction clock of 4 MHz (Fosc = 16 MHz).
+const DefaultWatchdogPeriod = 16000
+
+// WatchdogTimer emulates the WDT.
+// The timer is cleared by the CLRWDT and SLEEP instructions and by any reset.
+// A time-out resets the device when it's running, or wakes it up if it's asleep.
+type WatchdogTimer struct {
+	Mode WatchdogMode
+
+	// Postscaler is the ratio of the WDT postscaler (WDTPS), a power of two from 1 to 32768.
+	Postscaler uint32
+
+	// Period is the nominal WDT period before the postscaler, measured in instruction cycles.
+	// If it is zero, [DefaultWatchdogPeriod] is used.
+	Period uint32
+
+	softwareEnable bool
+	counter        uint64
+}
+
+// Enabled reports whether the timer is counting.
+func (wdt *WatchdogTimer) Enabled(asleep bool) bool {
+	if wdt == nil {
+		return false
+	}
+
+	switch wdt.Mode {
+	case WatchdogSoftware:
+		return wdt.softwareEnable
+	case WatchdogActive:
+		return !asleep
+	case WatchdogAlways:
+		return true
+	default:
+		return false
+	}
+}
+
+// Timeout returns the number of instruction cycles until the timer expires.
+func (wdt *WatchdogTimer) Timeout() uint64 {
+	period := uint64(wdt.Period)
+	if period == 0 {
+		period = DefaultWatchdogPeriod
+	}
+
+	postscaler := uint64(wdt.Postscaler)
+	if postscaler == 0 {
+		postscaler = 1
+	}
+
+	return period * postscaler
+}
+
+// Clear restarts the timer, this is what CLRWDT and SLEEP do.
+func (wdt *WatchdogTimer) Clear() {
+	if wdt == nil {
+		return
+	}
+	wdt.counter = 0
+}
+
+func (wdt *WatchdogTimer) reset() {
+	if wdt == nil {
+		return
+	}
+	wdt.counter = 0
+	wdt.softwareEnable = false
+}
+
+// tick advances the timer by one instruction cycle.
+// It returns true if the timer expired.
+func (wdt *WatchdogTimer) tick(asleep bool) bool {
+	if !wdt.Enabled(asleep) {
+		return false
+	}
+
+	wdt.counter++
+	if wdt.counter < wdt.Timeout() {
+		return false
+	}
+
+	wdt.counter = 0
+	return true
+}
+
+func (wdt *WatchdogTimer) BusRead(addr uint16) (uint8, AddrMask) {
+	if addr != Registers.WDTCON {
+		return 0, 0
+	}
+
+	if wdt.softwareEnable {
+		return 0x01, 0x01
+	}
+	return 0, 0x01
+}
+
+func (wdt *WatchdogTimer) BusWrite(addr uint16, data uint8) AddrMask {
+	if addr != Registers.WDTCON {
+		return 0
+	}
+
+	wdt.softwareEnable = data&0x01 != 0
+	return 0x01
+}
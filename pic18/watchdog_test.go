@@ -0,0 +1,96 @@
+package pic18_test
+
+import (
+	"testing"
+
+	"github.com/natk64/go-pic-emu/pic18"
+)
+
+// newWatchdogMachine returns a machine whose WDT expires after 20 instruction cycles.
+func newWatchdogMachine(t *testing.T, mode pic18.WatchdogMode, source string) *testMachine {
+	t.Helper()
+	m := newTestMachine(t, assemble(t, source)...)
+	m.cpu.Watchdog.Mode = mode
+	m.cpu.Watchdog.Period = 10
+	m.cpu.Watchdog.Postscaler = 2
+	return m
+}
+
+func TestWatchdogResetWhileRunning(t *testing.T) {
+	m := newWatchdogMachine(t, pic18.WatchdogAlways, `
+	org 0
+loop
+	bra loop
+`)
+
+	for range 19 {
+		m.cpu.Tick()
+	}
+	if cause := m.cpu.Resets.Cause(); cause != pic18.ResetPowerOn {
+		t.Fatalf("reset cause is %v after 19 cycles, want %v", cause, pic18.ResetPowerOn)
+	}
+
+	m.cpu.Tick()
+	if cause := m.cpu.Resets.Cause(); cause != pic18.ResetWatchdog {
+		t.Fatalf("reset cause is %v after 20 cycles, want %v", cause, pic18.ResetWatchdog)
+	}
+	if rcon := m.read(pic18.Registers.RCON, 1); rcon != rconRI|rconPD {
+		t.Errorf("RCON = 0x%02X after the time-out, want 0x%02X", rcon, rconRI|rconPD)
+	}
+}
+
+// A time-out during sleep wakes the device, which continues after the SLEEP instruction.
+func TestWatchdogWakeUp(t *testing.T) {
+	m := newWatchdogMachine(t, pic18.WatchdogAlways, `
+	org 0
+	sleep
+	movlw 0x42
+	sleep
+`)
+
+	m.run(t, 10)
+	m.tickUntil(t, "wake-up", 20, func() bool { return !m.cpu.Sleep.Asleep() })
+	if rcon := m.read(pic18.Registers.RCON, 1); rcon != rconRI {
+		t.Errorf("RCON = 0x%02X after the wake-up, want 0x%02X", rcon, rconRI)
+	}
+
+	m.cpu.Tick()
+	m.run(t, 10)
+	if cause := m.cpu.Resets.Cause(); cause != pic18.ResetPowerOn {
+		t.Errorf("reset cause is %v, want %v", cause, pic18.ResetPowerOn)
+	}
+	if m.cpu.WReg != 0x42 {
+		t.Errorf("W = 0x%02X, want 0x42", m.cpu.WReg)
+	}
+}
+
+func TestWatchdogModes(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   pic18.WatchdogMode
+		source string
+		reset  bool
+	}{
+		{"disabled", pic18.WatchdogDisabled, "loop\n\tbra loop", false},
+		{"SWDTEN cleared", pic18.WatchdogSoftware, "loop\n\tbra loop", false},
+		{"SWDTEN set", pic18.WatchdogSoftware, "\tbsf WDTCON, 0\nloop\n\tbra loop", true},
+		// SWDTEN has no effect if the WDT is enabled in hardware.
+		{"always, SWDTEN cleared", pic18.WatchdogAlways, "\tbcf WDTCON, 0\nloop\n\tbra loop", true},
+		{"active", pic18.WatchdogActive, "loop\n\tbra loop", true},
+		// The WDT stops during sleep, so it never wakes the device.
+		{"active, asleep", pic18.WatchdogActive, "\tsleep\n\tbra $", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newWatchdogMachine(t, test.mode, "\torg 0\n"+test.source+"\n")
+			for range 100 {
+				m.cpu.Tick()
+			}
+
+			if reset := m.cpu.Resets.Cause() == pic18.ResetWatchdog; reset != test.reset {
+				t.Errorf("WDT reset %v, want %v", reset, test.reset)
+			}
+		})
+	}
+}
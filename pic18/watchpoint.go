@@ -0,0 +1,166 @@
+package pic18
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// WatchKind selects the accesses that trigger a watchpoint, kinds can be combined.
+type WatchKind uint8
+
+const (
+	// WatchRead triggers on every read.
+	WatchRead WatchKind = 1 << iota
+	// WatchWrite triggers on every write.
+	WatchWrite
+	// WatchChange triggers on writes that change the value.
+	WatchChange
+)
+
+func (kind WatchKind) String() string {
+	var names []string
+	if kind&WatchRead != 0 {
+		names = append(names, "read")
+	}
+	if kind&WatchWrite != 0 {
+		names = append(names, "write")
+	}
+	if kind&WatchChange != 0 {
+		names = append(names, "change")
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// WatchHit is an access that triggered a watchpoint.
+type WatchHit struct {
+	Addr  uint16
+	Write bool
+
+	// Value is the value that was read or written, Old is the value before a write.
+	Value uint8
+	Old   uint8
+
+	// PC is the address of the instruction that made the access.
+	PC uint32
+}
+
+// Watchpoints intercepts the data bus like [BusPrinter] and records accesses to watched addresses.
+//
+// Indirect accesses (INDF0, POSTINC1, ...) are reported at the address the FSR points to, because
+// the bank controller resolves them through its bus, which is replaced by the watchpoints.
+// The indirect registers themselves can't be watched. Registers that instructions modify without
+// going through the bus (e.g. WREG after ADDLW, STATUS) only trigger watchpoints for explicit accesses.
+type Watchpoints struct {
+	// Memory is used to read the old value before a write to an address with a change watchpoint.
+	// Reads must not have side effects. If it is nil, every write counts as a change.
+	Memory BusReader[uint16]
+
+	// Hits collects the accesses that triggered a watchpoint, the owner clears it.
+	Hits []WatchHit
+
+	cpu   *CPU
+	inner BusReadWriter[uint16]
+
+	// watches is replaced instead of modified, so it can be read while the CPU runs.
+	watches atomic.Pointer[map[uint16]WatchKind]
+}
+
+// NewWatchpoints attaches watchpoints to the CPU.
+// The CPU's data bus is replaced with the watchpoints, which forward everything to the previous bus.
+func NewWatchpoints(cpu *CPU) *Watchpoints {
+	watchpoints := &Watchpoints{
+		cpu:   cpu,
+		inner: cpu.DataBus,
+	}
+
+	cpu.DataBus = watchpoints
+	cpu.BankController.Bus = watchpoints
+	return watchpoints
+}
+
+// Bus returns the bus that the watchpoints forward to, accesses made through it aren't watched.
+func (watchpoints *Watchpoints) Bus() BusReadWriter[uint16] {
+	return watchpoints.inner
+}
+
+// Watch sets the kinds of watchpoints at an address, replacing the previous ones.
+// A kind of 0 removes the watchpoint.
+func (watchpoints *Watchpoints) Watch(addr uint16, kind WatchKind) {
+	watches := make(map[uint16]WatchKind)
+	if old := watchpoints.watches.Load(); old != nil {
+		maps.Copy(watches, *old)
+	}
+
+	if kind == 0 {
+		delete(watches, addr)
+	} else {
+		watches[addr] = kind
+	}
+	watchpoints.watches.Store(&watches)
+}
+
+// Clear removes all watchpoints.
+func (watchpoints *Watchpoints) Clear() {
+	watchpoints.watches.Store(nil)
+}
+
+// Kind returns the kinds of watchpoints at an address.
+func (watchpoints *Watchpoints) Kind(addr uint16) WatchKind {
+	watches := watchpoints.watches.Load()
+	if watches == nil {
+		return 0
+	}
+	return (*watches)[addr]
+}
+
+// Addresses returns the watched addresses in ascending order.
+func (watchpoints *Watchpoints) Addresses() []uint16 {
+	watches := watchpoints.watches.Load()
+	if watches == nil {
+		return nil
+	}
+
+	addrs := make([]uint16, 0, len(*watches))
+	for addr := range *watches {
+		addrs = append(addrs, addr)
+	}
+	slices.Sort(addrs)
+	return addrs
+}
+
+func (watchpoints *Watchpoints) BusRead(addr uint16) (uint8, AddrMask) {
+	data, mask := watchpoints.inner.BusRead(addr)
+	if watchpoints.Kind(addr)&WatchRead != 0 && !IsIndirectRegister(addr) {
+		watchpoints.Hits = append(watchpoints.Hits, WatchHit{
+			Addr:  addr,
+			Value: data & uint8(mask),
+			PC:    watchpoints.cpu.InstructionAddress(),
+		})
+	}
+	return data, mask
+}
+
+func (watchpoints *Watchpoints) BusWrite(addr uint16, data uint8) AddrMask {
+	kind := watchpoints.Kind(addr)
+	if kind == 0 || IsIndirectRegister(addr) {
+		return watchpoints.inner.BusWrite(addr, data)
+	}
+
+	hit := WatchHit{Addr: addr, Write: true, Value: data, PC: watchpoints.cpu.InstructionAddress()}
+	changed := true
+	if watchpoints.Memory != nil {
+		old, mask := watchpoints.Memory.BusRead(addr)
+		hit.Old = old & uint8(mask)
+		changed = mask == 0 || hit.Old != data
+	}
+
+	if kind&WatchWrite != 0 || (kind&WatchChange != 0 && changed) {
+		watchpoints.Hits = append(watchpoints.Hits, hit)
+	}
+	return watchpoints.inner.BusWrite(addr, data)
+}
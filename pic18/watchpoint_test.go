@@ -0,0 +1,96 @@
+package pic18_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/natk64/go-pic-emu/pic18"
+)
+
+const watchSource = `
+	org 0
+	lfsr 0, 0x123
+	lfsr 1, 0x200
+	movlw 0x55
+	movwf INDF0
+	movf POSTINC1, w
+	movwf 0x10
+	movwf 0x10
+	sleep
+`
+
+// Addresses of the instructions of watchSource.
+const (
+	watchMovwfINDF0  = 0x0A
+	watchMovfPOSTINC = 0x0C
+	watchMovwf1      = 0x0E
+	watchMovwf2      = 0x10
+)
+
+func TestWatchpoints(t *testing.T) {
+	tests := []struct {
+		name string
+		addr uint16
+		kind pic18.WatchKind
+		want []pic18.WatchHit
+	}{
+		// Indirect accesses are reported at the address the FSR points to.
+		{"write through INDF0", 0x123, pic18.WatchWrite, []pic18.WatchHit{
+			{Addr: 0x123, Write: true, Value: 0x55, PC: watchMovwfINDF0},
+		}},
+		{"read through POSTINC1", 0x200, pic18.WatchRead, []pic18.WatchHit{
+			{Addr: 0x200, Value: 0x77, PC: watchMovfPOSTINC},
+		}},
+		{"write", 0x10, pic18.WatchWrite, []pic18.WatchHit{
+			{Addr: 0x10, Write: true, Value: 0x77, Old: 0x01, PC: watchMovwf1},
+			{Addr: 0x10, Write: true, Value: 0x77, Old: 0x77, PC: watchMovwf2},
+		}},
+		// The second MOVWF writes the same value again.
+		{"change", 0x10, pic18.WatchChange, []pic18.WatchHit{
+			{Addr: 0x10, Write: true, Value: 0x77, Old: 0x01, PC: watchMovwf1},
+		}},
+		{"read of a written address", 0x10, pic18.WatchRead, nil},
+		// The indirect registers themselves can't be watched.
+		{"INDF0", pic18.INDF0, pic18.WatchRead | pic18.WatchWrite, nil},
+	}
+
+	for _, test := range tests {
+		m := newTestMachine(t, assemble(t, watchSource)...)
+		m.write(0x200, 1, 0x77)
+		m.write(0x10, 1, 0x01)
+
+		watchpoints := pic18.NewWatchpoints(m.cpu)
+		watchpoints.Memory = watchpoints.Bus()
+		watchpoints.Watch(test.addr, test.kind)
+		m.run(t, 20)
+
+		if !slices.Equal(watchpoints.Hits, test.want) {
+			t.Errorf("%s: hits %+v, want %+v", test.name, watchpoints.Hits, test.want)
+		}
+	}
+}
+
+func TestWatchpointsUpdate(t *testing.T) {
+	m := newTestMachine(t)
+	watchpoints := pic18.NewWatchpoints(m.cpu)
+
+	watchpoints.Watch(0x20, pic18.WatchRead)
+	watchpoints.Watch(0x10, pic18.WatchWrite)
+	watchpoints.Watch(0x10, pic18.WatchWrite|pic18.WatchChange)
+	if addrs := watchpoints.Addresses(); !slices.Equal(addrs, []uint16{0x10, 0x20}) {
+		t.Errorf("watched addresses are %X, want [10 20]", addrs)
+	}
+	if kind := watchpoints.Kind(0x10); kind != pic18.WatchWrite|pic18.WatchChange {
+		t.Errorf("the kind at 0x10 is %v, want write|change", kind)
+	}
+
+	watchpoints.Watch(0x20, 0)
+	if addrs := watchpoints.Addresses(); !slices.Equal(addrs, []uint16{0x10}) {
+		t.Errorf("watched addresses are %X after removing 0x20, want [10]", addrs)
+	}
+
+	watchpoints.Clear()
+	if addrs := watchpoints.Addresses(); addrs != nil {
+		t.Errorf("watched addresses are %X after clearing, want none", addrs)
+	}
+}